@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
@@ -20,6 +21,12 @@ const (
 	DefaultRateLimitRPS         = 100
 	DefaultRateLimitBurst       = 200
 	DefaultRateLimitEnabled     = true
+	DefaultRateLimitUserRPS     = 0 // Zero uses the per-client limits for users too
+	DefaultRateLimitUserBurst   = 0
+	DefaultExpensiveRPS         = 2 // Per client and per user for expensive endpoints (getRandomSongs, /debug)
+	DefaultExpensiveBurst       = 10
+	DefaultRateLimitIdleTimeout = 10 * time.Minute // Limiters unused for this long are evicted
+	DefaultTrustedProxies       = ""               // Comma-separated IPs/CIDRs whose X-Forwarded-For is honored
 	DefaultDBMaxOpenConns       = 25
 	DefaultDBMaxIdleConns       = 5
 	DefaultDBConnMaxLifetime    = 30 * time.Minute
@@ -42,19 +49,63 @@ const (
 	DefaultReferrerPolicy          = "strict-origin-when-cross-origin"
 	DefaultDebugMode               = false
 	DefaultCredentialWorkers       = 100 // Maximum concurrent credential validation workers
+	DefaultCredentialKey           = ""  // Empty disables the persistent credential store
+	DefaultCredentialKeyFile       = ""
+	DefaultSyncStrategy            = SyncStrategyID3
+	DefaultFullSyncInterval        = 24 * time.Hour // Full reconciliation schedule; hourly syncs in between are incremental
+	DefaultSyncConcurrency         = 4              // Concurrent upstream requests per library crawl
+	DefaultSyncRequestRate         = 20             // Upstream requests per second during library sync (0 = unlimited)
+	DefaultSyncMaxRetries          = 3              // Retries for transient upstream errors during library sync
+	DefaultAdminToken              = ""             // Empty disables the admin API
+	DefaultMetricsEnabled          = false          // The endpoint is unauthenticated, so it must be enabled explicitly
+	DefaultShuffleMaxPerArtist     = 0              // Maximum songs by one artist per shuffled batch (0 = unlimited)
+	DefaultShuffleMaxPerAlbum      = 0              // Maximum songs from one album per shuffled batch (0 = unlimited)
+	DefaultShuffleArtistSpacing    = 0              // Minimum other songs between two songs by the same artist (0 = no spacing)
+	DefaultReplayWindowPlayedDays  = 14             // Days a played song is excluded from shuffles (0 = no exclusion)
+	DefaultReplayWindowSkippedDays = 14             // Days a skipped song is excluded from shuffles (0 = no exclusion)
+	DefaultWeightingPreset         = "balanced"     // Weighting preset used until a default profile is set through the admin API
+	DefaultScrobbleDeviceFields    = "client"       // Request parts identifying a device for skip detection (comma-separated)
+	DefaultStreamTracking          = true           // Observe /rest/stream responses for partial-play skip detection
+	DefaultStreamPlayThreshold     = 0.5            // Listened fraction at which a stream counts as a play
+)
+
+// Library sync strategies
+const (
+	SyncStrategyID3     = "id3"     // getArtists -> getArtist -> getAlbum
+	SyncStrategySearch3 = "search3" // search3 with an empty query and offset paging
+	SyncStrategyFolder  = "folder"  // getMusicFolders -> getIndexes -> getMusicDirectory
+)
+
+// Request parts that identify the device of a scrobble
+const (
+	ScrobbleDeviceFieldClient    = "client"     // Subsonic client name (c parameter)
+	ScrobbleDeviceFieldAddress   = "address"    // Client IP address, honoring trusted proxies
+	ScrobbleDeviceFieldUserAgent = "user-agent" // User-Agent header
 )
 
 // Validation limits
 const (
-	MinPortNumber     = 1
-	MaxPortNumber     = 65535
-	MinRateLimitRPS   = 1
-	MinRateLimitBurst = 1
-	MinDBMaxOpenConns = 1
+	MinPortNumber        = 1
+	MaxPortNumber        = 65535
+	MinRateLimitRPS      = 1
+	MinRateLimitBurst    = 1
+	MinRateLimitOptional = 0 // Zero uses the default for optional rate limits
+	MinDBMaxOpenConns    = 1
 	MinDBMaxIdleConns    = 0
 	MinDBConnLifetime    = 0
 	MinDBConnIdleTime    = 0
 	MinCredentialWorkers = 1
+	MinCredentialKeyLen  = 16
+	MinFullSyncInterval  = 0 // Zero makes every sync a full reconciliation
+	MinSyncConcurrency   = 0 // Zero uses DefaultSyncConcurrency
+	MinSyncRequestRate   = 0 // Zero disables upstream rate limiting during sync
+	MinSyncMaxRetries    = 0
+	MinAdminTokenLen     = 16
+	MinShuffleDiversity  = 0 // Zero disables a diversity rule
+	MinReplayWindowDays  = 0 // Zero disables a replay exclusion
+
+	MinStreamPlayThreshold = 0.0 // Zero uses DefaultStreamPlayThreshold
+	MaxStreamPlayThreshold = 1.0
 )
 
 type Config struct {
@@ -65,6 +116,14 @@ type Config struct {
 	RateLimitRPS     int
 	RateLimitBurst   int
 	RateLimitEnabled bool
+	// Keyed rate limiting: RateLimitRPS/Burst apply per client IP, the user limits per
+	// authenticated user, and the expensive limits to expensive endpoints for both
+	RateLimitUserRPS        int
+	RateLimitUserBurst      int
+	RateLimitExpensiveRPS   int
+	RateLimitExpensiveBurst int
+	RateLimitIdleTimeout    time.Duration
+	TrustedProxies          []string
 	// Database connection pool settings
 	DBMaxOpenConns    int
 	DBMaxIdleConns    int
@@ -90,6 +149,37 @@ type Config struct {
 	DebugMode bool
 	// Credential validation worker pool
 	CredentialWorkers int
+	// Persistent credential store (enabled when a master key is configured)
+	CredentialKey             string
+	CredentialKeyFile         string
+	CredentialPreviousKey     string
+	CredentialPreviousKeyFile string
+	// Library sync strategy (id3, search3 or folder)
+	SyncStrategy string
+	// Interval between full library reconciliations
+	FullSyncInterval time.Duration
+	// Library crawl limits
+	SyncConcurrency int
+	SyncRequestRate int
+	SyncMaxRetries  int
+	// Bearer token for the admin API (empty disables it)
+	AdminToken string
+	// Prometheus metrics endpoint
+	MetricsEnabled bool
+	// Shuffle diversity rules (zero disables a rule)
+	ShuffleMaxPerArtist  int
+	ShuffleMaxPerAlbum   int
+	ShuffleArtistSpacing int
+	// Shuffle replay exclusion windows in days (users may override them)
+	ReplayWindowPlayedDays  int
+	ReplayWindowSkippedDays int
+	// Shuffle weighting preset (balanced, discovery, comfort); empty means balanced
+	WeightingPreset string
+	// Request parts identifying the device of a scrobble (client, address, user-agent); empty means client
+	ScrobbleDeviceFields []string
+	// Partial-play skip detection from stream responses; a zero threshold means the default
+	StreamTracking      bool
+	StreamPlayThreshold float64
 }
 
 func New() (*Config, error) {
@@ -101,6 +191,13 @@ func New() (*Config, error) {
 		rateLimitRPS     = flag.Int("rate-limit-rps", getEnvIntOrDefault("RATE_LIMIT_RPS", DefaultRateLimitRPS), "Rate limit requests per second")
 		rateLimitBurst   = flag.Int("rate-limit-burst", getEnvIntOrDefault("RATE_LIMIT_BURST", DefaultRateLimitBurst), "Rate limit burst size")
 		rateLimitEnabled = flag.Bool("rate-limit-enabled", getEnvBoolOrDefault("RATE_LIMIT_ENABLED", DefaultRateLimitEnabled), "Enable rate limiting")
+		// Keyed rate limiting flags
+		rateLimitUserRPS        = flag.Int("rate-limit-user-rps", getEnvIntOrDefault("RATE_LIMIT_USER_RPS", DefaultRateLimitUserRPS), "Rate limit requests per second per authenticated user (0 = same as -rate-limit-rps)")
+		rateLimitUserBurst      = flag.Int("rate-limit-user-burst", getEnvIntOrDefault("RATE_LIMIT_USER_BURST", DefaultRateLimitUserBurst), "Rate limit burst size per authenticated user (0 = same as -rate-limit-burst)")
+		rateLimitExpensiveRPS   = flag.Int("rate-limit-expensive-rps", getEnvIntOrDefault("RATE_LIMIT_EXPENSIVE_RPS", DefaultExpensiveRPS), "Rate limit requests per second for expensive endpoints, per client and per user")
+		rateLimitExpensiveBurst = flag.Int("rate-limit-expensive-burst", getEnvIntOrDefault("RATE_LIMIT_EXPENSIVE_BURST", DefaultExpensiveBurst), "Rate limit burst size for expensive endpoints")
+		rateLimitIdleTimeout    = flag.Duration("rate-limit-idle-timeout", getEnvDurationOrDefault("RATE_LIMIT_IDLE_TIMEOUT", DefaultRateLimitIdleTimeout), "Evict per-client and per-user rate limiters unused for this long")
+		trustedProxies          = flag.String("trusted-proxies", getEnvOrDefault("TRUSTED_PROXIES", DefaultTrustedProxies), "Reverse proxy IPs or CIDRs whose X-Forwarded-For header is trusted (comma-separated)")
 		// Database connection pool flags
 		dbMaxOpenConns    = flag.Int("db-max-open-conns", getEnvIntOrDefault("DB_MAX_OPEN_CONNS", DefaultDBMaxOpenConns), "Maximum number of open database connections")
 		dbMaxIdleConns    = flag.Int("db-max-idle-conns", getEnvIntOrDefault("DB_MAX_IDLE_CONNS", DefaultDBMaxIdleConns), "Maximum number of idle database connections")
@@ -124,37 +221,84 @@ func New() (*Config, error) {
 		referrerPolicy          = flag.String("referrer-policy", getEnvOrDefault("REFERRER_POLICY", DefaultReferrerPolicy), "Referrer-Policy header value")
 		debugMode               = flag.Bool("debug-mode", getEnvBoolOrDefault("DEBUG", DefaultDebugMode), "Enable debug endpoint")
 		credentialWorkers       = flag.Int("credential-workers", getEnvIntOrDefault("CREDENTIAL_WORKERS", DefaultCredentialWorkers), "Maximum concurrent credential validation workers")
+		// Credential store flags (prefer environment variables or key files for secrets)
+		credentialKey             = flag.String("credential-key", getEnvOrDefault("CREDENTIAL_KEY", DefaultCredentialKey), "Master key for the persistent credential store")
+		credentialKeyFile         = flag.String("credential-key-file", getEnvOrDefault("CREDENTIAL_KEY_FILE", DefaultCredentialKeyFile), "File containing the master key for the persistent credential store")
+		credentialPreviousKey     = flag.String("credential-previous-key", getEnvOrDefault("CREDENTIAL_PREVIOUS_KEY", DefaultCredentialKey), "Previous master key, used to re-encrypt stored credentials during key rotation")
+		credentialPreviousKeyFile = flag.String("credential-previous-key-file", getEnvOrDefault("CREDENTIAL_PREVIOUS_KEY_FILE", DefaultCredentialKeyFile), "File containing the previous master key for key rotation")
+		syncStrategy              = flag.String("sync-strategy", getEnvOrDefault("SYNC_STRATEGY", DefaultSyncStrategy), "Library sync strategy (id3, search3, folder)")
+		fullSyncInterval          = flag.Duration("full-sync-interval", getEnvDurationOrDefault("FULL_SYNC_INTERVAL", DefaultFullSyncInterval), "Interval between full library reconciliations (0 = always full)")
+		syncConcurrency           = flag.Int("sync-concurrency", getEnvIntOrDefault("SYNC_CONCURRENCY", DefaultSyncConcurrency), "Concurrent upstream requests per library crawl")
+		syncRequestRate           = flag.Int("sync-request-rate", getEnvIntOrDefault("SYNC_REQUEST_RATE", DefaultSyncRequestRate), "Upstream requests per second during library sync (0 = unlimited)")
+		syncMaxRetries            = flag.Int("sync-max-retries", getEnvIntOrDefault("SYNC_MAX_RETRIES", DefaultSyncMaxRetries), "Retries for transient upstream errors during library sync")
+		adminToken                = flag.String("admin-token", getEnvOrDefault("ADMIN_TOKEN", DefaultAdminToken), "Bearer token for the admin API (empty disables it)")
+		metricsEnabled            = flag.Bool("metrics-enabled", getEnvBoolOrDefault("METRICS_ENABLED", DefaultMetricsEnabled), "Enable the Prometheus metrics endpoint at /metrics")
+		shuffleMaxPerArtist       = flag.Int("shuffle-max-per-artist", getEnvIntOrDefault("SHUFFLE_MAX_PER_ARTIST", DefaultShuffleMaxPerArtist), "Maximum songs by one artist per shuffled batch (0 = unlimited)")
+		shuffleMaxPerAlbum        = flag.Int("shuffle-max-per-album", getEnvIntOrDefault("SHUFFLE_MAX_PER_ALBUM", DefaultShuffleMaxPerAlbum), "Maximum songs from one album per shuffled batch (0 = unlimited)")
+		shuffleArtistSpacing      = flag.Int("shuffle-artist-spacing", getEnvIntOrDefault("SHUFFLE_ARTIST_SPACING", DefaultShuffleArtistSpacing), "Minimum number of other songs between two songs by the same artist in a shuffled batch")
+		replayWindowPlayedDays    = flag.Int("replay-window-played-days", getEnvIntOrDefault("REPLAY_WINDOW_PLAYED_DAYS", DefaultReplayWindowPlayedDays), "Days a played song is excluded from shuffles (0 = no exclusion)")
+		replayWindowSkippedDays   = flag.Int("replay-window-skipped-days", getEnvIntOrDefault("REPLAY_WINDOW_SKIPPED_DAYS", DefaultReplayWindowSkippedDays), "Days a skipped song is excluded from shuffles (0 = no exclusion)")
+		weightingPreset           = flag.String("weighting-preset", getEnvOrDefault("WEIGHTING_PRESET", DefaultWeightingPreset), "Default shuffle weighting preset (balanced, discovery, comfort)")
+		scrobbleDeviceFields      = flag.String("scrobble-device-fields", getEnvOrDefault("SCROBBLE_DEVICE_FIELDS", DefaultScrobbleDeviceFields), "Request parts identifying a device for skip detection: client, address, user-agent (comma-separated)")
+		streamTracking            = flag.Bool("stream-tracking", getEnvBoolOrDefault("STREAM_TRACKING", DefaultStreamTracking), "Observe stream responses for partial-play skip detection")
+		streamPlayThreshold       = flag.Float64("stream-play-threshold", getEnvFloatOrDefault("STREAM_PLAY_THRESHOLD", DefaultStreamPlayThreshold), "Listened fraction of a song (0-1) at which a stream counts as a play")
 	)
 	flag.Parse()
 
 	config := &Config{
-		ProxyPort:               *port,
-		UpstreamURL:             *upstream,
-		LogLevel:                *logLevel,
-		DatabasePath:            *dbPath,
-		RateLimitRPS:            *rateLimitRPS,
-		RateLimitBurst:          *rateLimitBurst,
-		RateLimitEnabled:        *rateLimitEnabled,
-		DBMaxOpenConns:          *dbMaxOpenConns,
-		DBMaxIdleConns:          *dbMaxIdleConns,
-		DBConnMaxLifetime:       *dbConnMaxLifetime,
-		DBConnMaxIdleTime:       *dbConnMaxIdleTime,
-		DBHealthCheck:           *dbHealthCheck,
-		CORSEnabled:             *corsEnabled,
-		CORSAllowOrigins:        parseCommaSeparatedString(*corsAllowOrigins),
-		CORSAllowMethods:        parseCommaSeparatedString(*corsAllowMethods),
-		CORSAllowHeaders:        parseCommaSeparatedString(*corsAllowHeaders),
-		CORSAllowCredentials:    *corsAllowCredentials,
-		SecurityHeadersEnabled:  *securityHeadersEnabled,
-		SecurityDevMode:         *securityDevMode,
-		XContentTypeOptions:     *xContentTypeOptions,
-		XFrameOptions:           *xFrameOptions,
-		XXSSProtection:          *xxxxProtection,
-		StrictTransportSecurity: *strictTransportSecurity,
-		ContentSecurityPolicy:   *contentSecurityPolicy,
-		ReferrerPolicy:          *referrerPolicy,
-		DebugMode:               *debugMode,
-		CredentialWorkers:       *credentialWorkers,
+		ProxyPort:                 *port,
+		UpstreamURL:               *upstream,
+		LogLevel:                  *logLevel,
+		DatabasePath:              *dbPath,
+		RateLimitRPS:              *rateLimitRPS,
+		RateLimitBurst:            *rateLimitBurst,
+		RateLimitEnabled:          *rateLimitEnabled,
+		RateLimitUserRPS:          *rateLimitUserRPS,
+		RateLimitUserBurst:        *rateLimitUserBurst,
+		RateLimitExpensiveRPS:     *rateLimitExpensiveRPS,
+		RateLimitExpensiveBurst:   *rateLimitExpensiveBurst,
+		RateLimitIdleTimeout:      *rateLimitIdleTimeout,
+		TrustedProxies:            parseCommaSeparatedString(*trustedProxies),
+		DBMaxOpenConns:            *dbMaxOpenConns,
+		DBMaxIdleConns:            *dbMaxIdleConns,
+		DBConnMaxLifetime:         *dbConnMaxLifetime,
+		DBConnMaxIdleTime:         *dbConnMaxIdleTime,
+		DBHealthCheck:             *dbHealthCheck,
+		CORSEnabled:               *corsEnabled,
+		CORSAllowOrigins:          parseCommaSeparatedString(*corsAllowOrigins),
+		CORSAllowMethods:          parseCommaSeparatedString(*corsAllowMethods),
+		CORSAllowHeaders:          parseCommaSeparatedString(*corsAllowHeaders),
+		CORSAllowCredentials:      *corsAllowCredentials,
+		SecurityHeadersEnabled:    *securityHeadersEnabled,
+		SecurityDevMode:           *securityDevMode,
+		XContentTypeOptions:       *xContentTypeOptions,
+		XFrameOptions:             *xFrameOptions,
+		XXSSProtection:            *xxxxProtection,
+		StrictTransportSecurity:   *strictTransportSecurity,
+		ContentSecurityPolicy:     *contentSecurityPolicy,
+		ReferrerPolicy:            *referrerPolicy,
+		DebugMode:                 *debugMode,
+		CredentialWorkers:         *credentialWorkers,
+		CredentialKey:             *credentialKey,
+		CredentialKeyFile:         *credentialKeyFile,
+		CredentialPreviousKey:     *credentialPreviousKey,
+		CredentialPreviousKeyFile: *credentialPreviousKeyFile,
+		SyncStrategy:              strings.ToLower(*syncStrategy),
+		FullSyncInterval:          *fullSyncInterval,
+		SyncConcurrency:           *syncConcurrency,
+		SyncRequestRate:           *syncRequestRate,
+		SyncMaxRetries:            *syncMaxRetries,
+		AdminToken:                *adminToken,
+		MetricsEnabled:            *metricsEnabled,
+		ShuffleMaxPerArtist:       *shuffleMaxPerArtist,
+		ShuffleMaxPerAlbum:        *shuffleMaxPerAlbum,
+		ShuffleArtistSpacing:      *shuffleArtistSpacing,
+		ReplayWindowPlayedDays:    *replayWindowPlayedDays,
+		ReplayWindowSkippedDays:   *replayWindowSkippedDays,
+		WeightingPreset:           *weightingPreset,
+		ScrobbleDeviceFields:      parseCommaSeparatedString(*scrobbleDeviceFields),
+		StreamTracking:            *streamTracking,
+		StreamPlayThreshold:       *streamPlayThreshold,
 	}
 
 	if err := config.Validate(); err != nil {
@@ -202,6 +346,146 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if err := c.validateCredentialStore(); err != nil {
+		return err
+	}
+
+	if err := c.validateSyncStrategy(); err != nil {
+		return err
+	}
+
+	if err := c.validateSyncCrawl(); err != nil {
+		return err
+	}
+
+	if err := c.validateAdminToken(); err != nil {
+		return err
+	}
+
+	if err := c.validateShuffleDiversity(); err != nil {
+		return err
+	}
+
+	if err := c.validateReplayWindows(); err != nil {
+		return err
+	}
+
+	if err := c.validateScrobbleDeviceFields(); err != nil {
+		return err
+	}
+
+	if err := c.validateStreamPlayThreshold(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Config) validateShuffleDiversity() error {
+	for _, rule := range []struct {
+		name  string
+		value int
+	}{
+		{"max_per_artist", c.ShuffleMaxPerArtist},
+		{"max_per_album", c.ShuffleMaxPerAlbum},
+		{"artist_spacing", c.ShuffleArtistSpacing},
+	} {
+		if rule.value < MinShuffleDiversity {
+			return errors.New(errors.CategoryConfig, "INVALID_SHUFFLE_DIVERSITY", "shuffle diversity rules cannot be negative").
+				WithContext("rule", rule.name).
+				WithContext("value", rule.value)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateReplayWindows() error {
+	if c.ReplayWindowPlayedDays < MinReplayWindowDays {
+		return errors.New(errors.CategoryConfig, "INVALID_REPLAY_WINDOW", "replay window cannot be negative").
+			WithContext("window", "played").
+			WithContext("days", c.ReplayWindowPlayedDays)
+	}
+	if c.ReplayWindowSkippedDays < MinReplayWindowDays {
+		return errors.New(errors.CategoryConfig, "INVALID_REPLAY_WINDOW", "replay window cannot be negative").
+			WithContext("window", "skipped").
+			WithContext("days", c.ReplayWindowSkippedDays)
+	}
+
+	return nil
+}
+
+func (c *Config) validateScrobbleDeviceFields() error {
+	for _, field := range c.ScrobbleDeviceFields {
+		switch field {
+		case ScrobbleDeviceFieldClient, ScrobbleDeviceFieldAddress, ScrobbleDeviceFieldUserAgent:
+		default:
+			return errors.New(errors.CategoryConfig, "INVALID_SCROBBLE_DEVICE_FIELD", "scrobble device field must be one of: client, address, user-agent").
+				WithContext("field", field)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateStreamPlayThreshold() error {
+	if c.StreamPlayThreshold < MinStreamPlayThreshold || c.StreamPlayThreshold > MaxStreamPlayThreshold {
+		return errors.New(errors.CategoryConfig, "INVALID_STREAM_PLAY_THRESHOLD", "stream play threshold must be between 0 and 1").
+			WithContext("threshold", c.StreamPlayThreshold)
+	}
+
+	return nil
+}
+
+func (c *Config) validateSyncStrategy() error {
+	if c.FullSyncInterval < MinFullSyncInterval {
+		return errors.New(errors.CategoryConfig, "INVALID_FULL_SYNC_INTERVAL", "full sync interval cannot be negative").
+			WithContext("interval", c.FullSyncInterval)
+	}
+
+	// Empty means the default strategy
+	if c.SyncStrategy == "" {
+		return nil
+	}
+
+	validStrategies := []string{SyncStrategyID3, SyncStrategySearch3, SyncStrategyFolder}
+	for _, strategy := range validStrategies {
+		if c.SyncStrategy == strategy {
+			return nil
+		}
+	}
+
+	return errors.New(errors.CategoryConfig, "INVALID_SYNC_STRATEGY", "invalid library sync strategy").
+		WithContext("strategy", c.SyncStrategy).
+		WithContext("valid_strategies", validStrategies)
+}
+
+func (c *Config) validateSyncCrawl() error {
+	if c.SyncConcurrency < MinSyncConcurrency {
+		return errors.New(errors.CategoryConfig, "INVALID_SYNC_CONCURRENCY", "sync concurrency cannot be negative").
+			WithContext("sync_concurrency", c.SyncConcurrency).
+			WithContext("min_sync_concurrency", MinSyncConcurrency)
+	}
+
+	if c.SyncRequestRate < MinSyncRequestRate {
+		return errors.New(errors.CategoryConfig, "INVALID_SYNC_REQUEST_RATE", "sync request rate cannot be negative").
+			WithContext("sync_request_rate", c.SyncRequestRate)
+	}
+
+	if c.SyncMaxRetries < MinSyncMaxRetries {
+		return errors.New(errors.CategoryConfig, "INVALID_SYNC_MAX_RETRIES", "sync max retries cannot be negative").
+			WithContext("sync_max_retries", c.SyncMaxRetries)
+	}
+
+	return nil
+}
+
+func (c *Config) validateAdminToken() error {
+	if c.AdminToken != "" && len(c.AdminToken) < MinAdminTokenLen {
+		return errors.New(errors.CategoryConfig, "INVALID_ADMIN_TOKEN", "admin token is too short").
+			WithContext("min_length", MinAdminTokenLen)
+	}
+
 	return nil
 }
 
@@ -306,9 +590,81 @@ func (c *Config) validateRateLimit() error {
 			WithContext("rps", c.RateLimitRPS)
 	}
 
+	if c.RateLimitUserRPS < MinRateLimitOptional || c.RateLimitUserBurst < MinRateLimitOptional ||
+		c.RateLimitExpensiveRPS < MinRateLimitOptional || c.RateLimitExpensiveBurst < MinRateLimitOptional {
+		return errors.New(errors.CategoryConfig, "INVALID_RATE_LIMIT", "user and expensive endpoint rate limits cannot be negative").
+			WithContext("user_rps", c.RateLimitUserRPS).
+			WithContext("user_burst", c.RateLimitUserBurst).
+			WithContext("expensive_rps", c.RateLimitExpensiveRPS).
+			WithContext("expensive_burst", c.RateLimitExpensiveBurst)
+	}
+
+	if c.RateLimitIdleTimeout < 0 {
+		return errors.New(errors.CategoryConfig, "INVALID_RATE_LIMIT_IDLE_TIMEOUT", "rate limit idle timeout cannot be negative").
+			WithContext("idle_timeout", c.RateLimitIdleTimeout)
+	}
+
+	if _, err := c.TrustedProxyNets(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// UserRateLimit returns the per-user rate limit, falling back to the per-client limit
+func (c *Config) UserRateLimit() (rps, burst int) {
+	rps, burst = c.RateLimitUserRPS, c.RateLimitUserBurst
+	if rps == 0 {
+		rps = c.RateLimitRPS
+	}
+	if burst == 0 {
+		burst = c.RateLimitBurst
+	}
+	return rps, burst
+}
+
+// ExpensiveRateLimit returns the rate limit for expensive endpoints, falling back to the defaults
+func (c *Config) ExpensiveRateLimit() (rps, burst int) {
+	rps, burst = c.RateLimitExpensiveRPS, c.RateLimitExpensiveBurst
+	if rps == 0 {
+		rps = DefaultExpensiveRPS
+	}
+	if burst == 0 {
+		burst = DefaultExpensiveBurst
+	}
+	return rps, burst
+}
+
+// TrustedProxyNets parses the trusted proxy list. Plain IPs are treated as single-host networks.
+func (c *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, entry := range c.TrustedProxies {
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New(errors.CategoryConfig, "INVALID_TRUSTED_PROXY", "trusted proxy must be an IP address or CIDR").
+					WithContext("proxy", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, errors.CategoryConfig, "INVALID_TRUSTED_PROXY", "trusted proxy must be an IP address or CIDR").
+				WithContext("proxy", entry)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
 // GetDatabasePoolConfig returns database pool configuration
 func (c *Config) GetDatabasePoolConfig() *DatabasePoolConfig {
 	return &DatabasePoolConfig{
@@ -354,6 +710,15 @@ func getEnvBoolOrDefault(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -488,6 +853,76 @@ func (c *Config) validateCredentialWorkers() error {
 	return nil
 }
 
+func (c *Config) validateCredentialStore() error {
+	if c.CredentialKey != "" && c.CredentialKeyFile != "" {
+		return errors.New(errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "credential key and credential key file are mutually exclusive")
+	}
+
+	if c.CredentialPreviousKey != "" && c.CredentialPreviousKeyFile != "" {
+		return errors.New(errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "previous credential key and previous credential key file are mutually exclusive")
+	}
+
+	currentKey, previousKey, err := c.CredentialKeys()
+	if err != nil {
+		return err
+	}
+
+	if currentKey == nil && previousKey != nil {
+		return errors.New(errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "previous credential key requires a current credential key")
+	}
+
+	for _, key := range [][]byte{currentKey, previousKey} {
+		if key != nil && len(key) < MinCredentialKeyLen {
+			return errors.New(errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "credential key is too short").
+				WithContext("min_length", MinCredentialKeyLen)
+		}
+	}
+
+	return nil
+}
+
+// CredentialKeys returns the master key material for the persistent credential store and the
+// previous key used for rotation. Keys are read from the configured value or key file; a nil
+// current key means the credential store is disabled.
+func (c *Config) CredentialKeys() (current, previous []byte, err error) {
+	current, err = readKeyMaterial(c.CredentialKey, c.CredentialKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous, err = readKeyMaterial(c.CredentialPreviousKey, c.CredentialPreviousKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return current, previous, nil
+}
+
+// readKeyMaterial returns the key from a literal value or, if set, the trimmed contents of a key file
+func readKeyMaterial(value, file string) ([]byte, error) {
+	if value != "" {
+		return []byte(value), nil
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "cannot read credential key file").
+			WithContext("path", file)
+	}
+
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return nil, errors.New(errors.CategoryConfig, "INVALID_CREDENTIAL_KEY", "credential key file is empty").
+			WithContext("path", file)
+	}
+
+	return []byte(key), nil
+}
+
 // IsDevMode checks if the server is running in development mode
 // Development mode is enabled when:
 // 1. SecurityDevMode is explicitly set to true, OR
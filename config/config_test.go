@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -853,3 +854,325 @@ func TestParseCommaSeparatedString(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCredentialStore(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "credential.key")
+	if err := os.WriteFile(keyFile, []byte("file-based-master-key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:    "Store disabled",
+			config:  &Config{},
+			wantErr: false,
+		},
+		{
+			name:    "Key from value",
+			config:  &Config{CredentialKey: "a-sufficiently-long-key"},
+			wantErr: false,
+		},
+		{
+			name:    "Key from file",
+			config:  &Config{CredentialKeyFile: keyFile},
+			wantErr: false,
+		},
+		{
+			name:    "Key too short",
+			config:  &Config{CredentialKey: "short"},
+			wantErr: true,
+		},
+		{
+			name:    "Both key and key file",
+			config:  &Config{CredentialKey: "a-sufficiently-long-key", CredentialKeyFile: keyFile},
+			wantErr: true,
+		},
+		{
+			name:    "Previous key without current key",
+			config:  &Config{CredentialPreviousKey: "a-sufficiently-long-key"},
+			wantErr: true,
+		},
+		{
+			name:    "Missing key file",
+			config:  &Config{CredentialKeyFile: filepath.Join(t.TempDir(), "missing.key")},
+			wantErr: true,
+		},
+		{
+			name:    "Rotation with previous key",
+			config:  &Config{CredentialKeyFile: keyFile, CredentialPreviousKey: "the-old-master-key"},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateCredentialStore()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCredentialStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialKeys(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "credential.key")
+	if err := os.WriteFile(keyFile, []byte("  file-based-master-key \n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	cfg := &Config{CredentialKeyFile: keyFile, CredentialPreviousKey: "the-old-master-key"}
+	current, previous, err := cfg.CredentialKeys()
+	if err != nil {
+		t.Fatalf("CredentialKeys() unexpected error: %v", err)
+	}
+	if string(current) != "file-based-master-key" {
+		t.Errorf("Expected trimmed key from file, got %q", current)
+	}
+	if string(previous) != "the-old-master-key" {
+		t.Errorf("Expected previous key, got %q", previous)
+	}
+
+	current, previous, err = (&Config{}).CredentialKeys()
+	if err != nil || current != nil || previous != nil {
+		t.Errorf("Expected no keys when store is disabled, got %q, %q, %v", current, previous, err)
+	}
+}
+
+func TestValidateSyncStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		wantErr  bool
+	}{
+		{"", false},
+		{SyncStrategyID3, false},
+		{SyncStrategySearch3, false},
+		{SyncStrategyFolder, false},
+		{"getIndexes", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			err := (&Config{SyncStrategy: tt.strategy}).validateSyncStrategy()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSyncStrategy(%q) error = %v, wantErr %v", tt.strategy, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFullSyncInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		wantErr  bool
+	}{
+		{0, false},
+		{DefaultFullSyncInterval, false},
+		{-time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.interval.String(), func(t *testing.T) {
+			err := (&Config{FullSyncInterval: tt.interval}).validateSyncStrategy()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSyncStrategy() with interval %v error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSyncCrawl(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"defaults", Config{SyncConcurrency: DefaultSyncConcurrency, SyncRequestRate: DefaultSyncRequestRate, SyncMaxRetries: DefaultSyncMaxRetries}, false},
+		{"unset uses defaults", Config{}, false},
+		{"negative concurrency", Config{SyncConcurrency: -1}, true},
+		{"negative request rate", Config{SyncRequestRate: -1}, true},
+		{"negative retries", Config{SyncMaxRetries: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateSyncCrawl()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSyncCrawl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAdminToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"disabled", "", false},
+		{"valid token", "0123456789abcdef", false},
+		{"too short", "short", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{AdminToken: tt.token}
+			err := c.validateAdminToken()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAdminToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateShuffleDiversity(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"all rules", Config{ShuffleMaxPerArtist: 2, ShuffleMaxPerAlbum: 1, ShuffleArtistSpacing: 3}, false},
+		{"negative artist limit", Config{ShuffleMaxPerArtist: -1}, true},
+		{"negative album limit", Config{ShuffleMaxPerAlbum: -1}, true},
+		{"negative spacing", Config{ShuffleArtistSpacing: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateShuffleDiversity()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateShuffleDiversity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateScrobbleDeviceFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"all fields", []string{ScrobbleDeviceFieldClient, ScrobbleDeviceFieldAddress, ScrobbleDeviceFieldUserAgent}, false},
+		{"unknown field", []string{ScrobbleDeviceFieldClient, "cookie"}, true},
+		{"empty field", []string{""}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{ScrobbleDeviceFields: tt.fields}).validateScrobbleDeviceFields()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateScrobbleDeviceFields() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateStreamPlayThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		wantErr   bool
+	}{
+		{"default", 0, false},
+		{"half", 0.5, false},
+		{"whole song", 1, false},
+		{"negative", -0.1, true},
+		{"above 1", 1.5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Config{StreamPlayThreshold: tt.threshold}).validateStreamPlayThreshold()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateStreamPlayThreshold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReplayWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"separate windows", Config{ReplayWindowPlayedDays: 14, ReplayWindowSkippedDays: 30}, false},
+		{"negative played window", Config{ReplayWindowPlayedDays: -1}, true},
+		{"negative skipped window", Config{ReplayWindowSkippedDays: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validateReplayWindows()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateReplayWindows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateKeyedRateLimits(t *testing.T) {
+	base := Config{RateLimitRPS: 10, RateLimitBurst: 20}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{"defaults", func(c *Config) {}, false},
+		{"user and expensive limits", func(c *Config) {
+			c.RateLimitUserRPS, c.RateLimitUserBurst = 5, 10
+			c.RateLimitExpensiveRPS, c.RateLimitExpensiveBurst = 1, 2
+		}, false},
+		{"negative user rps", func(c *Config) { c.RateLimitUserRPS = -1 }, true},
+		{"negative expensive burst", func(c *Config) { c.RateLimitExpensiveBurst = -1 }, true},
+		{"negative idle timeout", func(c *Config) { c.RateLimitIdleTimeout = -time.Second }, true},
+		{"trusted proxies", func(c *Config) { c.TrustedProxies = []string{"10.0.0.1", "172.16.0.0/12", "::1"} }, false},
+		{"invalid trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.local"} }, true},
+		{"invalid trusted proxy CIDR", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/33"} }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := base
+			tt.modify(&c)
+			err := c.validateRateLimit()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRateLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyedRateLimitFallbacks(t *testing.T) {
+	c := Config{RateLimitRPS: 10, RateLimitBurst: 20}
+
+	if rps, burst := c.UserRateLimit(); rps != 10 || burst != 20 {
+		t.Errorf("Expected user limits to fall back to client limits, got %d/%d", rps, burst)
+	}
+	if rps, burst := c.ExpensiveRateLimit(); rps != DefaultExpensiveRPS || burst != DefaultExpensiveBurst {
+		t.Errorf("Expected default expensive limits, got %d/%d", rps, burst)
+	}
+
+	c.RateLimitUserRPS, c.RateLimitUserBurst = 3, 6
+	if rps, burst := c.UserRateLimit(); rps != 3 || burst != 6 {
+		t.Errorf("Expected configured user limits, got %d/%d", rps, burst)
+	}
+
+	c.TrustedProxies = []string{"10.0.0.1", "", "fd00::/8"}
+	nets, err := c.TrustedProxyNets()
+	if err != nil {
+		t.Fatalf("TrustedProxyNets() error = %v", err)
+	}
+	if len(nets) != 2 || nets[0].String() != "10.0.0.1/32" || nets[1].String() != "fd00::/8" {
+		t.Errorf("Unexpected trusted proxy networks: %v", nets)
+	}
+}
@@ -1,11 +1,15 @@
 package credentials
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -16,6 +20,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
 )
 
 // Encryption constants
@@ -27,18 +32,49 @@ const (
 	ClientName             = "subsoxy"
 )
 
+// Authentication cache constants
+const (
+	AuthCacheTTL        = 5 * time.Minute  // How long a successful check is trusted
+	AuthFailureCacheTTL = 30 * time.Second // How long a rejected check is remembered
+	MaxAuthCacheEntries = 10000
+)
+
+type contextKey string
+
+const authenticatedUserKey contextKey = "authenticatedUser"
+
+// authCacheEntry records the outcome of a recent synchronous credential check
+type authCacheEntry struct {
+	username  string
+	valid     bool
+	expiresAt time.Time
+}
+
 // encryptedCredential holds encrypted password data
 type encryptedCredential struct {
 	EncryptedPassword []byte `json:"encrypted_password"`
 	Nonce             []byte `json:"nonce"`
 }
 
+// Store persists encrypted credentials across restarts
+type Store interface {
+	SaveCredential(credential models.StoredCredential) error
+	LoadCredentials() ([]models.StoredCredential, error)
+	DeleteCredential(username string) error
+}
+
 type Manager struct {
 	validCredentials map[string]encryptedCredential
 	mutex            sync.RWMutex
 	logger           *logrus.Logger
 	upstreamURL      string
 	encryptionKey    []byte
+	store            Store
+	keyID            string
+	authCache        map[string]authCacheEntry
+	authMutex        sync.Mutex
+	serverInfo       models.ServerInfo
+	serverInfoMutex  sync.RWMutex
 }
 
 func New(logger *logrus.Logger, upstreamURL string) *Manager {
@@ -50,7 +86,145 @@ func New(logger *logrus.Logger, upstreamURL string) *Manager {
 		logger:           logger,
 		upstreamURL:      upstreamURL,
 		encryptionKey:    encryptionKey,
+		authCache:        make(map[string]authCacheEntry),
+	}
+}
+
+// WithUser returns a copy of ctx carrying the authenticated username
+func WithUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, authenticatedUserKey, username)
+}
+
+// UserFromContext returns the authenticated username stored in ctx, or an empty
+// string if the request was not authenticated
+func UserFromContext(ctx context.Context) string {
+	if username, ok := ctx.Value(authenticatedUserKey).(string); ok {
+		return username
+	}
+	return ""
+}
+
+// Authenticate synchronously checks that username and password are accepted by the
+// upstream server. Results are cached so repeated requests don't hit upstream. Clients
+// salt every token afresh, so a verified token is cached for the user rather than for the
+// token, and tokens of users whose plain password is known are checked locally.
+// Returns true if the credentials were newly stored for background sync.
+func (cm *Manager) Authenticate(username, password string) (bool, error) {
+	if username == "" || password == "" {
+		return false, errors.ErrInvalidCredentials.WithContext("reason", "empty username or password")
 	}
+
+	tokenAuth := strings.HasPrefix(password, "TOKEN:")
+	if tokenAuth && cm.tokenMatchesPassword(username, password) {
+		return false, nil
+	}
+
+	cacheKey := authCacheKey(username, password)
+	now := time.Now()
+
+	if entry, cached := cm.cachedAuthResult(cacheKey, now); cached {
+		if entry.valid {
+			return false, nil
+		}
+		return false, errors.ErrInvalidCredentials.WithContext("username", username).
+			WithContext("reason", "recently rejected by upstream")
+	}
+	if tokenAuth {
+		if entry, cached := cm.cachedAuthResult(tokenAuthCacheKey(username), now); cached && entry.valid {
+			return false, nil
+		}
+	}
+
+	isNew, err := cm.ValidateAndStore(username, password)
+	switch {
+	case err == nil:
+		if tokenAuth {
+			cacheKey = tokenAuthCacheKey(username)
+		}
+		cm.cacheAuthResult(cacheKey, username, true, now.Add(AuthCacheTTL))
+	case errors.Is(err, errors.ErrInvalidCredentials):
+		cm.cacheAuthResult(cacheKey, username, false, now.Add(AuthFailureCacheTTL))
+	}
+	// Other errors (e.g. upstream unreachable) are not cached
+
+	return isNew, err
+}
+
+// tokenMatchesPassword reports whether a "TOKEN:token:salt" password is the token of the
+// user's stored plain password, which the Subsonic API defines as md5(password + salt)
+func (cm *Manager) tokenMatchesPassword(username, password string) bool {
+	parts := strings.Split(password, ":")
+	if len(parts) != 3 {
+		return false
+	}
+
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	storedCred, exists := cm.validCredentials[username]
+	if !exists {
+		return false
+	}
+	storedPassword, err := cm.decryptPassword(storedCred)
+	if err != nil || strings.HasPrefix(storedPassword, "TOKEN:") {
+		return false
+	}
+	hash := md5.Sum([]byte(storedPassword + parts[2]))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(strings.ToLower(parts[1]))) == 1
+}
+
+// cachedAuthResult returns the unexpired cached result of a credential check, if any
+func (cm *Manager) cachedAuthResult(cacheKey string, now time.Time) (authCacheEntry, bool) {
+	cm.authMutex.Lock()
+	defer cm.authMutex.Unlock()
+
+	entry, cached := cm.authCache[cacheKey]
+	return entry, cached && now.Before(entry.expiresAt)
+}
+
+func (cm *Manager) cacheAuthResult(cacheKey, username string, valid bool, expiresAt time.Time) {
+	cm.authMutex.Lock()
+	defer cm.authMutex.Unlock()
+
+	if len(cm.authCache) >= MaxAuthCacheEntries {
+		now := time.Now()
+		for key, entry := range cm.authCache {
+			if now.After(entry.expiresAt) {
+				delete(cm.authCache, key)
+			}
+		}
+		if len(cm.authCache) >= MaxAuthCacheEntries {
+			cm.authCache = make(map[string]authCacheEntry)
+		}
+	}
+
+	cm.authCache[cacheKey] = authCacheEntry{username: username, valid: valid, expiresAt: expiresAt}
+}
+
+// forgetAuthResults drops cached authentication results, for a single user or
+// for everyone when username is empty
+func (cm *Manager) forgetAuthResults(username string) {
+	cm.authMutex.Lock()
+	defer cm.authMutex.Unlock()
+
+	for key, entry := range cm.authCache {
+		if username == "" || entry.username == username {
+			delete(cm.authCache, key)
+		}
+	}
+}
+
+// tokenAuthCacheKey is the cache key of a user's verified tokens. Its prefix keeps it apart
+// from the keys of authCacheKey.
+func tokenAuthCacheKey(username string) string {
+	hash := sha256.Sum256([]byte(username))
+	return "token:" + hex.EncodeToString(hash[:])
+}
+
+// authCacheKey hashes the credentials so plain passwords are never kept as map keys
+func authCacheKey(username, password string) string {
+	hash := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(hash[:])
 }
 
 func (cm *Manager) ValidateAndStore(username, password string) (bool, error) {
@@ -79,10 +253,7 @@ func (cm *Manager) ValidateAndStore(username, password string) (bool, error) {
 		return false, errors.Wrap(err, errors.CategoryCredentials, "ENCRYPTION_FAILED", "failed to encrypt password")
 	}
 
-	cm.mutex.Lock()
-	isNewCredential := len(cm.validCredentials) == 0 || cm.validCredentials[username].EncryptedPassword == nil
-	cm.validCredentials[username] = encryptedCred
-	cm.mutex.Unlock()
+	isNewCredential := cm.storeValidated(username, password, encryptedCred)
 
 	// Only log when credentials are actually new or changed
 	if isNewCredential {
@@ -96,6 +267,29 @@ func (cm *Manager) ValidateAndStore(username, password string) (bool, error) {
 	return isNewCredential, nil
 }
 
+// storeValidated keeps validated credentials and persists them if they are new or changed.
+// The store is written with mutex held, so a late write can't undo a concurrent Remove.
+// Returns true if the user had no credentials before.
+func (cm *Manager) storeValidated(username, password string, encryptedCred encryptedCredential) bool {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	storedCred, exists := cm.validCredentials[username]
+	if exists {
+		if decryptedPassword, err := cm.decryptPassword(storedCred); err == nil && decryptedPassword == password {
+			return false // Stored meanwhile by a concurrent validation
+		}
+	}
+	cm.validCredentials[username] = encryptedCred
+
+	if cm.store != nil {
+		if err := cm.store.SaveCredential(toStoredCredential(username, encryptedCred, cm.keyID)); err != nil {
+			cm.logger.WithError(err).WithField("username", username).Warn("Failed to persist credentials")
+		}
+	}
+	return !exists
+}
+
 func (cm *Manager) validate(username, password string) error {
 	// Construct URL with proper encoding to prevent credential exposure in logs
 	baseURL, err := url.Parse(cm.upstreamURL + "/rest/ping")
@@ -159,6 +353,7 @@ func (cm *Manager) validate(username, password string) error {
 	}
 
 	if subsonicResp, ok := pingResp["subsonic-response"].(map[string]interface{}); ok {
+		cm.recordServerInfo(subsonicResp)
 		if status, ok := subsonicResp["status"].(string); ok {
 			if status == "ok" {
 				return nil
@@ -174,6 +369,38 @@ func (cm *Manager) validate(username, password string) error {
 		WithContext("reason", "invalid response format from upstream server")
 }
 
+// recordServerInfo remembers the version details the upstream server reported in a response
+func (cm *Manager) recordServerInfo(subsonicResp map[string]interface{}) {
+	version, _ := subsonicResp["version"].(string)
+	if version == "" {
+		return
+	}
+	serverType, _ := subsonicResp["type"].(string)
+	serverVersion, _ := subsonicResp["serverVersion"].(string)
+	openSubsonic, _ := subsonicResp["openSubsonic"].(bool)
+
+	cm.serverInfoMutex.Lock()
+	cm.serverInfo = models.ServerInfo{
+		Version:       version,
+		Type:          serverType,
+		ServerVersion: serverVersion,
+		OpenSubsonic:  openSubsonic,
+	}
+	cm.serverInfoMutex.Unlock()
+}
+
+// ServerInfo returns the version details last reported by the upstream server. The
+// version is SubsonicAPIVersion until the upstream server has been contacted.
+func (cm *Manager) ServerInfo() models.ServerInfo {
+	cm.serverInfoMutex.RLock()
+	defer cm.serverInfoMutex.RUnlock()
+
+	if cm.serverInfo.Version == "" {
+		return models.ServerInfo{Version: SubsonicAPIVersion}
+	}
+	return cm.serverInfo
+}
+
 func (cm *Manager) GetValid() (string, string) {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
@@ -226,8 +453,160 @@ func (cm *Manager) ClearInvalid() {
 				}
 			}
 		}
+		if cm.store != nil {
+			for username := range cm.validCredentials {
+				if err := cm.store.DeleteCredential(username); err != nil {
+					cm.logger.WithError(err).WithField("username", username).Warn("Failed to delete stored credentials")
+				}
+			}
+		}
 		cm.validCredentials = make(map[string]encryptedCredential)
 	}
+	cm.forgetAuthResults("")
+}
+
+// Remove forgets the credentials for a single user, both in memory and in the
+// persistent store. Used when upstream rejects previously valid credentials.
+func (cm *Manager) Remove(username string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cred, exists := cm.validCredentials[username]; exists {
+		for i := range cred.EncryptedPassword {
+			cred.EncryptedPassword[i] = 0
+		}
+		for i := range cred.Nonce {
+			cred.Nonce[i] = 0
+		}
+		delete(cm.validCredentials, username)
+	}
+
+	if cm.store != nil {
+		if err := cm.store.DeleteCredential(username); err != nil {
+			cm.logger.WithError(err).WithField("username", username).Warn("Failed to delete stored credentials")
+		}
+	}
+	cm.forgetAuthResults(username)
+}
+
+// EnablePersistence switches the manager to a key derived from masterKey and
+// loads previously stored credentials. Entries encrypted with previousKey are
+// re-encrypted with the current key (key rotation). Returns the number of
+// credentials restored.
+func (cm *Manager) EnablePersistence(store Store, masterKey, previousKey []byte) (int, error) {
+	if store == nil {
+		return 0, errors.New(errors.CategoryCredentials, "STORE_UNAVAILABLE", "credential store is nil")
+	}
+	if len(masterKey) == 0 {
+		return 0, errors.New(errors.CategoryCredentials, "INVALID_CREDENTIAL_KEY", "master key must not be empty")
+	}
+
+	newKey := DeriveKey(masterKey)
+	newKeyID := KeyID(newKey)
+
+	var oldKey []byte
+	oldKeyID := ""
+	if len(previousKey) > 0 {
+		oldKey = DeriveKey(previousKey)
+		oldKeyID = KeyID(oldKey)
+	}
+
+	stored, err := store.LoadCredentials()
+	if err != nil {
+		return 0, errors.Wrap(err, errors.CategoryCredentials, "STORE_LOAD_FAILED", "failed to load stored credentials")
+	}
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	// Re-encrypt credentials captured before persistence was enabled
+	current := make(map[string]encryptedCredential, len(cm.validCredentials))
+	for username, cred := range cm.validCredentials {
+		password, err := decryptWithKey(cm.encryptionKey, cred)
+		if err != nil {
+			continue
+		}
+		if reencrypted, err := encryptWithKey(newKey, password); err == nil {
+			current[username] = reencrypted
+		}
+	}
+
+	restored := 0
+	for _, entry := range stored {
+		if _, exists := current[entry.Username]; exists {
+			continue
+		}
+
+		cred := encryptedCredential{EncryptedPassword: entry.EncryptedPassword, Nonce: entry.Nonce}
+		switch {
+		case entry.KeyID == newKeyID:
+			if _, err := decryptWithKey(newKey, cred); err != nil {
+				cm.logger.WithError(err).WithField("username", entry.Username).Warn("Failed to decrypt stored credentials")
+				continue
+			}
+			current[entry.Username] = cred
+			restored++
+		case oldKeyID != "" && entry.KeyID == oldKeyID:
+			password, err := decryptWithKey(oldKey, cred)
+			if err != nil {
+				cm.logger.WithError(err).WithField("username", entry.Username).Warn("Failed to decrypt stored credentials with previous key")
+				continue
+			}
+			reencrypted, err := encryptWithKey(newKey, password)
+			if err != nil {
+				cm.logger.WithError(err).WithField("username", entry.Username).Warn("Failed to re-encrypt stored credentials")
+				continue
+			}
+			if err := store.SaveCredential(toStoredCredential(entry.Username, reencrypted, newKeyID)); err != nil {
+				cm.logger.WithError(err).WithField("username", entry.Username).Warn("Failed to persist re-encrypted credentials")
+			}
+			current[entry.Username] = reencrypted
+			restored++
+		default:
+			cm.logger.WithFields(logrus.Fields{
+				"username": entry.Username,
+				"keyID":    entry.KeyID,
+			}).Warn("Skipping stored credentials encrypted with an unknown key")
+		}
+	}
+
+	// Persist credentials that were only held in memory so far
+	for username, cred := range current {
+		if _, inMemory := cm.validCredentials[username]; inMemory {
+			if err := store.SaveCredential(toStoredCredential(username, cred, newKeyID)); err != nil {
+				cm.logger.WithError(err).WithField("username", username).Warn("Failed to persist credentials")
+			}
+		}
+	}
+
+	cm.validCredentials = current
+	cm.encryptionKey = newKey
+	cm.keyID = newKeyID
+	cm.store = store
+
+	return restored, nil
+}
+
+// DeriveKey derives an AES-256 key from arbitrary master key material
+func DeriveKey(masterKey []byte) []byte {
+	hash := sha256.Sum256(masterKey)
+	return hash[:]
+}
+
+// KeyID returns a non-secret identifier for an encryption key
+func KeyID(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:KeyIdentificationBytes])
+}
+
+func toStoredCredential(username string, cred encryptedCredential, keyID string) models.StoredCredential {
+	return models.StoredCredential{
+		Username:          username,
+		EncryptedPassword: cred.EncryptedPassword,
+		Nonce:             cred.Nonce,
+		KeyID:             keyID,
+		UpdatedAt:         time.Now(),
+	}
 }
 
 // generateEncryptionKey creates a random 32-byte key for AES-256
@@ -245,7 +624,16 @@ func generateEncryptionKey() []byte {
 
 // encryptPassword encrypts a password using AES-256-GCM
 func (cm *Manager) encryptPassword(password string) (encryptedCredential, error) {
-	block, err := aes.NewCipher(cm.encryptionKey)
+	return encryptWithKey(cm.encryptionKey, password)
+}
+
+// decryptPassword decrypts a password using AES-256-GCM
+func (cm *Manager) decryptPassword(cred encryptedCredential) (string, error) {
+	return decryptWithKey(cm.encryptionKey, cred)
+}
+
+func encryptWithKey(key []byte, password string) (encryptedCredential, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return encryptedCredential{}, err
 	}
@@ -268,9 +656,8 @@ func (cm *Manager) encryptPassword(password string) (encryptedCredential, error)
 	}, nil
 }
 
-// decryptPassword decrypts a password using AES-256-GCM
-func (cm *Manager) decryptPassword(cred encryptedCredential) (string, error) {
-	block, err := aes.NewCipher(cm.encryptionKey)
+func decryptWithKey(key []byte, cred encryptedCredential) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
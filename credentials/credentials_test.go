@@ -1,14 +1,18 @@
 package credentials
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/syeo66/subsoxy/models"
 )
 
 func TestNew(t *testing.T) {
@@ -60,6 +64,39 @@ func TestValidateAndStoreValidCredentials(t *testing.T) {
 	}
 }
 
+func TestServerInfo(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{
+			"subsonic-response": map[string]interface{}{
+				"status":        "ok",
+				"version":       "1.16.1",
+				"type":          "navidrome",
+				"serverVersion": "0.53.3",
+				"openSubsonic":  true,
+			},
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer mockServer.Close()
+
+	manager := New(logger, mockServer.URL)
+	if info := manager.ServerInfo(); info != (models.ServerInfo{Version: SubsonicAPIVersion}) {
+		t.Errorf("Expected default server info before contacting upstream, got %+v", info)
+	}
+
+	if _, err := manager.ValidateAndStore("testuser", "testpass"); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	expected := models.ServerInfo{Version: "1.16.1", Type: "navidrome", ServerVersion: "0.53.3", OpenSubsonic: true}
+	if info := manager.ServerInfo(); info != expected {
+		t.Errorf("Expected server info %+v, got %+v", expected, info)
+	}
+}
+
 func TestValidateAndStoreInvalidCredentials(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -793,3 +830,326 @@ func TestNetworkPartialResponse(t *testing.T) {
 		t.Error("Expected validation failure due to incomplete response, got success")
 	}
 }
+
+// memoryStore is an in-memory Store used to test credential persistence
+type memoryStore struct {
+	mu          sync.Mutex
+	credentials map[string]models.StoredCredential
+	saves       int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{credentials: make(map[string]models.StoredCredential)}
+}
+
+func (s *memoryStore) SaveCredential(credential models.StoredCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[credential.Username] = credential
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) LoadCredentials() ([]models.StoredCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []models.StoredCredential
+	for _, credential := range s.credentials {
+		result = append(result, credential)
+	}
+	return result, nil
+}
+
+func (s *memoryStore) DeleteCredential(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.credentials, username)
+	return nil
+}
+
+func newOKUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subsonic-response": map[string]interface{}{"status": "ok", "version": "1.15.0"},
+		})
+	}))
+}
+
+func TestPersistenceRoundTrip(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	mockServer := newOKUpstream()
+	defer mockServer.Close()
+
+	store := newMemoryStore()
+	masterKey := []byte("a-sufficiently-long-master-key")
+
+	// Credentials captured before persistence is enabled are persisted too
+	first := New(logger, mockServer.URL)
+	if _, err := first.ValidateAndStore("early", "earlypass"); err != nil {
+		t.Fatalf("ValidateAndStore failed: %v", err)
+	}
+	if _, err := first.EnablePersistence(store, masterKey, nil); err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	if _, err := first.ValidateAndStore("testuser", "TOKEN:abc:def"); err != nil {
+		t.Fatalf("ValidateAndStore failed: %v", err)
+	}
+
+	if len(store.credentials) != 2 {
+		t.Fatalf("Expected 2 persisted credentials, got %d", len(store.credentials))
+	}
+	for _, credential := range store.credentials {
+		if strings.Contains(string(credential.EncryptedPassword), "pass") {
+			t.Error("Persisted password should be encrypted")
+		}
+	}
+
+	// A new manager (simulating a restart) restores the credentials
+	second := New(logger, mockServer.URL)
+	restored, err := second.EnablePersistence(store, masterKey, nil)
+	if err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("Expected 2 restored credentials, got %d", restored)
+	}
+	all := second.GetAllValid()
+	if all["early"] != "earlypass" || all["testuser"] != "TOKEN:abc:def" {
+		t.Errorf("Unexpected restored credentials: %v", all)
+	}
+
+	// A manager with a different key cannot read them
+	third := New(logger, mockServer.URL)
+	restored, err = third.EnablePersistence(store, []byte("a-completely-different-key"), nil)
+	if err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	if restored != 0 || len(third.GetAllValid()) != 0 {
+		t.Errorf("Expected no credentials restored with wrong key, got %d", restored)
+	}
+}
+
+func TestPersistenceWritesChangesOnly(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	mockServer := newOKUpstream()
+	defer mockServer.Close()
+
+	store := newMemoryStore()
+	manager := New(logger, mockServer.URL)
+	if _, err := manager.EnablePersistence(store, []byte("a-sufficiently-long-master-key"), nil); err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := manager.ValidateAndStore("testuser", "testpass"); err != nil {
+			t.Fatalf("ValidateAndStore failed: %v", err)
+		}
+	}
+	if store.saves != 1 {
+		t.Errorf("Expected unchanged credentials to be persisted once, got %d writes", store.saves)
+	}
+
+	if _, err := manager.ValidateAndStore("testuser", "newpass"); err != nil {
+		t.Fatalf("ValidateAndStore failed: %v", err)
+	}
+	if store.saves != 2 {
+		t.Errorf("Expected changed credentials to be persisted, got %d writes", store.saves)
+	}
+}
+
+func TestPersistenceKeyRotation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	mockServer := newOKUpstream()
+	defer mockServer.Close()
+
+	store := newMemoryStore()
+	oldKey := []byte("the-old-master-key")
+	newKey := []byte("the-new-master-key")
+
+	original := New(logger, mockServer.URL)
+	if _, err := original.EnablePersistence(store, oldKey, nil); err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	if _, err := original.ValidateAndStore("testuser", "testpass"); err != nil {
+		t.Fatalf("ValidateAndStore failed: %v", err)
+	}
+
+	rotated := New(logger, mockServer.URL)
+	restored, err := rotated.EnablePersistence(store, newKey, oldKey)
+	if err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	if restored != 1 {
+		t.Fatalf("Expected 1 restored credential, got %d", restored)
+	}
+	if store.credentials["testuser"].KeyID != KeyID(DeriveKey(newKey)) {
+		t.Error("Stored credential should be re-encrypted with the new key")
+	}
+
+	// After rotation the old key is no longer needed
+	restarted := New(logger, mockServer.URL)
+	if restored, _ := restarted.EnablePersistence(store, newKey, nil); restored != 1 {
+		t.Errorf("Expected 1 restored credential after rotation, got %d", restored)
+	}
+	if user, pass := restarted.GetValid(); user != "testuser" || pass != "testpass" {
+		t.Errorf("Expected testuser/testpass, got %s/%s", user, pass)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	mockServer := newOKUpstream()
+	defer mockServer.Close()
+
+	store := newMemoryStore()
+	manager := New(logger, mockServer.URL)
+	if _, err := manager.EnablePersistence(store, []byte("a-sufficiently-long-master-key"), nil); err != nil {
+		t.Fatalf("EnablePersistence failed: %v", err)
+	}
+	manager.ValidateAndStore("user1", "pass1")
+	manager.ValidateAndStore("user2", "pass2")
+
+	manager.Remove("user1")
+
+	all := manager.GetAllValid()
+	if _, exists := all["user1"]; exists {
+		t.Error("user1 should be removed from memory")
+	}
+	if _, exists := store.credentials["user1"]; exists {
+		t.Error("user1 should be removed from the store")
+	}
+	if all["user2"] != "pass2" {
+		t.Error("user2 should be unaffected")
+	}
+
+	manager.ClearInvalid()
+	if len(store.credentials) != 0 {
+		t.Errorf("ClearInvalid should purge the store, %d entries left", len(store.credentials))
+	}
+}
+
+func TestAuthenticateCachesResults(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	var requestCount int
+	var mu sync.Mutex
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestCount++
+		mu.Unlock()
+		status := "ok"
+		if strings.HasPrefix(r.URL.Query().Get("t"), "bad") {
+			status = "failed"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subsonic-response": map[string]interface{}{"status": status, "version": "1.15.0"},
+		})
+	}))
+	defer mockServer.Close()
+
+	manager := New(logger, mockServer.URL)
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requestCount
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := manager.Authenticate("testuser", "TOKEN:badtoken:salt"); err == nil {
+			t.Error("Expected rejected credentials to fail")
+		}
+	}
+	if requests() != 1 {
+		t.Errorf("Expected rejected credentials to be validated once, got %d upstream calls", requests())
+	}
+
+	isNew, err := manager.Authenticate("testuser", "TOKEN:goodtoken:salt")
+	if err != nil || !isNew {
+		t.Fatalf("Expected new valid credentials, got isNew=%v err=%v", isNew, err)
+	}
+	if _, err := manager.Authenticate("testuser", "TOKEN:goodtoken:salt"); err != nil {
+		t.Errorf("Expected cached credentials to be valid, got %v", err)
+	}
+	if requests() != 2 {
+		t.Errorf("Expected a single upstream validation, got %d", requests())
+	}
+
+	// Removing the user drops its cached results
+	manager.Remove("testuser")
+	if _, err := manager.Authenticate("testuser", "TOKEN:goodtoken:salt"); err != nil {
+		t.Errorf("Expected credentials to validate again, got %v", err)
+	}
+	if requests() != 3 {
+		t.Errorf("Expected revalidation after Remove, got %d upstream calls", requests())
+	}
+}
+
+func TestAuthenticateTokensWithFreshSalts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	var requestCount int
+	var mu sync.Mutex
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestCount++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subsonic-response": map[string]interface{}{"status": "ok", "version": "1.15.0"},
+		})
+	}))
+	defer mockServer.Close()
+
+	manager := New(logger, mockServer.URL)
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requestCount
+	}
+	token := func(password, salt string) string {
+		hash := md5.Sum([]byte(password + salt))
+		return "TOKEN:" + hex.EncodeToString(hash[:]) + ":" + salt
+	}
+
+	// Clients salt every request afresh: only the first token is checked upstream
+	for _, salt := range []string{"salt1", "salt2"} {
+		if _, err := manager.Authenticate("tokenuser", token("secret", salt)); err != nil {
+			t.Fatalf("Expected token to be valid, got %v", err)
+		}
+	}
+	if requests() != 1 {
+		t.Errorf("Expected a single upstream validation for two salts, got %d", requests())
+	}
+
+	// Tokens of a user whose password is known are checked locally
+	if _, err := manager.Authenticate("passworduser", "secret"); err != nil {
+		t.Fatalf("Expected password to be valid, got %v", err)
+	}
+	if _, err := manager.Authenticate("passworduser", token("secret", "salt3")); err != nil {
+		t.Errorf("Expected token of the known password to be valid, got %v", err)
+	}
+	if requests() != 2 {
+		t.Errorf("Expected the token to be verified locally, got %d upstream calls", requests())
+	}
+}
+
+func TestUserFromContext(t *testing.T) {
+	req := httptest.NewRequest("GET", "/rest/ping", nil)
+	if user := UserFromContext(req.Context()); user != "" {
+		t.Errorf("Expected no user, got %q", user)
+	}
+
+	ctx := WithUser(req.Context(), "testuser")
+	if user := UserFromContext(ctx); user != "testuser" {
+		t.Errorf("Expected testuser, got %q", user)
+	}
+}
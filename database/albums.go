@@ -0,0 +1,124 @@
+package database
+
+import (
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// AlbumGrouping selects the songs column albums are grouped by
+type AlbumGrouping string
+
+// Album groupings: ID3 albums for getAlbumList2, album directories for getAlbumList
+const (
+	AlbumGroupingID3       AlbumGrouping = "album_id"
+	AlbumGroupingDirectory AlbumGrouping = "parent"
+)
+
+// AlbumOrder selects which albums GetAlbums returns and in which order. The values
+// match the getAlbumList type parameter.
+type AlbumOrder string
+
+// Album orders
+const (
+	AlbumOrderName     AlbumOrder = "alphabeticalByName" // All albums by name
+	AlbumOrderFrequent AlbumOrder = "frequent"           // Played albums, most played first
+	AlbumOrderRecent   AlbumOrder = "recent"             // Played albums, most recently played first
+	AlbumOrderHighest  AlbumOrder = "highest"            // Played albums, highest Bayesian play ratio first
+)
+
+var albumOrderClauses = map[AlbumOrder]string{
+	AlbumOrderName:     `ORDER BY name COLLATE NOCASE, album_key`,
+	AlbumOrderFrequent: `HAVING plays > 0 ORDER BY plays DESC, played DESC, album_key`,
+	AlbumOrderRecent:   `HAVING plays > 0 ORDER BY played DESC, album_key`,
+	AlbumOrderHighest: `HAVING plays > 0 ORDER BY (adjusted_plays + 1.0) / (adjusted_plays + adjusted_skips + 2.0) DESC,
+		plays DESC, album_key`,
+}
+
+// AlbumQuery selects the albums returned by GetAlbums
+type AlbumQuery struct {
+	Grouping AlbumGrouping
+	Order    AlbumOrder
+	Filter   models.SongFilter
+	Limit    int // Zero returns all albums
+	Offset   int
+}
+
+// GetAlbums returns the user's albums, aggregated from the songs table and the user's play
+// events. Songs without an ID for the requested grouping are left out.
+func (db *DB) GetAlbums(userID string, query AlbumQuery) ([]models.Album, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if query.Grouping != AlbumGroupingID3 && query.Grouping != AlbumGroupingDirectory {
+		return nil, errors.ErrValidationFailed.WithContext("field", "grouping")
+	}
+	orderClause, ok := albumOrderClauses[query.Order]
+	if !ok {
+		return nil, errors.ErrValidationFailed.WithContext("field", "order")
+	}
+	if query.Limit < 0 || query.Offset < 0 {
+		return nil, errors.ErrValidationFailed.WithContext("field", "limit")
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = -1 // No limit in SQLite
+	}
+
+	column := string(query.Grouping)
+	filterClause, filterArgs := songFilterClause(query.Filter)
+	args := append([]interface{}{userID, userID}, filterArgs...)
+	args = append(args, limit, query.Offset)
+
+	rows, err := db.conn.Query(`SELECT `+column+` AS album_key,
+			MAX(album) AS name,
+			MAX(artist),
+			MAX(COALESCE(artist_id, '')),
+			MAX(COALESCE(cover_art, '')),
+			COUNT(*),
+			SUM(duration),
+			MAX(COALESCE(year, 0)),
+			MAX(COALESCE(genre, '')),
+			COALESCE(SUM(p.plays), 0) AS plays,
+			COALESCE(MAX(p.played), '1970-01-01') AS played,
+			SUM(COALESCE(adjusted_plays, 0.0)) AS adjusted_plays,
+			SUM(COALESCE(adjusted_skips, 0.0)) AS adjusted_skips
+		FROM songs s
+		LEFT JOIN (
+			SELECT song_id, COUNT(*) AS plays, MAX(timestamp) AS played FROM play_events
+			WHERE user_id = ? AND event_type = 'play' GROUP BY song_id
+		) p ON p.song_id = s.id
+		WHERE s.user_id = ? AND COALESCE(`+column+`, '') != ''`+filterClause+`
+		GROUP BY `+column+` `+orderClause+` LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query albums").
+			WithContext("userID", userID).
+			WithContext("order", string(query.Order))
+	}
+	defer rows.Close()
+
+	var albums []models.Album
+	for rows.Next() {
+		var album models.Album
+		var playedStr string
+		if err := rows.Scan(&album.ID, &album.Name, &album.Artist, &album.ArtistID, &album.CoverArt,
+			&album.SongCount, &album.Duration, &album.Year, &album.Genre, &album.PlayCount, &playedStr,
+			&album.AdjustedPlays, &album.AdjustedSkips); err != nil {
+			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan album")
+			continue
+		}
+
+		if playedStr != DefaultDateString {
+			album.LastPlayed, _ = parseTimestamp(playedStr)
+		}
+
+		albums = append(albums, album)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "error occurred during album iteration").
+			WithContext("userID", userID)
+	}
+
+	return albums, nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newAlbumsTestDB creates a database with three albums: Album A played three times,
+// Album B played once after that and Album C never played
+func newAlbumsTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Parent: "dir-a", Duration: 100, Year: 2001, MusicFolderID: "1"},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Parent: "dir-a", Duration: 150, Year: 2001, MusicFolderID: "1"},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", Parent: "dir-b", Duration: 200, MusicFolderID: "2"},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", AlbumID: "al-c", Parent: "dir-c", Duration: 300, MusicFolderID: "1"},
+		{ID: "x1", Title: "X1", Artist: "Artist X", Album: "Loose", Duration: 300},
+	}
+	db := newTestDB(t, songs)
+
+	for _, event := range []struct{ songID, eventType string }{
+		{"a1", "play"}, {"a2", "play"}, {"a1", "play"}, {"c1", "skip"}, {"b1", "play"},
+	} {
+		if err := db.RecordPlayEvent("testuser", event.songID, event.eventType, nil); err != nil {
+			t.Fatalf("Failed to record play event: %v", err)
+		}
+	}
+
+	return db
+}
+
+func albumIDs(albums []models.Album) []string {
+	ids := make([]string, 0, len(albums))
+	for _, album := range albums {
+		ids = append(ids, album.ID)
+	}
+	return ids
+}
+
+func TestGetAlbums(t *testing.T) {
+	db := newAlbumsTestDB(t)
+
+	tests := []struct {
+		name     string
+		query    AlbumQuery
+		expected []string
+	}{
+		{"frequent", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderFrequent}, []string{"al-a", "al-b"}},
+		{"recent", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderRecent}, []string{"al-b", "al-a"}},
+		{"highest", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderHighest}, []string{"al-a", "al-b"}},
+		{"by name", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderName}, []string{"al-a", "al-b", "al-c"}},
+		{"directories", AlbumQuery{Grouping: AlbumGroupingDirectory, Order: AlbumOrderFrequent}, []string{"dir-a", "dir-b"}},
+		{"paged", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderName, Limit: 1, Offset: 1}, []string{"al-b"}},
+		{"music folder", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderFrequent, Filter: models.SongFilter{MusicFolderID: "2"}}, []string{"al-b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			albums, err := db.GetAlbums("testuser", tt.query)
+			if err != nil {
+				t.Fatalf("GetAlbums failed: %v", err)
+			}
+			ids := albumIDs(albums)
+			if len(ids) != len(tt.expected) {
+				t.Fatalf("Expected albums %v, got %v", tt.expected, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.expected[i] {
+					t.Errorf("Expected albums %v, got %v", tt.expected, ids)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestGetAlbumsAggregates(t *testing.T) {
+	db := newAlbumsTestDB(t)
+
+	albums, err := db.GetAlbums("testuser", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderFrequent, Limit: 1})
+	if err != nil {
+		t.Fatalf("GetAlbums failed: %v", err)
+	}
+	if len(albums) != 1 {
+		t.Fatalf("Expected one album, got %d", len(albums))
+	}
+
+	album := albums[0]
+	if album.Name != "Album A" || album.Artist != "Artist A" || album.Year != 2001 {
+		t.Errorf("Unexpected album metadata: %+v", album)
+	}
+	if album.SongCount != 2 || album.Duration != 250 || album.PlayCount != 3 {
+		t.Errorf("Expected 2 songs, 250s and 3 plays, got %d songs, %ds and %d plays", album.SongCount, album.Duration, album.PlayCount)
+	}
+	if album.LastPlayed.IsZero() {
+		t.Error("Expected the last play time to be set")
+	}
+}
+
+func TestGetAlbumsValidation(t *testing.T) {
+	db := newAlbumsTestDB(t)
+
+	invalid := []AlbumQuery{
+		{Grouping: "title; DROP TABLE songs", Order: AlbumOrderFrequent},
+		{Grouping: AlbumGroupingID3, Order: "newest"},
+		{Grouping: AlbumGroupingID3, Order: AlbumOrderFrequent, Offset: -1},
+	}
+	for _, query := range invalid {
+		if _, err := db.GetAlbums("testuser", query); err == nil {
+			t.Errorf("Expected query %+v to be rejected", query)
+		}
+	}
+
+	if albums, err := db.GetAlbums("otheruser", AlbumQuery{Grouping: AlbumGroupingID3, Order: AlbumOrderName}); err != nil || len(albums) != 0 {
+		t.Errorf("Albums must be isolated per user, got %v (%v)", albumIDs(albums), err)
+	}
+}
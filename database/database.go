@@ -27,6 +27,8 @@ const (
 const (
 	DefaultTransitionProbability = 0.5
 	DefaultDateString            = "1970-01-01"
+	MaxSyncHistoryPerUser        = 100  // Sync runs kept per user, older runs are pruned
+	DefaultDecayFactor           = 0.95 // Factor applied to adjusted plays/skips on every new event
 )
 
 // parseTimestamp tries multiple datetime formats to parse SQLite timestamps
@@ -199,6 +201,68 @@ func (db *DB) createTables() error {
 			ratio REAL DEFAULT 0.5,
 			PRIMARY KEY (user_id, artist)
 		)`,
+		`CREATE TABLE IF NOT EXISTS stored_credentials (
+			username TEXT PRIMARY KEY,
+			encrypted_password BLOB NOT NULL,
+			nonce BLOB NOT NULL,
+			key_id TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_cursors (
+			user_id TEXT PRIMARY KEY,
+			strategy TEXT NOT NULL,
+			last_full_sync DATETIME,
+			last_incremental_sync DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_folder_cursors (
+			user_id TEXT NOT NULL,
+			folder_id TEXT NOT NULL,
+			last_modified INTEGER DEFAULT 0,
+			album_cursor DATETIME,
+			PRIMARY KEY (user_id, folder_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			strategy TEXT NOT NULL,
+			full_sync BOOLEAN NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME NOT NULL,
+			duration_ms INTEGER DEFAULT 0,
+			total INTEGER DEFAULT 0,
+			added INTEGER DEFAULT 0,
+			updated INTEGER DEFAULT 0,
+			deleted INTEGER DEFAULT 0,
+			unchanged INTEGER DEFAULT 0,
+			error TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_settings (
+			user_id TEXT PRIMARY KEY,
+			replay_window_played_days INTEGER,
+			replay_window_skipped_days INTEGER,
+			weighting_profile TEXT,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS server_settings (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS scrobble_state (
+			user_id TEXT PRIMARY KEY,
+			last_played_song_id TEXT,
+			last_played_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_scrobbles (
+			user_id TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			song_id TEXT NOT NULL,
+			duration INTEGER DEFAULT 0,
+			submission BOOLEAN NOT NULL DEFAULT 0,
+			scrobbled_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, device_id)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_songs_user_id ON songs(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_play_events_user_id ON play_events(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_play_events_song_id ON play_events(song_id)`,
@@ -207,6 +271,7 @@ func (db *DB) createTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_song_transitions_from ON song_transitions(from_song_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_artist_stats_user_id ON artist_stats(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_artist_stats_artist ON artist_stats(artist)`,
+		`CREATE INDEX IF NOT EXISTS idx_sync_history_user_started ON sync_history(user_id, started_at)`,
 	}
 
 	for _, query := range queries {
@@ -236,6 +301,16 @@ func (db *DB) createTables() error {
 		return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_FAILED", "failed to add adjusted play/skip columns")
 	}
 
+	// Add genre, year, music_folder_id and the remaining song metadata columns if they don't exist
+	if err := db.addSongMetadataColumns(); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_FAILED", "failed to add song metadata columns")
+	}
+
+	// Add weighting_profile column to user settings if it doesn't exist
+	if err := db.addUserSettingsWeightingColumn(); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_FAILED", "failed to add weighting_profile column")
+	}
+
 	// Migrate artist statistics for existing users
 	if err := db.MigrateArtistStats(); err != nil {
 		db.logger.WithError(err).Warn("Failed to migrate artist statistics (non-fatal)")
@@ -336,6 +411,27 @@ func (db *DB) addCoverArtColumn() error {
 	return nil
 }
 
+// addUserSettingsWeightingColumn adds the weighting_profile column to the user_settings table if it doesn't exist
+func (db *DB) addUserSettingsWeightingColumn() error {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('user_settings') WHERE name='weighting_profile'`).Scan(&count)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_CHECK_FAILED", "failed to check for weighting_profile column")
+	}
+
+	// If column already exists, no migration needed
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := db.conn.Exec(`ALTER TABLE user_settings ADD COLUMN weighting_profile TEXT`); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_FAILED", "failed to add weighting_profile column")
+	}
+
+	db.logger.Info("Added weighting_profile column to user_settings table")
+	return nil
+}
+
 // addLastSkippedColumn adds the last_skipped column to the songs table if it doesn't exist
 func (db *DB) addLastSkippedColumn() error {
 	// Check if last_skipped column already exists
@@ -396,6 +492,97 @@ func (db *DB) addAdjustedPlaySkipColumns() error {
 	return nil
 }
 
+// addSongMetadataColumns adds the genre, year, music folder and other upstream metadata columns to the songs table if they don't exist
+func (db *DB) addSongMetadataColumns() error {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"genre", "TEXT"},
+		{"year", "INTEGER"},
+		{"music_folder_id", "TEXT"},
+		{"album_id", "TEXT"},
+		{"artist_id", "TEXT"},
+		{"track", "INTEGER"},
+		{"suffix", "TEXT"},
+		{"content_type", "TEXT"},
+		{"bit_rate", "INTEGER"},
+		{"size", "INTEGER"},
+		{"path", "TEXT"},
+		{"parent", "TEXT"},
+	}
+
+	for _, column := range columns {
+		var count int
+		err := db.conn.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('songs') WHERE name=?`, column.name).Scan(&count)
+		if err != nil {
+			return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_CHECK_FAILED", "failed to check for column").
+				WithContext("column", column.name)
+		}
+
+		// If column already exists, no migration needed
+		if count > 0 {
+			continue
+		}
+
+		if _, err := db.conn.Exec(`ALTER TABLE songs ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return errors.Wrap(err, errors.CategoryDatabase, "MIGRATION_FAILED", "failed to add column").
+				WithContext("column", column.name)
+		}
+
+		db.logger.WithField("column", column.name).Info("Added column to songs table")
+	}
+
+	return nil
+}
+
+// songMetadataColumns selects the upstream metadata stored for a song, in the order of songMetadataFields
+const songMetadataColumns = `COALESCE(cover_art, '') as cover_art,
+		COALESCE(genre, '') as genre,
+		COALESCE(year, 0) as year,
+		COALESCE(music_folder_id, '') as music_folder_id,
+		COALESCE(album_id, '') as album_id,
+		COALESCE(artist_id, '') as artist_id,
+		COALESCE(track, 0) as track,
+		COALESCE(suffix, '') as suffix,
+		COALESCE(content_type, '') as content_type,
+		COALESCE(bit_rate, 0) as bit_rate,
+		COALESCE(size, 0) as size,
+		COALESCE(path, '') as path,
+		COALESCE(parent, '') as parent`
+
+// songMetadataFields returns the scan destinations for songMetadataColumns
+func songMetadataFields(song *models.Song) []interface{} {
+	return []interface{}{&song.CoverArt, &song.Genre, &song.Year, &song.MusicFolderID,
+		&song.AlbumID, &song.ArtistID, &song.Track, &song.Suffix, &song.ContentType, &song.BitRate, &song.Size, &song.Path, &song.Parent}
+}
+
+// songFilterClause builds the SQL conditions and arguments restricting songs to a filter.
+// The returned clause starts with " AND" so it can be appended to an existing WHERE clause.
+func songFilterClause(filter models.SongFilter) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+
+	if filter.Genre != "" {
+		clause.WriteString(" AND COALESCE(genre, '') = ? COLLATE NOCASE")
+		args = append(args, filter.Genre)
+	}
+	if filter.FromYear > 0 {
+		clause.WriteString(" AND COALESCE(year, 0) >= ?")
+		args = append(args, filter.FromYear)
+	}
+	if filter.ToYear > 0 {
+		clause.WriteString(" AND COALESCE(year, 0) > 0 AND COALESCE(year, 0) <= ?")
+		args = append(args, filter.ToYear)
+	}
+	if filter.MusicFolderID != "" {
+		clause.WriteString(" AND COALESCE(music_folder_id, '') = ?")
+		args = append(args, filter.MusicFolderID)
+	}
+
+	return clause.String(), args
+}
+
 func (db *DB) StoreSongs(userID string, songs []models.Song) error {
 	if userID == "" {
 		return errors.ErrValidationFailed.WithContext("field", "userID")
@@ -407,8 +594,8 @@ func (db *DB) StoreSongs(userID string, songs []models.Song) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO songs (id, user_id, title, artist, album, duration, cover_art, play_count, skip_count, last_played, last_skipped, adjusted_plays, adjusted_skips)
-		VALUES (?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT play_count FROM songs WHERE id = ? AND user_id = ?), 0), COALESCE((SELECT skip_count FROM songs WHERE id = ? AND user_id = ?), 0), COALESCE((SELECT last_played FROM songs WHERE id = ? AND user_id = ?), NULL), COALESCE((SELECT last_skipped FROM songs WHERE id = ? AND user_id = ?), NULL), COALESCE((SELECT adjusted_plays FROM songs WHERE id = ? AND user_id = ?), 0.0), COALESCE((SELECT adjusted_skips FROM songs WHERE id = ? AND user_id = ?), 0.0))`)
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO songs (id, user_id, title, artist, album, duration, cover_art, genre, year, music_folder_id, album_id, artist_id, track, suffix, content_type, bit_rate, size, path, parent, play_count, skip_count, last_played, last_skipped, adjusted_plays, adjusted_skips)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT play_count FROM songs WHERE id = ? AND user_id = ?), 0), COALESCE((SELECT skip_count FROM songs WHERE id = ? AND user_id = ?), 0), COALESCE((SELECT last_played FROM songs WHERE id = ? AND user_id = ?), NULL), COALESCE((SELECT last_skipped FROM songs WHERE id = ? AND user_id = ?), NULL), COALESCE((SELECT adjusted_plays FROM songs WHERE id = ? AND user_id = ?), 0.0), COALESCE((SELECT adjusted_skips FROM songs WHERE id = ? AND user_id = ?), 0.0))`)
 	if err != nil {
 		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to prepare song insert statement")
 	}
@@ -416,7 +603,8 @@ func (db *DB) StoreSongs(userID string, songs []models.Song) error {
 
 	var failedSongs []string
 	for _, song := range songs {
-		_, err := stmt.Exec(song.ID, userID, song.Title, song.Artist, song.Album, song.Duration, song.CoverArt, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID)
+		_, err := stmt.Exec(song.ID, userID, song.Title, song.Artist, song.Album, song.Duration, song.CoverArt, song.Genre, song.Year, song.MusicFolderID,
+			song.AlbumID, song.ArtistID, song.Track, song.Suffix, song.ContentType, song.BitRate, song.Size, song.Path, song.Parent, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID, song.ID, userID)
 		if err != nil {
 			db.logger.WithError(err).WithFields(logrus.Fields{
 				"songId": song.ID,
@@ -448,7 +636,7 @@ func (db *DB) GetAllSongs(userID string) ([]models.Song, error) {
 		COALESCE(skip_count, 0) as skip_count,
 		COALESCE(adjusted_plays, 0.0) as adjusted_plays,
 		COALESCE(adjusted_skips, 0.0) as adjusted_skips,
-		COALESCE(cover_art, '') as cover_art
+		`+songMetadataColumns+`
 		FROM songs WHERE user_id = ?`, userID)
 	if err != nil {
 		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query songs").
@@ -460,8 +648,9 @@ func (db *DB) GetAllSongs(userID string) ([]models.Song, error) {
 	for rows.Next() {
 		var song models.Song
 		var lastPlayedStr, lastSkippedStr string
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album,
-			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips, &song.CoverArt)
+		err := rows.Scan(append([]interface{}{&song.ID, &song.Title, &song.Artist, &song.Album,
+			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips},
+			songMetadataFields(&song)...)...)
 		if err != nil {
 			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan song")
 			continue
@@ -521,7 +710,7 @@ func (db *DB) GetSongsBatch(userID string, limit, offset int) ([]models.Song, er
 		COALESCE(skip_count, 0) as skip_count,
 		COALESCE(adjusted_plays, 0.0) as adjusted_plays,
 		COALESCE(adjusted_skips, 0.0) as adjusted_skips,
-		COALESCE(cover_art, '') as cover_art
+		`+songMetadataColumns+`
 		FROM songs WHERE user_id = ?
 		ORDER BY id LIMIT ? OFFSET ?`, userID, limit, offset)
 	if err != nil {
@@ -536,8 +725,9 @@ func (db *DB) GetSongsBatch(userID string, limit, offset int) ([]models.Song, er
 	for rows.Next() {
 		var song models.Song
 		var lastPlayedStr, lastSkippedStr string
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album,
-			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips, &song.CoverArt)
+		err := rows.Scan(append([]interface{}{&song.ID, &song.Title, &song.Artist, &song.Album,
+			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips},
+			songMetadataFields(&song)...)...)
 		if err != nil {
 			db.logger.WithError(err).WithFields(logrus.Fields{
 				"userID": userID,
@@ -569,9 +759,10 @@ func (db *DB) GetSongsBatch(userID string, limit, offset int) ([]models.Song, er
 }
 
 // GetSongsBatchFiltered returns a batch of songs filtered by last played/skipped dates.
-// Songs played or skipped after cutoffTime are excluded from results for 2-week replay prevention.
-// Uses consistent COALESCE-based filtering with single cutoff time for robust NULL handling.
-func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, cutoffTime time.Time) ([]models.Song, error) {
+// Songs played after playedCutoff or skipped after skippedCutoff are excluded from results
+// for replay prevention. Songs not matching the song filter (genre, year range, music folder)
+// are excluded as well. Uses consistent COALESCE-based filtering for robust NULL handling.
+func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, playedCutoff, skippedCutoff time.Time, filter models.SongFilter) ([]models.Song, error) {
 	if userID == "" {
 		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
 	}
@@ -582,8 +773,13 @@ func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, cutoffTime
 		return nil, errors.ErrValidationFailed.WithContext("field", "offset")
 	}
 
-	// Format the cutoff time for database comparison
-	cutoffStr := cutoffTime.Format("2006-01-02 15:04:05")
+	// Format the cutoff times for database comparison
+	playedCutoffStr := playedCutoff.Format("2006-01-02 15:04:05")
+	skippedCutoffStr := skippedCutoff.Format("2006-01-02 15:04:05")
+
+	filterClause, filterArgs := songFilterClause(filter)
+	args := append([]interface{}{userID, playedCutoffStr, skippedCutoffStr}, filterArgs...)
+	args = append(args, limit, offset)
 
 	rows, err := db.conn.Query(`SELECT id, title, artist, album, duration,
 		COALESCE(last_played, '1970-01-01') as last_played,
@@ -592,15 +788,16 @@ func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, cutoffTime
 		COALESCE(skip_count, 0) as skip_count,
 		COALESCE(adjusted_plays, 0.0) as adjusted_plays,
 		COALESCE(adjusted_skips, 0.0) as adjusted_skips,
-		COALESCE(cover_art, '') as cover_art
-		FROM songs WHERE user_id = ? AND (COALESCE(last_played, '1970-01-01') < ?) AND (COALESCE(last_skipped, '1970-01-01') < ?)
-		ORDER BY id LIMIT ? OFFSET ?`, userID, cutoffStr, cutoffStr, limit, offset)
+		`+songMetadataColumns+`
+		FROM songs WHERE user_id = ? AND (COALESCE(last_played, '1970-01-01') < ?) AND (COALESCE(last_skipped, '1970-01-01') < ?)`+filterClause+`
+		ORDER BY id LIMIT ? OFFSET ?`, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query filtered songs batch").
 			WithContext("userID", userID).
 			WithContext("limit", limit).
 			WithContext("offset", offset).
-			WithContext("cutoffTime", cutoffTime.Format("2006-01-02 15:04:05"))
+			WithContext("playedCutoff", playedCutoff.Format("2006-01-02 15:04:05")).
+			WithContext("skippedCutoff", skippedCutoff.Format("2006-01-02 15:04:05"))
 	}
 	defer rows.Close()
 
@@ -608,14 +805,16 @@ func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, cutoffTime
 	for rows.Next() {
 		var song models.Song
 		var lastPlayedStr, lastSkippedStr string
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album,
-			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips, &song.CoverArt)
+		err := rows.Scan(append([]interface{}{&song.ID, &song.Title, &song.Artist, &song.Album,
+			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips},
+			songMetadataFields(&song)...)...)
 		if err != nil {
 			db.logger.WithError(err).WithFields(logrus.Fields{
-				"userID":     userID,
-				"limit":      limit,
-				"offset":     offset,
-				"cutoffTime": cutoffTime.Format("2006-01-02 15:04:05"),
+				"userID":        userID,
+				"limit":         limit,
+				"offset":        offset,
+				"playedCutoff":  playedCutoff.Format("2006-01-02 15:04:05"),
+				"skippedCutoff": skippedCutoff.Format("2006-01-02 15:04:05"),
 			}).Error("Failed to scan song in filtered batch")
 			continue
 		}
@@ -636,30 +835,37 @@ func (db *DB) GetSongsBatchFiltered(userID string, limit, offset int, cutoffTime
 			WithContext("userID", userID).
 			WithContext("limit", limit).
 			WithContext("offset", offset).
-			WithContext("cutoffTime", cutoffTime.Format("2006-01-02 15:04:05"))
+			WithContext("playedCutoff", playedCutoff.Format("2006-01-02 15:04:05")).
+			WithContext("skippedCutoff", skippedCutoff.Format("2006-01-02 15:04:05"))
 	}
 
 	return songs, nil
 }
 
 // GetSongCountFiltered returns the count of songs filtered by last played/skipped dates.
-// Songs played or skipped after cutoffTime are excluded from the count for 2-week replay prevention.
-// Uses consistent COALESCE-based filtering with single cutoff time for robust NULL handling.
-func (db *DB) GetSongCountFiltered(userID string, cutoffTime time.Time) (int, error) {
+// Songs played after playedCutoff or skipped after skippedCutoff are excluded from the count
+// for replay prevention. Songs not matching the song filter (genre, year range, music folder)
+// are excluded as well. Uses consistent COALESCE-based filtering for robust NULL handling.
+func (db *DB) GetSongCountFiltered(userID string, playedCutoff, skippedCutoff time.Time, filter models.SongFilter) (int, error) {
 	if userID == "" {
 		return 0, errors.ErrValidationFailed.WithContext("field", "userID")
 	}
 
-	// Format the cutoff time for database comparison
-	cutoffStr := cutoffTime.Format("2006-01-02 15:04:05")
+	// Format the cutoff times for database comparison
+	playedCutoffStr := playedCutoff.Format("2006-01-02 15:04:05")
+	skippedCutoffStr := skippedCutoff.Format("2006-01-02 15:04:05")
+
+	filterClause, filterArgs := songFilterClause(filter)
+	args := append([]interface{}{userID, playedCutoffStr, skippedCutoffStr}, filterArgs...)
 
 	var count int
-	err := db.conn.QueryRow(`SELECT COUNT(*) FROM songs WHERE user_id = ? AND (COALESCE(last_played, '1970-01-01') < ?) AND (COALESCE(last_skipped, '1970-01-01') < ?)`,
-		userID, cutoffStr, cutoffStr).Scan(&count)
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM songs WHERE user_id = ? AND (COALESCE(last_played, '1970-01-01') < ?) AND (COALESCE(last_skipped, '1970-01-01') < ?)`+filterClause,
+		args...).Scan(&count)
 	if err != nil {
 		return 0, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get filtered song count").
 			WithContext("userID", userID).
-			WithContext("cutoffTime", cutoffTime.Format("2006-01-02 15:04:05"))
+			WithContext("playedCutoff", playedCutoff.Format("2006-01-02 15:04:05")).
+			WithContext("skippedCutoff", skippedCutoff.Format("2006-01-02 15:04:05"))
 	}
 
 	return count, nil
@@ -783,7 +989,7 @@ func (db *DB) GetSongsByIDs(userID string, songIDs []string) (map[string]models.
 	}
 
 	query := `SELECT id, title, artist, album, duration,
-		COALESCE(cover_art, '') as cover_art
+		` + songMetadataColumns + `
 		FROM songs WHERE user_id = ? AND id IN (` +
 		strings.Join(placeholders, ",") + `)`
 
@@ -798,7 +1004,8 @@ func (db *DB) GetSongsByIDs(userID string, songIDs []string) (map[string]models.
 	songs := make(map[string]models.Song)
 	for rows.Next() {
 		var song models.Song
-		err := rows.Scan(&song.ID, &song.Title, &song.Artist, &song.Album, &song.Duration, &song.CoverArt)
+		err := rows.Scan(append([]interface{}{&song.ID, &song.Title, &song.Artist, &song.Album, &song.Duration},
+			songMetadataFields(&song)...)...)
 		if err != nil {
 			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan song")
 			continue
@@ -868,7 +1075,15 @@ func (db *DB) DeleteSongs(userID string, songIDs []string) error {
 	return nil
 }
 
+// RecordPlayEvent records a play event using DefaultDecayFactor for the adjusted play/skip counts
 func (db *DB) RecordPlayEvent(userID, songID, eventType string, previousSong *string) error {
+	return db.RecordPlayEventWithDecay(userID, songID, eventType, previousSong, DefaultDecayFactor)
+}
+
+// RecordPlayEventWithDecay records a play event and updates the song's play/skip statistics.
+// The adjusted play and skip counts are multiplied by decayFactor before counting the new event,
+// so recent events weigh more than older ones.
+func (db *DB) RecordPlayEventWithDecay(userID, songID, eventType string, previousSong *string, decayFactor float64) error {
 	if userID == "" {
 		return errors.ErrValidationFailed.WithContext("field", "userID")
 	}
@@ -878,6 +1093,9 @@ func (db *DB) RecordPlayEvent(userID, songID, eventType string, previousSong *st
 	if eventType == "" {
 		return errors.ErrValidationFailed.WithContext("field", "eventType")
 	}
+	if decayFactor <= 0 || decayFactor > 1 {
+		return errors.ErrValidationFailed.WithContext("field", "decayFactor")
+	}
 
 	now := time.Now()
 
@@ -907,7 +1125,6 @@ func (db *DB) RecordPlayEvent(userID, songID, eventType string, previousSong *st
 		// Song exists, update stats
 		if eventType == "play" {
 			// Apply decay formula for play event
-			const decayFactor = 0.95
 			newAdjustedPlays := 1.0 + (currentAdjustedPlays * decayFactor)
 			newAdjustedSkips := currentAdjustedSkips * decayFactor
 
@@ -934,7 +1151,6 @@ func (db *DB) RecordPlayEvent(userID, songID, eventType string, previousSong *st
 			}
 		} else if eventType == "skip" {
 			// Apply decay formula for skip event
-			const decayFactor = 0.95
 			newAdjustedPlays := currentAdjustedPlays * decayFactor
 			newAdjustedSkips := 1.0 + (currentAdjustedSkips * decayFactor)
 
@@ -1484,3 +1700,239 @@ func (db *DB) MigrateArtistStats() error {
 
 	return nil
 }
+
+// SaveCredential stores or replaces the encrypted credential for a user
+func (db *DB) SaveCredential(credential models.StoredCredential) error {
+	if credential.Username == "" {
+		return errors.ErrValidationFailed.WithContext("field", "username")
+	}
+	if len(credential.EncryptedPassword) == 0 || len(credential.Nonce) == 0 {
+		return errors.ErrValidationFailed.WithContext("missing_fields", []string{"encryptedPassword", "nonce"})
+	}
+	if credential.KeyID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "keyID")
+	}
+
+	updatedAt := credential.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	_, err := db.conn.Exec(`INSERT OR REPLACE INTO stored_credentials (username, encrypted_password, nonce, key_id, updated_at)
+		VALUES (?, ?, ?, ?, ?)`, credential.Username, credential.EncryptedPassword, credential.Nonce, credential.KeyID, updatedAt)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save credential").
+			WithContext("username", credential.Username)
+	}
+
+	return nil
+}
+
+// LoadCredentials returns all stored encrypted credentials
+func (db *DB) LoadCredentials() ([]models.StoredCredential, error) {
+	rows, err := db.conn.Query(`SELECT username, encrypted_password, nonce, key_id, updated_at FROM stored_credentials ORDER BY username`)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query stored credentials")
+	}
+	defer rows.Close()
+
+	var credentials []models.StoredCredential
+	for rows.Next() {
+		var credential models.StoredCredential
+		var updatedAtStr string
+		if err := rows.Scan(&credential.Username, &credential.EncryptedPassword, &credential.Nonce, &credential.KeyID, &updatedAtStr); err != nil {
+			db.logger.WithError(err).Error("Failed to scan stored credential")
+			continue
+		}
+		credential.UpdatedAt, _ = parseTimestamp(updatedAtStr)
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "error occurred during stored credential iteration")
+	}
+
+	return credentials, nil
+}
+
+// DeleteCredential removes the stored credential for a user
+func (db *DB) DeleteCredential(username string) error {
+	if username == "" {
+		return errors.ErrValidationFailed.WithContext("field", "username")
+	}
+
+	if _, err := db.conn.Exec(`DELETE FROM stored_credentials WHERE username = ?`, username); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to delete stored credential").
+			WithContext("username", username)
+	}
+
+	return nil
+}
+
+// GetSyncCursor returns the library sync cursor for a user, or nil if the user was never synced
+func (db *DB) GetSyncCursor(userID string) (*models.SyncCursor, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	var cursor models.SyncCursor
+	var lastFullSync, lastIncrementalSync sql.NullString
+	err := db.conn.QueryRow(`SELECT user_id, strategy, last_full_sync, last_incremental_sync
+		FROM sync_cursors WHERE user_id = ?`, userID).
+		Scan(&cursor.UserID, &cursor.Strategy, &lastFullSync, &lastIncrementalSync)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get sync cursor").
+			WithContext("userID", userID)
+	}
+
+	if lastFullSync.Valid {
+		cursor.LastFullSync, _ = parseTimestamp(lastFullSync.String)
+	}
+	if lastIncrementalSync.Valid {
+		cursor.LastIncrementalSync, _ = parseTimestamp(lastIncrementalSync.String)
+	}
+
+	rows, err := db.conn.Query(`SELECT folder_id, last_modified, album_cursor
+		FROM sync_folder_cursors WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get folder sync cursors").
+			WithContext("userID", userID)
+	}
+	defer rows.Close()
+
+	cursor.Folders = make(map[string]models.FolderSyncCursor)
+	for rows.Next() {
+		var folderID string
+		var folder models.FolderSyncCursor
+		var albumCursor sql.NullString
+		if err := rows.Scan(&folderID, &folder.LastModified, &albumCursor); err != nil {
+			return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to scan folder sync cursor").
+				WithContext("userID", userID)
+		}
+		if albumCursor.Valid {
+			folder.AlbumCursor, _ = parseTimestamp(albumCursor.String)
+		}
+		cursor.Folders[folderID] = folder
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to read folder sync cursors").
+			WithContext("userID", userID)
+	}
+
+	return &cursor, nil
+}
+
+// SaveSyncCursor stores or replaces the library sync cursor for a user, including the
+// change markers of all its music folders
+func (db *DB) SaveSyncCursor(cursor models.SyncCursor) error {
+	if cursor.UserID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "TRANSACTION_FAILED", "failed to begin transaction for sync cursor").
+			WithContext("userID", cursor.UserID)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO sync_cursors (user_id, strategy, last_full_sync, last_incremental_sync)
+		VALUES (?, ?, ?, ?)`,
+		cursor.UserID, cursor.Strategy, cursor.LastFullSync, cursor.LastIncrementalSync); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save sync cursor").
+			WithContext("userID", cursor.UserID)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sync_folder_cursors WHERE user_id = ?`, cursor.UserID); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to replace folder sync cursors").
+			WithContext("userID", cursor.UserID)
+	}
+	for folderID, folder := range cursor.Folders {
+		if _, err := tx.Exec(`INSERT INTO sync_folder_cursors (user_id, folder_id, last_modified, album_cursor)
+			VALUES (?, ?, ?, ?)`,
+			cursor.UserID, folderID, folder.LastModified, folder.AlbumCursor); err != nil {
+			return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save folder sync cursor").
+				WithContext("userID", cursor.UserID).
+				WithContext("folderID", folderID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "COMMIT_FAILED", "failed to commit sync cursor").
+			WithContext("userID", cursor.UserID)
+	}
+
+	return nil
+}
+
+// RecordSyncRun stores the outcome of a library sync and prunes history beyond MaxSyncHistoryPerUser
+func (db *DB) RecordSyncRun(run models.SyncRun) error {
+	if run.UserID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	_, err := db.conn.Exec(`INSERT INTO sync_history (user_id, strategy, full_sync, status, started_at, finished_at,
+			duration_ms, total, added, updated, deleted, unchanged, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.UserID, run.Strategy, run.Full, run.Status, run.StartedAt, run.FinishedAt,
+		run.DurationMs, run.Total, run.Added, run.Updated, run.Deleted, run.Unchanged, run.Error)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to record sync run").
+			WithContext("userID", run.UserID)
+	}
+
+	_, err = db.conn.Exec(`DELETE FROM sync_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM sync_history WHERE user_id = ? ORDER BY started_at DESC, id DESC LIMIT ?)`,
+		run.UserID, run.UserID, MaxSyncHistoryPerUser)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to prune sync history").
+			WithContext("userID", run.UserID)
+	}
+
+	return nil
+}
+
+// GetSyncHistory returns the most recent sync runs of a user, newest first
+func (db *DB) GetSyncHistory(userID string, limit int) ([]models.SyncRun, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if limit <= 0 || limit > MaxSyncHistoryPerUser {
+		limit = MaxSyncHistoryPerUser
+	}
+
+	rows, err := db.conn.Query(`SELECT id, user_id, strategy, full_sync, status, started_at, finished_at,
+			duration_ms, total, added, updated, deleted, unchanged, error
+		FROM sync_history WHERE user_id = ? ORDER BY started_at DESC, id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get sync history").
+			WithContext("userID", userID)
+	}
+	defer rows.Close()
+
+	var runs []models.SyncRun
+	for rows.Next() {
+		var run models.SyncRun
+		var startedAt, finishedAt string
+		var runError sql.NullString
+		if err := rows.Scan(&run.ID, &run.UserID, &run.Strategy, &run.Full, &run.Status, &startedAt, &finishedAt,
+			&run.DurationMs, &run.Total, &run.Added, &run.Updated, &run.Deleted, &run.Unchanged, &runError); err != nil {
+			return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to scan sync run").
+				WithContext("userID", userID)
+		}
+		run.StartedAt, _ = parseTimestamp(startedAt)
+		run.FinishedAt, _ = parseTimestamp(finishedAt)
+		run.Error = runError.String
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to iterate sync history").
+			WithContext("userID", userID)
+	}
+
+	return runs, nil
+}
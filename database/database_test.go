@@ -158,6 +158,47 @@ func TestStoreSongs(t *testing.T) {
 	}
 }
 
+func TestStoreSongsMetadata(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_song_metadata.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	song := models.Song{
+		ID: "1", Title: "Song", Artist: "Artist", Album: "Album", Duration: 245,
+		CoverArt: "al-1", Genre: "Jazz", Year: 1959, MusicFolderID: "1",
+		AlbumID: "al-1", ArtistID: "ar-1", Track: 2, Suffix: "mp3", ContentType: "audio/mpeg",
+		BitRate: 320, Size: 9800000, Path: "Artist/Album/02 - Song.mp3",
+	}
+	if err := db.StoreSongs("testuser", []models.Song{song}); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+
+	all, err := db.GetAllSongs("testuser")
+	if err != nil || len(all) != 1 {
+		t.Fatalf("Expected 1 song, got %d (err: %v)", len(all), err)
+	}
+	byID, err := db.GetSongsByIDs("testuser", []string{"1"})
+	if err != nil {
+		t.Fatalf("Failed to get songs by ID: %v", err)
+	}
+
+	for name, stored := range map[string]models.Song{"GetAllSongs": all[0], "GetSongsByIDs": byID["1"]} {
+		if stored.AlbumID != song.AlbumID || stored.ArtistID != song.ArtistID || stored.Track != song.Track ||
+			stored.Suffix != song.Suffix || stored.ContentType != song.ContentType || stored.BitRate != song.BitRate ||
+			stored.Size != song.Size || stored.Path != song.Path || stored.Genre != song.Genre || stored.Year != song.Year {
+			t.Errorf("%s returned %+v, want metadata of %+v", name, stored, song)
+		}
+	}
+}
+
 func TestStoreSongsReplace(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -356,6 +397,44 @@ func TestRecordPlayEvent(t *testing.T) {
 	}
 }
 
+func TestRecordPlayEventWithDecay(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_decay.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.StoreSongs("testuser", []models.Song{{ID: "1", Title: "Test Song", Artist: "Test Artist", Album: "Test Album"}}); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+
+	for _, eventType := range []string{"play", "play"} {
+		if err := db.RecordPlayEventWithDecay("testuser", "1", eventType, nil, 0.5); err != nil {
+			t.Fatalf("Failed to record play event: %v", err)
+		}
+	}
+
+	var adjustedPlays float64
+	if err := db.conn.QueryRow("SELECT adjusted_plays FROM songs WHERE id = ?", "1").Scan(&adjustedPlays); err != nil {
+		t.Fatalf("Failed to get adjusted plays: %v", err)
+	}
+	if adjustedPlays != 1.5 {
+		t.Errorf("Expected adjusted plays 1.5 with a decay factor of 0.5, got %f", adjustedPlays)
+	}
+
+	for _, decayFactor := range []float64{0, -0.5, 1.5} {
+		if err := db.RecordPlayEventWithDecay("testuser", "1", "play", nil, decayFactor); err == nil {
+			t.Errorf("Expected validation error for decay factor %f", decayFactor)
+		}
+	}
+}
+
 func TestRecordSkipEvent(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -1040,6 +1119,81 @@ func TestGetSongsBatch(t *testing.T) {
 	}
 }
 
+func TestGetSongsFilteredBySongFilter(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_song_filter.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	songs := []models.Song{
+		{ID: "1", Title: "Rock 90s", Artist: "A", Album: "X", Duration: 200, Genre: "Rock", Year: 1994, MusicFolderID: "1"},
+		{ID: "2", Title: "Rock 80s", Artist: "A", Album: "Y", Duration: 200, Genre: "Rock", Year: 1985, MusicFolderID: "1"},
+		{ID: "3", Title: "Jazz 90s", Artist: "B", Album: "Z", Duration: 200, Genre: "Jazz", Year: 1996, MusicFolderID: "2"},
+		{ID: "4", Title: "Unknown", Artist: "C", Album: "W", Duration: 200},
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -14)
+
+	tests := []struct {
+		name     string
+		filter   models.SongFilter
+		expected []string
+	}{
+		{"no filter", models.SongFilter{}, []string{"1", "2", "3", "4"}},
+		{"genre", models.SongFilter{Genre: "rock"}, []string{"1", "2"}},
+		{"year range", models.SongFilter{FromYear: 1990, ToYear: 1999}, []string{"1", "3"}},
+		{"to year excludes unknown year", models.SongFilter{ToYear: 1990}, []string{"2"}},
+		{"music folder", models.SongFilter{MusicFolderID: "2"}, []string{"3"}},
+		{"combined", models.SongFilter{Genre: "Rock", FromYear: 1990, ToYear: 1999, MusicFolderID: "1"}, []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := db.GetSongCountFiltered("testuser", cutoff, cutoff, tt.filter)
+			if err != nil {
+				t.Fatalf("Failed to get filtered count: %v", err)
+			}
+			if count != len(tt.expected) {
+				t.Errorf("Expected count %d, got %d", len(tt.expected), count)
+			}
+
+			batch, err := db.GetSongsBatchFiltered("testuser", 10, 0, cutoff, cutoff, tt.filter)
+			if err != nil {
+				t.Fatalf("Failed to get filtered batch: %v", err)
+			}
+			if len(batch) != len(tt.expected) {
+				t.Fatalf("Expected %d songs, got %d", len(tt.expected), len(batch))
+			}
+			for i, song := range batch {
+				if song.ID != tt.expected[i] {
+					t.Errorf("Expected song %s at position %d, got %s", tt.expected[i], i, song.ID)
+				}
+			}
+		})
+	}
+
+	t.Run("metadata round-trips through storage", func(t *testing.T) {
+		stored, err := db.GetSongsByIDs("testuser", []string{"3"})
+		if err != nil {
+			t.Fatalf("Failed to get songs by IDs: %v", err)
+		}
+		song := stored["3"]
+		if song.Genre != "Jazz" || song.Year != 1996 || song.MusicFolderID != "2" {
+			t.Errorf("Unexpected metadata: genre=%q year=%d folder=%q", song.Genre, song.Year, song.MusicFolderID)
+		}
+	})
+}
+
 func TestGetTransitionProbabilities(t *testing.T) {
 	db, err := New(":memory:", logrus.New())
 	if err != nil {
@@ -1914,3 +2068,208 @@ func TestSQLInjectionResistance(t *testing.T) {
 		t.Error("Songs table should still exist after injection attempts")
 	}
 }
+
+func TestStoredCredentials(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_stored_credentials.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	credential := models.StoredCredential{
+		Username:          "alice",
+		EncryptedPassword: []byte{1, 2, 3},
+		Nonce:             []byte{4, 5, 6},
+		KeyID:             "key1",
+	}
+	if err := db.SaveCredential(credential); err != nil {
+		t.Fatalf("Failed to save credential: %v", err)
+	}
+
+	// Saving again replaces the existing entry
+	credential.EncryptedPassword = []byte{7, 8, 9}
+	credential.KeyID = "key2"
+	if err := db.SaveCredential(credential); err != nil {
+		t.Fatalf("Failed to update credential: %v", err)
+	}
+
+	loaded, err := db.LoadCredentials()
+	if err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("Expected 1 stored credential, got %d", len(loaded))
+	}
+	if loaded[0].Username != "alice" || loaded[0].KeyID != "key2" || string(loaded[0].EncryptedPassword) != string([]byte{7, 8, 9}) {
+		t.Errorf("Unexpected stored credential: %+v", loaded[0])
+	}
+	if loaded[0].UpdatedAt.IsZero() {
+		t.Error("Expected UpdatedAt to be set")
+	}
+
+	if err := db.DeleteCredential("alice"); err != nil {
+		t.Fatalf("Failed to delete credential: %v", err)
+	}
+	loaded, err = db.LoadCredentials()
+	if err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("Expected no stored credentials after delete, got %d", len(loaded))
+	}
+
+	if err := db.SaveCredential(models.StoredCredential{Username: "bob"}); err == nil {
+		t.Error("Expected error when saving credential without encrypted data")
+	}
+}
+
+func TestSyncCursor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_sync_cursor.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cursor, err := db.GetSyncCursor("alice")
+	if err != nil {
+		t.Fatalf("Failed to get sync cursor: %v", err)
+	}
+	if cursor != nil {
+		t.Fatalf("Expected no cursor before the first sync, got %+v", cursor)
+	}
+
+	saved := models.SyncCursor{
+		UserID:   "alice",
+		Strategy: "id3",
+		Folders: map[string]models.FolderSyncCursor{
+			"1": {LastModified: 1700000000000, AlbumCursor: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)},
+			"2": {LastModified: 1700000005000},
+		},
+		LastFullSync:        time.Date(2024, 2, 2, 10, 0, 0, 0, time.UTC),
+		LastIncrementalSync: time.Date(2024, 2, 3, 10, 0, 0, 0, time.UTC),
+	}
+	if err := db.SaveSyncCursor(saved); err != nil {
+		t.Fatalf("Failed to save sync cursor: %v", err)
+	}
+
+	// Saving again replaces the existing cursor, including folders no longer present
+	saved.Folders = map[string]models.FolderSyncCursor{
+		"1": {LastModified: 1700000001000, AlbumCursor: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	if err := db.SaveSyncCursor(saved); err != nil {
+		t.Fatalf("Failed to update sync cursor: %v", err)
+	}
+
+	cursor, err = db.GetSyncCursor("alice")
+	if err != nil {
+		t.Fatalf("Failed to get sync cursor: %v", err)
+	}
+	if cursor == nil {
+		t.Fatal("Expected saved cursor")
+	}
+	if cursor.Strategy != "id3" || len(cursor.Folders) != 1 || cursor.Folders["1"].LastModified != 1700000001000 {
+		t.Errorf("Unexpected sync cursor: %+v", cursor)
+	}
+	if !cursor.Folders["1"].AlbumCursor.Equal(saved.Folders["1"].AlbumCursor) || !cursor.LastFullSync.Equal(saved.LastFullSync) || !cursor.LastIncrementalSync.Equal(saved.LastIncrementalSync) {
+		t.Errorf("Expected timestamps to round-trip, got %+v", cursor)
+	}
+
+	if err := db.SaveSyncCursor(models.SyncCursor{}); err == nil {
+		t.Error("Expected error when saving cursor without user ID")
+	}
+}
+
+func TestSyncHistory(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_sync_history.db"
+	defer os.Remove(dbPath)
+
+	db, err := New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	history, err := db.GetSyncHistory("alice", 10)
+	if err != nil {
+		t.Fatalf("Failed to get sync history: %v", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("Expected no sync history before the first sync, got %d runs", len(history))
+	}
+
+	start := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < MaxSyncHistoryPerUser+5; i++ {
+		run := models.SyncRun{
+			UserID:     "alice",
+			Strategy:   "id3",
+			Full:       i == 0,
+			Status:     models.SyncStatusSuccess,
+			StartedAt:  start.Add(time.Duration(i) * time.Hour),
+			FinishedAt: start.Add(time.Duration(i)*time.Hour + time.Second),
+			DurationMs: 1000,
+			Total:      10,
+			Added:      i,
+		}
+		if err := db.RecordSyncRun(run); err != nil {
+			t.Fatalf("Failed to record sync run: %v", err)
+		}
+	}
+	failed := models.SyncRun{
+		UserID:     "bob",
+		Strategy:   "search3",
+		Status:     models.SyncStatusFailed,
+		StartedAt:  start,
+		FinishedAt: start.Add(time.Second),
+		Error:      "SEARCH_FAILED: search3 failed",
+	}
+	if err := db.RecordSyncRun(failed); err != nil {
+		t.Fatalf("Failed to record failed sync run: %v", err)
+	}
+
+	history, err = db.GetSyncHistory("alice", 2)
+	if err != nil {
+		t.Fatalf("Failed to get sync history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 sync runs, got %d", len(history))
+	}
+	if history[0].Added != MaxSyncHistoryPerUser+4 || !history[0].StartedAt.After(history[1].StartedAt) {
+		t.Errorf("Expected newest run first, got %+v", history)
+	}
+
+	// Older runs beyond the per-user limit are pruned
+	history, err = db.GetSyncHistory("alice", 0)
+	if err != nil {
+		t.Fatalf("Failed to get sync history: %v", err)
+	}
+	if len(history) != MaxSyncHistoryPerUser {
+		t.Errorf("Expected %d sync runs after pruning, got %d", MaxSyncHistoryPerUser, len(history))
+	}
+
+	history, err = db.GetSyncHistory("bob", 10)
+	if err != nil {
+		t.Fatalf("Failed to get sync history: %v", err)
+	}
+	if len(history) != 1 || history[0].Status != models.SyncStatusFailed || history[0].Error != failed.Error || history[0].Full {
+		t.Errorf("Unexpected sync history for bob: %+v", history)
+	}
+
+	if err := db.RecordSyncRun(models.SyncRun{}); err == nil {
+		t.Error("Expected error when recording sync run without user ID")
+	}
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newTestDB creates a database in a temporary directory, holding songs for testuser
+func newTestDB(t *testing.T, songs []models.Song) *DB {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	db, err := New(filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if len(songs) > 0 {
+		if err := db.StoreSongs("testuser", songs); err != nil {
+			t.Fatalf("Failed to store songs: %v", err)
+		}
+	}
+	return db
+}
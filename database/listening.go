@@ -0,0 +1,290 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// songSelectColumns selects a complete song row including the user's listening data,
+// in the order scanned by querySongs
+const songSelectColumns = `id, title, artist, album, duration,
+		COALESCE(last_played, '1970-01-01') as last_played,
+		COALESCE(last_skipped, '1970-01-01') as last_skipped,
+		COALESCE(play_count, 0) as play_count,
+		COALESCE(skip_count, 0) as skip_count,
+		COALESCE(adjusted_plays, 0.0) as adjusted_plays,
+		COALESCE(adjusted_skips, 0.0) as adjusted_skips,
+		` + songMetadataColumns
+
+// placeholders returns n comma separated SQL placeholders for an IN clause
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// querySongs runs a query selecting songSelectColumns and scans the resulting songs
+func (db *DB) querySongs(userID, query string, args ...interface{}) ([]models.Song, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query songs").
+			WithContext("userID", userID)
+	}
+	defer rows.Close()
+
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		var lastPlayedStr, lastSkippedStr string
+		err := rows.Scan(append([]interface{}{&song.ID, &song.Title, &song.Artist, &song.Album,
+			&song.Duration, &lastPlayedStr, &lastSkippedStr, &song.PlayCount, &song.SkipCount, &song.AdjustedPlays, &song.AdjustedSkips},
+			songMetadataFields(&song)...)...)
+		if err != nil {
+			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan song")
+			continue
+		}
+
+		if lastPlayedStr != DefaultDateString {
+			song.LastPlayed, _ = parseTimestamp(lastPlayedStr)
+		}
+		if lastSkippedStr != DefaultDateString {
+			song.LastSkipped, _ = parseTimestamp(lastSkippedStr)
+		}
+
+		songs = append(songs, song)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "error occurred during song iteration").
+			WithContext("userID", userID)
+	}
+
+	return songs, nil
+}
+
+// GetSeedSongs resolves a Subsonic ID to the user's songs it refers to. The ID is tried as a
+// song ID, then as an album ID and finally as an artist ID. Returns no songs if nothing matches.
+func (db *DB) GetSeedSongs(userID, seedID string) ([]models.Song, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if seedID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "seedID")
+	}
+
+	for _, column := range []string{"id", "album_id", "artist_id"} {
+		songs, err := db.querySongs(userID, `SELECT `+songSelectColumns+`
+			FROM songs WHERE user_id = ? AND `+column+` = ? ORDER BY album, track, id`, userID, seedID)
+		if err != nil {
+			return nil, err
+		}
+		if len(songs) > 0 {
+			return songs, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetSongsByArtists returns all of the user's songs by the given artists
+func (db *DB) GetSongsByArtists(userID string, artists []string) ([]models.Song, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if len(artists) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{userID}
+	for _, artist := range artists {
+		args = append(args, artist)
+	}
+
+	return db.querySongs(userID, `SELECT `+songSelectColumns+`
+		FROM songs WHERE user_id = ? AND artist IN (`+placeholders(len(artists))+`)`, args...)
+}
+
+// GetSongsWithHistory returns the user's songs with the given IDs, including their listening data
+func (db *DB) GetSongsWithHistory(userID string, songIDs []string) ([]models.Song, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if len(songIDs) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{userID}
+	for _, songID := range songIDs {
+		args = append(args, songID)
+	}
+
+	return db.querySongs(userID, `SELECT `+songSelectColumns+`
+		FROM songs WHERE user_id = ? AND id IN (`+placeholders(len(songIDs))+`)`, args...)
+}
+
+// GetTransitionNeighbours returns the songs the user played right after or right before any of
+// the given songs, with the highest play probability of those transitions. Only transitions that
+// were played at least once are considered.
+func (db *DB) GetTransitionNeighbours(userID string, songIDs []string, limit int) (map[string]float64, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if limit <= 0 {
+		return nil, errors.ErrValidationFailed.WithContext("field", "limit")
+	}
+	neighbours := make(map[string]float64)
+	if len(songIDs) == 0 {
+		return neighbours, nil
+	}
+
+	in := placeholders(len(songIDs))
+	args := []interface{}{userID}
+	for _, songID := range songIDs {
+		args = append(args, songID)
+	}
+	args = append(args, userID)
+	for _, songID := range songIDs {
+		args = append(args, songID)
+	}
+	args = append(args, limit)
+
+	rows, err := db.conn.Query(`SELECT song_id, MAX(probability) FROM (
+			SELECT to_song_id AS song_id, COALESCE(probability, 0.5) AS probability FROM song_transitions
+			WHERE user_id = ? AND from_song_id IN (`+in+`) AND play_count > 0
+			UNION ALL
+			SELECT from_song_id AS song_id, COALESCE(probability, 0.5) AS probability FROM song_transitions
+			WHERE user_id = ? AND to_song_id IN (`+in+`) AND play_count > 0
+		) GROUP BY song_id ORDER BY MAX(probability) DESC, song_id LIMIT ?`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query transition neighbours").
+			WithContext("userID", userID).
+			WithContext("songCount", len(songIDs))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var songID string
+		var probability float64
+		if err := rows.Scan(&songID, &probability); err != nil {
+			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan transition neighbour")
+			continue
+		}
+		neighbours[songID] = probability
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "error occurred during transition neighbour iteration").
+			WithContext("userID", userID)
+	}
+
+	// A song is not its own neighbour
+	for _, songID := range songIDs {
+		delete(neighbours, songID)
+	}
+
+	return neighbours, nil
+}
+
+// GetCoPlayedArtists returns the artists the user played directly before or after songs by
+// any of the given artists, with the number of such played transitions. The given artists
+// themselves are not included.
+func (db *DB) GetCoPlayedArtists(userID string, artists []string, limit int) (map[string]int, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if limit <= 0 {
+		return nil, errors.ErrValidationFailed.WithContext("field", "limit")
+	}
+	coPlays := make(map[string]int)
+	if len(artists) == 0 {
+		return coPlays, nil
+	}
+
+	in := placeholders(len(artists))
+	var artistArgs []interface{}
+	for _, artist := range artists {
+		artistArgs = append(artistArgs, artist)
+	}
+	var args []interface{}
+	for i := 0; i < 2; i++ {
+		args = append(args, userID)
+		args = append(args, artistArgs...)
+		args = append(args, artistArgs...)
+	}
+	args = append(args, limit)
+
+	rows, err := db.conn.Query(`SELECT artist, SUM(plays) FROM (
+			SELECT b.artist AS artist, t.play_count AS plays FROM song_transitions t
+			JOIN songs a ON a.id = t.from_song_id AND a.user_id = t.user_id
+			JOIN songs b ON b.id = t.to_song_id AND b.user_id = t.user_id
+			WHERE t.user_id = ? AND a.artist IN (`+in+`) AND b.artist NOT IN (`+in+`)
+			UNION ALL
+			SELECT a.artist AS artist, t.play_count AS plays FROM song_transitions t
+			JOIN songs a ON a.id = t.from_song_id AND a.user_id = t.user_id
+			JOIN songs b ON b.id = t.to_song_id AND b.user_id = t.user_id
+			WHERE t.user_id = ? AND b.artist IN (`+in+`) AND a.artist NOT IN (`+in+`)
+		) WHERE plays > 0 GROUP BY artist ORDER BY SUM(plays) DESC, artist LIMIT ?`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to query co-played artists").
+			WithContext("userID", userID).
+			WithContext("artistCount", len(artists))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var artist string
+		var plays int
+		if err := rows.Scan(&artist, &plays); err != nil {
+			db.logger.WithError(err).WithField("userID", userID).Error("Failed to scan co-played artist")
+			continue
+		}
+		coPlays[artist] = plays
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "error occurred during co-played artist iteration").
+			WithContext("userID", userID)
+	}
+
+	return coPlays, nil
+}
+
+// GetTopSongs returns the user's most played songs by an artist (matched case-insensitively),
+// ordered by play count and then by recent plays. Songs that were never played are not included.
+func (db *DB) GetTopSongs(userID, artist string, limit int) ([]models.Song, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if artist == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "artist")
+	}
+	if limit <= 0 {
+		return nil, errors.ErrValidationFailed.WithContext("field", "limit")
+	}
+
+	return db.querySongs(userID, `SELECT `+songSelectColumns+`
+		FROM songs WHERE user_id = ? AND artist = ? COLLATE NOCASE AND COALESCE(play_count, 0) > 0
+		ORDER BY play_count DESC, adjusted_plays DESC, title LIMIT ?`, userID, artist, limit)
+}
+
+// GetReplayExcludedSongs returns up to limit songs that GetSongsBatchFiltered excludes because
+// they were played after playedCutoff or skipped after skippedCutoff, least recently presented
+// first. Songs not matching the song filter are not included.
+func (db *DB) GetReplayExcludedSongs(userID string, playedCutoff, skippedCutoff time.Time, filter models.SongFilter, limit int) ([]models.Song, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if limit <= 0 {
+		return nil, errors.ErrValidationFailed.WithContext("field", "limit")
+	}
+
+	filterClause, filterArgs := songFilterClause(filter)
+	args := append([]interface{}{userID,
+		playedCutoff.Format("2006-01-02 15:04:05"), skippedCutoff.Format("2006-01-02 15:04:05")}, filterArgs...)
+	args = append(args, limit)
+
+	return db.querySongs(userID, `SELECT `+songSelectColumns+`
+		FROM songs WHERE user_id = ? AND (COALESCE(last_played, '1970-01-01') >= ? OR COALESCE(last_skipped, '1970-01-01') >= ?)`+filterClause+`
+		ORDER BY MAX(COALESCE(last_played, '1970-01-01'), COALESCE(last_skipped, '1970-01-01')), id LIMIT ?`, args...)
+}
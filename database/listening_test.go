@@ -0,0 +1,178 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newListeningTestDB creates a database with songs by three artists and a few transitions:
+// A1 -> B1 played twice, B2 -> A2 played once and A1 -> C1 skipped
+func newListeningTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", ArtistID: "ar-a", Track: 1, Duration: 200},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", ArtistID: "ar-a", Track: 2, Duration: 200},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", ArtistID: "ar-b", Duration: 200},
+		{ID: "b2", Title: "B2", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", ArtistID: "ar-b", Duration: 200},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", AlbumID: "al-c", ArtistID: "ar-c", Duration: 200},
+	}
+	db := newTestDB(t, songs)
+
+	transitions := []struct{ from, to, event string }{
+		{"a1", "b1", "play"},
+		{"a1", "b1", "play"},
+		{"b2", "a2", "play"},
+		{"a1", "c1", "skip"},
+	}
+	for _, tr := range transitions {
+		if err := db.RecordTransition("testuser", tr.from, tr.to, tr.event); err != nil {
+			t.Fatalf("Failed to record transition: %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestGetSeedSongs(t *testing.T) {
+	db := newListeningTestDB(t)
+
+	tests := []struct {
+		seed     string
+		expected []string
+	}{
+		{"a2", []string{"a2"}},
+		{"al-a", []string{"a1", "a2"}},
+		{"ar-b", []string{"b1", "b2"}},
+		{"unknown", nil},
+	}
+
+	for _, tt := range tests {
+		songs, err := db.GetSeedSongs("testuser", tt.seed)
+		if err != nil {
+			t.Fatalf("GetSeedSongs(%q) failed: %v", tt.seed, err)
+		}
+		if len(songs) != len(tt.expected) {
+			t.Fatalf("GetSeedSongs(%q) returned %d songs, want %d", tt.seed, len(songs), len(tt.expected))
+		}
+		for i, song := range songs {
+			if song.ID != tt.expected[i] {
+				t.Errorf("GetSeedSongs(%q)[%d] = %s, want %s", tt.seed, i, song.ID, tt.expected[i])
+			}
+		}
+	}
+
+	if songs, _ := db.GetSeedSongs("otheruser", "a1"); len(songs) != 0 {
+		t.Error("Seed songs must be isolated per user")
+	}
+}
+
+func TestGetTransitionNeighbours(t *testing.T) {
+	db := newListeningTestDB(t)
+
+	neighbours, err := db.GetTransitionNeighbours("testuser", []string{"a1", "a2"}, 10)
+	if err != nil {
+		t.Fatalf("GetTransitionNeighbours failed: %v", err)
+	}
+
+	// b1 follows a1, b2 precedes a2; the skipped c1 is not a neighbour
+	if len(neighbours) != 2 || neighbours["b1"] != 1.0 || neighbours["b2"] != 1.0 {
+		t.Errorf("Unexpected neighbours: %v", neighbours)
+	}
+}
+
+func TestGetCoPlayedArtists(t *testing.T) {
+	db := newListeningTestDB(t)
+
+	coPlays, err := db.GetCoPlayedArtists("testuser", []string{"Artist A"}, 10)
+	if err != nil {
+		t.Fatalf("GetCoPlayedArtists failed: %v", err)
+	}
+	if len(coPlays) != 1 || coPlays["Artist B"] != 3 {
+		t.Errorf("Expected Artist B co-played 3 times, got %v", coPlays)
+	}
+
+	coPlays, err = db.GetCoPlayedArtists("testuser", []string{"Artist C"}, 10)
+	if err != nil {
+		t.Fatalf("GetCoPlayedArtists failed: %v", err)
+	}
+	if len(coPlays) != 0 {
+		t.Errorf("Expected no co-played artists for skipped transitions, got %v", coPlays)
+	}
+}
+
+func TestGetTopSongs(t *testing.T) {
+	db := newListeningTestDB(t)
+
+	for _, songID := range []string{"a2", "a2", "a1"} {
+		if err := db.RecordPlayEvent("testuser", songID, "play", nil); err != nil {
+			t.Fatalf("Failed to record play: %v", err)
+		}
+	}
+
+	songs, err := db.GetTopSongs("testuser", "artist a", 10)
+	if err != nil {
+		t.Fatalf("GetTopSongs failed: %v", err)
+	}
+	if len(songs) != 2 || songs[0].ID != "a2" || songs[0].PlayCount != 2 || songs[1].ID != "a1" {
+		t.Errorf("Expected a2 (2 plays) before a1, got %+v", songs)
+	}
+
+	if songs, _ := db.GetTopSongs("testuser", "Artist B", 10); len(songs) != 0 {
+		t.Errorf("Expected no top songs for an artist never played, got %d", len(songs))
+	}
+}
+
+func TestGetReplayExcludedSongs(t *testing.T) {
+	db := newListeningTestDB(t)
+
+	for _, event := range []struct{ songID, eventType string }{
+		{"a2", "play"},
+		{"b1", "play"},
+		{"c1", "skip"},
+	} {
+		if err := db.RecordPlayEvent("testuser", event.songID, event.eventType, nil); err != nil {
+			t.Fatalf("Failed to record play event: %v", err)
+		}
+	}
+
+	dayAgo := time.Now().AddDate(0, 0, -1)
+	songs, err := db.GetReplayExcludedSongs("testuser", dayAgo, dayAgo, models.SongFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetReplayExcludedSongs failed: %v", err)
+	}
+	if got := songIDs(songs); len(got) != 3 || got[0] != "a2" || got[1] != "b1" || got[2] != "c1" {
+		t.Errorf("Expected a2, b1, c1 (least recently presented first), got %v", got)
+	}
+
+	// Skips before the skipped cutoff no longer exclude c1
+	songs, err = db.GetReplayExcludedSongs("testuser", dayAgo, time.Now().Add(time.Minute), models.SongFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetReplayExcludedSongs failed: %v", err)
+	}
+	if got := songIDs(songs); len(got) != 2 || got[0] != "a2" || got[1] != "b1" {
+		t.Errorf("Expected a2, b1 with skips outside the window, got %v", got)
+	}
+
+	songs, err = db.GetReplayExcludedSongs("testuser", dayAgo, dayAgo, models.SongFilter{}, 1)
+	if err != nil {
+		t.Fatalf("GetReplayExcludedSongs failed: %v", err)
+	}
+	if got := songIDs(songs); len(got) != 1 || got[0] != "a2" {
+		t.Errorf("Expected only a2 with limit 1, got %v", got)
+	}
+
+	if _, err := db.GetReplayExcludedSongs("testuser", dayAgo, dayAgo, models.SongFilter{}, 0); err == nil {
+		t.Error("Expected validation error for zero limit")
+	}
+}
+
+func songIDs(songs []models.Song) []string {
+	ids := make([]string, 0, len(songs))
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+	return ids
+}
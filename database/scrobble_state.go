@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// SaveLastPlayed stores the last played song of a user; an empty songID clears it
+func (db *DB) SaveLastPlayed(userID, songID string, at time.Time) error {
+	if userID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	var song, playedAt interface{}
+	if songID != "" {
+		song, playedAt = songID, at
+	}
+	_, err := db.conn.Exec(`INSERT INTO scrobble_state (user_id, last_played_song_id, last_played_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET last_played_song_id = excluded.last_played_song_id, last_played_at = excluded.last_played_at`,
+		userID, song, playedAt)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save last played song").
+			WithContext("userID", userID)
+	}
+	return nil
+}
+
+// SaveLastScrobble stores the last scrobble sent from one of a user's devices, which skip
+// detection compares the device's next scrobble against
+func (db *DB) SaveLastScrobble(userID string, scrobble models.DeviceScrobble) error {
+	if userID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if scrobble.SongID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "songID")
+	}
+
+	_, err := db.conn.Exec(`INSERT OR REPLACE INTO device_scrobbles (user_id, device_id, song_id, duration, submission, scrobbled_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, scrobble.DeviceID, scrobble.SongID, scrobble.Duration, scrobble.IsSubmission, scrobble.ScrobbledAt)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save last scrobble").
+			WithContext("userID", userID).
+			WithContext("songID", scrobble.SongID)
+	}
+	return nil
+}
+
+// DeleteExpiredScrobbles deletes the last scrobbles of all devices older than cutoff and
+// returns how many were deleted
+func (db *DB) DeleteExpiredScrobbles(cutoff time.Time) (int64, error) {
+	result, err := db.conn.Exec(`DELETE FROM device_scrobbles WHERE scrobbled_at < ?`, cutoff)
+	if err != nil {
+		return 0, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to delete expired scrobbles")
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// GetScrobbleStates deletes the state older than cutoff and returns the remaining state of
+// all users, ordered by user ID
+func (db *DB) GetScrobbleStates(cutoff time.Time) ([]models.ScrobbleState, error) {
+	if _, err := db.conn.Exec(`DELETE FROM scrobble_state WHERE last_played_at IS NULL OR last_played_at < ?`, cutoff); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to delete expired last played songs")
+	}
+	if _, err := db.DeleteExpiredScrobbles(cutoff); err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*models.ScrobbleState)
+	state := func(userID string) *models.ScrobbleState {
+		if states[userID] == nil {
+			states[userID] = &models.ScrobbleState{UserID: userID}
+		}
+		return states[userID]
+	}
+
+	rows, err := db.conn.Query(`SELECT user_id, last_played_song_id, last_played_at FROM scrobble_state`)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get last played songs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		var songID, playedAt sql.NullString
+		if err := rows.Scan(&userID, &songID, &playedAt); err != nil {
+			return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to scan last played song")
+		}
+		if at, err := parseTimestamp(playedAt.String); songID.Valid && err == nil {
+			state(userID).LastPlayedSongID, state(userID).LastPlayedAt = songID.String, at
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to iterate last played songs")
+	}
+
+	scrobbleRows, err := db.conn.Query(`SELECT user_id, device_id, song_id, duration, submission, scrobbled_at
+		FROM device_scrobbles ORDER BY user_id, device_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get last scrobbles")
+	}
+	defer scrobbleRows.Close()
+	for scrobbleRows.Next() {
+		var userID, scrobbledAt string
+		var scrobble models.DeviceScrobble
+		if err := scrobbleRows.Scan(&userID, &scrobble.DeviceID, &scrobble.SongID, &scrobble.Duration,
+			&scrobble.IsSubmission, &scrobbledAt); err != nil {
+			return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to scan last scrobble")
+		}
+		if scrobble.ScrobbledAt, err = parseTimestamp(scrobbledAt); err != nil {
+			continue
+		}
+		state(userID).Scrobbles = append(state(userID).Scrobbles, scrobble)
+	}
+	if err := scrobbleRows.Err(); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to iterate last scrobbles")
+	}
+
+	result := make([]models.ScrobbleState, 0, len(states))
+	for _, s := range states {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestScrobbleState(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	now := time.Now()
+	cutoff := now.Add(-time.Hour)
+
+	if err := db.SaveLastPlayed("alice", "song1", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("SaveLastPlayed failed: %v", err)
+	}
+	scrobbles := []models.DeviceScrobble{
+		{DeviceID: "phone", SongID: "song2", Duration: 180, ScrobbledAt: now},
+		{DeviceID: "desktop", SongID: "song3", Duration: 200, IsSubmission: true, ScrobbledAt: now},
+		{DeviceID: "tablet", SongID: "song4", ScrobbledAt: now.Add(-2 * time.Hour)},
+	}
+	for _, scrobble := range scrobbles {
+		if err := db.SaveLastScrobble("alice", scrobble); err != nil {
+			t.Fatalf("SaveLastScrobble failed: %v", err)
+		}
+	}
+	// A newer scrobble replaces the device's last one
+	if err := db.SaveLastScrobble("alice", models.DeviceScrobble{DeviceID: "phone", SongID: "song5", Duration: 240, ScrobbledAt: now}); err != nil {
+		t.Fatalf("SaveLastScrobble failed: %v", err)
+	}
+	// Bob's state has expired entirely
+	if err := db.SaveLastPlayed("bob", "song6", now.Add(-3*time.Hour)); err != nil {
+		t.Fatalf("SaveLastPlayed failed: %v", err)
+	}
+
+	states, err := db.GetScrobbleStates(cutoff)
+	if err != nil {
+		t.Fatalf("GetScrobbleStates failed: %v", err)
+	}
+	if len(states) != 1 || states[0].UserID != "alice" {
+		t.Fatalf("Expected only the state of alice, got %+v", states)
+	}
+	state := states[0]
+	if state.LastPlayedSongID != "song1" {
+		t.Errorf("Expected last played song1, got %s", state.LastPlayedSongID)
+	}
+	if len(state.Scrobbles) != 2 {
+		t.Fatalf("Expected scrobbles of desktop and phone, got %+v", state.Scrobbles)
+	}
+	desktop, phone := state.Scrobbles[0], state.Scrobbles[1]
+	if desktop.DeviceID != "desktop" || desktop.SongID != "song3" || !desktop.IsSubmission {
+		t.Errorf("Unexpected desktop scrobble: %+v", desktop)
+	}
+	if phone.DeviceID != "phone" || phone.SongID != "song5" || phone.Duration != 240 || phone.IsSubmission {
+		t.Errorf("Unexpected phone scrobble: %+v", phone)
+	}
+	if phone.ScrobbledAt.Sub(now).Abs() > time.Second {
+		t.Errorf("Expected scrobble time %v, got %v", now, phone.ScrobbledAt)
+	}
+
+	// Clearing the last played song keeps the scrobbles
+	if err := db.SaveLastPlayed("alice", "", now); err != nil {
+		t.Fatalf("SaveLastPlayed failed: %v", err)
+	}
+	states, err = db.GetScrobbleStates(cutoff)
+	if err != nil {
+		t.Fatalf("GetScrobbleStates failed: %v", err)
+	}
+	if len(states) != 1 || states[0].LastPlayedSongID != "" || len(states[0].Scrobbles) != 2 {
+		t.Errorf("Expected cleared last played song and kept scrobbles, got %+v", states)
+	}
+
+	if err := db.SaveLastScrobble("", models.DeviceScrobble{SongID: "song1", ScrobbledAt: now}); err == nil {
+		t.Error("Expected validation error for empty user ID")
+	}
+}
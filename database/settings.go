@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// serverSettingWeightingProfile is the server_settings entry holding the default weighting profile
+const serverSettingWeightingProfile = "weighting_profile"
+
+// GetUserSettings returns the settings overrides of a user, or nil if the user has none
+func (db *DB) GetUserSettings(userID string) (*models.UserSettings, error) {
+	if userID == "" {
+		return nil, errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	settings := models.UserSettings{UserID: userID}
+	var playedDays, skippedDays sql.NullInt64
+	var weighting sql.NullString
+	var updatedAt string
+	err := db.conn.QueryRow(`SELECT replay_window_played_days, replay_window_skipped_days, weighting_profile, updated_at
+		FROM user_settings WHERE user_id = ?`, userID).Scan(&playedDays, &skippedDays, &weighting, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get user settings").
+			WithContext("userID", userID)
+	}
+
+	settings.ReplayWindowPlayedDays = nullableDays(playedDays)
+	settings.ReplayWindowSkippedDays = nullableDays(skippedDays)
+	settings.UpdatedAt, _ = parseTimestamp(updatedAt)
+	if weighting.Valid {
+		settings.Weighting = &models.WeightingProfile{}
+		if err := json.Unmarshal([]byte(weighting.String), settings.Weighting); err != nil {
+			return nil, errors.Wrap(err, errors.CategoryDatabase, "INVALID_DATA", "failed to decode weighting profile").
+				WithContext("userID", userID)
+		}
+	}
+
+	return &settings, nil
+}
+
+// SaveUserSettings stores or replaces the settings overrides of a user
+func (db *DB) SaveUserSettings(settings models.UserSettings) error {
+	if settings.UserID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+	if settings.ReplayWindowPlayedDays != nil && *settings.ReplayWindowPlayedDays < 0 {
+		return errors.ErrValidationFailed.WithContext("field", "replayWindowPlayedDays")
+	}
+	if settings.ReplayWindowSkippedDays != nil && *settings.ReplayWindowSkippedDays < 0 {
+		return errors.ErrValidationFailed.WithContext("field", "replayWindowSkippedDays")
+	}
+
+	var weighting *string
+	if settings.Weighting != nil {
+		encoded, err := json.Marshal(settings.Weighting)
+		if err != nil {
+			return errors.Wrap(err, errors.CategoryDatabase, "INVALID_DATA", "failed to encode weighting profile").
+				WithContext("userID", settings.UserID)
+		}
+		value := string(encoded)
+		weighting = &value
+	}
+
+	_, err := db.conn.Exec(`INSERT OR REPLACE INTO user_settings (user_id, replay_window_played_days, replay_window_skipped_days, weighting_profile, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		settings.UserID, settings.ReplayWindowPlayedDays, settings.ReplayWindowSkippedDays, weighting, time.Now())
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save user settings").
+			WithContext("userID", settings.UserID)
+	}
+
+	return nil
+}
+
+// DeleteUserSettings removes the settings overrides of a user, restoring the server defaults
+func (db *DB) DeleteUserSettings(userID string) error {
+	if userID == "" {
+		return errors.ErrValidationFailed.WithContext("field", "userID")
+	}
+
+	if _, err := db.conn.Exec(`DELETE FROM user_settings WHERE user_id = ?`, userID); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to delete user settings").
+			WithContext("userID", userID)
+	}
+
+	return nil
+}
+
+// GetDefaultWeightingProfile returns the weighting profile stored as the default for all
+// users, or nil if none was stored
+func (db *DB) GetDefaultWeightingProfile() (*models.WeightingProfile, error) {
+	var value string
+	err := db.conn.QueryRow(`SELECT value FROM server_settings WHERE name = ?`, serverSettingWeightingProfile).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to get default weighting profile")
+	}
+
+	var profile models.WeightingProfile
+	if err := json.Unmarshal([]byte(value), &profile); err != nil {
+		return nil, errors.Wrap(err, errors.CategoryDatabase, "INVALID_DATA", "failed to decode default weighting profile")
+	}
+	return &profile, nil
+}
+
+// SaveDefaultWeightingProfile stores the weighting profile used by users without their own
+func (db *DB) SaveDefaultWeightingProfile(profile models.WeightingProfile) error {
+	encoded, err := json.Marshal(profile)
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "INVALID_DATA", "failed to encode default weighting profile")
+	}
+
+	_, err = db.conn.Exec(`INSERT OR REPLACE INTO server_settings (name, value, updated_at) VALUES (?, ?, ?)`,
+		serverSettingWeightingProfile, string(encoded), time.Now())
+	if err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to save default weighting profile")
+	}
+
+	return nil
+}
+
+// DeleteDefaultWeightingProfile removes the stored default weighting profile
+func (db *DB) DeleteDefaultWeightingProfile() error {
+	if _, err := db.conn.Exec(`DELETE FROM server_settings WHERE name = ?`, serverSettingWeightingProfile); err != nil {
+		return errors.Wrap(err, errors.CategoryDatabase, "QUERY_FAILED", "failed to delete default weighting profile")
+	}
+
+	return nil
+}
+
+func nullableDays(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	days := int(value.Int64)
+	return &days
+}
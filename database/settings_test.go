@@ -0,0 +1,93 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestUserSettings(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	settings, err := db.GetUserSettings("testuser")
+	if err != nil {
+		t.Fatalf("GetUserSettings failed: %v", err)
+	}
+	if settings != nil {
+		t.Fatalf("Expected no settings for a new user, got %+v", settings)
+	}
+
+	playedDays := 3
+	if err := db.SaveUserSettings(models.UserSettings{UserID: "testuser", ReplayWindowPlayedDays: &playedDays}); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+
+	settings, err = db.GetUserSettings("testuser")
+	if err != nil {
+		t.Fatalf("GetUserSettings failed: %v", err)
+	}
+	if settings == nil || settings.ReplayWindowPlayedDays == nil || *settings.ReplayWindowPlayedDays != 3 {
+		t.Fatalf("Expected played window of 3 days, got %+v", settings)
+	}
+	if settings.ReplayWindowSkippedDays != nil {
+		t.Errorf("Expected no skipped window override, got %d", *settings.ReplayWindowSkippedDays)
+	}
+	if settings.UpdatedAt.IsZero() {
+		t.Error("Expected updatedAt to be set")
+	}
+
+	if other, _ := db.GetUserSettings("otheruser"); other != nil {
+		t.Error("Settings must be isolated per user")
+	}
+
+	negative := -1
+	if err := db.SaveUserSettings(models.UserSettings{UserID: "testuser", ReplayWindowSkippedDays: &negative}); err == nil {
+		t.Error("Expected validation error for a negative window")
+	}
+
+	if err := db.DeleteUserSettings("testuser"); err != nil {
+		t.Fatalf("DeleteUserSettings failed: %v", err)
+	}
+	if settings, _ := db.GetUserSettings("testuser"); settings != nil {
+		t.Errorf("Expected settings to be deleted, got %+v", settings)
+	}
+}
+
+func TestWeightingProfileSettings(t *testing.T) {
+	db := newTestDB(t, nil)
+
+	profile := models.WeightingProfile{Preset: "discovery", NeverPlayedWeight: 8.0, TimeDecayDays: 60, DecayFactor: 0.9}
+	if err := db.SaveUserSettings(models.UserSettings{UserID: "testuser", Weighting: &profile}); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+	settings, err := db.GetUserSettings("testuser")
+	if err != nil {
+		t.Fatalf("GetUserSettings failed: %v", err)
+	}
+	if settings == nil || settings.Weighting == nil || *settings.Weighting != profile {
+		t.Fatalf("Expected weighting profile %+v, got %+v", profile, settings)
+	}
+
+	stored, err := db.GetDefaultWeightingProfile()
+	if err != nil || stored != nil {
+		t.Fatalf("Expected no default weighting profile, got %+v (%v)", stored, err)
+	}
+	if err := db.SaveDefaultWeightingProfile(profile); err != nil {
+		t.Fatalf("SaveDefaultWeightingProfile failed: %v", err)
+	}
+	profile.Preset = "comfort"
+	if err := db.SaveDefaultWeightingProfile(profile); err != nil {
+		t.Fatalf("SaveDefaultWeightingProfile failed: %v", err)
+	}
+	stored, err = db.GetDefaultWeightingProfile()
+	if err != nil || stored == nil || *stored != profile {
+		t.Fatalf("Expected default weighting profile %+v, got %+v (%v)", profile, stored, err)
+	}
+
+	if err := db.DeleteDefaultWeightingProfile(); err != nil {
+		t.Fatalf("DeleteDefaultWeightingProfile failed: %v", err)
+	}
+	if stored, _ := db.GetDefaultWeightingProfile(); stored != nil {
+		t.Errorf("Expected default weighting profile to be deleted, got %+v", stored)
+	}
+}
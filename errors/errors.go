@@ -135,6 +135,8 @@ var (
 	ErrServerShutdown = New(CategoryServer, "SHUTDOWN_FAILED", "server shutdown failed")
 	ErrProxySetup     = New(CategoryServer, "PROXY_SETUP_FAILED", "proxy setup failed")
 	ErrHookExecution  = New(CategoryServer, "HOOK_EXECUTION_FAILED", "hook execution failed")
+	ErrSyncCanceled   = New(CategoryServer, "SYNC_CANCELED", "library sync canceled by shutdown")
+	ErrSyncInProgress = New(CategoryServer, "SYNC_IN_PROGRESS", "a library sync is already running for this user")
 )
 
 // Network errors
@@ -151,6 +153,11 @@ var (
 	ErrMissingParameter = New(CategoryValidation, "MISSING_PARAMETER", "missing required parameter")
 )
 
+// Auth errors
+var (
+	ErrUnauthorized = New(CategoryAuth, "UNAUTHORIZED", "request is not authenticated")
+)
+
 // Helper functions for common error patterns
 func IsCategory(err error, category string) bool {
 	var subsoxyErr *SubsoxyError
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Album list constants
+const (
+	AlbumListRandom      = "random"
+	DefaultAlbumListSize = 10
+	MaxAlbumListSize     = 500
+)
+
+// HandleAlbumList answers getAlbumList and getAlbumList2 with type frequent, recent,
+// highest or random from the user's own listening data. Frequent and recent albums are
+// ranked by the user's play events, highest by the Bayesian play ratio of their songs and
+// random albums are drawn using the shuffle weights of their songs. Other list types,
+// invalid parameters and users without listening history are passed on to the upstream server.
+func (h *Handler) HandleAlbumList(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	userID := credentials.UserFromContext(r.Context())
+	if userID == "" {
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Album list request without authenticated user")
+		return false
+	}
+
+	query := r.URL.Query()
+	listType := query.Get("type")
+	switch listType {
+	case string(database.AlbumOrderFrequent), string(database.AlbumOrderRecent), string(database.AlbumOrderHighest), AlbumListRandom:
+	default:
+		return false
+	}
+
+	size, ok := parseBoundedInt(query.Get("size"), DefaultAlbumListSize, 1, MaxAlbumListSize)
+	if !ok {
+		return false
+	}
+	offset, ok := parseBoundedInt(query.Get("offset"), 0, 0, -1)
+	if !ok {
+		return false
+	}
+	musicFolderID := query.Get("musicFolderId")
+	if len(musicFolderID) > MaxSongIDLength {
+		return false
+	}
+
+	id3 := strings.HasSuffix(strings.TrimSuffix(endpoint, ".view"), "2")
+	grouping := database.AlbumGroupingDirectory
+	if id3 {
+		grouping = database.AlbumGroupingID3
+	}
+	filter := models.SongFilter{MusicFolderID: musicFolderID}
+
+	var albums []models.Album
+	var err error
+	if listType == AlbumListRandom {
+		albums, err = h.shuffle.GetWeightedRandomAlbums(userID, grouping, filter, size)
+	} else {
+		albums, err = h.shuffle.GetAlbums(userID, database.AlbumQuery{
+			Grouping: grouping,
+			Order:    database.AlbumOrder(listType),
+			Filter:   filter,
+			Limit:    size,
+			Offset:   offset,
+		})
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).
+			Warn("Failed to build album list, passing to upstream")
+		return false
+	}
+	// Without any local albums the upstream list is the better answer; an empty page
+	// past the end of the local list is still answered locally
+	if len(albums) == 0 && (offset == 0 || listType == AlbumListRandom) {
+		return false
+	}
+
+	var payload Payload
+	if id3 {
+		if albums == nil {
+			albums = []models.Album{} // Encode an empty page as an empty list, not null
+		}
+		payload = Payload{Name: "albumList2", Value: models.AlbumList2{Album: albums}}
+	} else {
+		directories := make([]models.Song, 0, len(albums))
+		for _, album := range albums {
+			directories = append(directories, albumDirectory(album))
+		}
+		payload = Payload{Name: "albumList", Value: models.AlbumList{Album: directories}}
+	}
+
+	if err := WriteSubsonicResponse(w, r, h.upstreamInfo(), FormatXML, payload); err != nil {
+		encodeErr := errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode album list response").
+			WithContext("type", listType).
+			WithContext("album_count", len(albums))
+		h.logger.WithError(encodeErr).Error("Failed to encode album list response")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), encodeErr)
+		return true
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"type":     listType,
+		"endpoint": endpoint,
+		"returned": len(albums),
+		"userID":   SanitizeForLogging(userID),
+	}).Info("Served album list from listening data")
+
+	return true
+}
+
+// albumDirectory converts an album grouped by its directory into the directory child
+// returned by getAlbumList
+func albumDirectory(album models.Album) models.Song {
+	return models.Song{
+		ID:       album.ID,
+		Title:    album.Name,
+		Album:    album.Name,
+		Artist:   album.Artist,
+		Duration: album.Duration,
+		IsDir:    true,
+		CoverArt: album.CoverArt,
+		Genre:    album.Genre,
+		Year:     album.Year,
+	}
+}
+
+// parseBoundedInt parses an optional integer parameter, returning defaultValue if it is
+// missing. Values below min are rejected, values above max (if max >= 0) are capped.
+func parseBoundedInt(value string, defaultValue, min, max int) (int, bool) {
+	if value == "" {
+		return defaultValue, true
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min {
+		return 0, false
+	}
+	if max >= 0 && parsed > max {
+		parsed = max
+	}
+	return parsed, true
+}
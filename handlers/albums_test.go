@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+	"github.com/syeo66/subsoxy/shuffle"
+)
+
+// newAlbumListTestHandler creates a handler whose user played Album A twice and Album B once
+func newAlbumListTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_albums.db"
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Parent: "dir-a", Duration: 100},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", Parent: "dir-b", Duration: 200},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", AlbumID: "al-c", Parent: "dir-c", Duration: 300},
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	for _, songID := range []string{"a1", "b1", "a1"} {
+		if err := db.RecordPlayEvent("testuser", songID, "play", nil); err != nil {
+			t.Fatalf("Failed to record play: %v", err)
+		}
+	}
+
+	return New(logger, shuffle.New(db, logger))
+}
+
+func TestHandleAlbumList2(t *testing.T) {
+	handler := newAlbumListTestHandler(t)
+
+	req := newAuthenticatedRequest("GET", "/rest/getAlbumList2?u=testuser&type=frequent&f=json", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleAlbumList(w, req, "/rest/getAlbumList2") {
+		t.Fatal("Expected the album list to be served from listening data")
+	}
+
+	var response struct {
+		SubsonicResponse struct {
+			Status     string `json:"status"`
+			AlbumList2 struct {
+				Album []map[string]interface{} `json:"album"`
+			} `json:"albumList2"`
+		} `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	albums := response.SubsonicResponse.AlbumList2.Album
+	if response.SubsonicResponse.Status != "ok" || len(albums) != 2 {
+		t.Fatalf("Expected the 2 played albums, got %s", w.Body.String())
+	}
+	if albums[0]["id"] != "al-a" || albums[0]["name"] != "Album A" || albums[0]["playCount"] != float64(2) {
+		t.Errorf("Expected Album A with 2 plays first, got %v", albums[0])
+	}
+	for _, internal := range []string{"LastPlayed", "AdjustedPlays", "lastPlayed"} {
+		if _, ok := albums[0][internal]; ok {
+			t.Errorf("Internal field %s should not be serialized", internal)
+		}
+	}
+}
+
+func TestHandleAlbumListDirectories(t *testing.T) {
+	handler := newAlbumListTestHandler(t)
+
+	req := newAuthenticatedRequest("GET", "/rest/getAlbumList?u=testuser&type=recent&size=1", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleAlbumList(w, req, "/rest/getAlbumList") {
+		t.Fatal("Expected the album list to be served from listening data")
+	}
+
+	var response struct {
+		AlbumList struct {
+			Album []models.Song `xml:"album"`
+		} `xml:"albumList"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse XML response: %v", err)
+	}
+
+	albums := response.AlbumList.Album
+	if len(albums) != 1 || albums[0].ID != "dir-a" || albums[0].Title != "Album A" || !albums[0].IsDir {
+		t.Errorf("Expected the directory of the most recently played Album A, got %+v", albums)
+	}
+}
+
+func TestHandleAlbumListRandom(t *testing.T) {
+	handler := newAlbumListTestHandler(t)
+
+	req := newAuthenticatedRequest("GET", "/rest/getAlbumList2?u=testuser&type=random&size=5", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleAlbumList(w, req, "/rest/getAlbumList2") {
+		t.Fatal("Expected random albums to be served from listening data")
+	}
+
+	var response struct {
+		AlbumList2 struct {
+			Album []models.Album `xml:"album"`
+		} `xml:"albumList2"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse XML response: %v", err)
+	}
+	if len(response.AlbumList2.Album) != 3 {
+		t.Errorf("Expected all 3 albums including the unplayed one, got %d", len(response.AlbumList2.Album))
+	}
+}
+
+func TestHandleAlbumListFallback(t *testing.T) {
+	handler := newAlbumListTestHandler(t)
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"upstream type", "/rest/getAlbumList2?u=testuser&type=newest"},
+		{"missing type", "/rest/getAlbumList2?u=testuser"},
+		{"no history", "/rest/getAlbumList2?u=otheruser&type=frequent"},
+		{"invalid size", "/rest/getAlbumList2?u=testuser&type=frequent&size=abc"},
+		{"negative offset", "/rest/getAlbumList2?u=testuser&type=frequent&offset=-1"},
+		{"unauthenticated", "/rest/getAlbumList2?type=frequent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if handler.HandleAlbumList(w, newAuthenticatedRequest("GET", tt.target, nil), "/rest/getAlbumList2") {
+				t.Error("Expected the request to be passed to upstream")
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("Expected nothing written on fallback, got %s", w.Body.String())
+			}
+		})
+	}
+
+	// A page past the end of the local list is answered locally with no albums
+	req := newAuthenticatedRequest("GET", "/rest/getAlbumList2?u=testuser&type=frequent&offset=10", nil)
+	if !handler.HandleAlbumList(httptest.NewRecorder(), req, "/rest/getAlbumList2") {
+		t.Error("Expected an empty page to be served locally")
+	}
+}
@@ -0,0 +1,557 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Debug page constants
+const (
+	DefaultDebugPageSize = 100
+	MaxDebugPageSize     = 1000
+	MaxDebugSearchLength = 200
+	DefaultDebugSortKey  = "weight"
+
+	DebugFormatHTML = "html"
+	DebugFormatCSV  = "csv"
+	DebugFormatJSON = "json"
+)
+
+const (
+	debugTimeFormat       = "2006-01-02 15:04:05"
+	debugHighWeight       = 2.0
+	debugMediumWeight     = 1.0
+	debugExportFilename   = "subsoxy-debug"
+	debugCSVFormulaPrefix = "=+-@\t\r" // Leading characters spreadsheets treat as formulas
+)
+
+// debugRow is a song of the debug page and its exports
+type debugRow struct {
+	ID               string     `json:"id"`
+	Title            string     `json:"title"`
+	Artist           string     `json:"artist"`
+	Album            string     `json:"album"`
+	Duration         int        `json:"duration"`
+	PlayCount        int        `json:"playCount"`
+	SkipCount        int        `json:"skipCount"`
+	LastPlayed       *time.Time `json:"lastPlayed"`
+	LastSkipped      *time.Time `json:"lastSkipped"`
+	TimeWeight       float64    `json:"timeWeight"`
+	PlaySkipWeight   float64    `json:"playSkipWeight"`
+	TransitionWeight float64    `json:"transitionWeight"`
+	ArtistWeight     float64    `json:"artistWeight"`
+	FinalWeight      float64    `json:"finalWeight"`
+}
+
+// debugColumn is a sortable column of the debug table. Numeric columns sort descending first.
+type debugColumn struct {
+	Key     string
+	Label   string
+	Numeric bool
+	less    func(a, b *debugRow) bool
+}
+
+func compareTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return a.Before(*b)
+}
+
+var debugColumns = []debugColumn{
+	{"id", "Song ID", false, func(a, b *debugRow) bool { return a.ID < b.ID }},
+	{"title", "Title", false, func(a, b *debugRow) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }},
+	{"artist", "Artist", false, func(a, b *debugRow) bool { return strings.ToLower(a.Artist) < strings.ToLower(b.Artist) }},
+	{"album", "Album", false, func(a, b *debugRow) bool { return strings.ToLower(a.Album) < strings.ToLower(b.Album) }},
+	{"duration", "Duration (s)", true, func(a, b *debugRow) bool { return a.Duration < b.Duration }},
+	{"playCount", "Play Count", true, func(a, b *debugRow) bool { return a.PlayCount < b.PlayCount }},
+	{"skipCount", "Skip Count", true, func(a, b *debugRow) bool { return a.SkipCount < b.SkipCount }},
+	{"lastPlayed", "Last Played", false, func(a, b *debugRow) bool { return compareTimes(a.LastPlayed, b.LastPlayed) }},
+	{"lastSkipped", "Last Skipped", false, func(a, b *debugRow) bool { return compareTimes(a.LastSkipped, b.LastSkipped) }},
+	{"timeWeight", "Time Weight", true, func(a, b *debugRow) bool { return a.TimeWeight < b.TimeWeight }},
+	{"playSkipWeight", "Play/Skip Weight", true, func(a, b *debugRow) bool { return a.PlaySkipWeight < b.PlaySkipWeight }},
+	{"transitionWeight", "Transition Weight", true, func(a, b *debugRow) bool { return a.TransitionWeight < b.TransitionWeight }},
+	{"artistWeight", "Artist Weight", true, func(a, b *debugRow) bool { return a.ArtistWeight < b.ArtistWeight }},
+	{"weight", "Final Weight", true, func(a, b *debugRow) bool { return a.FinalWeight < b.FinalWeight }},
+}
+
+// debugQuery holds the parsed parameters of a debug request
+type debugQuery struct {
+	ReferenceSongID string
+	Search          string
+	Sort            string
+	Descending      bool
+	Page            int
+	PageSize        int
+	Format          string
+}
+
+// parseDebugQuery parses the debug page parameters: id (reference song), q (search by title
+// or artist), sort, order (asc or desc), page, pageSize and format (html, csv or json)
+func parseDebugQuery(r *http.Request) (debugQuery, error) {
+	query := r.URL.Query()
+	q := debugQuery{
+		ReferenceSongID: query.Get("id"),
+		Search:          strings.TrimSpace(query.Get("q")),
+		Sort:            DefaultDebugSortKey,
+		Page:            1,
+		PageSize:        DefaultDebugPageSize,
+		Format:          DebugFormatHTML,
+	}
+
+	if q.ReferenceSongID != "" {
+		if err := ValidateSongID(q.ReferenceSongID); err != nil {
+			return q, err
+		}
+	}
+	if len(q.Search) > MaxDebugSearchLength {
+		return q, errors.ErrInvalidInput.WithContext("field", "q").WithContext("max_length", MaxDebugSearchLength)
+	}
+
+	if value := query.Get("sort"); value != "" {
+		column, ok := findDebugColumn(value)
+		if !ok {
+			return q, errors.ErrInvalidInput.WithContext("field", "sort")
+		}
+		q.Sort = column.Key
+	}
+	column, _ := findDebugColumn(q.Sort)
+	q.Descending = column.Numeric
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		q.Descending = false
+	case "desc":
+		q.Descending = true
+	default:
+		return q, errors.ErrInvalidInput.WithContext("field", "order")
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"page", &q.Page, 0},
+		{"pageSize", &q.PageSize, MaxDebugPageSize},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || (param.max > 0 && value > param.max) {
+			return q, errors.ErrInvalidInput.WithContext("field", param.name)
+		}
+		*param.value = value
+	}
+
+	if value := query.Get("format"); value != "" {
+		if value != DebugFormatHTML && value != DebugFormatCSV && value != DebugFormatJSON {
+			return q, errors.ErrInvalidInput.WithContext("field", "format")
+		}
+		q.Format = value
+	}
+
+	return q, nil
+}
+
+func findDebugColumn(key string) (debugColumn, bool) {
+	for _, column := range debugColumns {
+		if column.Key == key {
+			return column, true
+		}
+	}
+	return debugColumn{}, false
+}
+
+// url returns the relative URL of the debug page with the given changes. Credentials are never
+// part of it; browsers resend them with the Basic Auth challenge of the debug pages.
+func (q debugQuery) url(modify func(*debugQuery)) string {
+	modify(&q)
+
+	values := url.Values{}
+	if q.ReferenceSongID != "" {
+		values.Set("id", q.ReferenceSongID)
+	}
+	if q.Search != "" {
+		values.Set("q", q.Search)
+	}
+	values.Set("sort", q.Sort)
+	values.Set("order", "asc")
+	if q.Descending {
+		values.Set("order", "desc")
+	}
+	if q.Format != DebugFormatHTML {
+		values.Set("format", q.Format)
+	} else {
+		values.Set("page", strconv.Itoa(q.Page))
+		values.Set("pageSize", strconv.Itoa(q.PageSize))
+	}
+	return "?" + values.Encode()
+}
+
+// HandleDebug serves the song weights of the authenticated user as a paginated, sortable and
+// searchable HTML table, or exports all matching rows as CSV or JSON (format parameter).
+// Transition weights are calculated from the id song, or from the user's last played song.
+// Errors are answered with Subsonic error responses, like the other endpoints.
+func (h *Handler) HandleDebug(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	userID := credentials.UserFromContext(r.Context())
+	if userID == "" {
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Debug request without authenticated user")
+		WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorWrongCredentials, "Wrong username or password")
+		return true
+	}
+
+	q, err := parseDebugQuery(r)
+	if err != nil {
+		h.logger.WithError(err).Debug("Invalid debug request parameters")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), err)
+		return true
+	}
+
+	factors, err := h.shuffle.GetAllSongWeightFactors(userID, q.ReferenceSongID)
+	if err != nil {
+		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).Error("Failed to get songs for debug")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), err)
+		return true
+	}
+
+	rows := debugRows(factors, q)
+	switch q.Format {
+	case DebugFormatCSV:
+		err = writeDebugCSV(w, rows)
+	case DebugFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(rows)
+	default:
+		err = h.writeDebugPage(w, userID, q, rows, factors)
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("format", q.Format).Error("Failed to write debug response")
+		return true
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"userID":          SanitizeForLogging(userID),
+		"songCount":       len(factors),
+		"matchingSongs":   len(rows),
+		"format":          q.Format,
+		"referenceSongID": SanitizeForLogging(q.ReferenceSongID),
+	}).Info("Served debug request")
+
+	return true
+}
+
+// debugRows returns the songs matching the search, in the requested order
+func debugRows(factors []models.SongWeightFactors, q debugQuery) []debugRow {
+	search := strings.ToLower(q.Search)
+	rows := make([]debugRow, 0, len(factors))
+	for _, f := range factors {
+		song := f.Song
+		if search != "" && !strings.Contains(strings.ToLower(song.Title), search) &&
+			!strings.Contains(strings.ToLower(song.Artist), search) {
+			continue
+		}
+		rows = append(rows, debugRow{
+			ID:               song.ID,
+			Title:            song.Title,
+			Artist:           song.Artist,
+			Album:            song.Album,
+			Duration:         song.Duration,
+			PlayCount:        song.PlayCount,
+			SkipCount:        song.SkipCount,
+			LastPlayed:       timeOrNil(song.LastPlayed),
+			LastSkipped:      timeOrNil(song.LastSkipped),
+			TimeWeight:       f.Weights.Time,
+			PlaySkipWeight:   f.Weights.PlaySkip,
+			TransitionWeight: f.Weights.Transition,
+			ArtistWeight:     f.Weights.Artist,
+			FinalWeight:      f.Weights.Final,
+		})
+	}
+
+	column, _ := findDebugColumn(q.Sort)
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if column.less(a, b) {
+			return true
+		}
+		if column.less(b, a) {
+			return false
+		}
+		// Equal rows keep a stable order across pages
+		return rows[i].ID < rows[j].ID
+	})
+	return rows
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// writeDebugCSV exports rows as CSV. Text starting like a spreadsheet formula is prefixed with
+// a quote, so opening the export can't run formulas from song metadata.
+func writeDebugCSV(w http.ResponseWriter, rows []debugRow) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+debugExportFilename+`.csv"`)
+
+	text := func(value string) string {
+		if value != "" && strings.ContainsRune(debugCSVFormulaPrefix, rune(value[0])) {
+			return "'" + value
+		}
+		return value
+	}
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	weight := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 4, 64)
+	}
+
+	writer := csv.NewWriter(w)
+	header := make([]string, len(debugColumns))
+	for i, column := range debugColumns {
+		header[i] = column.Key
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			text(row.ID), text(row.Title), text(row.Artist), text(row.Album),
+			strconv.Itoa(row.Duration), strconv.Itoa(row.PlayCount), strconv.Itoa(row.SkipCount),
+			timestamp(row.LastPlayed), timestamp(row.LastSkipped),
+			weight(row.TimeWeight), weight(row.PlaySkipWeight), weight(row.TransitionWeight),
+			weight(row.ArtistWeight), weight(row.FinalWeight),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// debugPageData is the data of debugPageTemplate
+type debugPageData struct {
+	UserID        string
+	Reference     string
+	HasReference  bool
+	Query         debugQuery
+	TotalSongs    int
+	MatchingSongs int
+	Page          int
+	Pages         int
+	Columns       []debugPageColumn
+	Rows          []debugPageRow
+	PrevURL       string
+	NextURL       string
+	ClearURL      string
+	CSVURL        string
+	JSONURL       string
+}
+
+type debugPageColumn struct {
+	Label   string
+	Numeric bool
+	URL     string
+	Arrow   string
+}
+
+type debugPageRow struct {
+	debugRow
+	URL   string
+	Class string
+}
+
+func (h *Handler) writeDebugPage(w http.ResponseWriter, userID string, q debugQuery, rows []debugRow, factors []models.SongWeightFactors) error {
+	pages := max(1, (len(rows)+q.PageSize-1)/q.PageSize)
+	q.Page = min(q.Page, pages)
+	start := (q.Page - 1) * q.PageSize
+	end := min(start+q.PageSize, len(rows))
+
+	data := debugPageData{
+		UserID:        userID,
+		Reference:     "None (using current last played track)",
+		HasReference:  q.ReferenceSongID != "",
+		Query:         q,
+		TotalSongs:    len(factors),
+		MatchingSongs: len(rows),
+		Page:          q.Page,
+		Pages:         pages,
+		ClearURL:      q.url(func(q *debugQuery) { q.ReferenceSongID = "" }),
+		CSVURL:        q.url(func(q *debugQuery) { q.Format = DebugFormatCSV }),
+		JSONURL:       q.url(func(q *debugQuery) { q.Format = DebugFormatJSON }),
+	}
+	if q.ReferenceSongID != "" {
+		data.Reference = q.ReferenceSongID
+		for _, f := range factors {
+			if f.Song.ID == q.ReferenceSongID {
+				data.Reference = f.Song.Title + " - " + f.Song.Artist
+				break
+			}
+		}
+	}
+	if q.Page > 1 {
+		data.PrevURL = q.url(func(q *debugQuery) { q.Page-- })
+	}
+	if q.Page < pages {
+		data.NextURL = q.url(func(q *debugQuery) { q.Page++ })
+	}
+
+	for _, column := range debugColumns {
+		pageColumn := debugPageColumn{Label: column.Label, Numeric: column.Numeric}
+		descending := column.Numeric
+		if column.Key == q.Sort {
+			descending = !q.Descending
+			pageColumn.Arrow = "▲"
+			if q.Descending {
+				pageColumn.Arrow = "▼"
+			}
+		}
+		pageColumn.URL = q.url(func(q *debugQuery) {
+			q.Sort, q.Descending, q.Page = column.Key, descending, 1
+		})
+		data.Columns = append(data.Columns, pageColumn)
+	}
+
+	for _, row := range rows[start:end] {
+		pageRow := debugPageRow{
+			debugRow: row,
+			URL:      q.url(func(q *debugQuery) { q.ReferenceSongID = row.ID }),
+			Class:    "low-weight",
+		}
+		switch {
+		case row.ID == q.ReferenceSongID:
+			pageRow.Class = "reference-track"
+		case row.FinalWeight >= debugHighWeight:
+			pageRow.Class = "high-weight"
+		case row.FinalWeight >= debugMediumWeight:
+			pageRow.Class = "medium-weight"
+		}
+		data.Rows = append(data.Rows, pageRow)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return debugPageTemplate.Execute(w, data)
+}
+
+var debugPageTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(debugTimeFormat)
+	},
+	"weight": func(value float64) string {
+		return strconv.FormatFloat(value, 'f', 4, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Subsoxy Debug - User: {{.UserID}}</title>
+	<style>
+		body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
+		h1 { color: #333; }
+		table { border-collapse: collapse; width: 100%; background-color: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+		th, td { border: 1px solid #ddd; padding: 12px; text-align: left; }
+		th { background-color: #4CAF50; position: sticky; top: 0; }
+		th a { color: white; text-decoration: none; }
+		tr:nth-child(even) { background-color: #f2f2f2; }
+		tr:hover { background-color: #ddd; }
+		.num { text-align: right; font-family: monospace; }
+		.date { font-family: monospace; font-size: 0.9em; }
+		.never { color: #999; font-style: italic; }
+		.high-weight { background-color: #c8e6c9; }
+		.medium-weight { background-color: #fff9c4; }
+		.low-weight { background-color: #ffccbc; }
+		.reference-track { background-color: #bbdefb; font-weight: bold; border-left: 4px solid #2196F3; }
+		.info { margin: 20px 0; padding: 15px; background-color: #e3f2fd; border-left: 4px solid #2196F3; }
+		.controls { margin: 20px 0; }
+		.song-id-link { color: #1976D2; text-decoration: none; cursor: pointer; }
+		.song-id-link:hover { text-decoration: underline; }
+	</style>
+</head>
+<body>
+	<h1>Subsoxy Debug - User: {{.UserID}}</h1>
+	<div class="info">
+		<strong>Total Songs:</strong> {{.TotalSongs}} ({{.MatchingSongs}} matching)<br>
+		<strong>Reference Track:</strong> {{.Reference}}{{if .HasReference}} (<a href="{{.ClearURL}}">clear</a>){{end}}<br>
+		<strong>Weight Calculation:</strong> Time Weight × Play/Skip Weight (Bayesian) × Transition Weight × Artist Weight<br>
+		<strong>Play/Skip Method:</strong> Bayesian Beta-Binomial model with empirical priors from the listening history<br>
+		<strong>Transition Weight:</strong> {{if .HasReference}}Based on transition probability from selected reference track{{else}}Based on current last played track (click song ID to set reference){{end}}<br>
+		<strong>Color Legend:</strong>
+		<span style="background-color: #c8e6c9; padding: 2px 6px;">High (≥2.0)</span>
+		<span style="background-color: #fff9c4; padding: 2px 6px;">Medium (1.0-2.0)</span>
+		<span style="background-color: #ffccbc; padding: 2px 6px;">Low (&lt;1.0)</span>
+		<span style="background-color: #bbdefb; padding: 2px 6px; font-weight: bold;">Reference Track</span>
+	</div>
+	<form class="controls" method="get">
+		{{if .HasReference}}<input type="hidden" name="id" value="{{.Query.ReferenceSongID}}">{{end}}
+		<input type="hidden" name="sort" value="{{.Query.Sort}}">
+		<input type="hidden" name="order" value="{{if .Query.Descending}}desc{{else}}asc{{end}}">
+		<input type="hidden" name="pageSize" value="{{.Query.PageSize}}">
+		<input type="search" name="q" value="{{.Query.Search}}" placeholder="Search title or artist" maxlength="200">
+		<button type="submit">Search</button>
+		Export: <a href="{{.CSVURL}}">CSV</a> | <a href="{{.JSONURL}}">JSON</a>
+	</form>
+	<div class="controls">
+		{{if .PrevURL}}<a href="{{.PrevURL}}">&laquo; Previous</a>{{end}}
+		Page {{.Page}} of {{.Pages}}
+		{{if .NextURL}}<a href="{{.NextURL}}">Next &raquo;</a>{{end}}
+	</div>
+	<table>
+		<thead>
+			<tr>
+				{{range .Columns}}<th{{if .Numeric}} class="num"{{end}}><a href="{{.URL}}">{{.Label}}</a> {{.Arrow}}</th>
+				{{end}}
+			</tr>
+		</thead>
+		<tbody>
+			{{range .Rows}}<tr class="{{.Class}}">
+				<td><a class="song-id-link" href="{{.URL}}">{{.ID}}</a></td>
+				<td>{{.Title}}</td>
+				<td>{{.Artist}}</td>
+				<td>{{.Album}}</td>
+				<td class="num">{{.Duration}}</td>
+				<td class="num">{{.PlayCount}}</td>
+				<td class="num">{{.SkipCount}}</td>
+				<td>{{with .LastPlayed}}<span class="date">{{date .}}</span>{{else}}<span class="never">Never</span>{{end}}</td>
+				<td>{{with .LastSkipped}}<span class="date">{{date .}}</span>{{else}}<span class="never">Never</span>{{end}}</td>
+				<td class="num">{{weight .TimeWeight}}</td>
+				<td class="num">{{weight .PlaySkipWeight}}</td>
+				<td class="num">{{weight .TransitionWeight}}</td>
+				<td class="num">{{weight .ArtistWeight}}</td>
+				<td class="num">{{weight .FinalWeight}}</td>
+			</tr>
+			{{end}}
+		</tbody>
+	</table>
+</body>
+</html>
+`))
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+	"github.com/syeo66/subsoxy/shuffle"
+)
+
+// newDebugTestHandler creates a handler whose user has three songs, one with markup and one
+// with a spreadsheet formula in its metadata
+func newDebugTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_debug.db"
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	songs := []models.Song{
+		{ID: "s1", Title: "<script>alert(1)</script>", Artist: "Artist A", Album: "Album", Duration: 200},
+		{ID: "s2", Title: "Blue", Artist: "=HYPERLINK(\"x\")", Album: "Album", Duration: 180},
+		{ID: "s3", Title: "Calm", Artist: "Artist C", Album: "Album", Duration: 240},
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	if err := db.RecordPlayEvent("testuser", "s3", "play", nil); err != nil {
+		t.Fatalf("Failed to record play: %v", err)
+	}
+
+	return New(logger, shuffle.New(db, logger))
+}
+
+func serveDebug(t *testing.T, handler *Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newAuthenticatedRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleDebug(w, req, "/debug") {
+		t.Fatal("Expected debug request to be handled")
+	}
+	return w
+}
+
+func TestHandleDebugEscapesAndHidesCredentials(t *testing.T) {
+	handler := newDebugTestHandler(t)
+
+	w := serveDebug(t, handler, "/debug?u=testuser&p=supersecret&id=s1")
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(body, "<script>alert(1)</script>") {
+		t.Error("Expected song titles to be escaped")
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Error("Expected escaped song title on the page")
+	}
+	if strings.Contains(body, "supersecret") || strings.Contains(body, "p=") {
+		t.Error("Expected password not to be echoed in the page")
+	}
+	if !strings.Contains(body, `class="reference-track"`) {
+		t.Error("Expected reference track to be highlighted")
+	}
+}
+
+func TestHandleDebugPaginationAndSearch(t *testing.T) {
+	handler := newDebugTestHandler(t)
+
+	body := serveDebug(t, handler, "/debug?u=testuser&sort=title&order=asc&pageSize=2&page=2").Body.String()
+	if !strings.Contains(body, "Page 2 of 2") {
+		t.Error("Expected second of two pages")
+	}
+	if !strings.Contains(body, "Calm") || strings.Contains(body, "Blue") {
+		t.Error("Expected only the last song by title on the second page")
+	}
+
+	body = serveDebug(t, handler, "/debug?u=testuser&q=artist+c").Body.String()
+	if !strings.Contains(body, "(1 matching)") || !strings.Contains(body, "Calm") {
+		t.Error("Expected search to match the artist case-insensitively")
+	}
+
+	for _, target := range []string{
+		"/debug?u=testuser&sort=unknown",
+		"/debug?u=testuser&order=up",
+		"/debug?u=testuser&page=0",
+		"/debug?u=testuser&pageSize=5000",
+		"/debug?u=testuser&format=xml",
+		"/debug?u=testuser&q=" + strings.Repeat("a", MaxDebugSearchLength+1),
+	} {
+		w := serveDebug(t, handler, target)
+		if !strings.Contains(w.Body.String(), `status="failed"`) || !strings.Contains(w.Body.String(), `code="0"`) {
+			t.Errorf("Expected a Subsonic error response for %s, got %d %q", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleDebugExports(t *testing.T) {
+	handler := newDebugTestHandler(t)
+
+	w := serveDebug(t, handler, "/debug?u=testuser&format=json&sort=title&order=desc&pageSize=1")
+	var rows []debugRow
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("Failed to decode JSON export: %v", err)
+	}
+	if len(rows) != 3 || rows[0].ID != "s3" || rows[2].ID != "s1" {
+		t.Errorf("Expected all rows by descending title, got %+v", rows)
+	}
+	if rows[0].PlayCount != 1 || rows[0].LastPlayed == nil {
+		t.Errorf("Expected play of s3 to be exported, got %+v", rows[0])
+	}
+
+	w = serveDebug(t, handler, "/debug?u=testuser&format=csv&q=blue")
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+		t.Errorf("Expected CSV content type, got %s", contentType)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV export: %v", err)
+	}
+	if len(records) != 2 || records[0][0] != "id" {
+		t.Fatalf("Expected header and one row, got %v", records)
+	}
+	if artist := records[1][2]; artist != `'=HYPERLINK("x")` {
+		t.Errorf("Expected formula to be neutralized, got %s", artist)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/errors"
+)
+
+// explainErrorResponse is the JSON body of a failed explain request
+type explainErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// HandleExplain explains why songs get picked by the weighted shuffle. It answers with JSON
+// holding each weight factor, the empirical Bayes priors, the replay window exclusion and the
+// probability share in the eligible pool of every song given as id parameter. The optional
+// from parameter sets the reference song for transition weights; the shuffle filter
+// parameters (genre, fromYear, toYear, musicFolderId) narrow the eligible pool.
+func (h *Handler) HandleExplain(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	userID := credentials.UserFromContext(r.Context())
+	if userID == "" {
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Explain request without authenticated user")
+		writeExplainError(w, http.StatusUnauthorized, "Wrong username or password")
+		return true
+	}
+
+	query := r.URL.Query()
+	songIDs := query["id"]
+	if len(songIDs) == 0 {
+		writeExplainError(w, http.StatusBadRequest, "Required parameter is missing: id")
+		return true
+	}
+	for _, songID := range songIDs {
+		if err := ValidateSongID(songID); err != nil {
+			h.logger.WithError(err).Debug("Invalid id in explain request")
+			writeExplainError(w, http.StatusBadRequest, "Invalid id parameter")
+			return true
+		}
+	}
+	fromSongID := query.Get("from")
+	if fromSongID != "" {
+		if err := ValidateSongID(fromSongID); err != nil {
+			h.logger.WithError(err).Debug("Invalid from in explain request")
+			writeExplainError(w, http.StatusBadRequest, "Invalid from parameter")
+			return true
+		}
+	}
+	filter, err := ParseSongFilter(r)
+	if err != nil {
+		h.logger.WithError(err).Debug("Invalid filter in explain request")
+		writeExplainError(w, http.StatusBadRequest, "Invalid filter parameter")
+		return true
+	}
+
+	explanation, err := h.shuffle.ExplainSongs(userID, songIDs, fromSongID, filter)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := InternalErrorMessage
+		switch {
+		case errors.Is(err, errors.ErrSongNotFound):
+			status, message = http.StatusNotFound, "Song not found"
+		case errors.Is(err, errors.ErrValidationFailed):
+			status, message = http.StatusBadRequest, "Too many id parameters"
+		default:
+			h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).Error("Failed to explain shuffle weights")
+		}
+		writeExplainError(w, status, message)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(explanation); err != nil {
+		h.logger.WithError(err).Error("Failed to encode explain response")
+		return true
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"songs":  len(songIDs),
+		"from":   SanitizeForLogging(fromSongID),
+		"userID": SanitizeForLogging(userID),
+	}).Debug("Served shuffle explanation")
+
+	return true
+}
+
+func writeExplainError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(explainErrorResponse{Error: message})
+}
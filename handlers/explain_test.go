@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestHandleExplain(t *testing.T) {
+	handler := newSimilarTestHandler(t)
+
+	req := newAuthenticatedRequest("GET", "/debug/explain?u=testuser&id=b1&id=c1&from=a1", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleExplain(w, req, "/debug/explain") {
+		t.Fatal("Expected explain request to be handled")
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", contentType)
+	}
+
+	var explanation models.ShuffleExplanation
+	if err := json.Unmarshal(w.Body.Bytes(), &explanation); err != nil {
+		t.Fatalf("Failed to decode explanation: %v", err)
+	}
+	if explanation.UserID != "testuser" || explanation.ReferenceSongID != "a1" {
+		t.Errorf("Unexpected explanation header: %+v", explanation)
+	}
+	if len(explanation.Songs) != 2 || explanation.Songs[0].Song.ID != "b1" || explanation.Songs[1].Song.ID != "c1" {
+		t.Fatalf("Expected explanations for b1 and c1 in request order, got %+v", explanation.Songs)
+	}
+	if explanation.Songs[0].PlayCount != 3 {
+		t.Errorf("Expected 3 plays of b1, got %d", explanation.Songs[0].PlayCount)
+	}
+}
+
+func TestHandleExplainErrors(t *testing.T) {
+	handler := newSimilarTestHandler(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"missing id", "/debug/explain?u=testuser", http.StatusBadRequest},
+		{"invalid id", "/debug/explain?u=testuser&id=", http.StatusBadRequest},
+		{"invalid from", "/debug/explain?u=testuser&id=b1&from=" + strings.Repeat("a", MaxSongIDLength+1), http.StatusBadRequest},
+		{"invalid filter", "/debug/explain?u=testuser&id=b1&fromYear=abc", http.StatusBadRequest},
+		{"unknown song", "/debug/explain?u=testuser&id=unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newAuthenticatedRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			if !handler.HandleExplain(w, req, "/debug/explain") {
+				t.Fatal("Expected explain request to be handled")
+			}
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+
+			var body explainErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+				t.Errorf("Expected JSON error body, got %q", w.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/debug/explain?id=b1", nil)
+	w := httptest.NewRecorder()
+	handler.HandleExplain(w, req, "/debug/explain")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without user, got %d", w.Code)
+	}
+}
@@ -1,14 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
-	"encoding/xml"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/syeo66/subsoxy/credentials"
 	"github.com/syeo66/subsoxy/errors"
 	"github.com/syeo66/subsoxy/models"
 	"github.com/syeo66/subsoxy/shuffle"
@@ -26,6 +25,19 @@ const (
 	SubsonicAPIVersion = "1.15.0"
 )
 
+// Subsonic API error codes
+const (
+	SubsonicErrorGeneric               = 0
+	SubsonicErrorMissingParameter      = 10
+	SubsonicErrorClientTooOld          = 20
+	SubsonicErrorServerTooOld          = 30
+	SubsonicErrorWrongCredentials      = 40
+	SubsonicErrorTokenAuthNotSupported = 41
+	SubsonicErrorNotAuthorized         = 50
+	SubsonicErrorTrialExpired          = 60
+	SubsonicErrorNotFound              = 70
+)
+
 // ASCII control character constants
 const (
 	ASCIIControlCharMin = 32
@@ -33,8 +45,10 @@ const (
 )
 
 type Handler struct {
-	logger  *logrus.Logger
-	shuffle *shuffle.Service
+	logger     *logrus.Logger
+	shuffle    *shuffle.Service
+	serverInfo func() models.ServerInfo
+	deviceID   func(*http.Request) string
 }
 
 func New(logger *logrus.Logger, shuffleService *shuffle.Service) *Handler {
@@ -44,6 +58,34 @@ func New(logger *logrus.Logger, shuffleService *shuffle.Service) *Handler {
 	}
 }
 
+// SetServerInfo sets the source of the upstream server details mirrored in
+// proxy-generated responses
+func (h *Handler) SetServerInfo(serverInfo func() models.ServerInfo) {
+	h.serverInfo = serverInfo
+}
+
+// SetDeviceIdentifier sets the function identifying the device a scrobble was sent from.
+// Without one, the Subsonic client name (c parameter) identifies the device.
+func (h *Handler) SetDeviceIdentifier(deviceID func(*http.Request) string) {
+	h.deviceID = deviceID
+}
+
+// scrobbleDeviceID returns the device a scrobble was sent from
+func (h *Handler) scrobbleDeviceID(r *http.Request) string {
+	if h.deviceID == nil {
+		return r.URL.Query().Get("c")
+	}
+	return h.deviceID(r)
+}
+
+// upstreamInfo returns the upstream server details, or defaults if they aren't known
+func (h *Handler) upstreamInfo() models.ServerInfo {
+	if h.serverInfo == nil {
+		return models.ServerInfo{}
+	}
+	return h.serverInfo()
+}
+
 // SanitizeForLogging removes control characters and limits length to prevent log injection
 func SanitizeForLogging(input string) string {
 	// Remove control characters (ASCII 0-31 and 127)
@@ -73,13 +115,69 @@ func ValidateSongID(songID string) error {
 	return nil
 }
 
+// ParseSongFilter extracts the getRandomSongs filter parameters (genre, fromYear, toYear, musicFolderId)
+func ParseSongFilter(r *http.Request) (models.SongFilter, error) {
+	query := r.URL.Query()
+	filter := models.SongFilter{
+		Genre:         query.Get("genre"),
+		MusicFolderID: query.Get("musicFolderId"),
+	}
+
+	if len(filter.Genre) > MaxInputLength {
+		return models.SongFilter{}, errors.ErrInvalidInput.WithContext("field", "genre").
+			WithContext("length", len(filter.Genre)).
+			WithContext("max_length", MaxInputLength)
+	}
+	if len(filter.MusicFolderID) > MaxSongIDLength {
+		return models.SongFilter{}, errors.ErrInvalidInput.WithContext("field", "musicFolderId").
+			WithContext("length", len(filter.MusicFolderID)).
+			WithContext("max_length", MaxSongIDLength)
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{
+		{"fromYear", &filter.FromYear},
+		{"toYear", &filter.ToYear},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		year, err := strconv.Atoi(value)
+		if err != nil || year < 0 {
+			return models.SongFilter{}, errors.ErrInvalidInput.WithContext("field", param.name).
+				WithContext("value", SanitizeForLogging(value))
+		}
+		*param.target = year
+	}
+
+	return filter, nil
+}
+
+// ParseRandomSeed reads the optional randomSeed parameter that makes a shuffle reproducible,
+// returning nil if it is absent
+func ParseRandomSeed(r *http.Request) (*int64, error) {
+	value := r.URL.Query().Get("randomSeed")
+	if value == "" {
+		return nil, nil
+	}
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errors.ErrInvalidInput.WithContext("field", "randomSeed").
+			WithContext("value", SanitizeForLogging(value))
+	}
+	return &seed, nil
+}
+
 func (h *Handler) HandleShuffle(w http.ResponseWriter, r *http.Request, endpoint string) bool {
-	// Extract user ID from request
-	userID := r.URL.Query().Get("u")
+	// Only serve the shuffle for the user the request authenticated as
+	userID := credentials.UserFromContext(r.Context())
 	if userID == "" {
-		h.logger.WithError(errors.ErrMissingParameter.WithContext("parameter", "u")).
-			Warn("Shuffle request missing user ID")
-		http.Error(w, "Missing user parameter", http.StatusBadRequest)
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Shuffle request without authenticated user")
+		WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorWrongCredentials, "Wrong username or password")
 		return true
 	}
 
@@ -92,7 +190,7 @@ func (h *Handler) HandleShuffle(w http.ResponseWriter, r *http.Request, endpoint
 					WithContext("value", parsedSize).
 					WithContext("max_allowed", MaxShuffleSize)
 				h.logger.WithError(validationErr).Warn("Size parameter too large")
-				http.Error(w, "Size parameter too large (max: 10000)", http.StatusBadRequest)
+				WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorGeneric, "Size parameter too large (max: 10000)")
 				return true
 			}
 			if parsedSize > 0 { // Only use valid positive sizes, otherwise keep default
@@ -102,73 +200,66 @@ func (h *Handler) HandleShuffle(w http.ResponseWriter, r *http.Request, endpoint
 			validationErr := errors.ErrInvalidInput.WithContext("field", "size").
 				WithContext("value", sizeStr)
 			h.logger.WithError(validationErr).Warn("Invalid size parameter")
-			http.Error(w, "Invalid size parameter", http.StatusBadRequest)
+			WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorGeneric, "Invalid size parameter")
 			return true
 		}
 	}
 
-	songs, err := h.shuffle.GetWeightedShuffledSongs(userID, size)
+	filter, err := ParseSongFilter(r)
 	if err != nil {
-		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).Error("Failed to get weighted shuffled songs")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		h.logger.WithError(err).Warn("Invalid filter parameter")
+		WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorGeneric, "Invalid filter parameter")
 		return true
 	}
 
-	// Check format parameter (defaults to json if not specified)
-	format := r.URL.Query().Get("f")
-	if format == "" {
-		format = "json"
+	// A seed song, album or artist ID turns the shuffle into a radio around the seed
+	seed := r.URL.Query().Get("seed")
+	if seed != "" {
+		if err := ValidateSongID(seed); err != nil {
+			h.logger.WithError(err).Warn("Invalid seed parameter")
+			WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorGeneric, "Invalid seed parameter")
+			return true
+		}
 	}
 
-	if format == "xml" {
-		// XML response
-		xmlResponse := models.XMLSubsonicResponse{
-			Status:  "ok",
-			Version: "1.15.0",
-			Songs: &models.XMLSongs{
-				Song: songs,
-			},
-		}
+	randomSeed, err := ParseRandomSeed(r)
+	if err != nil {
+		h.logger.WithError(err).Warn("Invalid randomSeed parameter")
+		WriteSubsonicError(w, r, h.upstreamInfo(), SubsonicErrorGeneric, "Invalid randomSeed parameter")
+		return true
+	}
 
-		w.Header().Set("Content-Type", "application/xml")
-		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`))
-		if err := xml.NewEncoder(w).Encode(xmlResponse); err != nil {
-			encodeErr := errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode XML shuffle response").
-				WithContext("size", size).
-				WithContext("song_count", len(songs)).
-				WithContext("userID", userID)
-			h.logger.WithError(encodeErr).Error("Failed to encode XML shuffle response")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return true
-		}
+	var songs []models.Song
+	if seed != "" {
+		songs, err = h.shuffle.GetSeededShuffledSongs(userID, seed, size, filter, randomSeed)
 	} else {
-		// JSON response (default)
-		response := map[string]interface{}{
-			"subsonic-response": map[string]interface{}{
-				"status":  "ok",
-				"version": "1.15.0",
-				"songs": map[string]interface{}{
-					"song": songs,
-				},
-			},
-		}
+		songs, err = h.shuffle.GetWeightedShuffledSongs(userID, size, filter, randomSeed)
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).Error("Failed to get weighted shuffled songs")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), err)
+		return true
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			encodeErr := errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode JSON shuffle response").
-				WithContext("size", size).
-				WithContext("song_count", len(songs)).
-				WithContext("userID", userID)
-			h.logger.WithError(encodeErr).Error("Failed to encode JSON shuffle response")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return true
-		}
+	// Shuffle responses default to JSON unless the client asks for another format
+	payload := Payload{Name: "songs", Value: models.SongList{Song: songs}}
+	if err := WriteSubsonicResponse(w, r, h.upstreamInfo(), FormatJSON, payload); err != nil {
+		encodeErr := errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode shuffle response").
+			WithContext("size", size).
+			WithContext("song_count", len(songs)).
+			WithContext("userID", userID)
+		h.logger.WithError(encodeErr).Error("Failed to encode shuffle response")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), encodeErr)
+		return true
 	}
 
 	h.logger.WithFields(logrus.Fields{
-		"size":     size,
-		"returned": len(songs),
-		"userID":   SanitizeForLogging(userID),
+		"size":         size,
+		"returned":     len(songs),
+		"userID":       SanitizeForLogging(userID),
+		"filtered":     !filter.IsEmpty(),
+		"seed":         SanitizeForLogging(seed),
+		"reproducible": randomSeed != nil,
 	}).Info("Served weighted shuffle request")
 
 	return true
@@ -185,12 +276,12 @@ func (h *Handler) HandleGetLicense(w http.ResponseWriter, r *http.Request, endpo
 }
 
 func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request, endpoint string) bool {
-	userID := r.URL.Query().Get("u")
+	userID := credentials.UserFromContext(r.Context())
 	songID := r.URL.Query().Get("id")
 
 	if userID == "" {
-		h.logger.WithError(errors.ErrMissingParameter.WithContext("parameter", "u")).
-			Warn("Stream request missing user ID")
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Stream request without authenticated user")
 		return false
 	}
 
@@ -203,7 +294,7 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request, endpoint
 		h.logger.WithFields(logrus.Fields{
 			"song_id": SanitizeForLogging(songID),
 			"user_id": SanitizeForLogging(userID),
-		}).Debug("Stream request logged (no tracking for skip detection)")
+		}).Debug("Stream request logged")
 	} else {
 		h.logger.WithError(errors.ErrMissingParameter.WithContext("parameter", "id")).
 			Warn("Stream request missing song ID")
@@ -211,14 +302,14 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request, endpoint
 	return false
 }
 
-func (h *Handler) HandleScrobble(w http.ResponseWriter, r *http.Request, endpoint string, recordFunc func(string, string, string, *string), setLastPlayed func(string, string), processScrobbleFunc func(string, string, bool) bool) bool {
-	userID := r.URL.Query().Get("u")
+func (h *Handler) HandleScrobble(w http.ResponseWriter, r *http.Request, endpoint string, recordFunc func(string, string, string, *string), setLastPlayed func(string, string), processScrobbleFunc func(string, string, string, bool) bool) bool {
+	userID := credentials.UserFromContext(r.Context())
 	songID := r.URL.Query().Get("id")
 	submission := r.URL.Query().Get("submission")
 
 	if userID == "" {
-		h.logger.WithError(errors.ErrMissingParameter.WithContext("parameter", "u")).
-			Warn("Scrobble request missing user ID")
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Scrobble request without authenticated user")
 		return false
 	}
 
@@ -238,16 +329,23 @@ func (h *Handler) HandleScrobble(w http.ResponseWriter, r *http.Request, endpoin
 
 	isSubmission := submission == "true"
 
-	// Process pending songs first (may mark earlier songs as skipped)
+	// Process the device's pending song first (may mark it as skipped)
 	// Returns true if this is a new play event, false if it's a duplicate
-	shouldRecord := processScrobbleFunc(userID, songID, isSubmission)
+	shouldRecord := processScrobbleFunc(userID, h.scrobbleDeviceID(r), songID, isSubmission)
 
 	if isSubmission && shouldRecord {
-		recordFunc(userID, songID, "play", nil)
+		// Use the previously played song as the transition source (repeats are not transitions)
+		previousSong := h.shuffle.GetLastPlayedSongID(userID)
+		if previousSong != nil && *previousSong == songID {
+			previousSong = nil
+		}
+
+		recordFunc(userID, songID, "play", previousSong)
 		setLastPlayed(userID, songID)
 		h.logger.WithFields(logrus.Fields{
-			"song_id": sanitizedSongID,
-			"user_id": sanitizedUserID,
+			"song_id":      sanitizedSongID,
+			"user_id":      sanitizedUserID,
+			"has_previous": previousSong != nil,
 		}).Debug("Recorded play event and processed pending songs")
 	} else if isSubmission && !shouldRecord {
 		h.logger.WithFields(logrus.Fields{
@@ -263,183 +361,3 @@ func (h *Handler) HandleScrobble(w http.ResponseWriter, r *http.Request, endpoin
 
 	return false
 }
-
-func (h *Handler) HandleDebug(w http.ResponseWriter, r *http.Request, endpoint string) bool {
-	userID := r.URL.Query().Get("u")
-	if userID == "" {
-		h.logger.WithError(errors.ErrMissingParameter.WithContext("parameter", "u")).
-			Warn("Debug request missing user ID")
-		http.Error(w, "Missing user parameter", http.StatusBadRequest)
-		return true
-	}
-
-	// Get optional reference song ID for transition weight calculation
-	referenceSongID := r.URL.Query().Get("id")
-	password := r.URL.Query().Get("p")
-
-	songs, err := h.shuffle.GetAllSongsWithWeights(userID)
-	if err != nil {
-		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).Error("Failed to get songs for debug")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return true
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	// Build reference song info for display
-	referenceSongInfo := "None (using current last played track)"
-	if referenceSongID != "" {
-		for _, sw := range songs {
-			if sw.Song.ID == referenceSongID {
-				referenceSongInfo = sw.Song.Title + " - " + sw.Song.Artist
-				break
-			}
-		}
-	}
-
-	html := `<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="UTF-8">
-	<title>Subsoxy Debug - User: ` + SanitizeForLogging(userID) + `</title>
-	<style>
-		body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
-		h1 { color: #333; }
-		table { border-collapse: collapse; width: 100%; background-color: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
-		th, td { border: 1px solid #ddd; padding: 12px; text-align: left; }
-		th { background-color: #4CAF50; color: white; position: sticky; top: 0; }
-		tr:nth-child(even) { background-color: #f2f2f2; }
-		tr:hover { background-color: #ddd; }
-		.num { text-align: right; font-family: monospace; }
-		.date { font-family: monospace; font-size: 0.9em; }
-		.never { color: #999; font-style: italic; }
-		.high-weight { background-color: #c8e6c9; }
-		.medium-weight { background-color: #fff9c4; }
-		.low-weight { background-color: #ffccbc; }
-		.reference-track { background-color: #bbdefb; font-weight: bold; border-left: 4px solid #2196F3; }
-		.info { margin: 20px 0; padding: 15px; background-color: #e3f2fd; border-left: 4px solid #2196F3; }
-		.song-id-link { color: #1976D2; text-decoration: none; cursor: pointer; }
-		.song-id-link:hover { text-decoration: underline; }
-	</style>
-</head>
-<body>
-	<h1>Subsoxy Debug - User: ` + SanitizeForLogging(userID) + `</h1>
-	<div class="info">
-		<strong>Total Songs:</strong> ` + strconv.Itoa(len(songs)) + `<br>
-		<strong>Reference Track:</strong> ` + referenceSongInfo + `<br>
-		<strong>Weight Calculation:</strong> Base Weight × Time Weight × Play/Skip Weight (Bayesian) × Transition Weight × Artist Weight<br>
-		<strong>Play/Skip Method:</strong> Bayesian Beta-Binomial model with α=2.0, β=2.0 for robust weighting<br>
-		<strong>Transition Weight:</strong> ` + func() string {
-		if referenceSongID != "" {
-			return "Based on transition probability from selected reference track"
-		}
-		return "Based on current last played track (click song ID to set reference)"
-	}() + `<br>
-		<strong>Color Legend:</strong>
-		<span style="background-color: #c8e6c9; padding: 2px 6px;">High (≥2.0)</span>
-		<span style="background-color: #fff9c4; padding: 2px 6px;">Medium (1.0-2.0)</span>
-		<span style="background-color: #ffccbc; padding: 2px 6px;">Low (&lt;1.0)</span>
-		<span style="background-color: #bbdefb; padding: 2px 6px; font-weight: bold;">Reference Track</span>
-	</div>
-	<table>
-		<thead>
-			<tr>
-				<th>Song ID</th>
-				<th>Title</th>
-				<th>Artist</th>
-				<th>Album</th>
-				<th class="num">Duration (s)</th>
-				<th class="num">Play Count</th>
-				<th class="num">Skip Count</th>
-				<th>Last Played</th>
-				<th>Last Skipped</th>
-				<th class="num">Time Weight</th>
-				<th class="num">Play/Skip Weight</th>
-				<th class="num">Transition Weight</th>
-				<th class="num">Artist Weight</th>
-				<th class="num">Final Weight</th>
-			</tr>
-		</thead>
-		<tbody>
-`
-
-	for _, songWeight := range songs {
-		song := songWeight.Song
-
-		// Format dates
-		lastPlayed := `<span class="never">Never</span>`
-		if !song.LastPlayed.IsZero() {
-			lastPlayed = `<span class="date">` + song.LastPlayed.Format("2006-01-02 15:04:05") + `</span>`
-		}
-
-		lastSkipped := `<span class="never">Never</span>`
-		if !song.LastSkipped.IsZero() {
-			lastSkipped = `<span class="date">` + song.LastSkipped.Format("2006-01-02 15:04:05") + `</span>`
-		}
-
-		// Calculate individual weight components based on whether we have a reference song
-		var timeWeight, playSkipWeight, transitionWeight, artistWeight float64
-		if referenceSongID != "" {
-			timeWeight, playSkipWeight, transitionWeight, artistWeight = h.shuffle.GetWeightComponentsWithTransition(userID, song, referenceSongID)
-		} else {
-			timeWeight, playSkipWeight, transitionWeight, artistWeight = h.shuffle.GetWeightComponents(userID, song)
-		}
-
-		// Recalculate final weight with the new transition weight
-		finalWeight := 1.0 * timeWeight * playSkipWeight * transitionWeight * artistWeight
-
-		// Determine row class based on final weight
-		rowClass := ""
-		isReferenceTrack := referenceSongID != "" && song.ID == referenceSongID
-
-		if isReferenceTrack {
-			rowClass = " class=\"reference-track\""
-		} else if finalWeight >= 2.0 {
-			rowClass = " class=\"high-weight\""
-		} else if finalWeight >= 1.0 {
-			rowClass = " class=\"medium-weight\""
-		} else {
-			rowClass = " class=\"low-weight\""
-		}
-
-		// Build clickable song ID link
-		songIDLink := `<a class="song-id-link" href="?u=` + userID
-		if password != "" {
-			songIDLink += `&p=` + password
-		}
-		songIDLink += `&id=` + song.ID + `">` + song.ID + `</a>`
-
-		html += `<tr` + rowClass + `>
-				<td>` + songIDLink + `</td>
-				<td>` + song.Title + `</td>
-				<td>` + song.Artist + `</td>
-				<td>` + song.Album + `</td>
-				<td class="num">` + strconv.Itoa(song.Duration) + `</td>
-				<td class="num">` + strconv.Itoa(song.PlayCount) + `</td>
-				<td class="num">` + strconv.Itoa(song.SkipCount) + `</td>
-				<td>` + lastPlayed + `</td>
-				<td>` + lastSkipped + `</td>
-				<td class="num">` + strconv.FormatFloat(timeWeight, 'f', 4, 64) + `</td>
-				<td class="num">` + strconv.FormatFloat(playSkipWeight, 'f', 4, 64) + `</td>
-				<td class="num">` + strconv.FormatFloat(transitionWeight, 'f', 4, 64) + `</td>
-				<td class="num">` + strconv.FormatFloat(artistWeight, 'f', 4, 64) + `</td>
-				<td class="num">` + strconv.FormatFloat(finalWeight, 'f', 4, 64) + `</td>
-			</tr>
-`
-	}
-
-	html += `		</tbody>
-	</table>
-</body>
-</html>`
-
-	w.Write([]byte(html))
-
-	h.logger.WithFields(logrus.Fields{
-		"userID":          SanitizeForLogging(userID),
-		"songCount":       len(songs),
-		"referenceSongID": SanitizeForLogging(referenceSongID),
-	}).Info("Served debug request")
-
-	return true
-}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -11,11 +13,22 @@ import (
 	"testing"
 
 	"github.com/sirupsen/logrus"
+	"github.com/syeo66/subsoxy/credentials"
 	"github.com/syeo66/subsoxy/database"
 	"github.com/syeo66/subsoxy/models"
 	"github.com/syeo66/subsoxy/shuffle"
 )
 
+// newAuthenticatedRequest builds a request as the proxy would hand it to a hook after
+// successfully authenticating the user named in the u parameter
+func newAuthenticatedRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	if username := req.URL.Query().Get("u"); username != "" {
+		req = req.WithContext(credentials.WithUser(req.Context(), username))
+	}
+	return req
+}
+
 func TestNew(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -73,7 +86,7 @@ func TestHandleShuffle(t *testing.T) {
 	handler := New(logger, shuffleService)
 
 	t.Run("Default size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -121,7 +134,7 @@ func TestHandleShuffle(t *testing.T) {
 	})
 
 	t.Run("Custom size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser&size=2", nil)
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&size=2", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -146,7 +159,7 @@ func TestHandleShuffle(t *testing.T) {
 	})
 
 	t.Run("Invalid size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser&size=invalid", nil)
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&size=invalid", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -155,19 +168,18 @@ func TestHandleShuffle(t *testing.T) {
 			t.Error("HandleShuffle should return true (handled)")
 		}
 
-		// Should return HTTP 400 error for invalid size parameter
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		// Should return a Subsonic error for invalid size parameter
+		code, message := decodeSubsonicError(t, w)
+		if code != SubsonicErrorGeneric {
+			t.Errorf("Expected error code %d, got %d", SubsonicErrorGeneric, code)
 		}
-
-		body := w.Body.String()
-		if !strings.Contains(body, "Invalid size parameter") {
-			t.Errorf("Expected error message about invalid size parameter, got: %s", body)
+		if message != "Invalid size parameter" {
+			t.Errorf("Expected error message about invalid size parameter, got: %s", message)
 		}
 	})
 
 	t.Run("Zero size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser&size=0", nil)
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&size=0", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -193,7 +205,7 @@ func TestHandleShuffle(t *testing.T) {
 	})
 
 	t.Run("Negative size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser&size=-5", nil)
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&size=-5", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -218,18 +230,27 @@ func TestHandleShuffle(t *testing.T) {
 		}
 	})
 
-	t.Run("Missing user parameter", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/getRandomSongs", nil)
+	t.Run("Missing authenticated user", func(t *testing.T) {
+		// The u parameter alone must not grant access to a user's shuffle
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser&f=json", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
 
 		if !handled {
-			t.Error("HandleShuffle should return true when user parameter is missing")
+			t.Error("HandleShuffle should return true when the request is not authenticated")
 		}
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("Expected status 400, got %d", w.Code)
+		var response map[string]map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if response["subsonic-response"]["status"] != "failed" {
+			t.Errorf("Expected failed status, got %v", response["subsonic-response"]["status"])
+		}
+		errorInfo, _ := response["subsonic-response"]["error"].(map[string]interface{})
+		if errorInfo["code"] != float64(SubsonicErrorWrongCredentials) {
+			t.Errorf("Expected error code 40, got %v", errorInfo["code"])
 		}
 	})
 }
@@ -250,7 +271,7 @@ func TestHandleShuffleEmpty(t *testing.T) {
 	shuffleService := shuffle.New(db, logger)
 	handler := New(logger, shuffleService)
 
-	req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
 	w := httptest.NewRecorder()
 
 	handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -294,7 +315,7 @@ func TestHandlePing(t *testing.T) {
 	shuffleService := shuffle.New(db, logger)
 	handler := New(logger, shuffleService)
 
-	req := httptest.NewRequest("GET", "/rest/ping", nil)
+	req := newAuthenticatedRequest("GET", "/rest/ping", nil)
 	w := httptest.NewRecorder()
 
 	handled := handler.HandlePing(w, req, "/rest/ping")
@@ -325,7 +346,7 @@ func TestHandleGetLicense(t *testing.T) {
 	shuffleService := shuffle.New(db, logger)
 	handler := New(logger, shuffleService)
 
-	req := httptest.NewRequest("GET", "/rest/getLicense", nil)
+	req := newAuthenticatedRequest("GET", "/rest/getLicense", nil)
 	w := httptest.NewRecorder()
 
 	handled := handler.HandleGetLicense(w, req, "/rest/getLicense")
@@ -357,7 +378,7 @@ func TestHandleStream(t *testing.T) {
 	handler := New(logger, shuffleService)
 
 	t.Run("With song ID", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/stream?u=testuser&id=123", nil)
+		req := newAuthenticatedRequest("GET", "/rest/stream?u=testuser&id=123", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleStream(w, req, "/rest/stream")
@@ -368,7 +389,7 @@ func TestHandleStream(t *testing.T) {
 	})
 
 	t.Run("Without song ID", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/stream?u=testuser", nil)
+		req := newAuthenticatedRequest("GET", "/rest/stream?u=testuser", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleStream(w, req, "/rest/stream")
@@ -379,7 +400,7 @@ func TestHandleStream(t *testing.T) {
 	})
 
 	t.Run("With empty song ID", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/stream?u=testuser&id=", nil)
+		req := newAuthenticatedRequest("GET", "/rest/stream?u=testuser&id=", nil)
 		w := httptest.NewRecorder()
 
 		handled := handler.HandleStream(w, req, "/rest/stream")
@@ -407,7 +428,7 @@ func TestHandleScrobble(t *testing.T) {
 	handler := New(logger, shuffleService)
 
 	t.Run("Play event", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&id=123&submission=true", nil)
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=123&submission=true", nil)
 		w := httptest.NewRecorder()
 
 		var recordedSongID string
@@ -425,7 +446,7 @@ func TestHandleScrobble(t *testing.T) {
 			lastPlayedSongID = songID
 		}
 
-		processScrobbleFunc := func(userID, songID string, isSubmission bool) bool {
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
 			// Mock processing of pending songs
 			return true // Allow recording
 		}
@@ -453,8 +474,55 @@ func TestHandleScrobble(t *testing.T) {
 		}
 	})
 
+	t.Run("Play event after previous play passes transition source", func(t *testing.T) {
+		shuffleService.SetLastPlayed("transitionuser", &models.Song{ID: "100"})
+
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=transitionuser&id=200&submission=true", nil)
+		w := httptest.NewRecorder()
+
+		var recordedPreviousSong *string
+		recordFunc := func(userID, songID, eventType string, previousSong *string) {
+			recordedPreviousSong = previousSong
+		}
+		setLastPlayedFunc := func(userID, songID string) {}
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
+			return true
+		}
+
+		handler.HandleScrobble(w, req, "/rest/scrobble", recordFunc, setLastPlayedFunc, processScrobbleFunc)
+
+		if recordedPreviousSong == nil {
+			t.Fatal("Expected previous song to be passed for transition recording")
+		}
+		if *recordedPreviousSong != "100" {
+			t.Errorf("Expected previous song '100', got '%s'", *recordedPreviousSong)
+		}
+	})
+
+	t.Run("Repeated play does not record self-transition", func(t *testing.T) {
+		shuffleService.SetLastPlayed("repeatuser", &models.Song{ID: "300"})
+
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=repeatuser&id=300&submission=true", nil)
+		w := httptest.NewRecorder()
+
+		var recordedPreviousSong *string
+		recordFunc := func(userID, songID, eventType string, previousSong *string) {
+			recordedPreviousSong = previousSong
+		}
+		setLastPlayedFunc := func(userID, songID string) {}
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
+			return true
+		}
+
+		handler.HandleScrobble(w, req, "/rest/scrobble", recordFunc, setLastPlayedFunc, processScrobbleFunc)
+
+		if recordedPreviousSong != nil {
+			t.Errorf("Expected no previous song for repeated play, got '%s'", *recordedPreviousSong)
+		}
+	})
+
 	t.Run("Song ended without play threshold (submission=false)", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&id=456&submission=false", nil)
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=456&submission=false", nil)
 		w := httptest.NewRecorder()
 
 		var recordCalled bool
@@ -468,7 +536,7 @@ func TestHandleScrobble(t *testing.T) {
 			lastPlayedCalled = true
 		}
 
-		processScrobbleFunc := func(userID, songID string, isSubmission bool) bool {
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
 			// Mock processing of pending songs
 			return true // Allow recording
 		}
@@ -489,7 +557,7 @@ func TestHandleScrobble(t *testing.T) {
 	})
 
 	t.Run("Without song ID", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&submission=true", nil)
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&submission=true", nil)
 		w := httptest.NewRecorder()
 
 		var recordCalled bool
@@ -503,7 +571,7 @@ func TestHandleScrobble(t *testing.T) {
 			lastPlayedCalled = true
 		}
 
-		processScrobbleFunc := func(userID, songID string, isSubmission bool) bool {
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
 			// Mock processing of pending songs
 			return true // Allow recording
 		}
@@ -524,7 +592,7 @@ func TestHandleScrobble(t *testing.T) {
 	})
 
 	t.Run("With empty song ID", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&id=&submission=true", nil)
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=&submission=true", nil)
 		w := httptest.NewRecorder()
 
 		var recordCalled bool
@@ -538,7 +606,7 @@ func TestHandleScrobble(t *testing.T) {
 			lastPlayedCalled = true
 		}
 
-		processScrobbleFunc := func(userID, songID string, isSubmission bool) bool {
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
 			// Mock processing of pending songs
 			return true // Allow recording
 		}
@@ -559,7 +627,7 @@ func TestHandleScrobble(t *testing.T) {
 	})
 
 	t.Run("Without submission parameter", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&id=789", nil)
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=789", nil)
 		w := httptest.NewRecorder()
 
 		var recordCalled bool
@@ -573,7 +641,7 @@ func TestHandleScrobble(t *testing.T) {
 			lastPlayedCalled = true
 		}
 
-		processScrobbleFunc := func(userID, songID string, isSubmission bool) bool {
+		processScrobbleFunc := func(userID, deviceID, songID string, isSubmission bool) bool {
 			// Mock processing of pending songs
 			return true // Allow recording
 		}
@@ -592,6 +660,96 @@ func TestHandleScrobble(t *testing.T) {
 			t.Error("SetLastPlayed should not be called without submission parameter")
 		}
 	})
+
+	t.Run("Device identification", func(t *testing.T) {
+		recordFunc := func(userID, songID, eventType string, previousSong *string) {}
+		setLastPlayedFunc := func(userID, songID string) {}
+
+		var deviceID string
+		processScrobbleFunc := func(userID, scrobbleDeviceID, songID string, isSubmission bool) bool {
+			deviceID = scrobbleDeviceID
+			return true
+		}
+
+		req := newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=123&c=phone", nil)
+		handler.HandleScrobble(httptest.NewRecorder(), req, "/rest/scrobble", recordFunc, setLastPlayedFunc, processScrobbleFunc)
+		if deviceID != "phone" {
+			t.Errorf("Expected client name as default device ID, got '%s'", deviceID)
+		}
+
+		handler.SetDeviceIdentifier(func(r *http.Request) string { return "custom-device" })
+		defer handler.SetDeviceIdentifier(nil)
+		req = newAuthenticatedRequest("GET", "/rest/scrobble?u=testuser&id=123&c=phone", nil)
+		handler.HandleScrobble(httptest.NewRecorder(), req, "/rest/scrobble", recordFunc, setLastPlayedFunc, processScrobbleFunc)
+		if deviceID != "custom-device" {
+			t.Errorf("Expected device ID from identifier, got '%s'", deviceID)
+		}
+	})
+}
+
+func TestParseSongFilter(t *testing.T) {
+	t.Run("All parameters", func(t *testing.T) {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&genre=Rock&fromYear=1990&toYear=1999&musicFolderId=3", nil)
+		filter, err := ParseSongFilter(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := models.SongFilter{Genre: "Rock", FromYear: 1990, ToYear: 1999, MusicFolderID: "3"}
+		if filter != expected {
+			t.Errorf("Expected %+v, got %+v", expected, filter)
+		}
+	})
+
+	t.Run("No parameters", func(t *testing.T) {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
+		filter, err := ParseSongFilter(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !filter.IsEmpty() {
+			t.Errorf("Expected empty filter, got %+v", filter)
+		}
+	})
+
+	t.Run("Invalid year", func(t *testing.T) {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&fromYear=nineties", nil)
+		if _, err := ParseSongFilter(req); err == nil {
+			t.Error("Expected error for non-numeric fromYear")
+		}
+	})
+
+	t.Run("Negative year", func(t *testing.T) {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&toYear=-1", nil)
+		if _, err := ParseSongFilter(req); err == nil {
+			t.Error("Expected error for negative toYear")
+		}
+	})
+}
+
+func TestHandleShuffleInvalidFilter(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	handler := New(logger, shuffle.New(db, logger))
+
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&fromYear=abc", nil)
+	w := httptest.NewRecorder()
+
+	if !handler.HandleShuffle(w, req, "/rest/getRandomSongs") {
+		t.Error("HandleShuffle should handle the request")
+	}
+	if _, message := decodeSubsonicError(t, w); message != "Invalid filter parameter" {
+		t.Errorf("Expected error message about invalid filter, got: %s", message)
+	}
 }
 
 func TestHandleShuffleContentType(t *testing.T) {
@@ -610,7 +768,7 @@ func TestHandleShuffleContentType(t *testing.T) {
 	shuffleService := shuffle.New(db, logger)
 	handler := New(logger, shuffleService)
 
-	req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser", nil)
 	w := httptest.NewRecorder()
 
 	handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -621,6 +779,182 @@ func TestHandleShuffleContentType(t *testing.T) {
 	}
 }
 
+func TestHandleShuffleJSONP(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	handler := New(logger, shuffle.New(db, logger))
+	handler.SetServerInfo(func() models.ServerInfo {
+		return models.ServerInfo{Version: "1.16.1", OpenSubsonic: true}
+	})
+
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&f=jsonp&callback=handleSongs", nil)
+	w := httptest.NewRecorder()
+
+	handler.HandleShuffle(w, req, "/rest/getRandomSongs")
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/javascript" {
+		t.Errorf("Expected Content-Type 'application/javascript', got '%s'", contentType)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "handleSongs(") || !strings.Contains(body, `"version":"1.16.1"`) {
+		t.Errorf("Expected JSONP response with upstream version, got %s", body)
+	}
+}
+
+func TestHandleShuffleSongMetadata(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_shuffle_metadata.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.StoreSongs("testuser", []models.Song{{
+		ID: "1", Title: "Song", Artist: "Artist", Album: "Album", Duration: 200,
+		AlbumID: "al-1", ArtistID: "ar-1", Track: 4, Suffix: "flac", ContentType: "audio/flac",
+		BitRate: 900, Size: 22500000, Path: "Artist/Album/04 - Song.flac",
+	}}); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	handler := New(logger, shuffle.New(db, logger))
+
+	for _, format := range []string{"json", "xml"} {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&f="+format, nil)
+		w := httptest.NewRecorder()
+
+		handler.HandleShuffle(w, req, "/rest/getRandomSongs")
+
+		body := w.Body.String()
+		for _, attribute := range []string{"albumId", "artistId", "track", "suffix", "contentType", "bitRate", "size", "path"} {
+			if !strings.Contains(body, attribute) {
+				t.Errorf("Expected %s response to contain %s, got %s", format, attribute, body)
+			}
+		}
+		for _, internal := range []string{"adjustedPlays", "adjustedSkips", "skipCount", "lastSkipped"} {
+			if strings.Contains(body, internal) {
+				t.Errorf("Expected %s response to omit %s, got %s", format, internal, body)
+			}
+		}
+	}
+}
+
+func TestHandleShuffleSeed(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_shuffle_seed.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.StoreSongs("testuser", []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", ArtistID: "ar-a"},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", ArtistID: "ar-a"},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", ArtistID: "ar-b"},
+	}); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	handler := New(logger, shuffle.New(db, logger))
+
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&seed=a1&size=1", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleShuffle(w, req, "/rest/getRandomSongs") {
+		t.Fatal("HandleShuffle should handle the request")
+	}
+
+	var response struct {
+		SubsonicResponse struct {
+			Songs models.SongList `json:"songs"`
+		} `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if songs := response.SubsonicResponse.Songs.Song; len(songs) != 1 || songs[0].ID != "a2" {
+		t.Errorf("Expected the other song by the seed's artist, got %+v", songs)
+	}
+
+	tests := []struct {
+		seed    string
+		code    int
+		message string
+	}{
+		{"unknown", SubsonicErrorNotFound, "song not found"},
+		{strings.Repeat("x", MaxSongIDLength+1), SubsonicErrorGeneric, "Invalid seed parameter"},
+	}
+	for _, tt := range tests {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&seed="+tt.seed, nil)
+		w := httptest.NewRecorder()
+		handler.HandleShuffle(w, req, "/rest/getRandomSongs")
+
+		if code, message := decodeSubsonicError(t, w); code != tt.code || message != tt.message {
+			t.Errorf("Expected error %d %q for seed %q, got %d %q", tt.code, tt.message, tt.seed, code, message)
+		}
+	}
+}
+
+func TestHandleShuffleRandomSeed(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_shuffle_random_seed.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	songs := make([]models.Song, 20)
+	for i := range songs {
+		songs[i] = models.Song{ID: fmt.Sprintf("%d", i), Title: "Song", Artist: "Artist", Album: "Album"}
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	handler := New(logger, shuffle.New(db, logger))
+
+	shuffleBody := func(query string) string {
+		req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&size=10"+query, nil)
+		w := httptest.NewRecorder()
+		if !handler.HandleShuffle(w, req, "/rest/getRandomSongs") {
+			t.Fatal("HandleShuffle should handle the request")
+		}
+		return w.Body.String()
+	}
+
+	if first, second := shuffleBody("&randomSeed=42"), shuffleBody("&randomSeed=42"); first != second {
+		t.Errorf("Expected identical shuffles for the same random seed, got %s and %s", first, second)
+	}
+
+	req := newAuthenticatedRequest("GET", "/rest/getRandomSongs?u=testuser&randomSeed=abc", nil)
+	w := httptest.NewRecorder()
+	handler.HandleShuffle(w, req, "/rest/getRandomSongs")
+	if _, message := decodeSubsonicError(t, w); message != "Invalid randomSeed parameter" {
+		t.Errorf("Expected error message about invalid random seed, got: %s", message)
+	}
+}
+
 func TestHandleShuffleWithURLParams(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -639,7 +973,7 @@ func TestHandleShuffleWithURLParams(t *testing.T) {
 
 	// Test with multiple query parameters
 	reqURL := "/rest/getRandomSongs?size=10&v=1.15.0&u=testuser&p=testpass&c=testclient&f=json"
-	req := httptest.NewRequest("GET", reqURL, nil)
+	req := newAuthenticatedRequest("GET", reqURL, nil)
 	w := httptest.NewRecorder()
 
 	handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -791,6 +1125,7 @@ func TestShuffleSizeBoundaryConditions(t *testing.T) {
 		name           string
 		sizeParam      string
 		expectedStatus int
+		expectFailed   bool
 		shouldHandle   bool
 	}{
 		{
@@ -808,19 +1143,22 @@ func TestShuffleSizeBoundaryConditions(t *testing.T) {
 		{
 			name:           "Very large size",
 			sizeParam:      "999999",
-			expectedStatus: http.StatusBadRequest, // Very large sizes are rejected
+			expectedStatus: http.StatusOK,
+			expectFailed:   true, // Very large sizes are rejected
 			shouldHandle:   true,
 		},
 		{
 			name:           "Non-numeric size",
 			sizeParam:      "abc",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusOK,
+			expectFailed:   true,
 			shouldHandle:   true,
 		},
 		{
 			name:           "Float size",
 			sizeParam:      "10.5",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusOK,
+			expectFailed:   true,
 			shouldHandle:   true,
 		},
 		{
@@ -844,7 +1182,7 @@ func TestShuffleSizeBoundaryConditions(t *testing.T) {
 				reqURL += "&size=" + tt.sizeParam
 			}
 
-			req := httptest.NewRequest("GET", reqURL, nil)
+			req := newAuthenticatedRequest("GET", reqURL, nil)
 			w := httptest.NewRecorder()
 
 			handled := handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -856,6 +1194,9 @@ func TestShuffleSizeBoundaryConditions(t *testing.T) {
 			if w.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
 			}
+			if failed := strings.Contains(w.Body.String(), `status="failed"`); failed != tt.expectFailed {
+				t.Errorf("Expected failed response=%v, got body: %s", tt.expectFailed, w.Body.String())
+			}
 		})
 	}
 }
@@ -880,11 +1221,13 @@ func TestHandlerUserParameterBoundaryConditions(t *testing.T) {
 		name           string
 		userParam      string
 		expectedStatus int
+		expectFailed   bool
 	}{
 		{
 			name:           "Empty user",
 			userParam:      "",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusOK, // Subsonic errors are reported in the response body
+			expectFailed:   true,
 		},
 		{
 			name:           "Very long username",
@@ -915,7 +1258,7 @@ func TestHandlerUserParameterBoundaryConditions(t *testing.T) {
 				reqURL += "&u=" + url.QueryEscape(tt.userParam)
 			}
 
-			req := httptest.NewRequest("GET", reqURL, nil)
+			req := newAuthenticatedRequest("GET", reqURL, nil)
 			w := httptest.NewRecorder()
 
 			handler.HandleShuffle(w, req, "/rest/getRandomSongs")
@@ -923,6 +1266,9 @@ func TestHandlerUserParameterBoundaryConditions(t *testing.T) {
 			if w.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
 			}
+			if failed := strings.Contains(w.Body.String(), `status="failed"`); failed != tt.expectFailed {
+				t.Errorf("Expected failed response %v, got body %s", tt.expectFailed, w.Body.String())
+			}
 		})
 	}
 }
@@ -989,7 +1335,7 @@ func TestHandleShuffleXMLFormat(t *testing.T) {
 				reqURL += "&f=" + tt.formatParam
 			}
 
-			req := httptest.NewRequest("GET", reqURL, nil)
+			req := newAuthenticatedRequest("GET", reqURL, nil)
 			w := httptest.NewRecorder()
 
 			handler.HandleShuffle(w, req, "/rest/getRandomSongs")
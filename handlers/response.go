@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Subsonic response formats selected by the f parameter
+const (
+	FormatXML   = "xml"
+	FormatJSON  = "json"
+	FormatJSONP = "jsonp"
+
+	MaxCallbackLength = 100
+)
+
+// InternalErrorMessage is sent to clients instead of the details of internal failures
+const InternalErrorMessage = "Internal server error"
+
+// ResponseFormat returns the response format requested by the f parameter, or
+// defaultFormat if none was requested. JSONP without a usable callback falls back
+// to plain JSON.
+func ResponseFormat(r *http.Request, defaultFormat string) string {
+	switch r.URL.Query().Get("f") {
+	case FormatXML:
+		return FormatXML
+	case FormatJSON:
+		return FormatJSON
+	case FormatJSONP:
+		if validCallback(r.URL.Query().Get("callback")) {
+			return FormatJSONP
+		}
+		return FormatJSON
+	default:
+		return defaultFormat
+	}
+}
+
+// validCallback only accepts JavaScript identifiers and dotted paths, so the callback
+// can't inject script into the JSONP response
+func validCallback(callback string) bool {
+	if callback == "" || len(callback) > MaxCallbackLength {
+		return false
+	}
+	for i, r := range callback {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == '$':
+		case r >= '0' && r <= '9', r == '.':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SubsonicNamespace is the XML namespace of Subsonic responses
+const SubsonicNamespace = "http://subsonic.org/restapi"
+
+// Payload is the content of a successful response. It is encoded as the element Name
+// in XML and as the key Name in JSON, so Value needs matching xml and json tags.
+type Payload struct {
+	Name  string
+	Value interface{}
+}
+
+// MarshalXML encodes the payload value as an element named after the payload
+func (p Payload) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: p.Name}
+	return e.EncodeElement(p.Value, start)
+}
+
+// xmlEnvelope is the subsonic-response element of proxy-generated XML responses
+type xmlEnvelope struct {
+	XMLName       xml.Name         `xml:"subsonic-response"`
+	Xmlns         string           `xml:"xmlns,attr"`
+	Status        string           `xml:"status,attr"`
+	Version       string           `xml:"version,attr"`
+	Type          string           `xml:"type,attr,omitempty"`
+	ServerVersion string           `xml:"serverVersion,attr,omitempty"`
+	OpenSubsonic  bool             `xml:"openSubsonic,attr,omitempty"`
+	Error         *models.XMLError `xml:"error,omitempty"`
+	Payload       *Payload         `xml:",omitempty"`
+}
+
+// WriteSubsonicResponse writes a successful Subsonic response carrying payload. The format
+// is negotiated from the f and callback parameters, falling back to defaultFormat, and the
+// envelope mirrors the version and OpenSubsonic fields reported by the upstream server.
+// The response is encoded before anything is written, so on error the caller can still
+// reply with an error response.
+func WriteSubsonicResponse(w http.ResponseWriter, r *http.Request, info models.ServerInfo, defaultFormat string, payload Payload) error {
+	return writeEnvelope(w, r, info, ResponseFormat(r, defaultFormat), "ok", nil, &payload)
+}
+
+// WriteSubsonicError writes a Subsonic "failed" response with the given error code,
+// using the format requested by the client (XML unless f=json or f=jsonp). As in the
+// Subsonic API, errors are reported in the response body with HTTP status 200.
+func WriteSubsonicError(w http.ResponseWriter, r *http.Request, info models.ServerInfo, code int, message string) {
+	subsonicErr := &models.XMLError{Code: code, Message: message}
+	if err := writeEnvelope(w, r, info, ResponseFormat(r, FormatXML), "failed", subsonicErr, nil); err != nil {
+		http.Error(w, InternalErrorMessage, http.StatusInternalServerError)
+	}
+}
+
+func writeEnvelope(w http.ResponseWriter, r *http.Request, info models.ServerInfo, format, status string, subsonicErr *models.XMLError, payload *Payload) error {
+	if info.Version == "" {
+		info.Version = SubsonicAPIVersion
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/json"
+
+	if format == FormatXML {
+		contentType = "application/xml"
+		buf.WriteString(xml.Header)
+		envelope := xmlEnvelope{
+			Xmlns:         SubsonicNamespace,
+			Status:        status,
+			Version:       info.Version,
+			Type:          info.Type,
+			ServerVersion: info.ServerVersion,
+			OpenSubsonic:  info.OpenSubsonic,
+			Error:         subsonicErr,
+			Payload:       payload,
+		}
+		if err := xml.NewEncoder(&buf).Encode(envelope); err != nil {
+			return errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode XML response")
+		}
+	} else {
+		envelope := map[string]interface{}{
+			"status":  status,
+			"version": info.Version,
+		}
+		if info.Type != "" {
+			envelope["type"] = info.Type
+		}
+		if info.ServerVersion != "" {
+			envelope["serverVersion"] = info.ServerVersion
+		}
+		if info.OpenSubsonic {
+			envelope["openSubsonic"] = true
+		}
+		if subsonicErr != nil {
+			envelope["error"] = map[string]interface{}{
+				"code":    subsonicErr.Code,
+				"message": subsonicErr.Message,
+			}
+		}
+		if payload != nil {
+			envelope[payload.Name] = payload.Value
+		}
+
+		callback := ""
+		if format == FormatJSONP {
+			contentType = "application/javascript"
+			callback = r.URL.Query().Get("callback")
+			buf.WriteString(callback + "(")
+		}
+		if err := json.NewEncoder(&buf).Encode(map[string]interface{}{"subsonic-response": envelope}); err != nil {
+			return errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode JSON response")
+		}
+		if callback != "" {
+			buf.WriteString(");")
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteSubsonicErrorFor writes the Subsonic error response matching err
+func WriteSubsonicErrorFor(w http.ResponseWriter, r *http.Request, info models.ServerInfo, err error) {
+	code, message := SubsonicErrorFor(err)
+	WriteSubsonicError(w, r, info, code, message)
+}
+
+// SubsonicErrorFor maps an error to a Subsonic error code and a client-facing message.
+// Validation and authentication errors describe the problem; database, server and
+// network failures are reported as a generic error without internal details. The error
+// context is never exposed, it is meant for the logs only.
+func SubsonicErrorFor(err error) (int, string) {
+	var subsoxyErr *errors.SubsoxyError
+	if !errors.As(err, &subsoxyErr) {
+		return SubsonicErrorGeneric, InternalErrorMessage
+	}
+
+	switch subsoxyErr.Category {
+	case errors.CategoryValidation:
+		code := SubsonicErrorGeneric
+		if subsoxyErr.Code == errors.ErrMissingParameter.Code {
+			code = SubsonicErrorMissingParameter
+		}
+		return code, subsoxyErr.Message
+	case errors.CategoryCredentials:
+		if errors.Is(err, errors.ErrInvalidCredentials) || errors.Is(err, errors.ErrUpstreamAuth) {
+			return SubsonicErrorWrongCredentials, "Wrong username or password"
+		}
+		return SubsonicErrorGeneric, "Unable to verify credentials with upstream server"
+	case errors.CategoryAuth:
+		if errors.Is(err, errors.ErrUnauthorized) {
+			return SubsonicErrorWrongCredentials, "Wrong username or password"
+		}
+		return SubsonicErrorNotAuthorized, subsoxyErr.Message
+	case errors.CategoryDatabase:
+		if errors.Is(err, errors.ErrSongNotFound) {
+			return SubsonicErrorNotFound, subsoxyErr.Message
+		}
+	}
+	return SubsonicErrorGeneric, InternalErrorMessage
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// decodeSubsonicError parses an XML Subsonic error response and returns its code and message
+func decodeSubsonicError(t *testing.T, w *httptest.ResponseRecorder) (int, string) {
+	t.Helper()
+
+	if w.Code != 200 {
+		t.Errorf("Expected Subsonic errors to use status 200, got %d", w.Code)
+	}
+	var response models.XMLSubsonicResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse XML response: %v", err)
+	}
+	if response.Status != "failed" || response.Error == nil {
+		t.Fatalf("Expected failed response, got %+v", response)
+	}
+	return response.Error.Code, response.Error.Message
+}
+
+func TestWriteSubsonicError(t *testing.T) {
+	t.Run("XML by default", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs", nil)
+		w := httptest.NewRecorder()
+
+		WriteSubsonicError(w, req, models.ServerInfo{}, SubsonicErrorWrongCredentials, "Wrong username or password")
+
+		if w.Header().Get("Content-Type") != "application/xml" {
+			t.Errorf("Expected XML content type, got %s", w.Header().Get("Content-Type"))
+		}
+		if code, _ := decodeSubsonicError(t, w); code != SubsonicErrorWrongCredentials {
+			t.Errorf("Expected code %d, got %d", SubsonicErrorWrongCredentials, code)
+		}
+	})
+
+	t.Run("JSON when requested", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?f=json", nil)
+		w := httptest.NewRecorder()
+
+		WriteSubsonicError(w, req, models.ServerInfo{}, SubsonicErrorWrongCredentials, "Wrong username or password")
+
+		var response map[string]map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		errorInfo, _ := response["subsonic-response"]["error"].(map[string]interface{})
+		if errorInfo["code"] != float64(SubsonicErrorWrongCredentials) || errorInfo["message"] != "Wrong username or password" {
+			t.Errorf("Unexpected error response: %v", response)
+		}
+	})
+
+	t.Run("JSONP with callback", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?f=jsonp&callback=app.onResponse", nil)
+		w := httptest.NewRecorder()
+
+		WriteSubsonicError(w, req, models.ServerInfo{}, SubsonicErrorNotFound, "song not found")
+
+		if w.Header().Get("Content-Type") != "application/javascript" {
+			t.Errorf("Expected JavaScript content type, got %s", w.Header().Get("Content-Type"))
+		}
+		body := w.Body.String()
+		if !strings.HasPrefix(body, "app.onResponse(") || !strings.HasSuffix(body, ");") {
+			t.Fatalf("Expected body wrapped in callback, got %s", body)
+		}
+		payload := strings.TrimSuffix(strings.TrimPrefix(body, "app.onResponse("), ");")
+		var response map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &response); err != nil {
+			t.Fatalf("Failed to parse JSONP payload: %v", err)
+		}
+		if response["subsonic-response"]["status"] != "failed" {
+			t.Errorf("Unexpected error response: %v", response)
+		}
+	})
+
+	t.Run("JSONP with unsafe callback falls back to JSON", func(t *testing.T) {
+		for _, callback := range []string{"", "alert(1)//", "1abc", "a b", strings.Repeat("a", MaxCallbackLength+1)} {
+			req := httptest.NewRequest("GET", "/rest/getRandomSongs?f=jsonp&callback="+strings.ReplaceAll(callback, " ", "%20"), nil)
+			w := httptest.NewRecorder()
+
+			WriteSubsonicError(w, req, models.ServerInfo{}, SubsonicErrorGeneric, "error")
+
+			if w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Expected JSON content type for callback %q, got %s", callback, w.Header().Get("Content-Type"))
+			}
+			if !json.Valid(w.Body.Bytes()) {
+				t.Errorf("Expected plain JSON body for callback %q, got %s", callback, w.Body.String())
+			}
+		}
+	})
+}
+
+func TestWriteSubsonicResponse(t *testing.T) {
+	info := models.ServerInfo{Version: "1.16.1", Type: "navidrome", ServerVersion: "0.53.3", OpenSubsonic: true}
+	payload := Payload{Name: "songs", Value: models.SongList{Song: []models.Song{{ID: "1", Title: "Song 1", Artist: "Artist"}}}}
+
+	t.Run("XML mirrors upstream server", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?f=xml", nil)
+		w := httptest.NewRecorder()
+
+		if err := WriteSubsonicResponse(w, req, info, FormatJSON, payload); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var response models.XMLSubsonicResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse XML response: %v", err)
+		}
+		if response.Status != "ok" || response.Version != "1.16.1" || response.Type != "navidrome" ||
+			response.ServerVersion != "0.53.3" || !response.OpenSubsonic {
+			t.Errorf("Unexpected envelope: %+v", response)
+		}
+		if response.Songs == nil || len(response.Songs.Song) != 1 || response.Songs.Song[0].Title != "Song 1" {
+			t.Errorf("Unexpected songs: %+v", response.Songs)
+		}
+		if !strings.Contains(w.Body.String(), `xmlns="`+SubsonicNamespace+`"`) {
+			t.Errorf("Expected Subsonic namespace, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("default format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs", nil)
+		w := httptest.NewRecorder()
+
+		if err := WriteSubsonicResponse(w, req, models.ServerInfo{}, FormatJSON, payload); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var response map[string]map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse JSON response: %v", err)
+		}
+		envelope := response["subsonic-response"]
+		if envelope["version"] != SubsonicAPIVersion {
+			t.Errorf("Expected fallback version %s, got %v", SubsonicAPIVersion, envelope["version"])
+		}
+		if _, ok := envelope["openSubsonic"]; ok {
+			t.Error("Expected no OpenSubsonic fields for a plain Subsonic upstream")
+		}
+	})
+
+	t.Run("JSONP mirrors upstream server", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?f=jsonp&callback=cb", nil)
+		w := httptest.NewRecorder()
+
+		if err := WriteSubsonicResponse(w, req, info, FormatXML, payload); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		body := w.Body.String()
+		if !strings.HasPrefix(body, "cb(") || !strings.HasSuffix(body, ");") {
+			t.Fatalf("Expected body wrapped in callback, got %s", body)
+		}
+		var response map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(body, "cb("), ");")), &response); err != nil {
+			t.Fatalf("Failed to parse JSONP payload: %v", err)
+		}
+		envelope := response["subsonic-response"]
+		if envelope["type"] != "navidrome" || envelope["serverVersion"] != "0.53.3" || envelope["openSubsonic"] != true {
+			t.Errorf("Unexpected envelope: %v", envelope)
+		}
+		songs, _ := envelope["songs"].(map[string]interface{})
+		if list, _ := songs["song"].([]interface{}); len(list) != 1 {
+			t.Errorf("Expected 1 song, got %v", envelope["songs"])
+		}
+	})
+}
+
+func TestSubsonicErrorFor(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedCode    int
+		expectedMessage string
+	}{
+		{"missing parameter", errors.ErrMissingParameter.WithContext("parameter", "id"), SubsonicErrorMissingParameter, "missing required parameter"},
+		{"invalid input", errors.ErrInvalidInput.WithContext("field", "size"), SubsonicErrorGeneric, "invalid input"},
+		{"validation failed", errors.ErrValidationFailed, SubsonicErrorGeneric, "validation failed"},
+		{"invalid credentials", errors.ErrInvalidCredentials, SubsonicErrorWrongCredentials, "Wrong username or password"},
+		{"upstream auth", errors.ErrUpstreamAuth.WithContext("status", 401), SubsonicErrorWrongCredentials, "Wrong username or password"},
+		{"credential check failed", errors.ErrCredentialsValidation, SubsonicErrorGeneric, "Unable to verify credentials with upstream server"},
+		{"unauthorized", errors.ErrUnauthorized, SubsonicErrorWrongCredentials, "Wrong username or password"},
+		{"song not found", errors.ErrSongNotFound.WithContext("parameter", "id"), SubsonicErrorNotFound, "song not found"},
+		{"database failure", errors.Wrap(errors.New("x", "y", "z"), errors.CategoryDatabase, "QUERY_FAILED", "database query failed"), SubsonicErrorGeneric, InternalErrorMessage},
+		{"network failure", errors.ErrUpstreamError, SubsonicErrorGeneric, InternalErrorMessage},
+		{"plain error", errors.New("other", "PLAIN", "plain"), SubsonicErrorGeneric, InternalErrorMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := SubsonicErrorFor(tt.err)
+			if code != tt.expectedCode || message != tt.expectedMessage {
+				t.Errorf("Expected (%d, %q), got (%d, %q)", tt.expectedCode, tt.expectedMessage, code, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Similar and top songs constants
+const (
+	DefaultSimilarSongsCount = 50
+	MaxSimilarSongsCount     = 500
+)
+
+// HandleSimilarSongs answers getSimilarSongs and getSimilarSongs2 from the user's listening
+// data. Requests the proxy can't answer (invalid parameters, unknown seed or too little
+// listening history) are passed on to the upstream server.
+func (h *Handler) HandleSimilarSongs(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	userID := credentials.UserFromContext(r.Context())
+	if userID == "" {
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Similar songs request without authenticated user")
+		return false
+	}
+
+	seedID := r.URL.Query().Get("id")
+	if err := ValidateSongID(seedID); err != nil {
+		h.logger.WithError(err).Debug("Invalid id in similar songs request, passing to upstream")
+		return false
+	}
+	count, ok := parseSongCount(r)
+	if !ok {
+		return false
+	}
+
+	songs, err := h.shuffle.GetSimilarSongs(userID, seedID, count)
+	if err != nil {
+		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).
+			Warn("Failed to calculate similar songs, passing to upstream")
+		return false
+	}
+	if len(songs) == 0 {
+		return false
+	}
+
+	element := "similarSongs"
+	if strings.HasSuffix(strings.TrimSuffix(endpoint, ".view"), "2") {
+		element = "similarSongs2"
+	}
+	h.writeSongList(w, r, element, songs)
+
+	h.logger.WithFields(logrus.Fields{
+		"seed":     SanitizeForLogging(seedID),
+		"returned": len(songs),
+		"userID":   SanitizeForLogging(userID),
+	}).Info("Served similar songs from listening data")
+
+	return true
+}
+
+// HandleTopSongs answers getTopSongs with the songs of the artist the user played most.
+// Requests for artists the user rarely played are passed on to the upstream server.
+func (h *Handler) HandleTopSongs(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	userID := credentials.UserFromContext(r.Context())
+	if userID == "" {
+		h.logger.WithError(errors.ErrUnauthorized.WithContext("endpoint", endpoint)).
+			Warn("Top songs request without authenticated user")
+		return false
+	}
+
+	artist := r.URL.Query().Get("artist")
+	if artist == "" || len(artist) > MaxInputLength {
+		return false
+	}
+	count, ok := parseSongCount(r)
+	if !ok {
+		return false
+	}
+
+	songs, err := h.shuffle.GetTopSongs(userID, artist, count)
+	if err != nil {
+		h.logger.WithError(err).WithField("userID", SanitizeForLogging(userID)).
+			Warn("Failed to get top songs, passing to upstream")
+		return false
+	}
+	if len(songs) == 0 {
+		return false
+	}
+
+	h.writeSongList(w, r, "topSongs", songs)
+
+	h.logger.WithFields(logrus.Fields{
+		"artist":   SanitizeForLogging(artist),
+		"returned": len(songs),
+		"userID":   SanitizeForLogging(userID),
+	}).Info("Served top songs from listening data")
+
+	return true
+}
+
+// parseSongCount returns the count parameter, DefaultSimilarSongsCount if it is missing,
+// or false if it is not a positive number
+func parseSongCount(r *http.Request) (int, bool) {
+	return parseBoundedInt(r.URL.Query().Get("count"), DefaultSimilarSongsCount, 1, MaxSimilarSongsCount)
+}
+
+// writeSongList writes songs as a standard Subsonic song list response
+func (h *Handler) writeSongList(w http.ResponseWriter, r *http.Request, element string, songs []models.Song) {
+	payload := Payload{Name: element, Value: models.SongList{Song: songs}}
+	if err := WriteSubsonicResponse(w, r, h.upstreamInfo(), FormatXML, payload); err != nil {
+		encodeErr := errors.Wrap(err, errors.CategoryServer, "RESPONSE_ENCODING_FAILED", "failed to encode song list response").
+			WithContext("element", element).
+			WithContext("song_count", len(songs))
+		h.logger.WithError(encodeErr).Error("Failed to encode song list response")
+		WriteSubsonicErrorFor(w, r, h.upstreamInfo(), encodeErr)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+	"github.com/syeo66/subsoxy/shuffle"
+)
+
+// newSimilarTestHandler creates a handler whose user played Artist B around Artist A and
+// played b1 three times; Artist C was never played
+func newSimilarTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_similar.db"
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", Duration: 200},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", Duration: 200},
+		{ID: "b2", Title: "B2", Artist: "Artist B", Album: "Album B", Duration: 200},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", Duration: 200},
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.RecordTransition("testuser", "a1", "b1", "play"); err != nil {
+			t.Fatalf("Failed to record transition: %v", err)
+		}
+		if err := db.RecordPlayEvent("testuser", "b1", "play", nil); err != nil {
+			t.Fatalf("Failed to record play: %v", err)
+		}
+	}
+
+	return New(logger, shuffle.New(db, logger))
+}
+
+type songListResponse struct {
+	Status string `xml:"status,attr"`
+	Lists  []struct {
+		XMLName xml.Name
+		Songs   []models.Song `xml:"song"`
+	} `xml:",any"`
+}
+
+func TestHandleSimilarSongs(t *testing.T) {
+	handler := newSimilarTestHandler(t)
+
+	tests := []struct {
+		endpoint string
+		element  string
+	}{
+		{"/rest/getSimilarSongs", "similarSongs"},
+		{"/rest/getSimilarSongs2", "similarSongs2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			req := newAuthenticatedRequest("GET", tt.endpoint+"?u=testuser&id=a1&count=5", nil)
+			w := httptest.NewRecorder()
+
+			if !handler.HandleSimilarSongs(w, req, tt.endpoint) {
+				t.Fatal("Expected similar songs to be served from listening data")
+			}
+
+			var response songListResponse
+			if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse XML response: %v", err)
+			}
+			if response.Status != "ok" || len(response.Lists) != 1 || response.Lists[0].XMLName.Local != tt.element {
+				t.Fatalf("Expected an ok response with a %s element, got %s", tt.element, w.Body.String())
+			}
+			if len(response.Lists[0].Songs) != 2 {
+				t.Errorf("Expected both songs by Artist B, got %d songs", len(response.Lists[0].Songs))
+			}
+		})
+	}
+}
+
+func TestHandleSimilarSongsFallback(t *testing.T) {
+	handler := newSimilarTestHandler(t)
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"no history", "/rest/getSimilarSongs2?u=testuser&id=c1"},
+		{"unknown seed", "/rest/getSimilarSongs2?u=testuser&id=unknown"},
+		{"missing id", "/rest/getSimilarSongs2?u=testuser"},
+		{"invalid count", "/rest/getSimilarSongs2?u=testuser&id=a1&count=abc"},
+		{"unauthenticated", "/rest/getSimilarSongs2?id=a1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if handler.HandleSimilarSongs(w, newAuthenticatedRequest("GET", tt.target, nil), "/rest/getSimilarSongs2") {
+				t.Error("Expected the request to be passed to upstream")
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("Expected nothing written on fallback, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleTopSongs(t *testing.T) {
+	handler := newSimilarTestHandler(t)
+
+	req := newAuthenticatedRequest("GET", "/rest/getTopSongs?u=testuser&artist=Artist%20B", nil)
+	w := httptest.NewRecorder()
+	if !handler.HandleTopSongs(w, req, "/rest/getTopSongs") {
+		t.Fatal("Expected top songs to be served from listening data")
+	}
+
+	var response songListResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse XML response: %v", err)
+	}
+	if len(response.Lists) != 1 || response.Lists[0].XMLName.Local != "topSongs" {
+		t.Fatalf("Expected a topSongs element, got %s", w.Body.String())
+	}
+	if songs := response.Lists[0].Songs; len(songs) != 1 || songs[0].ID != "b1" {
+		t.Errorf("Expected only the played song b1, got %+v", songs)
+	}
+
+	req = newAuthenticatedRequest("GET", "/rest/getTopSongs?u=testuser&artist=Artist%20A", nil)
+	w = httptest.NewRecorder()
+	if handler.HandleTopSongs(w, req, "/rest/getTopSongs") {
+		t.Error("Expected top songs of a rarely played artist to be passed to upstream")
+	}
+}
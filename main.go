@@ -41,24 +41,44 @@ func main() {
 		return handlers.HandleGetLicense(w, r, endpoint)
 	})
 
-	proxyServer.AddHook("/rest/stream", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	proxyServer.AddAuthenticatedHook("/rest/stream", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
 		return handlers.HandleStream(w, r, endpoint)
 	})
 
-	proxyServer.AddHook("/rest/scrobble", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	proxyServer.AddAuthenticatedHook("/rest/scrobble", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
 		return handlers.HandleScrobble(w, r, endpoint, proxyServer.RecordPlayEvent, proxyServer.SetLastPlayed, proxyServer.ProcessScrobble)
 	})
 
-	proxyServer.AddHook("/rest/getRandomSongs", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	proxyServer.AddAuthenticatedHook("/rest/getRandomSongs", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
 		return handlers.HandleShuffle(w, r, endpoint)
 	})
 
+	similarSongs := func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		return handlers.HandleSimilarSongs(w, r, endpoint)
+	}
+	proxyServer.AddAuthenticatedHook("/rest/getSimilarSongs", similarSongs)
+	proxyServer.AddAuthenticatedHook("/rest/getSimilarSongs2", similarSongs)
+
+	proxyServer.AddAuthenticatedHook("/rest/getTopSongs", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		return handlers.HandleTopSongs(w, r, endpoint)
+	})
+
+	albumList := func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		return handlers.HandleAlbumList(w, r, endpoint)
+	}
+	proxyServer.AddAuthenticatedHook("/rest/getAlbumList", albumList)
+	proxyServer.AddAuthenticatedHook("/rest/getAlbumList2", albumList)
+
 	// Register debug endpoint only when DEBUG=1 is set
 	if cfg.DebugMode {
-		proxyServer.AddHook("/debug", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		proxyServer.AddAuthenticatedHook("/debug", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
 			return handlers.HandleDebug(w, r, endpoint)
 		})
 		fmt.Println("Debug endpoint enabled at /debug")
+		proxyServer.AddAuthenticatedHook("/debug/explain", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+			return handlers.HandleExplain(w, r, endpoint)
+		})
+		fmt.Println("Explain endpoint enabled at /debug/explain")
 	}
 
 	if err := proxyServer.Start(); err != nil {
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	return sb.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Test requests.", "endpoint", "code")
+	plain := r.NewCounterVec("test_plain_total", "Counter without labels.")
+
+	requests.Inc("/rest/ping", "200")
+	requests.Inc("/rest/ping", "200")
+	requests.Add(3, "/rest/stream", "502")
+	requests.Add(-1, "/rest/stream", "502")
+
+	if got := requests.Value("/rest/ping", "200"); got != 2 {
+		t.Errorf("Expected 2 ping requests, got %v", got)
+	}
+
+	want := `# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{endpoint="/rest/ping",code="200"} 2
+test_requests_total{endpoint="/rest/stream",code="502"} 3
+# HELP test_plain_total Counter without labels.
+# TYPE test_plain_total counter
+test_plain_total 0
+`
+	if got := render(t, r); got != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+
+	plain.Inc()
+	if !strings.Contains(render(t, r), "test_plain_total 1\n") {
+		t.Error("Expected plain counter to be incremented")
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	latency := r.NewHistogramVec("test_duration_seconds", "Test latency.", []float64{1, 0.1}, "path")
+
+	latency.Observe(0.05, "small")
+	latency.Observe(0.1, "small")
+	latency.Observe(0.5, "small")
+	latency.Observe(5, "small")
+
+	if got := latency.Count("small"); got != 4 {
+		t.Errorf("Expected 4 observations, got %d", got)
+	}
+
+	want := `# HELP test_duration_seconds Test latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{path="small",le="0.1"} 2
+test_duration_seconds_bucket{path="small",le="1"} 3
+test_duration_seconds_bucket{path="small",le="+Inf"} 4
+test_duration_seconds_sum{path="small"} 5.65
+test_duration_seconds_count{path="small"} 4
+`
+	if got := render(t, r); got != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestValueFuncs(t *testing.T) {
+	r := NewRegistry()
+	open := 3
+	r.NewGaugeFunc("test_open_connections", "Open connections.", func() float64 { return float64(open) })
+	r.NewCounterFunc("test_checks_total", "Health checks.", func() float64 { return 7 })
+
+	output := render(t, r)
+	open = 5
+	updated := render(t, r)
+
+	if !strings.Contains(output, "# TYPE test_open_connections gauge\ntest_open_connections 3\n") {
+		t.Errorf("Expected gauge value 3, got:\n%s", output)
+	}
+	if !strings.Contains(updated, "test_open_connections 5\n") {
+		t.Errorf("Expected gauge to be read at scrape time, got:\n%s", updated)
+	}
+	if !strings.Contains(output, "# TYPE test_checks_total counter\ntest_checks_total 7\n") {
+		t.Errorf("Expected counter value 7, got:\n%s", output)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_escaped_total", "Help with \\ and\nnewline.", "value")
+	c.Inc("a\"b\\c\nd")
+
+	output := render(t, r)
+	if !strings.Contains(output, `# HELP test_escaped_total Help with \\ and\nnewline.`) {
+		t.Errorf("Expected escaped help text, got:\n%s", output)
+	}
+	if !strings.Contains(output, `test_escaped_total{value="a\"b\\c\nd"} 1`) {
+		t.Errorf("Expected escaped label value, got:\n%s", output)
+	}
+}
+
+func TestSeriesLimit(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_limited_total", "Limited counter.", "endpoint", "code")
+
+	for i := 0; i < MaxSeriesPerMetric+10; i++ {
+		c.Inc(strings.Repeat("x", i+1), "200")
+	}
+
+	if got := c.Value(OverflowLabel, OverflowLabel); got != 10 {
+		t.Errorf("Expected 10 requests in the overflow series, got %v", got)
+	}
+	if got := strings.Count(render(t, r), "test_limited_total{"); got != MaxSeriesPerMetric+1 {
+		t.Errorf("Expected %d series, got %d", MaxSeriesPerMetric+1, got)
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for duplicate metric name")
+		}
+	}()
+	r.NewGaugeFunc("test_total", "Test.", func() float64 { return 0 })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.").Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %q, got %q", ContentType, ct)
+	}
+	if !strings.Contains(w.Body.String(), "test_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", w.Body.String())
+	}
+}
@@ -3,24 +3,71 @@ package models
 import (
 	"encoding/xml"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// Song is a track of the upstream library. Its json/xml attributes form the Subsonic child
+// sent to clients; per-user listening data stays internal.
 type Song struct {
 	ID            string    `json:"id" xml:"id,attr"`
+	Parent        string    `json:"parent,omitempty" xml:"parent,attr,omitempty"`
 	Title         string    `json:"title" xml:"title,attr"`
 	Artist        string    `json:"artist" xml:"artist,attr"`
 	Album         string    `json:"album" xml:"album,attr"`
 	Duration      int       `json:"duration" xml:"duration,attr"`
-	LastPlayed    time.Time `json:"lastPlayed" xml:"lastPlayed,attr"`
-	LastSkipped   time.Time `json:"lastSkipped" xml:"lastSkipped,attr"`
-	PlayCount     int       `json:"playCount" xml:"playCount,attr"`
-	SkipCount     int       `json:"skipCount" xml:"skipCount,attr"`
-	AdjustedPlays float64   `json:"adjustedPlays" xml:"adjustedPlays,attr"`
-	AdjustedSkips float64   `json:"adjustedSkips" xml:"adjustedSkips,attr"`
+	LastPlayed    time.Time `json:"-" xml:"-"`
+	LastSkipped   time.Time `json:"-" xml:"-"`
+	PlayCount     int       `json:"-" xml:"-"`
+	SkipCount     int       `json:"-" xml:"-"`
+	AdjustedPlays float64   `json:"-" xml:"-"`
+	AdjustedSkips float64   `json:"-" xml:"-"`
 	IsDir         bool      `json:"isDir" xml:"isDir,attr"`
-	Name          string    `json:"name" xml:"name,attr"`
 	CoverArt      string    `json:"coverArt,omitempty" xml:"coverArt,attr,omitempty"`
+	Genre         string    `json:"genre,omitempty" xml:"genre,attr,omitempty"`
+	Year          int       `json:"year,omitempty" xml:"year,attr,omitempty"`
+	AlbumID       string    `json:"albumId,omitempty" xml:"albumId,attr,omitempty"`
+	ArtistID      string    `json:"artistId,omitempty" xml:"artistId,attr,omitempty"`
+	Track         int       `json:"track,omitempty" xml:"track,attr,omitempty"`
+	Suffix        string    `json:"suffix,omitempty" xml:"suffix,attr,omitempty"`
+	ContentType   string    `json:"contentType,omitempty" xml:"contentType,attr,omitempty"`
+	BitRate       int       `json:"bitRate,omitempty" xml:"bitRate,attr,omitempty"`
+	Size          int64     `json:"size,omitempty" xml:"size,attr,omitempty"`
+	Path          string    `json:"path,omitempty" xml:"path,attr,omitempty"`
+	MusicFolderID string    `json:"-" xml:"-"`                                      // Populated during sync, not part of the upstream child
+	Created       string    `json:"created,omitempty" xml:"created,attr,omitempty"` // Upstream timestamp, used for change detection during sync
+}
+
+// SongFilter restricts shuffle candidates using the getRandomSongs filter parameters.
+// Zero values mean "no restriction" for the corresponding field.
+type SongFilter struct {
+	Genre         string
+	FromYear      int
+	ToYear        int
+	MusicFolderID string
+}
+
+// IsEmpty reports whether the filter places no restriction on songs
+func (f SongFilter) IsEmpty() bool {
+	return f.Genre == "" && f.FromYear == 0 && f.ToYear == 0 && f.MusicFolderID == ""
+}
+
+// Matches reports whether a song satisfies every restriction of the filter.
+// Genre comparison is case-insensitive; songs without a year never match a year range.
+func (f SongFilter) Matches(song Song) bool {
+	if f.Genre != "" && !strings.EqualFold(f.Genre, song.Genre) {
+		return false
+	}
+	if f.FromYear > 0 && song.Year < f.FromYear {
+		return false
+	}
+	if f.ToYear > 0 && (song.Year == 0 || song.Year > f.ToYear) {
+		return false
+	}
+	if f.MusicFolderID != "" && f.MusicFolderID != song.MusicFolderID {
+		return false
+	}
+	return true
 }
 
 type PlayEvent struct {
@@ -47,11 +94,173 @@ type ArtistStats struct {
 	Ratio     float64 `json:"ratio"`
 }
 
+// SyncCursor records the upstream change markers seen by the last library sync of a user,
+// per music folder ID
+type SyncCursor struct {
+	UserID              string                      `json:"userId"`
+	Strategy            string                      `json:"strategy"`
+	Folders             map[string]FolderSyncCursor `json:"folders"`
+	LastFullSync        time.Time                   `json:"lastFullSync"`
+	LastIncrementalSync time.Time                   `json:"lastIncrementalSync"`
+}
+
+// FolderSyncCursor holds the change markers of a music folder. LastModified is the artist
+// collection timestamp (milliseconds) from getIndexes/getArtists and AlbumCursor the newest
+// album created/changed time seen.
+type FolderSyncCursor struct {
+	LastModified int64     `json:"lastModified"`
+	AlbumCursor  time.Time `json:"albumCursor"`
+}
+
+// Sync run statuses
+const (
+	SyncStatusSuccess  = "success"
+	SyncStatusFailed   = "failed"
+	SyncStatusCanceled = "canceled"
+)
+
+// SyncRun records the outcome of one library sync for a user
+type SyncRun struct {
+	ID         int64     `json:"id"`
+	UserID     string    `json:"userId"`
+	Strategy   string    `json:"strategy"`
+	Full       bool      `json:"full"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Total      int       `json:"total"`
+	Added      int       `json:"added"`
+	Updated    int       `json:"updated"`
+	Deleted    int       `json:"deleted"`
+	Unchanged  int       `json:"unchanged"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// UserSettings holds a user's overrides of the server-wide shuffle settings.
+// Nil fields fall back to the server configuration.
+type UserSettings struct {
+	UserID                  string            `json:"userId"`
+	ReplayWindowPlayedDays  *int              `json:"replayWindowPlayedDays"`
+	ReplayWindowSkippedDays *int              `json:"replayWindowSkippedDays"`
+	Weighting               *WeightingProfile `json:"weighting"`
+	UpdatedAt               time.Time         `json:"updatedAt"`
+}
+
+// ScrobbleState is the persisted skip detection and transition state of a user: the last
+// played song (zero LastPlayedAt if not set) and the last scrobble of each device
+type ScrobbleState struct {
+	UserID           string
+	LastPlayedSongID string
+	LastPlayedAt     time.Time
+	Scrobbles        []DeviceScrobble
+}
+
+// DeviceScrobble is the last scrobble sent from one of a user's devices
+type DeviceScrobble struct {
+	DeviceID     string
+	SongID       string
+	Duration     int // Seconds, 0 if unknown
+	IsSubmission bool
+	ScrobbledAt  time.Time
+}
+
+// StreamPlayback is what the proxy observed of a streamed song's response
+type StreamPlayback struct {
+	Status         int           // HTTP status of the response
+	BytesDelivered int64         // Response body bytes written to the client
+	RangeStart     int64         // First byte of a range response, 0 for the start of the song
+	TotalBytes     int64         // Size of the whole stream, 0 if unknown (e.g. chunked transcoding)
+	MaxBitRate     int           // Requested transcoding bitrate in kbps, 0 for the original
+	Elapsed        time.Duration // How long the connection was open
+	Completed      bool          // Whether the whole response was delivered
+}
+
+// WeightingProfile holds the parameters of the shuffle weight calculation.
+// Preset names the preset the parameters are based on.
+type WeightingProfile struct {
+	Preset               string  `json:"preset"`
+	NeverPlayedWeight    float64 `json:"neverPlayedWeight"`    // Time weight of songs never played or skipped
+	TimeDecayDays        int     `json:"timeDecayDays"`        // Days over which the time weight recovers after a song was presented
+	TimeDecayMinWeight   float64 `json:"timeDecayMinWeight"`   // Time weight right after a song was presented
+	TimeDecayMaxWeight   float64 `json:"timeDecayMaxWeight"`   // Time weight recovered over TimeDecayDays
+	UnplayedSongWeight   float64 `json:"unplayedSongWeight"`   // Play/skip weight of songs without history
+	PlayRatioMinWeight   float64 `json:"playRatioMinWeight"`   // Play/skip weight of songs that are always skipped
+	PlayRatioMaxWeight   float64 `json:"playRatioMaxWeight"`   // Play/skip weight of songs that are always played
+	ArtistRatioMinWeight float64 `json:"artistRatioMinWeight"` // Artist weight of artists that are always skipped
+	ArtistRatioMaxWeight float64 `json:"artistRatioMaxWeight"` // Artist weight of artists that are always played
+	BayesianPriorAlpha   float64 `json:"bayesianPriorAlpha"`   // Prior plays used until the user has listening history
+	BayesianPriorBeta    float64 `json:"bayesianPriorBeta"`    // Prior skips used until the user has listening history
+	DecayFactor          float64 `json:"decayFactor"`          // Factor applied to earlier plays and skips on every new event
+}
+
+// StoredCredential is an encrypted credential persisted across restarts.
+// KeyID identifies the master key the password was encrypted with.
+type StoredCredential struct {
+	Username          string    `json:"username"`
+	EncryptedPassword []byte    `json:"-"`
+	Nonce             []byte    `json:"-"`
+	KeyID             string    `json:"keyId"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
 type WeightedSong struct {
 	Song   Song    `json:"song"`
 	Weight float64 `json:"weight"`
 }
 
+// BayesPriors are the prior plays (Alpha) and skips (Beta) of the Beta-Binomial model
+type BayesPriors struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+}
+
+// WeightFactors are the factors of a song's shuffle weight; Final is their product
+type WeightFactors struct {
+	Time       float64 `json:"time"`
+	PlaySkip   float64 `json:"playSkip"`
+	Transition float64 `json:"transition"`
+	Artist     float64 `json:"artist"`
+	Final      float64 `json:"final"`
+}
+
+// SongWeightFactors pairs a song with the factors of its shuffle weight
+type SongWeightFactors struct {
+	Song    Song
+	Weights WeightFactors
+}
+
+// SongExplanation explains how likely a song is to be picked by the user's next shuffle
+type SongExplanation struct {
+	Song           Song          `json:"song"`
+	PlayCount      int           `json:"playCount"`
+	SkipCount      int           `json:"skipCount"`
+	AdjustedPlays  float64       `json:"adjustedPlays"`
+	AdjustedSkips  float64       `json:"adjustedSkips"`
+	LastPlayed     *time.Time    `json:"lastPlayed"`
+	LastSkipped    *time.Time    `json:"lastSkipped"`
+	Weights        WeightFactors `json:"weights"`
+	SongPriors     BayesPriors   `json:"songPriors"`
+	ArtistPriors   BayesPriors   `json:"artistPriors"`
+	MatchesFilter  bool          `json:"matchesFilter"`
+	ReplayExcluded bool          `json:"replayExcluded"`
+	ExcludedUntil  *time.Time    `json:"excludedUntil,omitempty"` // When a replay-excluded song becomes eligible again
+	Probability    float64       `json:"probability"`             // Share of the eligible pool's total weight, 0 for ineligible songs
+}
+
+// ShuffleExplanation explains the songs of a user's next shuffle. The eligible pool holds the
+// songs matching the filter that are not excluded by the replay windows.
+type ShuffleExplanation struct {
+	UserID                  string            `json:"userId"`
+	ReferenceSongID         string            `json:"referenceSongId,omitempty"` // Song the transition weights are calculated from
+	WeightingPreset         string            `json:"weightingPreset"`
+	ReplayWindowPlayedDays  int               `json:"replayWindowPlayedDays"`
+	ReplayWindowSkippedDays int               `json:"replayWindowSkippedDays"`
+	EligibleSongs           int               `json:"eligibleSongs"`
+	EligibleWeight          float64           `json:"eligibleWeight"`
+	Songs                   []SongExplanation `json:"songs"`
+}
+
 type MusicFolder struct {
 	ID   interface{} `json:"id"`
 	Name string      `json:"name"`
@@ -67,6 +276,63 @@ type Index struct {
 	Artists []Artist `json:"artist"`
 }
 
+// Album is an ID3 album as returned by getArtist (without songs) and getAlbum (with songs).
+// Albums aggregated from a user's songs form the entries of getAlbumList2 responses, with
+// per-user listening data kept internal.
+type Album struct {
+	ID            string    `json:"id" xml:"id,attr"`
+	Name          string    `json:"name" xml:"name,attr"`
+	Artist        string    `json:"artist" xml:"artist,attr"`
+	ArtistID      string    `json:"artistId" xml:"artistId,attr,omitempty"`
+	CoverArt      string    `json:"coverArt,omitempty" xml:"coverArt,attr,omitempty"`
+	SongCount     int       `json:"songCount" xml:"songCount,attr"`
+	Duration      int       `json:"duration,omitempty" xml:"duration,attr,omitempty"`
+	PlayCount     int       `json:"playCount,omitempty" xml:"playCount,attr,omitempty"`
+	Year          int       `json:"year,omitempty" xml:"year,attr,omitempty"`
+	Genre         string    `json:"genre,omitempty" xml:"genre,attr,omitempty"`
+	Created       string    `json:"created,omitempty" xml:"created,attr,omitempty"`
+	Changed       string    `json:"changed,omitempty" xml:"changed,attr,omitempty"`
+	Song          []Song    `json:"song,omitempty" xml:"song,omitempty"`
+	LastPlayed    time.Time `json:"-" xml:"-"`
+	AdjustedPlays float64   `json:"-" xml:"-"`
+	AdjustedSkips float64   `json:"-" xml:"-"`
+}
+
+// LibraryResponse holds the parts of a Subsonic response used by the library sync
+type LibraryResponse struct {
+	SubsonicResponse struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+		Error   struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+		Indexes struct {
+			LastModified int64   `json:"lastModified"`
+			Index        []Index `json:"index"`
+		} `json:"indexes,omitempty"`
+		Directory struct {
+			Child []Song `json:"child"`
+		} `json:"directory,omitempty"`
+		Artists struct {
+			LastModified int64   `json:"lastModified"`
+			Index        []Index `json:"index"`
+		} `json:"artists,omitempty"`
+		Artist struct {
+			ID    string  `json:"id"`
+			Name  string  `json:"name"`
+			Album []Album `json:"album"`
+		} `json:"artist,omitempty"`
+		Album        Album `json:"album,omitempty"`
+		MusicFolders struct {
+			MusicFolder []MusicFolder `json:"musicFolder"`
+		} `json:"musicFolders,omitempty"`
+		SearchResult3 struct {
+			Song []Song `json:"song"`
+		} `json:"searchResult3,omitempty"`
+	} `json:"subsonic-response"`
+}
+
 type SubsonicResponse struct {
 	SubsonicResponse struct {
 		Status  string `json:"status"`
@@ -83,17 +349,54 @@ type SubsonicResponse struct {
 		Directory struct {
 			Child []Song `json:"child"`
 		} `json:"directory,omitempty"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
 	} `json:"subsonic-response"`
 }
 
 type Hook func(w http.ResponseWriter, r *http.Request, endpoint string) bool
 
+// ServerInfo is what the upstream server reports about itself in its responses.
+// Type, ServerVersion and OpenSubsonic are only sent by OpenSubsonic servers.
+type ServerInfo struct {
+	Version       string `json:"version"`
+	Type          string `json:"type,omitempty"`
+	ServerVersion string `json:"serverVersion,omitempty"`
+	OpenSubsonic  bool   `json:"openSubsonic,omitempty"`
+}
+
+// SongList is the content of responses listing songs, such as the shuffled songs
+type SongList struct {
+	Song []Song `json:"song" xml:"song"`
+}
+
+// AlbumList holds the album directories of getAlbumList responses
+type AlbumList struct {
+	Album []Song `json:"album" xml:"album"`
+}
+
+// AlbumList2 holds the albums of getAlbumList2 responses
+type AlbumList2 struct {
+	Album []Album `json:"album" xml:"album"`
+}
+
 // XML response structures for Subsonic API
 type XMLSubsonicResponse struct {
-	XMLName xml.Name  `xml:"subsonic-response"`
-	Status  string    `xml:"status,attr"`
-	Version string    `xml:"version,attr"`
-	Songs   *XMLSongs `xml:"songs,omitempty"`
+	XMLName       xml.Name  `xml:"subsonic-response"`
+	Status        string    `xml:"status,attr"`
+	Version       string    `xml:"version,attr"`
+	Type          string    `xml:"type,attr,omitempty"`
+	ServerVersion string    `xml:"serverVersion,attr,omitempty"`
+	OpenSubsonic  bool      `xml:"openSubsonic,attr,omitempty"`
+	Songs         *XMLSongs `xml:"songs,omitempty"`
+	Error         *XMLError `xml:"error,omitempty"`
+}
+
+type XMLError struct {
+	Code    int    `xml:"code,attr"`
+	Message string `xml:"message,attr"`
 }
 
 type XMLSongs struct {
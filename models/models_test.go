@@ -54,6 +54,12 @@ func TestSongJSONSerialization(t *testing.T) {
 		LastPlayed: now,
 		PlayCount:  5,
 		SkipCount:  2,
+		AlbumID:    "al-1",
+		ArtistID:   "ar-1",
+		Track:      3,
+		Suffix:     "flac",
+		BitRate:    1024,
+		Size:       31457280,
 	}
 
 	jsonData, err := json.Marshal(song)
@@ -73,8 +79,20 @@ func TestSongJSONSerialization(t *testing.T) {
 	if unmarshaled.Title != song.Title {
 		t.Errorf("Unmarshaled Title = %s, want %s", unmarshaled.Title, song.Title)
 	}
-	if unmarshaled.PlayCount != song.PlayCount {
-		t.Errorf("Unmarshaled PlayCount = %d, want %d", unmarshaled.PlayCount, song.PlayCount)
+	if unmarshaled.AlbumID != song.AlbumID || unmarshaled.ArtistID != song.ArtistID || unmarshaled.Track != song.Track ||
+		unmarshaled.Suffix != song.Suffix || unmarshaled.BitRate != song.BitRate || unmarshaled.Size != song.Size {
+		t.Errorf("Unmarshaled metadata = %+v, want %+v", unmarshaled, song)
+	}
+
+	// Per-user listening data is internal and must not reach clients
+	var fields map[string]interface{}
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal song fields: %v", err)
+	}
+	for _, internal := range []string{"playCount", "skipCount", "lastPlayed", "lastSkipped", "adjustedPlays", "adjustedSkips"} {
+		if _, ok := fields[internal]; ok {
+			t.Errorf("Expected %s to be omitted from JSON", internal)
+		}
 	}
 }
 
@@ -184,6 +202,10 @@ func TestSubsonicResponseStructure(t *testing.T) {
 			Directory struct {
 				Child []Song `json:"child"`
 			} `json:"directory,omitempty"`
+			Error struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error,omitempty"`
 		}{
 			Status:  "ok",
 			Version: "1.15.0",
@@ -223,6 +245,10 @@ func TestSubsonicResponseJSONSerialization(t *testing.T) {
 			Directory struct {
 				Child []Song `json:"child"`
 			} `json:"directory,omitempty"`
+			Error struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error,omitempty"`
 		}{
 			Status:  "ok",
 			Version: "1.15.0",
@@ -294,3 +320,51 @@ func TestEventTypeValues(t *testing.T) {
 		}
 	}
 }
+
+func TestSongFilterMatches(t *testing.T) {
+	song := Song{ID: "1", Genre: "Rock", Year: 1994, MusicFolderID: "2"}
+
+	tests := []struct {
+		name     string
+		filter   SongFilter
+		expected bool
+	}{
+		{"empty filter", SongFilter{}, true},
+		{"matching genre", SongFilter{Genre: "Rock"}, true},
+		{"matching genre case-insensitive", SongFilter{Genre: "rock"}, true},
+		{"non-matching genre", SongFilter{Genre: "Jazz"}, false},
+		{"year within range", SongFilter{FromYear: 1990, ToYear: 1999}, true},
+		{"year before range", SongFilter{FromYear: 1995}, false},
+		{"year after range", SongFilter{ToYear: 1993}, false},
+		{"matching folder", SongFilter{MusicFolderID: "2"}, true},
+		{"non-matching folder", SongFilter{MusicFolderID: "3"}, false},
+		{"all restrictions matching", SongFilter{Genre: "Rock", FromYear: 1990, ToYear: 1999, MusicFolderID: "2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(song); got != tt.expected {
+				t.Errorf("Matches() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("unknown year never matches a year range", func(t *testing.T) {
+		unknownYear := Song{ID: "2", Genre: "Rock"}
+		if (SongFilter{ToYear: 2000}).Matches(unknownYear) {
+			t.Error("Song without year should not match toYear restriction")
+		}
+		if (SongFilter{FromYear: 1990}).Matches(unknownYear) {
+			t.Error("Song without year should not match fromYear restriction")
+		}
+	})
+
+	t.Run("IsEmpty", func(t *testing.T) {
+		if !(SongFilter{}).IsEmpty() {
+			t.Error("Zero filter should be empty")
+		}
+		if (SongFilter{Genre: "Rock"}).IsEmpty() {
+			t.Error("Filter with genre should not be empty")
+		}
+	})
+}
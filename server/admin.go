@@ -0,0 +1,353 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Admin API constants
+const (
+	AdminAPIPrefix          = "/admin/api"
+	DefaultSyncHistoryLimit = 20
+	MaxUserSettingsBodySize = 4096
+)
+
+// activeSync describes a sync that is currently running
+type activeSync struct {
+	UserID    string    `json:"userId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// userSyncStatus is the sync state of one user
+type userSyncStatus struct {
+	UserID  string          `json:"userId"`
+	Running bool            `json:"running"`
+	LastRun *models.SyncRun `json:"lastRun"`
+}
+
+// syncStatusResponse is returned by GET /admin/api/sync
+type syncStatusResponse struct {
+	Running     bool             `json:"running"`
+	ActiveSyncs []activeSync     `json:"activeSyncs"`
+	Users       []userSyncStatus `json:"users"`
+}
+
+// effectiveUserSettings are the settings in effect for a user after applying the overrides
+type effectiveUserSettings struct {
+	ReplayWindowPlayedDays  int                     `json:"replayWindowPlayedDays"`
+	ReplayWindowSkippedDays int                     `json:"replayWindowSkippedDays"`
+	Weighting               models.WeightingProfile `json:"weighting"`
+}
+
+// userSettingsRequest is the body of PUT /admin/api/users/{user}/settings
+type userSettingsRequest struct {
+	ReplayWindowPlayedDays  *int            `json:"replayWindowPlayedDays"`
+	ReplayWindowSkippedDays *int            `json:"replayWindowSkippedDays"`
+	Weighting               json.RawMessage `json:"weighting"`
+}
+
+// userSettingsResponse is returned by the /admin/api/users/{user}/settings endpoints
+type userSettingsResponse struct {
+	UserID    string                `json:"userId"`
+	Overrides *models.UserSettings  `json:"overrides"`
+	Effective effectiveUserSettings `json:"effective"`
+}
+
+// registerAdminRoutes mounts the admin API. It is only available when an admin token is configured.
+func (ps *ProxyServer) registerAdminRoutes(router *mux.Router) {
+	if ps.config.AdminToken == "" {
+		ps.logger.Info("Admin API disabled (no admin token configured)")
+		return
+	}
+
+	api := router.PathPrefix(AdminAPIPrefix).Subrouter()
+	api.Use(ps.requireAdminToken)
+	api.HandleFunc("/sync", ps.handleSyncStatus).Methods(http.MethodGet)
+	api.HandleFunc("/sync", ps.handleTriggerSyncAll).Methods(http.MethodPost)
+	api.HandleFunc("/sync/users/{user}", ps.handleSyncHistory).Methods(http.MethodGet)
+	api.HandleFunc("/sync/users/{user}", ps.handleTriggerUserSync).Methods(http.MethodPost)
+	api.HandleFunc("/users/{user}/settings", ps.handleGetUserSettings).Methods(http.MethodGet)
+	api.HandleFunc("/users/{user}/settings", ps.handleSaveUserSettings).Methods(http.MethodPut)
+	api.HandleFunc("/users/{user}/settings", ps.handleDeleteUserSettings).Methods(http.MethodDelete)
+	api.HandleFunc("/weighting", ps.handleGetWeighting).Methods(http.MethodGet)
+	api.HandleFunc("/weighting", ps.handleSaveWeighting).Methods(http.MethodPut)
+	api.HandleFunc("/weighting", ps.handleDeleteWeighting).Methods(http.MethodDelete)
+
+	ps.logger.WithField("prefix", AdminAPIPrefix).Info("Admin API enabled")
+}
+
+// requireAdminToken rejects requests without the configured bearer token
+func (ps *ProxyServer) requireAdminToken(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + ps.config.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			ps.logger.WithFields(logrus.Fields{
+				"remote_addr": sanitizeRemoteAddr(r.RemoteAddr),
+				"path":        sanitizeForLogging(r.URL.Path),
+			}).Warn("Rejected admin API request with missing or invalid token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="subsoxy admin"`)
+			writeAdminError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (ps *ProxyServer) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	active := ps.activeSyncList()
+
+	running := make(map[string]bool, len(active))
+	users := ps.credentials.GetAllValid()
+	for _, entry := range active {
+		running[entry.UserID] = true
+		if _, known := users[entry.UserID]; !known {
+			users[entry.UserID] = ""
+		}
+	}
+
+	response := syncStatusResponse{
+		Running:     len(active) > 0,
+		ActiveSyncs: active,
+		Users:       make([]userSyncStatus, 0, len(users)),
+	}
+	for _, username := range getSortedUsernames(users) {
+		status := userSyncStatus{UserID: username, Running: running[username]}
+		history, err := ps.db.GetSyncHistory(username, 1)
+		if err != nil {
+			ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to get sync history")
+			writeAdminError(w, http.StatusInternalServerError, "failed to get sync history")
+			return
+		}
+		if len(history) > 0 {
+			status.LastRun = &history[0]
+		}
+		response.Users = append(response.Users, status)
+	}
+
+	writeAdminJSON(w, http.StatusOK, response)
+}
+
+func (ps *ProxyServer) handleSyncHistory(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["user"]
+
+	limit := DefaultSyncHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeAdminError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+
+	history, err := ps.db.GetSyncHistory(username, limit)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to get sync history")
+		writeAdminError(w, http.StatusInternalServerError, "failed to get sync history")
+		return
+	}
+	if history == nil {
+		history = []models.SyncRun{}
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"userId":  username,
+		"running": ps.isSyncing(username),
+		"history": history,
+	})
+}
+
+func (ps *ProxyServer) handleTriggerSyncAll(w http.ResponseWriter, r *http.Request) {
+	if !ps.startBackgroundSync(ps.fetchAndStoreSongs) {
+		writeAdminError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	ps.logger.WithField("remote_addr", sanitizeRemoteAddr(r.RemoteAddr)).Info("Sync for all users triggered via admin API")
+	writeAdminJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status": "accepted",
+		"users":  len(ps.credentials.GetAllValid()),
+	})
+}
+
+func (ps *ProxyServer) handleTriggerUserSync(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["user"]
+
+	password, ok := ps.credentials.GetAllValid()[username]
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "no valid credentials for user")
+		return
+	}
+	if ps.isSyncing(username) {
+		writeAdminError(w, http.StatusConflict, "a sync is already running for this user")
+		return
+	}
+
+	if !ps.startBackgroundSync(func() { ps.syncUser(username, password) }) {
+		writeAdminError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"user":        sanitizeUsername(username),
+		"remote_addr": sanitizeRemoteAddr(r.RemoteAddr),
+	}).Info("Sync triggered via admin API")
+	writeAdminJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status": "accepted",
+		"userId": username,
+	})
+}
+
+func (ps *ProxyServer) handleGetUserSettings(w http.ResponseWriter, r *http.Request) {
+	ps.writeUserSettings(w, mux.Vars(r)["user"])
+}
+
+// handleSaveUserSettings replaces the user's overrides; omitted or null fields use the server defaults
+func (ps *ProxyServer) handleSaveUserSettings(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["user"]
+
+	var request userSettingsRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxUserSettingsBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid settings body")
+		return
+	}
+	if (request.ReplayWindowPlayedDays != nil && *request.ReplayWindowPlayedDays < config.MinReplayWindowDays) ||
+		(request.ReplayWindowSkippedDays != nil && *request.ReplayWindowSkippedDays < config.MinReplayWindowDays) {
+		writeAdminError(w, http.StatusBadRequest, "replay windows cannot be negative")
+		return
+	}
+	settings := models.UserSettings{
+		UserID:                  username,
+		ReplayWindowPlayedDays:  request.ReplayWindowPlayedDays,
+		ReplayWindowSkippedDays: request.ReplayWindowSkippedDays,
+	}
+	if len(request.Weighting) > 0 && string(request.Weighting) != "null" {
+		profile, err := ps.parseWeightingProfile(request.Weighting)
+		if err != nil {
+			writeWeightingError(w, err)
+			return
+		}
+		settings.Weighting = &profile
+	}
+
+	err := ps.db.SaveUserSettings(settings)
+	ps.shuffle.InvalidateUserSettings(username)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to save user settings")
+		writeAdminError(w, http.StatusInternalServerError, "failed to save user settings")
+		return
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"user":        sanitizeUsername(username),
+		"remote_addr": sanitizeRemoteAddr(r.RemoteAddr),
+	}).Info("User settings updated via admin API")
+	ps.writeUserSettings(w, username)
+}
+
+func (ps *ProxyServer) handleDeleteUserSettings(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["user"]
+
+	err := ps.db.DeleteUserSettings(username)
+	ps.shuffle.InvalidateUserSettings(username)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to delete user settings")
+		writeAdminError(w, http.StatusInternalServerError, "failed to delete user settings")
+		return
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"user":        sanitizeUsername(username),
+		"remote_addr": sanitizeRemoteAddr(r.RemoteAddr),
+	}).Info("User settings reset via admin API")
+	ps.writeUserSettings(w, username)
+}
+
+// writeUserSettings responds with the user's overrides and the settings in effect
+func (ps *ProxyServer) writeUserSettings(w http.ResponseWriter, username string) {
+	overrides, err := ps.db.GetUserSettings(username)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to get user settings")
+		writeAdminError(w, http.StatusInternalServerError, "failed to get user settings")
+		return
+	}
+
+	windows := ps.shuffle.ReplayWindows(username)
+	writeAdminJSON(w, http.StatusOK, userSettingsResponse{
+		UserID:    username,
+		Overrides: overrides,
+		Effective: effectiveUserSettings{
+			ReplayWindowPlayedDays:  windows.PlayedDays,
+			ReplayWindowSkippedDays: windows.SkippedDays,
+			Weighting:               ps.shuffle.WeightingProfile(username),
+		},
+	})
+}
+
+// startBackgroundSync runs a sync in the background, tracked so that Shutdown waits for it.
+// It returns false once the server is shutting down.
+func (ps *ProxyServer) startBackgroundSync(run func()) bool {
+	// Held until the sync is tracked, so Shutdown can't start waiting in between
+	ps.shutdownMutex.Lock()
+	defer ps.shutdownMutex.Unlock()
+
+	select {
+	case <-ps.shutdownChan:
+		return false
+	default:
+	}
+
+	ps.syncWg.Add(1)
+	go func() {
+		defer ps.syncWg.Done()
+		run()
+	}()
+	return true
+}
+
+// isSyncing reports whether a sync is running for a user
+func (ps *ProxyServer) isSyncing(username string) bool {
+	ps.activeSyncMutex.Lock()
+	defer ps.activeSyncMutex.Unlock()
+
+	_, running := ps.activeSyncs[username]
+	return running
+}
+
+// activeSyncList returns the running syncs ordered by user
+func (ps *ProxyServer) activeSyncList() []activeSync {
+	ps.activeSyncMutex.Lock()
+	defer ps.activeSyncMutex.Unlock()
+
+	active := make([]activeSync, 0, len(ps.activeSyncs))
+	for username, startedAt := range ps.activeSyncs {
+		active = append(active, activeSync{UserID: username, StartedAt: startedAt})
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].UserID < active[j].UserID
+	})
+	return active
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
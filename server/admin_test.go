@@ -0,0 +1,401 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+	"github.com/syeo66/subsoxy/shuffle"
+)
+
+const testAdminToken = "test-admin-token-0123456789"
+
+func newAdminTestRouter(t *testing.T) (*ProxyServer, *mux.Router) {
+	t.Helper()
+
+	mockServer := newMockLibraryServer(t, true, 0)
+	t.Cleanup(mockServer.Close)
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+	server.config.AdminToken = testAdminToken
+
+	if _, err := server.credentials.Authenticate("testuser", "testpass"); err != nil {
+		t.Fatalf("Failed to store credentials: %v", err)
+	}
+
+	router := mux.NewRouter()
+	server.registerAdminRoutes(router)
+	return server, router
+}
+
+func adminRequest(router *mux.Router, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// waitForSyncRun waits until a background sync for the user has been recorded
+func waitForSyncRun(t *testing.T, server *ProxyServer, username string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		history, err := server.db.GetSyncHistory(username, 1)
+		if err != nil {
+			t.Fatalf("Failed to get sync history: %v", err)
+		}
+		if len(history) > 0 && !server.isSyncing(username) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for sync of %s", username)
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	_, router := newAdminTestRouter(t)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "wrong-token-0123456789", http.StatusUnauthorized},
+		{"valid token", testAdminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := adminRequest(router, http.MethodGet, "/admin/api/sync", tt.token)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	server := newSyncTestServer(t, "http://localhost:4533", config.SyncStrategyID3)
+
+	router := mux.NewRouter()
+	server.registerAdminRoutes(router)
+
+	if w := adminRequest(router, http.MethodGet, "/admin/api/sync", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected admin API to be unavailable without token, got status %d", w.Code)
+	}
+}
+
+func TestAdminAPITriggerUserSync(t *testing.T) {
+	server, router := newAdminTestRouter(t)
+
+	if w := adminRequest(router, http.MethodPost, "/admin/api/sync/users/unknown", testAdminToken); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for user without credentials, got %d", w.Code)
+	}
+
+	// The credential capture triggers no sync here, so the only run is the admin-triggered one
+	if w := adminRequest(router, http.MethodPost, "/admin/api/sync/users/testuser", testAdminToken); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 when triggering sync, got %d: %s", w.Code, w.Body.String())
+	}
+	waitForSyncRun(t, server, "testuser")
+
+	w := adminRequest(router, http.MethodGet, "/admin/api/sync/users/testuser?limit=5", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for sync history, got %d", w.Code)
+	}
+	var history struct {
+		UserID  string           `json:"userId"`
+		Running bool             `json:"running"`
+		History []models.SyncRun `json:"history"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	if len(history.History) != 1 {
+		t.Fatalf("Expected 1 sync run, got %d", len(history.History))
+	}
+	run := history.History[0]
+	if run.Status != models.SyncStatusSuccess || run.Added != 4 || run.Total != 4 || !run.Full {
+		t.Errorf("Unexpected sync run: %+v", run)
+	}
+	if run.FinishedAt.Before(run.StartedAt) {
+		t.Errorf("Expected finish time after start time, got %+v", run)
+	}
+	if got := server.metrics.syncRuns.Value(config.SyncStrategyID3, models.SyncStatusSuccess); got != 1 {
+		t.Errorf("Expected 1 successful sync in metrics, got %v", got)
+	}
+	if got := server.metrics.syncDuration.Count(config.SyncStrategyID3); got != 1 {
+		t.Errorf("Expected 1 sync duration observation, got %d", got)
+	}
+
+	w = adminRequest(router, http.MethodGet, "/admin/api/sync", testAdminToken)
+	var status syncStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Running || len(status.Users) != 1 || status.Users[0].LastRun == nil || status.Users[0].LastRun.Added != 4 {
+		t.Errorf("Unexpected sync status: %+v", status)
+	}
+
+	if w := adminRequest(router, http.MethodGet, "/admin/api/sync/users/testuser?limit=abc", testAdminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid limit, got %d", w.Code)
+	}
+}
+
+func TestAdminAPIReportsRunningSync(t *testing.T) {
+	server, router := newAdminTestRouter(t)
+
+	if !server.beginSync("testuser") {
+		t.Fatal("Expected to mark sync as running")
+	}
+	defer server.endSync("testuser")
+
+	if w := adminRequest(router, http.MethodPost, "/admin/api/sync/users/testuser", testAdminToken); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while a sync is running, got %d", w.Code)
+	}
+	if err := server.syncSongsForUser("testuser", "testpass"); !errors.Is(err, errors.ErrSyncInProgress) {
+		t.Errorf("Expected ErrSyncInProgress, got %v", err)
+	}
+
+	w := adminRequest(router, http.MethodGet, "/admin/api/sync", testAdminToken)
+	var status syncStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if !status.Running || len(status.ActiveSyncs) != 1 || status.ActiveSyncs[0].UserID != "testuser" {
+		t.Errorf("Expected running sync for testuser, got %+v", status)
+	}
+	if time.Since(status.ActiveSyncs[0].StartedAt) > time.Minute {
+		t.Errorf("Unexpected sync start time %v", status.ActiveSyncs[0].StartedAt)
+	}
+}
+
+func TestAdminAPIUserSettings(t *testing.T) {
+	server, router := newAdminTestRouter(t)
+	server.shuffle.SetReplayWindows(shuffle.ReplayWindows{PlayedDays: 14, SkippedDays: 14})
+
+	decode := func(w *httptest.ResponseRecorder) userSettingsResponse {
+		t.Helper()
+		var response userSettingsResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode settings: %v", err)
+		}
+		return response
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/api/users/testuser/settings", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := adminRequest(router, http.MethodGet, "/admin/api/users/testuser/settings", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if response := decode(w); response.Overrides != nil || response.Effective.ReplayWindowPlayedDays != 14 {
+		t.Errorf("Expected server defaults without overrides, got %+v", response)
+	}
+
+	w = put(`{"replayWindowPlayedDays": 3}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	response := decode(w)
+	if response.Overrides == nil || *response.Overrides.ReplayWindowPlayedDays != 3 || response.Overrides.ReplayWindowSkippedDays != nil {
+		t.Errorf("Expected played window override, got %+v", response.Overrides)
+	}
+	if response.Effective.ReplayWindowPlayedDays != 3 || response.Effective.ReplayWindowSkippedDays != 14 {
+		t.Errorf("Expected effective windows 3/14, got %+v", response.Effective)
+	}
+
+	for _, body := range []string{`{"replayWindowSkippedDays": -1}`, `{"unknown": 1}`, `not json`} {
+		if w := put(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w = adminRequest(router, http.MethodDelete, "/admin/api/users/testuser/settings", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if response := decode(w); response.Overrides != nil || response.Effective.ReplayWindowPlayedDays != 14 {
+		t.Errorf("Expected server defaults after reset, got %+v", response)
+	}
+}
+
+func TestAdminAPIUserSettingsWeighting(t *testing.T) {
+	server, router := newAdminTestRouter(t)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/api/users/testuser/settings", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Weights are used as soon as the settings are saved
+	w := put(`{"weighting": {"preset": "discovery", "neverPlayedWeight": 12}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response userSettingsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode settings: %v", err)
+	}
+	if response.Overrides == nil || response.Overrides.Weighting == nil || response.Effective.Weighting.Preset != shuffle.WeightingPresetDiscovery {
+		t.Fatalf("Expected discovery weighting override, got %+v", response)
+	}
+	discovery, _ := shuffle.WeightingPreset(shuffle.WeightingPresetDiscovery)
+	profile := server.shuffle.WeightingProfile("testuser")
+	if profile.NeverPlayedWeight != 12 || profile.TimeDecayDays != discovery.TimeDecayDays {
+		t.Errorf("Expected discovery preset with neverPlayedWeight 12, got %+v", profile)
+	}
+
+	for _, body := range []string{
+		`{"weighting": {"preset": "unknown"}}`,
+		`{"weighting": {"decayFactor": 1.5}}`,
+		`{"weighting": {"unknownWeight": 1}}`,
+		`{"weighting": 3}`,
+	} {
+		if w := put(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w = adminRequest(router, http.MethodDelete, "/admin/api/users/testuser/settings", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if profile := server.shuffle.WeightingProfile("testuser"); profile != server.shuffle.DefaultWeightingProfile() {
+		t.Errorf("Expected default weighting after reset, got %+v", profile)
+	}
+}
+
+func TestAdminAPIDefaultWeighting(t *testing.T) {
+	server, router := newAdminTestRouter(t)
+
+	decode := func(w *httptest.ResponseRecorder) weightingResponse {
+		t.Helper()
+		var response weightingResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode weighting: %v", err)
+		}
+		return response
+	}
+
+	w := adminRequest(router, http.MethodGet, "/admin/api/weighting", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	response := decode(w)
+	if response.Default.Preset != shuffle.WeightingPresetBalanced || len(response.Presets) != len(shuffle.WeightingPresetNames()) {
+		t.Errorf("Expected balanced default and all presets, got %+v", response)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/api/weighting", strings.NewReader(`{"preset": "comfort"}`))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if response := decode(w); response.Default.Preset != shuffle.WeightingPresetComfort {
+		t.Errorf("Expected comfort default, got %+v", response.Default)
+	}
+	if profile := server.shuffle.WeightingProfile("testuser"); profile.Preset != shuffle.WeightingPresetComfort {
+		t.Errorf("Expected users without settings to use the new default, got %+v", profile)
+	}
+
+	// The default survives restarts
+	restored, err := defaultWeightingProfile(server.db, "", server.logger)
+	if err != nil || restored.Preset != shuffle.WeightingPresetComfort {
+		t.Errorf("Expected stored comfort default, got %+v (%v)", restored, err)
+	}
+
+	w = adminRequest(router, http.MethodDelete, "/admin/api/weighting", testAdminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if response := decode(w); response.Default.Preset != shuffle.WeightingPresetBalanced {
+		t.Errorf("Expected configured preset after reset, got %+v", response.Default)
+	}
+}
+
+func TestDefaultWeightingProfileRejectsUnknownPreset(t *testing.T) {
+	server, _ := newAdminTestRouter(t)
+
+	if _, err := defaultWeightingProfile(server.db, "unknown", server.logger); err == nil {
+		t.Error("Expected error for unknown weighting preset")
+	}
+}
+
+func TestSyncErrorSummary(t *testing.T) {
+	err := errors.Wrap(errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to fetch http://upstream/rest/ping?p=secret"),
+		errors.CategoryNetwork, "MUSIC_FOLDERS_FAILED", "failed to get music folders")
+
+	if summary := syncErrorSummary(err); summary != "MUSIC_FOLDERS_FAILED: failed to get music folders" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestStartBackgroundSyncDuringShutdown(t *testing.T) {
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       "http://localhost:4533",
+		LogLevel:          "error",
+		DatabasePath:      "test_shutdown_sync.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+	}
+	defer os.Remove("test_shutdown_sync.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Syncs started while the server shuts down are either refused or waited for
+	var started, finished atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := func() {
+				time.Sleep(10 * time.Millisecond)
+				finished.Add(1)
+			}
+			if server.startBackgroundSync(run) {
+				started.Add(1)
+			}
+		}()
+	}
+	server.Shutdown(context.Background())
+	finishedAtShutdown := finished.Load()
+	wg.Wait()
+
+	if finishedAtShutdown != started.Load() {
+		t.Errorf("Expected Shutdown to wait for all %d started syncs, %d finished", started.Load(), finishedAtShutdown)
+	}
+	if server.startBackgroundSync(func() {}) {
+		t.Error("Expected no sync to start after shutdown")
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Library crawl constants
+const (
+	SyncRetryBaseDelay = 500 * time.Millisecond // Delay before the first retry, doubled for every further attempt
+	SyncRetryMaxDelay  = 10 * time.Second
+)
+
+// crawl calls fetch for every index in [0, count) on a bounded pool of workers. It stops
+// handing out work once the server shuts down and then reports ErrSyncCanceled, so callers
+// never mistake a partial crawl for a complete one. fetch must be safe for concurrent use;
+// writing results into a pre-sized slice by index keeps them in upstream order.
+func (ps *ProxyServer) crawl(count int, fetch func(i int)) error {
+	workers := ps.config.SyncConcurrency
+	if workers <= 0 {
+		workers = config.DefaultSyncConcurrency
+	}
+	if workers > count {
+		workers = count
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fetch(i)
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < count; i++ {
+		select {
+		case jobs <- i:
+		case <-ps.shutdownChan:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// In-flight requests are aborted on shutdown, so their results are incomplete as well
+	if ps.syncCtx.Err() != nil {
+		return errors.ErrSyncCanceled
+	}
+	return nil
+}
+
+// fetchUpstream calls a Subsonic endpoint on the upstream server and checks the response status.
+// Requests are paced by the sync rate limiter and transient failures are retried with backoff.
+func (ps *ProxyServer) fetchUpstream(username, password, endpoint string, extra url.Values) (models.LibraryResponse, error) {
+	var response models.LibraryResponse
+
+	baseURL, err := url.Parse(ps.config.UpstreamURL + "/rest/" + endpoint)
+	if err != nil {
+		return response, errors.Wrap(err, errors.CategoryNetwork, "URL_PARSE_FAILED", "failed to parse upstream URL")
+	}
+
+	params := ps.buildAuthParams(username, password)
+	for key, values := range extra {
+		for _, value := range values {
+			params.Add(key, value)
+		}
+	}
+	baseURL.RawQuery = params.Encode()
+
+	for attempt := 0; ; attempt++ {
+		var transient bool
+		response, transient, err = ps.requestUpstream(baseURL.String(), endpoint)
+		if err == nil || !transient || attempt >= ps.config.SyncMaxRetries {
+			return response, err
+		}
+
+		delay := retryDelay(attempt)
+		ps.logger.WithError(err).WithFields(logrus.Fields{
+			"user":     sanitizeUsername(username),
+			"endpoint": endpoint,
+			"attempt":  attempt + 1,
+			"delay":    delay,
+		}).Debug("Transient upstream error during sync, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ps.shutdownChan:
+			timer.Stop()
+			return response, errors.ErrSyncCanceled
+		}
+	}
+}
+
+// requestUpstream performs a single rate-limited upstream request. transient reports whether
+// a failure is worth retrying: connection errors, 429 and 5xx responses. Subsonic error
+// responses and undecodable bodies are permanent.
+func (ps *ProxyServer) requestUpstream(rawURL, endpoint string) (response models.LibraryResponse, transient bool, err error) {
+	if ps.syncLimiter != nil {
+		if err := ps.syncLimiter.Wait(ps.syncCtx); err != nil {
+			return response, false, errors.ErrSyncCanceled
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ps.syncCtx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return response, false, errors.Wrap(err, errors.CategoryNetwork, "URL_PARSE_FAILED", "failed to build upstream request")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ps.syncCtx.Err() != nil {
+			return response, false, errors.ErrSyncCanceled
+		}
+		return response, true, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to fetch "+endpoint)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		transient = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return response, transient, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", fmt.Sprintf("unexpected HTTP status: %d", resp.StatusCode)).
+			WithContext("endpoint", endpoint)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return response, false, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to decode response").
+			WithContext("endpoint", endpoint)
+	}
+
+	if err := upstreamStatusError(response.SubsonicResponse.Status, response.SubsonicResponse.Error.Code); err != nil {
+		return response, false, err
+	}
+
+	return response, false, nil
+}
+
+// retryDelay returns the exponential backoff delay for a retry attempt
+func retryDelay(attempt int) time.Duration {
+	delay := SyncRetryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > SyncRetryMaxDelay {
+		return SyncRetryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/errors"
+)
+
+func TestCrawlBoundsConcurrency(t *testing.T) {
+	server := newSyncTestServer(t, "http://localhost:4533", config.SyncStrategyID3)
+	server.config.SyncConcurrency = 3
+
+	var running, maxRunning, processed int32
+	err := server.crawl(20, func(i int) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&processed, 1)
+	})
+	if err != nil {
+		t.Fatalf("crawl failed: %v", err)
+	}
+
+	if processed != 20 {
+		t.Errorf("Expected 20 items to be processed, got %d", processed)
+	}
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 concurrent fetches, got %d", maxRunning)
+	}
+	if maxRunning < 2 {
+		t.Errorf("Expected fetches to run concurrently, got %d", maxRunning)
+	}
+}
+
+func TestCrawlCanceledOnShutdown(t *testing.T) {
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       "http://localhost:4533",
+		LogLevel:          "error",
+		DatabasePath:      "test_crawl_shutdown.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		SyncConcurrency:   2,
+	}
+	defer os.Remove("test_crawl_shutdown.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	started := make(chan struct{}, 100)
+	var processed int32
+	done := make(chan error)
+	go func() {
+		done <- server.crawl(100, func(i int) {
+			started <- struct{}{}
+			<-server.syncCtx.Done()
+			atomic.AddInt32(&processed, 1)
+		})
+	}()
+
+	<-started
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errors.ErrSyncCanceled) {
+			t.Errorf("Expected ErrSyncCanceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl did not stop after shutdown")
+	}
+	if processed == 100 {
+		t.Error("Expected crawl to stop handing out work after shutdown")
+	}
+}
+
+func TestFetchLibraryCanceled(t *testing.T) {
+	mockServer := newMockLibraryServer(t, true, 0)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+	server.cancelSync()
+
+	if _, err := server.fetchLibrary("testuser", "testpass", nil); !errors.Is(err, errors.ErrSyncCanceled) {
+		t.Errorf("Expected ErrSyncCanceled without folder walk fallback, got %v", err)
+	}
+}
+
+func TestFetchUpstreamRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		status       int
+		maxRetries   int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"transient error is retried", 1, http.StatusServiceUnavailable, 2, false, 2},
+		{"rate limited request is retried", 1, http.StatusTooManyRequests, 2, false, 2},
+		{"retries are bounded", 5, http.StatusBadGateway, 1, true, 2},
+		{"permanent error is not retried", 1, http.StatusNotFound, 2, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": map[string]interface{}{"status": "ok"}})
+			}))
+			defer mockServer.Close()
+
+			server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+			server.config.SyncMaxRetries = tt.maxRetries
+
+			_, err := server.fetchUpstream("testuser", "testpass", "getArtists", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchUpstream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Expected %d upstream requests, got %d", tt.wantRequests, requests)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if retryDelay(0) != SyncRetryBaseDelay {
+		t.Errorf("Expected first retry after %v, got %v", SyncRetryBaseDelay, retryDelay(0))
+	}
+	if retryDelay(1) != 2*SyncRetryBaseDelay {
+		t.Errorf("Expected backoff to double, got %v", retryDelay(1))
+	}
+	if retryDelay(20) != SyncRetryMaxDelay {
+		t.Errorf("Expected backoff to be capped at %v, got %v", SyncRetryMaxDelay, retryDelay(20))
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/syeo66/subsoxy/config"
+)
+
+// MaxDeviceIDLength is the maximum length of a scrobble device ID
+const MaxDeviceIDLength = 255
+
+// scrobbleDeviceID identifies the device a scrobble was sent from by the request parts
+// configured in ScrobbleDeviceFields, so skip detection only compares scrobbles of the
+// same device when a user listens on several at once
+func (ps *ProxyServer) scrobbleDeviceID(r *http.Request) string {
+	fields := ps.config.ScrobbleDeviceFields
+	if len(fields) == 0 {
+		fields = []string{config.ScrobbleDeviceFieldClient}
+	}
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		switch field {
+		case config.ScrobbleDeviceFieldClient:
+			parts[i] = r.URL.Query().Get("c")
+		case config.ScrobbleDeviceFieldAddress:
+			parts[i] = clientAddress(r, ps.trustedProxies)
+		case config.ScrobbleDeviceFieldUserAgent:
+			parts[i] = r.UserAgent()
+		}
+	}
+
+	deviceID := strings.Join(parts, "|")
+	if len(deviceID) > MaxDeviceIDLength {
+		deviceID = deviceID[:MaxDeviceIDLength]
+	}
+	return deviceID
+}
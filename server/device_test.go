@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syeo66/subsoxy/config"
+)
+
+func TestScrobbleDeviceID(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name     string
+		fields   []string
+		expected string
+	}{
+		{"default client", nil, "phone"},
+		{"client", []string{"client"}, "phone"},
+		{"address behind trusted proxy", []string{"address"}, "203.0.113.5"},
+		{"client and user agent", []string{"client", "user-agent"}, "phone|TestAgent/1.0"},
+		{"all fields", []string{"client", "address", "user-agent"}, "phone|203.0.113.5|TestAgent/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &ProxyServer{
+				config:         &config.Config{ScrobbleDeviceFields: tt.fields},
+				trustedProxies: []*net.IPNet{trusted},
+			}
+			req := httptest.NewRequest("GET", "/rest/scrobble?id=1&c=phone", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113.5")
+			req.Header.Set("User-Agent", "TestAgent/1.0")
+
+			if deviceID := ps.scrobbleDeviceID(req); deviceID != tt.expected {
+				t.Errorf("Expected device ID %q, got %q", tt.expected, deviceID)
+			}
+		})
+	}
+
+	t.Run("truncates long device IDs", func(t *testing.T) {
+		ps := &ProxyServer{config: &config.Config{ScrobbleDeviceFields: []string{"user-agent"}}}
+		req := httptest.NewRequest("GET", "/rest/scrobble?id=1", nil)
+		req.Header.Set("User-Agent", strings.Repeat("a", 2*MaxDeviceIDLength))
+
+		if deviceID := ps.scrobbleDeviceID(req); len(deviceID) != MaxDeviceIDLength {
+			t.Errorf("Expected device ID of length %d, got %d", MaxDeviceIDLength, len(deviceID))
+		}
+	})
+}
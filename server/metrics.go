@@ -0,0 +1,191 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/metrics"
+)
+
+// Metrics constants
+const (
+	MetricsPath              = "/metrics"
+	MaxMetricsEndpointLength = 64
+	SubsonicViewSuffix       = ".view"
+)
+
+// Hook and credential outcome labels
+const (
+	HookResultHandled = "handled"
+	HookResultPassed  = "passed"
+	CredentialValid   = "valid"
+	CredentialInvalid = "invalid"
+	CredentialError   = "error"
+)
+
+// serverMetrics holds the metrics collected by the proxy server
+type serverMetrics struct {
+	registry              *metrics.Registry
+	requests              *metrics.CounterVec
+	requestDuration       *metrics.HistogramVec
+	hookInvocations       *metrics.CounterVec
+	upstreamErrors        *metrics.CounterVec
+	rateLimited           *metrics.CounterVec
+	credentialValidations *metrics.CounterVec
+	syncRuns              *metrics.CounterVec
+	syncDuration          *metrics.HistogramVec
+	shuffleDuration       *metrics.HistogramVec
+}
+
+func newServerMetrics(db *database.DB) *serverMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &serverMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("subsoxy_http_requests_total",
+			"Requests handled by the proxy, by endpoint, method and status code.", "endpoint", "method", "code"),
+		requestDuration: registry.NewHistogramVec("subsoxy_http_request_duration_seconds",
+			"Latency of requests handled by the proxy, by endpoint.", metrics.DefaultBuckets, "endpoint"),
+		hookInvocations: registry.NewCounterVec("subsoxy_hook_invocations_total",
+			"Requests that ran endpoint hooks, by endpoint and whether a hook answered the request.", "endpoint", "result"),
+		upstreamErrors: registry.NewCounterVec("subsoxy_upstream_errors_total",
+			"Requests that failed to reach the upstream server, by endpoint.", "endpoint"),
+		rateLimited: registry.NewCounterVec("subsoxy_rate_limited_requests_total",
+			"Requests rejected by the rate limiter, by scope (client, user).", "scope"),
+		credentialValidations: registry.NewCounterVec("subsoxy_credential_validations_total",
+			"Credential validations, by result (valid, invalid, error).", "result"),
+		syncRuns: registry.NewCounterVec("subsoxy_sync_runs_total",
+			"Library syncs, by strategy and status.", "strategy", "status"),
+		syncDuration: registry.NewHistogramVec("subsoxy_sync_duration_seconds",
+			"Duration of library syncs, by strategy.", metrics.SyncBuckets, "strategy"),
+		shuffleDuration: registry.NewHistogramVec("subsoxy_shuffle_duration_seconds",
+			"Latency of weighted shuffles, by algorithm path (small, optimized).", metrics.DefaultBuckets, "path"),
+	}
+
+	registry.NewGaugeFunc("subsoxy_db_open_connections", "Open database connections.", func() float64 {
+		return float64(db.GetConnectionStats().OpenConnections)
+	})
+	registry.NewGaugeFunc("subsoxy_db_idle_connections", "Idle database connections.", func() float64 {
+		return float64(db.GetConnectionStats().IdleConnections)
+	})
+	registry.NewGaugeFunc("subsoxy_db_in_use_connections", "Database connections in use.", func() float64 {
+		return float64(db.GetConnectionStats().ConnectionsInUse)
+	})
+	registry.NewCounterFunc("subsoxy_db_health_checks_total", "Database health checks run.", func() float64 {
+		return float64(db.GetConnectionStats().HealthChecks)
+	})
+	registry.NewCounterFunc("subsoxy_db_failed_health_checks_total", "Database health checks that failed.", func() float64 {
+		return float64(db.GetConnectionStats().FailedConnections)
+	})
+
+	return m
+}
+
+// registerMetricsRoute mounts the metrics endpoint when enabled
+func (ps *ProxyServer) registerMetricsRoute(router *mux.Router) {
+	if !ps.config.MetricsEnabled {
+		ps.logger.Info("Metrics endpoint disabled")
+		return
+	}
+
+	router.Handle(MetricsPath, ps.metrics.registry.Handler()).Methods(http.MethodGet)
+	ps.logger.WithField("path", MetricsPath).Info("Metrics endpoint enabled")
+}
+
+// metricsEndpoint maps a request path to a bounded endpoint label. Hook endpoints and
+// well-formed Subsonic API paths keep their name, everything else is reported as "other".
+func (ps *ProxyServer) metricsEndpoint(endpoint string) string {
+	if _, exists := ps.hooks[endpoint]; exists {
+		return endpoint
+	}
+
+	name, ok := strings.CutPrefix(endpoint, "/rest/")
+	if !ok {
+		return metrics.OverflowLabel
+	}
+	name = strings.TrimSuffix(name, SubsonicViewSuffix)
+	if name == "" || len(name) > MaxMetricsEndpointLength {
+		return metrics.OverflowLabel
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return metrics.OverflowLabel
+		}
+	}
+	return "/rest/" + name
+}
+
+// metricsMethod bounds the method label to standard HTTP methods
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return metrics.OverflowLabel
+}
+
+func (m *serverMetrics) observeRequest(endpoint, method string, status int, duration time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	m.requests.Inc(endpoint, metricsMethod(method), strconv.Itoa(status))
+	m.requestDuration.Observe(duration.Seconds(), endpoint)
+}
+
+// proxyErrorHandler answers requests the upstream server could not serve with 502 Bad Gateway
+func (ps *ProxyServer) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	ps.metrics.upstreamErrors.Inc(ps.metricsEndpoint(r.URL.Path))
+
+	// The request URL may carry credentials, so only log the underlying cause
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	ps.logger.WithError(err).WithFields(logrus.Fields{
+		"endpoint": sanitizeForLogging(r.URL.Path),
+		"remote":   sanitizeRemoteAddr(r.RemoteAddr),
+	}).Error("Upstream request failed")
+
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush supports streamed responses through the reverse proxy
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
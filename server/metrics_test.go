@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/metrics"
+)
+
+func newMetricsTestServer(t *testing.T, upstreamURL string) *ProxyServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       upstreamURL,
+		LogLevel:          "error",
+		DatabasePath:      "test_metrics.db",
+		RateLimitRPS:      2,
+		RateLimitBurst:    2,
+		RateLimitEnabled:  true,
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		MetricsEnabled:    true,
+	}
+	t.Cleanup(func() { os.Remove("test_metrics.db") })
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return server
+}
+
+func TestMetricsEndpointLabel(t *testing.T) {
+	server := newMetricsTestServer(t, "http://localhost:4533")
+	server.AddHook("/debug", func(w http.ResponseWriter, r *http.Request, endpoint string) bool { return false })
+
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"/rest/ping", "/rest/ping"},
+		{"/rest/ping.view", "/rest/ping"},
+		{"/rest/getAlbumList2", "/rest/getAlbumList2"},
+		{"/debug", "/debug"},
+		{"/", metrics.OverflowLabel},
+		{"/rest/", metrics.OverflowLabel},
+		{"/rest/../etc/passwd", metrics.OverflowLabel},
+		{"/rest/" + strings.Repeat("a", MaxMetricsEndpointLength+1), metrics.OverflowLabel},
+	}
+
+	for _, tt := range tests {
+		if got := server.metricsEndpoint(tt.endpoint); got != tt.want {
+			t.Errorf("metricsEndpoint(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestProxyHandlerMetrics(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{"status": "ok", "version": "1.15.0"}
+		if r.URL.Query().Get("p") != "secret" {
+			response["status"] = "failed"
+			response["error"] = map[string]interface{}{"code": 40, "message": "Wrong username or password"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": response})
+	}))
+	defer mockServer.Close()
+
+	server := newMetricsTestServer(t, mockServer.URL)
+	server.AddHook("/rest/getLicense", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		w.WriteHeader(http.StatusTeapot)
+		return true
+	})
+
+	for _, path := range []string{"/rest/getLicense?u=alice&p=secret", "/rest/getAlbum.view?u=alice&p=wrong"} {
+		server.proxyHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	// The burst of two is used up, so this request is rejected
+	server.proxyHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rest/ping", nil))
+
+	m := server.metrics
+	if got := m.requests.Value("/rest/getLicense", http.MethodGet, "418"); got != 1 {
+		t.Errorf("Expected 1 getLicense request with status 418, got %v", got)
+	}
+	if got := m.requests.Value("/rest/getAlbum", http.MethodGet, "200"); got != 1 {
+		t.Errorf("Expected 1 proxied getAlbum request, got %v", got)
+	}
+	if got := m.requests.Value("/rest/ping", http.MethodGet, "429"); got != 1 {
+		t.Errorf("Expected 1 rate limited ping request, got %v", got)
+	}
+	if got := m.requestDuration.Count("/rest/getLicense"); got != 1 {
+		t.Errorf("Expected 1 getLicense latency observation, got %d", got)
+	}
+	if got := m.hookInvocations.Value("/rest/getLicense", HookResultHandled); got != 1 {
+		t.Errorf("Expected 1 handled hook invocation, got %v", got)
+	}
+	if got := m.rateLimited.Value(RateLimitScopeClient); got != 1 {
+		t.Errorf("Expected 1 rate limited request, got %v", got)
+	}
+	if got := m.credentialValidations.Value(CredentialValid); got != 1 {
+		t.Errorf("Expected 1 valid credential validation, got %v", got)
+	}
+	if got := m.credentialValidations.Value(CredentialInvalid); got != 1 {
+		t.Errorf("Expected 1 invalid credential validation, got %v", got)
+	}
+}
+
+func TestUpstreamErrorMetrics(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstreamURL := mockServer.URL
+	mockServer.Close()
+
+	server := newMetricsTestServer(t, upstreamURL)
+
+	w := httptest.NewRecorder()
+	server.proxyHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d", w.Code)
+	}
+	if got := server.metrics.upstreamErrors.Value(metrics.OverflowLabel); got != 1 {
+		t.Errorf("Expected 1 upstream error, got %v", got)
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	server := newMetricsTestServer(t, "http://localhost:4533")
+	server.metrics.syncRuns.Inc("id3", "success")
+
+	router := mux.NewRouter()
+	server.registerMetricsRoute(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`subsoxy_sync_runs_total{strategy="id3",status="success"} 1`,
+		"# TYPE subsoxy_db_open_connections gauge",
+		"# TYPE subsoxy_shuffle_duration_seconds histogram",
+		"# TYPE subsoxy_rate_limited_requests_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+
+	server.config.MetricsEnabled = false
+	router = mux.NewRouter()
+	server.registerMetricsRoute(router)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected metrics endpoint to be unavailable when disabled, got %d", w.Code)
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/syeo66/subsoxy/config"
+)
+
+// Rate limit scopes, used in logs and metrics
+const (
+	RateLimitScopeClient = "client"
+	RateLimitScopeUser   = "user"
+)
+
+// ExpensiveEndpoints are rate limited with their own, smaller budget
+var ExpensiveEndpoints = map[string]bool{
+	"/rest/getRandomSongs":      true,
+	"/rest/getRandomSongs.view": true,
+	"/debug":                    true,
+	"/debug/explain":            true,
+}
+
+// limiterEntry is the token bucket of one key
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// keyedLimiter keeps a token bucket per key and evicts buckets that were idle
+// for longer than idleTimeout
+type keyedLimiter struct {
+	limit       rate.Limit
+	burst       int
+	idleTimeout time.Duration
+	mu          sync.Mutex
+	entries     map[string]*limiterEntry
+	lastSweep   time.Time
+}
+
+func newKeyedLimiter(rps, burst int, idleTimeout time.Duration) *keyedLimiter {
+	return &keyedLimiter{
+		limit:       rate.Limit(rps),
+		burst:       burst,
+		idleTimeout: idleTimeout,
+		entries:     make(map[string]*limiterEntry),
+		lastSweep:   time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of key. If none is available it returns
+// false and how long the caller has to wait for the next token.
+func (kl *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	if now.Sub(kl.lastSweep) >= kl.idleTimeout {
+		kl.evictIdle(now)
+	}
+
+	entry, exists := kl.entries[key]
+	if !exists {
+		entry = &limiterEntry{limiter: rate.NewLimiter(kl.limit, kl.burst)}
+		kl.entries[key] = entry
+	}
+	entry.lastSeen = now
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Give the token back, the request is rejected rather than delayed
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// evictIdle drops the buckets of keys not seen within the idle timeout
+func (kl *keyedLimiter) evictIdle(now time.Time) {
+	for key, entry := range kl.entries {
+		if now.Sub(entry.lastSeen) >= kl.idleTimeout {
+			delete(kl.entries, key)
+		}
+	}
+	kl.lastSweep = now
+}
+
+// size returns the number of tracked keys
+func (kl *keyedLimiter) size() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	return len(kl.entries)
+}
+
+// requestLimiter rate limits requests per client IP and per authenticated user,
+// with separate budgets for expensive endpoints
+type requestLimiter struct {
+	clients          *keyedLimiter
+	clientsExpensive *keyedLimiter
+	users            *keyedLimiter
+	usersExpensive   *keyedLimiter
+	trustedProxies   []*net.IPNet
+}
+
+func newRequestLimiter(cfg *config.Config) (*requestLimiter, error) {
+	trustedProxies, err := cfg.TrustedProxyNets()
+	if err != nil {
+		return nil, err
+	}
+
+	idleTimeout := cfg.RateLimitIdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = config.DefaultRateLimitIdleTimeout
+	}
+	userRPS, userBurst := cfg.UserRateLimit()
+	expensiveRPS, expensiveBurst := cfg.ExpensiveRateLimit()
+
+	return &requestLimiter{
+		clients:          newKeyedLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, idleTimeout),
+		clientsExpensive: newKeyedLimiter(expensiveRPS, expensiveBurst, idleTimeout),
+		users:            newKeyedLimiter(userRPS, userBurst, idleTimeout),
+		usersExpensive:   newKeyedLimiter(expensiveRPS, expensiveBurst, idleTimeout),
+		trustedProxies:   trustedProxies,
+	}, nil
+}
+
+// allowClient checks the budget of a client IP
+func (rl *requestLimiter) allowClient(clientIP string, expensive bool) (bool, time.Duration) {
+	if expensive {
+		return rl.clientsExpensive.allow(clientIP, time.Now())
+	}
+	return rl.clients.allow(clientIP, time.Now())
+}
+
+// allowUser checks the budget of an authenticated user
+func (rl *requestLimiter) allowUser(username string, expensive bool) (bool, time.Duration) {
+	if expensive {
+		return rl.usersExpensive.allow(username, time.Now())
+	}
+	return rl.users.allow(username, time.Now())
+}
+
+// clientIP returns the address of the client that sent the request (see clientAddress)
+func (rl *requestLimiter) clientIP(r *http.Request) string {
+	return clientAddress(r, rl.trustedProxies)
+}
+
+// clientAddress returns the address of the client that sent the request. X-Forwarded-For is
+// only honored for connections from trusted proxies, in which case the rightmost address
+// not belonging to a trusted proxy is used.
+func clientAddress(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// A malformed entry can't be attributed, so stop at the last trusted hop
+			break
+		}
+		client = hop
+		if !isTrustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectRateLimited answers a request that exceeded its budget with 429 Too Many Requests
+// and a Retry-After header
+func (ps *ProxyServer) rejectRateLimited(w http.ResponseWriter, r *http.Request, scope string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	ps.metrics.rateLimited.Inc(scope)
+	ps.logger.WithFields(logrus.Fields{
+		"scope":       scope,
+		"endpoint":    sanitizeForLogging(r.URL.Path),
+		"remote":      sanitizeRemoteAddr(r.RemoteAddr),
+		"retry_after": seconds,
+	}).Warn("Rate limit exceeded")
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/config"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	limiter := newKeyedLimiter(1, 2, time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.allow("a", now); !allowed {
+			t.Fatalf("Request %d within burst should be allowed", i+1)
+		}
+	}
+
+	allowed, retryAfter := limiter.allow("a", now)
+	if allowed {
+		t.Fatal("Request beyond burst should be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected retry after up to one second, got %v", retryAfter)
+	}
+
+	// Other keys have their own budget
+	if allowed, _ := limiter.allow("b", now); !allowed {
+		t.Error("Request for another key should be allowed")
+	}
+
+	// Rejected requests don't consume tokens
+	if allowed, _ := limiter.allow("a", now.Add(time.Second)); !allowed {
+		t.Error("Request should be allowed once a token is refilled")
+	}
+}
+
+func TestKeyedLimiterEvictsIdleKeys(t *testing.T) {
+	limiter := newKeyedLimiter(1, 1, time.Minute)
+	now := time.Now()
+
+	limiter.allow("idle", now)
+	limiter.allow("active", now.Add(50*time.Second))
+	if limiter.size() != 2 {
+		t.Fatalf("Expected 2 tracked keys, got %d", limiter.size())
+	}
+
+	limiter.allow("active", now.Add(90*time.Second))
+	if limiter.size() != 1 {
+		t.Errorf("Expected idle key to be evicted, got %d tracked keys", limiter.size())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	limiter, err := newRequestLimiter(&config.Config{
+		RateLimitRPS:   1,
+		RateLimitBurst: 1,
+		TrustedProxies: []string{"10.0.0.1", "172.16.0.0/12"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{"direct client", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer ignores header", "192.0.2.1:1234", []string{"203.0.113.5"}, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"spoofed entries before client", "10.0.0.1:1234", []string{"198.51.100.1, 203.0.113.5"}, "203.0.113.5"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"203.0.113.5, 172.16.0.9"}, "203.0.113.5"},
+		{"multiple headers", "10.0.0.1:1234", []string{"203.0.113.5", "172.16.0.9"}, "203.0.113.5"},
+		{"malformed entry", "10.0.0.1:1234", []string{"203.0.113.5, garbage"}, "10.0.0.1"},
+		{"trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/rest/ping", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := limiter.clientIP(req); got != tt.expectedIP {
+				t.Errorf("Expected client IP %s, got %s", tt.expectedIP, got)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidTrustedProxy(t *testing.T) {
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       "http://localhost:4533",
+		LogLevel:          "error",
+		DatabasePath:      "test_rate_limit_config.db",
+		RateLimitRPS:      1,
+		RateLimitBurst:    1,
+		RateLimitEnabled:  true,
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		TrustedProxies:    []string{"not-an-ip"},
+	}
+	defer os.Remove("test_rate_limit_config.db")
+
+	if _, err := New(cfg); err == nil {
+		t.Error("Expected error for invalid trusted proxy")
+	}
+}
+
+func TestPerClientAndPerUserRateLimits(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subsonic-response": map[string]interface{}{"status": "ok", "version": "1.15.0"},
+		})
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		ProxyPort:               "8080",
+		UpstreamURL:             mockServer.URL,
+		LogLevel:                "error",
+		DatabasePath:            "test_rate_limits.db",
+		RateLimitRPS:            3,
+		RateLimitBurst:          3,
+		RateLimitEnabled:        true,
+		RateLimitUserRPS:        2,
+		RateLimitUserBurst:      2,
+		RateLimitExpensiveRPS:   1,
+		RateLimitExpensiveBurst: 1,
+		CredentialWorkers:       config.DefaultCredentialWorkers,
+	}
+	defer os.Remove("test_rate_limits.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	server.AddHook("/rest/getRandomSongs", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		w.WriteHeader(http.StatusOK)
+		return true
+	})
+
+	request := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		server.proxyHandler(w, req)
+		return w
+	}
+
+	// Expensive endpoints have their own budget, leaving the regular one untouched
+	if w := request("/rest/getRandomSongs?u=alice&p=secret", "192.0.2.1:1000"); w.Code != http.StatusOK {
+		t.Fatalf("Expected first shuffle to be allowed, got %d", w.Code)
+	}
+	w := request("/rest/getRandomSongs?u=alice&p=secret", "192.0.2.1:1000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected second shuffle to be rate limited, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After of 1 second, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// The user budget applies across devices: alice uses two more requests from two IPs
+	for _, remote := range []string{"192.0.2.2:1000", "192.0.2.3:1000"} {
+		if w := request("/rest/ping?u=alice&p=secret", remote); w.Code != http.StatusOK {
+			t.Fatalf("Expected ping from %s to be allowed, got %d", remote, w.Code)
+		}
+	}
+	if w := request("/rest/ping?u=alice&p=secret", "192.0.2.4:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected alice to exceed the user budget, got %d", w.Code)
+	}
+	if got := server.metrics.rateLimited.Value(RateLimitScopeUser); got != 1 {
+		t.Errorf("Expected 1 user scoped rejection, got %v", got)
+	}
+
+	// Another household member on the same IP is not affected by alice
+	if w := request("/rest/ping?u=bob&p=secret", "192.0.2.4:1000"); w.Code != http.StatusOK {
+		t.Errorf("Expected bob to be allowed, got %d", w.Code)
+	}
+}
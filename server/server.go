@@ -2,8 +2,8 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -33,11 +33,16 @@ const (
 
 // Server operation constants
 const (
-	SongSyncInterval     = 1 * time.Hour
-	UserSyncStaggerDelay = 2 * time.Second
-	CORSMaxAge           = "86400"
-	SubsonicAPIVersion   = "1.15.0"
-	ClientName           = "subsoxy"
+	SongSyncInterval   = 1 * time.Hour
+	CORSMaxAge         = "86400"
+	SubsonicAPIVersion = "1.15.0"
+	ClientName         = "subsoxy"
+
+	// DebugEndpointPrefix is the path prefix of the browser-facing debug pages
+	DebugEndpointPrefix = "/debug"
+
+	// SubsonicErrorWrongCredentials is the Subsonic API error code for a wrong username or password
+	SubsonicErrorWrongCredentials = 40
 )
 
 // ASCII control character constants
@@ -51,6 +56,7 @@ type ProxyServer struct {
 	logger            *logrus.Logger
 	proxy             *httputil.ReverseProxy
 	hooks             map[string][]models.Hook
+	authEndpoints     map[string]bool // Endpoints whose hooks require an authenticated user
 	db                *database.DB
 	credentials       *credentials.Manager
 	handlers          *handlers.Handler
@@ -59,9 +65,19 @@ type ProxyServer struct {
 	syncTicker        *time.Ticker
 	syncMutex         sync.RWMutex
 	shutdownChan      chan struct{}
-	rateLimiter       *rate.Limiter
-	credentialWorkers chan struct{}   // Semaphore for limiting concurrent credential validations
-	credentialWg      sync.WaitGroup   // WaitGroup for tracking in-flight credential validations
+	shutdownMutex     sync.Mutex      // Makes closing shutdownChan atomic with starting background syncs
+	syncCtx           context.Context // Canceled on shutdown to abort in-flight sync requests
+	cancelSync        context.CancelFunc
+	syncWg            sync.WaitGroup       // WaitGroup for tracking the periodic sync routine and admin-triggered syncs
+	activeSyncs       map[string]time.Time // Users with a running sync, mapped to its start time
+	activeSyncMutex   sync.Mutex
+	rateLimiter       *requestLimiter // Per-client and per-user rate limits, nil when disabled
+	trustedProxies    []*net.IPNet    // Proxies whose X-Forwarded-For identifies the client
+	syncLimiter       *rate.Limiter   // Paces upstream requests during library sync, nil when unlimited
+	syncStrategy      SyncStrategy
+	credentialWorkers chan struct{}  // Semaphore for limiting concurrent credential validations
+	credentialWg      sync.WaitGroup // WaitGroup for tracking syncs triggered by new credentials
+	metrics           *serverMetrics
 }
 
 func New(cfg *config.Config) (*ProxyServer, error) {
@@ -112,15 +128,68 @@ func New(cfg *config.Config) (*ProxyServer, error) {
 	}).Info("Database connection pool configured")
 
 	credManager := credentials.New(logger, cfg.UpstreamURL)
+
+	currentKey, previousKey, err := cfg.CredentialKeys()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, errors.CategoryServer, "INITIALIZATION_FAILED", "failed to read credential store key")
+	}
+	if currentKey != nil {
+		restored, err := credManager.EnablePersistence(db, currentKey, previousKey)
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, errors.CategoryServer, "INITIALIZATION_FAILED", "failed to enable credential store")
+		}
+		logger.WithFields(logrus.Fields{
+			"restored_users": restored,
+			"key_rotation":   previousKey != nil,
+		}).Info("Persistent credential store enabled")
+	}
+	serverMetrics := newServerMetrics(db)
 	shuffleService := shuffle.New(db, logger)
+	shuffleService.SetDurationMetric(serverMetrics.shuffleDuration)
+	shuffleService.SetDiversityRules(shuffle.DiversityRules{
+		MaxPerArtist:  cfg.ShuffleMaxPerArtist,
+		MaxPerAlbum:   cfg.ShuffleMaxPerAlbum,
+		ArtistSpacing: cfg.ShuffleArtistSpacing,
+	})
+	shuffleService.SetReplayWindows(shuffle.ReplayWindows{
+		PlayedDays:  cfg.ReplayWindowPlayedDays,
+		SkippedDays: cfg.ReplayWindowSkippedDays,
+	})
+	shuffleService.SetStreamPlayThreshold(cfg.StreamPlayThreshold)
+	defaultWeighting, err := defaultWeightingProfile(db, cfg.WeightingPreset, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	shuffleService.SetDefaultWeightingProfile(defaultWeighting)
 	handlersService := handlers.New(logger, shuffleService)
+	handlersService.SetServerInfo(credManager.ServerInfo)
+
+	trustedProxies, err := cfg.TrustedProxyNets()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, errors.CategoryServer, "INITIALIZATION_FAILED", "failed to parse trusted proxies")
+	}
 
-	var rateLimiter *rate.Limiter
+	var rateLimiter *requestLimiter
 	if cfg.RateLimitEnabled {
-		rateLimiter = rate.NewLimiter(rate.Limit(cfg.RateLimitRPS), cfg.RateLimitBurst)
+		rateLimiter, err = newRequestLimiter(cfg)
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, errors.CategoryServer, "INITIALIZATION_FAILED", "failed to configure rate limiting")
+		}
+		userRPS, userBurst := cfg.UserRateLimit()
+		expensiveRPS, expensiveBurst := cfg.ExpensiveRateLimit()
 		logger.WithFields(logrus.Fields{
-			"rps":   cfg.RateLimitRPS,
-			"burst": cfg.RateLimitBurst,
+			"client_rps":      cfg.RateLimitRPS,
+			"client_burst":    cfg.RateLimitBurst,
+			"user_rps":        userRPS,
+			"user_burst":      userBurst,
+			"expensive_rps":   expensiveRPS,
+			"expensive_burst": expensiveBurst,
+			"trusted_proxies": len(rateLimiter.trustedProxies),
 		}).Info("Rate limiting enabled")
 	} else {
 		logger.Info("Rate limiting disabled")
@@ -132,21 +201,47 @@ func New(cfg *config.Config) (*ProxyServer, error) {
 		"max_workers": cfg.CredentialWorkers,
 	}).Info("Credential validation worker pool configured")
 
+	var syncLimiter *rate.Limiter
+	if cfg.SyncRequestRate > 0 {
+		syncLimiter = rate.NewLimiter(rate.Limit(cfg.SyncRequestRate), 1)
+	}
+	syncCtx, cancelSync := context.WithCancel(context.Background())
+
 	server := &ProxyServer{
 		config:            cfg,
 		logger:            logger,
 		proxy:             proxy,
 		hooks:             make(map[string][]models.Hook),
+		authEndpoints:     make(map[string]bool),
 		db:                db,
 		credentials:       credManager,
 		handlers:          handlersService,
 		shuffle:           shuffleService,
 		shutdownChan:      make(chan struct{}),
+		syncCtx:           syncCtx,
+		cancelSync:        cancelSync,
+		activeSyncs:       make(map[string]time.Time),
 		rateLimiter:       rateLimiter,
+		trustedProxies:    trustedProxies,
+		syncLimiter:       syncLimiter,
 		credentialWorkers: credentialWorkers,
+		metrics:           serverMetrics,
 	}
+	proxy.ErrorHandler = server.proxyErrorHandler
+	handlersService.SetDeviceIdentifier(server.scrobbleDeviceID)
+	server.syncStrategy = newSyncStrategy(cfg.SyncStrategy, server)
+	logger.WithFields(logrus.Fields{
+		"strategy":     server.syncStrategy.Name(),
+		"concurrency":  cfg.SyncConcurrency,
+		"request_rate": cfg.SyncRequestRate,
+		"max_retries":  cfg.SyncMaxRetries,
+	}).Info("Library sync configured")
 
-	go server.syncSongs()
+	server.syncWg.Add(1)
+	go func() {
+		defer server.syncWg.Done()
+		server.syncSongs()
+	}()
 
 	return server, nil
 }
@@ -199,6 +294,14 @@ func (ps *ProxyServer) AddHook(endpoint string, hook models.Hook) {
 	ps.hooks[endpoint] = append(ps.hooks[endpoint], hook)
 }
 
+// AddAuthenticatedHook registers a hook that only runs for requests carrying credentials
+// accepted by the upstream server. Unauthenticated requests to the endpoint are answered
+// with a Subsonic error (code 40) and never reach the hook or the upstream server.
+func (ps *ProxyServer) AddAuthenticatedHook(endpoint string, hook models.Hook) {
+	ps.authEndpoints[endpoint] = true
+	ps.AddHook(endpoint, hook)
+}
+
 // setCORSHeaders sets CORS headers based on configuration
 func (ps *ProxyServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
@@ -240,6 +343,14 @@ func (ps *ProxyServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
 func (ps *ProxyServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	endpoint := r.URL.Path
 
+	start := time.Now()
+	metricsEndpoint := ps.metricsEndpoint(endpoint)
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	defer func() {
+		ps.metrics.observeRequest(metricsEndpoint, r.Method, recorder.status, time.Since(start))
+	}()
+
 	// Add CORS headers if enabled
 	if ps.config.CORSEnabled {
 		ps.setCORSHeaders(w, r)
@@ -261,19 +372,18 @@ func (ps *ProxyServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		"remote":   sanitizedRemoteAddr,
 	}).Info("Incoming request")
 
+	expensive := ExpensiveEndpoints[endpoint]
 	if ps.rateLimiter != nil {
-		if !ps.rateLimiter.Allow() {
-			ps.logger.WithFields(logrus.Fields{
-				"endpoint": sanitizedEndpoint,
-				"remote":   sanitizedRemoteAddr,
-			}).Warn("Rate limit exceeded")
-
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		if allowed, retryAfter := ps.rateLimiter.allowClient(ps.rateLimiter.clientIP(r), expensive); !allowed {
+			ps.rejectRateLimited(w, r, RateLimitScopeClient, retryAfter)
 			return
 		}
 	}
 
-	if strings.HasPrefix(endpoint, "/rest/") {
+	requiresAuth := ps.authEndpoints[endpoint]
+	var authErr error
+
+	if strings.HasPrefix(endpoint, "/rest/") || requiresAuth {
 		username, password := ps.extractCredentials(r)
 
 		// Validate input lengths
@@ -285,29 +395,16 @@ func (ps *ProxyServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
 			username = username[:MaxUsernameLength]
 		}
 
-		if username != "" && password != "" && len(username) > 0 && len(password) > 0 {
+		if username != "" && password != "" {
 			ps.logger.WithField("username", sanitizeUsername(username)).Debug("Extracted credentials from request, attempting validation")
 
-			// Acquire a worker slot (blocks if all workers are busy)
-			ps.credentialWorkers <- struct{}{}
-			ps.credentialWg.Add(1)
-
-			go func() {
-				defer func() {
-					<-ps.credentialWorkers // Release worker slot
-					ps.credentialWg.Done()  // Mark goroutine as complete
-				}()
-
-				isNewCredential, err := ps.credentials.ValidateAndStore(username, password)
-				if err != nil {
-					ps.logger.WithError(err).WithField("username", sanitizeUsername(username)).Warn("Failed to validate credentials")
-				} else if isNewCredential {
-					ps.logger.WithField("username", sanitizeUsername(username)).Info("New credentials captured, triggering immediate sync")
-					// Trigger immediate sync for new credentials
-					ps.fetchAndStoreSongs()
-				}
-			}()
+			if authErr = ps.authenticate(username, password); authErr == nil {
+				r = r.WithContext(credentials.WithUser(r.Context(), username))
+			} else {
+				ps.logger.WithError(authErr).WithField("username", sanitizeUsername(username)).Warn("Failed to validate credentials")
+			}
 		} else {
+			authErr = errors.ErrInvalidCredentials.WithContext("reason", "no credentials in request")
 			// Log details about request without exposing any credential information
 			ps.logger.WithFields(logrus.Fields{
 				"has_username":  username != "",
@@ -321,21 +418,88 @@ func (ps *ProxyServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if requiresAuth && credentials.UserFromContext(r.Context()) == "" {
+		ps.logger.WithFields(logrus.Fields{
+			"endpoint": sanitizedEndpoint,
+			"remote":   sanitizedRemoteAddr,
+		}).Warn("Rejected unauthenticated request")
+
+		// Browsers get a Basic Auth challenge for the debug pages, so page links never carry credentials
+		if strings.HasPrefix(endpoint, DebugEndpointPrefix) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="subsoxy debug", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if errors.Is(authErr, errors.ErrInvalidCredentials) {
+			handlers.WriteSubsonicError(w, r, ps.credentials.ServerInfo(), handlers.SubsonicErrorWrongCredentials, "Wrong username or password")
+		} else {
+			handlers.WriteSubsonicError(w, r, ps.credentials.ServerInfo(), handlers.SubsonicErrorGeneric, "Unable to verify credentials with upstream server")
+		}
+		return
+	}
+
+	if username := credentials.UserFromContext(r.Context()); username != "" && ps.rateLimiter != nil {
+		if allowed, retryAfter := ps.rateLimiter.allowUser(username, expensive); !allowed {
+			ps.rejectRateLimited(w, r, RateLimitScopeUser, retryAfter)
+			return
+		}
+	}
+
 	if hooks, exists := ps.hooks[endpoint]; exists {
 		for _, hook := range hooks {
 			if hook(w, r, endpoint) {
+				ps.metrics.hookInvocations.Inc(metricsEndpoint, HookResultHandled)
 				return
 			}
 		}
+		ps.metrics.hookInvocations.Inc(metricsEndpoint, HookResultPassed)
 	}
 
 	if strings.HasPrefix(endpoint, "/rest/") {
 		ps.logger.WithField("endpoint", sanitizedEndpoint).Debug("Subsonic API endpoint")
 	}
 
+	if ps.tracksStream(endpoint, r) {
+		ps.proxyTrackedStream(w, r)
+		return
+	}
+
 	ps.proxy.ServeHTTP(w, r)
 }
 
+// authenticate synchronously validates credentials (using the credential manager's cache)
+// and triggers a background sync when they were not known before
+func (ps *ProxyServer) authenticate(username, password string) error {
+	// Acquire a worker slot (blocks if all workers are busy)
+	ps.credentialWorkers <- struct{}{}
+	isNewCredential, err := ps.credentials.Authenticate(username, password)
+	<-ps.credentialWorkers // Release worker slot
+
+	switch {
+	case err == nil:
+		ps.metrics.credentialValidations.Inc(CredentialValid)
+	case errors.Is(err, errors.ErrInvalidCredentials):
+		ps.metrics.credentialValidations.Inc(CredentialInvalid)
+	default:
+		ps.metrics.credentialValidations.Inc(CredentialError)
+	}
+	if err != nil {
+		return err
+	}
+
+	if isNewCredential {
+		ps.logger.WithField("username", sanitizeUsername(username)).Info("New credentials captured, triggering immediate sync")
+		ps.credentialWg.Add(1)
+		go func() {
+			defer ps.credentialWg.Done() // Mark sync as complete
+			ps.fetchAndStoreSongs()
+		}()
+	}
+
+	return nil
+}
+
 func (ps *ProxyServer) Start() error {
 	if ps.server != nil {
 		return errors.ErrServerStart.WithContext("reason", "server already started")
@@ -352,6 +516,8 @@ func (ps *ProxyServer) Start() error {
 		ps.logger.Info("Security headers middleware disabled")
 	}
 
+	ps.registerMetricsRoute(router)
+	ps.registerAdminRoutes(router)
 	router.PathPrefix("/").HandlerFunc(ps.proxyHandler)
 
 	ps.server = &http.Server{
@@ -377,7 +543,11 @@ func (ps *ProxyServer) Start() error {
 func (ps *ProxyServer) Shutdown(ctx context.Context) error {
 	ps.logger.Info("Shutting down proxy server...")
 
+	// Stop handing out sync work and abort in-flight upstream requests
+	ps.shutdownMutex.Lock()
 	close(ps.shutdownChan)
+	ps.shutdownMutex.Unlock()
+	ps.cancelSync()
 
 	// Safely stop the ticker
 	ps.syncMutex.RLock()
@@ -386,17 +556,18 @@ func (ps *ProxyServer) Shutdown(ctx context.Context) error {
 	}
 	ps.syncMutex.RUnlock()
 
-	// Wait for in-flight credential validations to complete
-	ps.logger.Info("Waiting for credential validation workers to finish...")
+	// Wait for canceled syncs to wind down before closing the database
+	ps.logger.Info("Waiting for running syncs to finish...")
 	done := make(chan struct{})
 	go func() {
 		ps.credentialWg.Wait()
+		ps.syncWg.Wait()
 		close(done)
 	}()
 
 	select {
 	case <-done:
-		ps.logger.Info("All credential validation workers finished")
+		ps.logger.Info("All syncs finished")
 	case <-ctx.Done():
 		ps.logger.Warn("Shutdown timeout reached, forcing shutdown")
 	}
@@ -432,8 +603,14 @@ func (ps *ProxyServer) syncSongs() {
 		ps.syncMutex.Unlock()
 	}()
 
-	// Skip initial sync - wait for credentials to be captured from client requests
-	ps.logger.Info("Song sync routine started - waiting for valid credentials from client requests")
+	// Sync immediately when credentials were restored from the persistent store,
+	// otherwise wait for credentials to be captured from client requests
+	if len(ps.credentials.GetAllValid()) > 0 {
+		ps.logger.Info("Song sync routine started - syncing with restored credentials")
+		ps.fetchAndStoreSongs()
+	} else {
+		ps.logger.Info("Song sync routine started - waiting for valid credentials from client requests")
+	}
 
 	for {
 		ps.syncMutex.RLock()
@@ -468,115 +645,138 @@ func (ps *ProxyServer) fetchAndStoreSongs() {
 
 	ps.logger.WithField("user_count", len(allCredentials)).Info("Starting multi-user song sync")
 
-	// Sync songs for each user with staggered delays
-	for i, username := range getSortedUsernames(allCredentials) {
+	// Sync songs for each user in turn; the sync rate limiter keeps upstream load bounded
+	for _, username := range getSortedUsernames(allCredentials) {
 		password := allCredentials[username]
 
-		// Add staggered delay to avoid overwhelming upstream server (except for first user)
-		if i > 0 {
-			delay := time.Duration(i) * UserSyncStaggerDelay
-			ps.logger.WithFields(logrus.Fields{
-				"user":  sanitizeUsername(username),
-				"delay": delay,
-			}).Debug("Adding staggered delay for user sync")
-			time.Sleep(delay)
+		select {
+		case <-ps.shutdownChan:
+			ps.logger.Info("Multi-user song sync canceled by shutdown")
+			return
+		default:
 		}
 
-		// Sync songs for this specific user
-		if err := ps.syncSongsForUser(username, password); err != nil {
-			ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to sync songs for user")
-			// Continue with other users even if one fails
-			continue
+		// Sync songs for this specific user, continuing with other users even if one fails
+		if err := ps.syncUser(username, password); errors.Is(err, errors.ErrSyncCanceled) {
+			return
 		}
 	}
 
 	ps.logger.Info("Multi-user song sync completed")
 }
 
-// syncSongsForUser handles song synchronization for a single user using directory traversal
+// syncUser syncs songs for one user and handles the outcome: credentials rejected by
+// upstream are forgotten and failures are logged. The sync error is returned for callers
+// that need to stop on cancellation.
+func (ps *ProxyServer) syncUser(username, password string) error {
+	err := ps.syncSongsForUser(username, password)
+	switch {
+	case err == nil:
+	case errors.Is(err, errors.ErrSyncCanceled):
+		ps.logger.WithField("user", sanitizeUsername(username)).Info("Song sync canceled by shutdown, keeping existing library")
+	case errors.Is(err, errors.ErrSyncInProgress):
+		ps.logger.WithField("user", sanitizeUsername(username)).Debug("Song sync already running for user, skipping")
+	case errors.Is(err, errors.ErrUpstreamAuth):
+		// Upstream no longer accepts these credentials - forget them
+		ps.credentials.Remove(username)
+		ps.logger.WithField("user", sanitizeUsername(username)).Warn("Upstream rejected stored credentials, removed them")
+	default:
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Error("Failed to sync songs for user")
+	}
+	return err
+}
+
+// syncSongsForUser handles song synchronization for a single user using the configured sync strategy
+// and records the outcome in the sync history. Only one sync per user runs at a time.
 func (ps *ProxyServer) syncSongsForUser(username, password string) error {
-	ps.logger.WithField("user", sanitizeUsername(username)).Info("Syncing songs for user")
+	if !ps.beginSync(username) {
+		return errors.ErrSyncInProgress
+	}
+	defer ps.endSync(username)
 
-	// First, get all music folders
-	musicFolders, err := ps.getMusicFolders(username, password)
-	if err != nil {
-		return errors.Wrap(err, errors.CategoryNetwork, "MUSIC_FOLDERS_FAILED", "failed to get music folders").
-			WithContext("username", username)
+	run := models.SyncRun{
+		UserID:    username,
+		Strategy:  ps.syncStrategy.Name(),
+		StartedAt: time.Now(),
 	}
+	err := ps.runUserSync(username, password, &run)
 
-	var allSongs []models.Song
+	run.FinishedAt = time.Now()
+	run.DurationMs = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	switch {
+	case err == nil:
+		run.Status = models.SyncStatusSuccess
+	case errors.Is(err, errors.ErrSyncCanceled):
+		run.Status = models.SyncStatusCanceled
+	default:
+		run.Status = models.SyncStatusFailed
+		run.Error = syncErrorSummary(err)
+	}
+	ps.metrics.syncRuns.Inc(run.Strategy, run.Status)
+	ps.metrics.syncDuration.Observe(run.FinishedAt.Sub(run.StartedAt).Seconds(), run.Strategy)
 
-	// Traverse each music folder
-	for _, folder := range musicFolders {
-		// Convert folder ID to string
-		folderID := fmt.Sprintf("%v", folder.ID)
+	if recordErr := ps.db.RecordSyncRun(run); recordErr != nil {
+		ps.logger.WithError(recordErr).WithField("user", sanitizeUsername(username)).Warn("Failed to record sync history")
+	}
 
-		ps.logger.WithFields(logrus.Fields{
-			"user":        sanitizeUsername(username),
-			"folder_id":   folderID,
-			"folder_name": folder.Name,
-		}).Debug("Processing music folder")
+	return err
+}
 
-		// Get indexes for this folder to get artists
-		indexes, err := ps.getIndexes(username, password, folderID)
-		if err != nil {
-			ps.logger.WithError(err).WithFields(logrus.Fields{
-				"user":      sanitizeUsername(username),
-				"folder_id": folderID,
-			}).Warn("Failed to get indexes for folder, skipping")
-			continue
-		}
-
-		// Process each artist
-		for _, index := range indexes {
-			for _, artist := range index.Artists {
-				ps.logger.WithFields(logrus.Fields{
-					"user":        sanitizeUsername(username),
-					"artist_id":   artist.ID,
-					"artist_name": artist.Name,
-				}).Debug("Processing artist")
-
-				// Get albums for this artist
-				albums, err := ps.getMusicDirectory(username, password, artist.ID)
-				if err != nil {
-					ps.logger.WithError(err).WithFields(logrus.Fields{
-						"user":      sanitizeUsername(username),
-						"artist_id": artist.ID,
-					}).Warn("Failed to get albums for artist, skipping")
-					continue
-				}
+// syncErrorSummary describes a sync failure without its cause, which may contain upstream URLs with credentials
+func syncErrorSummary(err error) string {
+	var subsoxyErr *errors.SubsoxyError
+	if errors.As(err, &subsoxyErr) {
+		return fmt.Sprintf("%s: %s", subsoxyErr.Code, subsoxyErr.Message)
+	}
+	return "sync failed"
+}
 
-				// Process each album
-				for _, album := range albums {
-					if album.IsDir {
-						ps.logger.WithFields(logrus.Fields{
-							"user":        sanitizeUsername(username),
-							"album_id":    album.ID,
-							"album_title": album.Title,
-						}).Debug("Processing album")
-
-						// Get songs for this album
-						songs, err := ps.getMusicDirectory(username, password, album.ID)
-						if err != nil {
-							ps.logger.WithError(err).WithFields(logrus.Fields{
-								"user":     sanitizeUsername(username),
-								"album_id": album.ID,
-							}).Warn("Failed to get songs for album, skipping")
-							continue
-						}
-
-						// Add songs (filter out directories)
-						for _, song := range songs {
-							if !song.IsDir {
-								allSongs = append(allSongs, song)
-							}
-						}
-					}
-				}
-			}
-		}
+// beginSync marks a sync as running for a user, returning false if one is already running
+func (ps *ProxyServer) beginSync(username string) bool {
+	ps.activeSyncMutex.Lock()
+	defer ps.activeSyncMutex.Unlock()
+
+	if _, running := ps.activeSyncs[username]; running {
+		return false
+	}
+	ps.activeSyncs[username] = time.Now()
+	return true
+}
+
+// endSync marks the sync of a user as finished
+func (ps *ProxyServer) endSync(username string) {
+	ps.activeSyncMutex.Lock()
+	defer ps.activeSyncMutex.Unlock()
+
+	delete(ps.activeSyncs, username)
+}
+
+// runUserSync fetches the library of a user, applies the differences to the database and fills in run
+func (ps *ProxyServer) runUserSync(username, password string, run *models.SyncRun) error {
+	cursor, err := ps.db.GetSyncCursor(username)
+	if err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Warn("Failed to load sync cursor, running full sync")
+		cursor = nil
 	}
 
+	full := ps.needsFullSync(cursor)
+	since := cursor
+	if full {
+		since = nil
+	}
+
+	ps.logger.WithFields(logrus.Fields{
+		"user": sanitizeUsername(username),
+		"full": full,
+	}).Info("Syncing songs for user")
+
+	result, err := ps.fetchLibrary(username, password, since)
+	if err != nil {
+		return err
+	}
+	allSongs := result.Songs
+	run.Full = result.Complete
+
 	// Implement differential sync - get existing songs to determine what to add/update/delete
 	existingSongIDs, err := ps.db.GetExistingSongIDs(username)
 	if err != nil {
@@ -590,11 +790,14 @@ func (ps *ProxyServer) syncSongsForUser(username, password string) error {
 		upstreamSongIDs[song.ID] = true
 	}
 
-	// Determine songs to delete (exist locally but not upstream)
+	// Determine songs to delete (exist locally but not upstream). Incremental results only
+	// cover changed subtrees, so deletions are left to the next full reconciliation.
 	var songsToDelete []string
-	for existingSongID := range existingSongIDs {
-		if !upstreamSongIDs[existingSongID] {
-			songsToDelete = append(songsToDelete, existingSongID)
+	if result.Complete {
+		for existingSongID := range existingSongIDs {
+			if !upstreamSongIDs[existingSongID] {
+				songsToDelete = append(songsToDelete, existingSongID)
+			}
 		}
 	}
 
@@ -647,13 +850,34 @@ func (ps *ProxyServer) syncSongsForUser(username, password string) error {
 			WithContext("username", username)
 	}
 
+	now := time.Now()
+	nextCursor := result.Cursor
+	nextCursor.UserID = username
+	nextCursor.LastIncrementalSync = now
+	if result.Complete {
+		nextCursor.LastFullSync = now
+	} else if cursor != nil {
+		nextCursor.LastFullSync = cursor.LastFullSync
+	}
+	if err := ps.db.SaveSyncCursor(nextCursor); err != nil {
+		ps.logger.WithError(err).WithField("user", sanitizeUsername(username)).Warn("Failed to save sync cursor")
+		// Don't fail the sync; the next run falls back to a full reconciliation
+	}
+
+	run.Total = len(allSongs)
+	run.Deleted = len(songsToDelete)
+	run.Added = len(newSongs)
+	run.Updated = actuallyUpdatedCount
+	run.Unchanged = len(existingSongsToCheck) - actuallyUpdatedCount
+
 	ps.logger.WithFields(logrus.Fields{
-		"user":       sanitizeUsername(username),
-		"total":      len(allSongs),
-		"deleted":    len(songsToDelete),
-		"added":      len(newSongs),
-		"updated":    actuallyUpdatedCount,
-		"unchanged":  len(existingSongsToCheck) - actuallyUpdatedCount,
+		"user":      sanitizeUsername(username),
+		"full":      result.Complete,
+		"total":     run.Total,
+		"deleted":   run.Deleted,
+		"added":     run.Added,
+		"updated":   run.Updated,
+		"unchanged": run.Unchanged,
 	}).Info("Successfully completed differential sync for user")
 
 	// Calculate artist statistics after sync completes
@@ -667,102 +891,28 @@ func (ps *ProxyServer) syncSongsForUser(username, password string) error {
 
 // getMusicFolders fetches all music folders for a user
 func (ps *ProxyServer) getMusicFolders(username, password string) ([]models.MusicFolder, error) {
-	baseURL, err := url.Parse(ps.config.UpstreamURL + "/rest/getMusicFolders")
+	response, err := ps.fetchUpstream(username, password, "getMusicFolders", nil)
 	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "URL_PARSE_FAILED", "failed to parse upstream URL")
-	}
-
-	params := ps.buildAuthParams(username, password)
-	baseURL.RawQuery = params.Encode()
-
-	resp, err := http.Get(baseURL.String())
-	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to fetch music folders")
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", fmt.Sprintf("unexpected HTTP status: %d", resp.StatusCode))
-	}
-
-	var response models.SubsonicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to decode response")
-	}
-
-	if response.SubsonicResponse.Status != "ok" {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", "API returned error status")
+		return nil, err
 	}
 
 	return response.SubsonicResponse.MusicFolders.MusicFolder, nil
 }
 
-// getIndexes fetches artist indexes for a music folder
-func (ps *ProxyServer) getIndexes(username, password, musicFolderId string) ([]models.Index, error) {
-	baseURL, err := url.Parse(ps.config.UpstreamURL + "/rest/getIndexes")
-	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "URL_PARSE_FAILED", "failed to parse upstream URL")
-	}
-
-	params := ps.buildAuthParams(username, password)
-	if musicFolderId != "" {
-		params.Add("musicFolderId", musicFolderId)
-	}
-	baseURL.RawQuery = params.Encode()
-
-	resp, err := http.Get(baseURL.String())
-	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to fetch indexes")
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", fmt.Sprintf("unexpected HTTP status: %d", resp.StatusCode))
-	}
-
-	var response models.SubsonicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to decode response")
+// upstreamStatusError converts a failed Subsonic response into an error. Error code 40
+// (wrong username or password) maps to ErrUpstreamAuth so callers can drop the credentials.
+func upstreamStatusError(status string, errorCode int) error {
+	if status == "ok" {
+		return nil
 	}
 
-	if response.SubsonicResponse.Status != "ok" {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", "API returned error status")
+	if errorCode == SubsonicErrorWrongCredentials {
+		return errors.New(errors.CategoryCredentials, "UPSTREAM_AUTH_FAILED", "upstream rejected credentials").
+			WithContext("subsonic_code", errorCode)
 	}
 
-	return response.SubsonicResponse.Indexes.Index, nil
-}
-
-// getMusicDirectory fetches directory contents (albums or songs)
-func (ps *ProxyServer) getMusicDirectory(username, password, id string) ([]models.Song, error) {
-	baseURL, err := url.Parse(ps.config.UpstreamURL + "/rest/getMusicDirectory")
-	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "URL_PARSE_FAILED", "failed to parse upstream URL")
-	}
-
-	params := ps.buildAuthParams(username, password)
-	params.Add("id", id)
-	baseURL.RawQuery = params.Encode()
-
-	resp, err := http.Get(baseURL.String())
-	if err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to fetch music directory")
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", fmt.Sprintf("unexpected HTTP status: %d", resp.StatusCode))
-	}
-
-	var response models.SubsonicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, errors.Wrap(err, errors.CategoryNetwork, "UPSTREAM_ERROR", "failed to decode response")
-	}
-
-	if response.SubsonicResponse.Status != "ok" {
-		return nil, errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", "API returned error status")
-	}
-
-	return response.SubsonicResponse.Directory.Child, nil
+	return errors.New(errors.CategoryNetwork, "UPSTREAM_ERROR", "API returned error status").
+		WithContext("subsonic_code", errorCode)
 }
 
 // buildAuthParams builds authentication parameters for API calls
@@ -873,7 +1023,8 @@ func (ps *ProxyServer) extractCredentials(r *http.Request) (username, password s
 }
 
 func (ps *ProxyServer) RecordPlayEvent(userID, songID, eventType string, previousSong *string) {
-	if err := ps.db.RecordPlayEvent(userID, songID, eventType, previousSong); err != nil {
+	decayFactor := ps.shuffle.WeightingProfile(userID).DecayFactor
+	if err := ps.db.RecordPlayEventWithDecay(userID, songID, eventType, previousSong, decayFactor); err != nil {
 		ps.logger.WithError(err).WithField("userID", sanitizeUsername(userID)).Error("Failed to record play event")
 		return
 	}
@@ -906,33 +1057,49 @@ func (ps *ProxyServer) SetLastPlayed(userID, songID string) {
 	ps.shuffle.SetLastPlayed(userID, song)
 }
 
-
 // songHasChanged compares two songs to detect if metadata has actually changed
 func songHasChanged(existing, new models.Song) bool {
 	return existing.Title != new.Title ||
 		existing.Artist != new.Artist ||
 		existing.Album != new.Album ||
 		existing.Duration != new.Duration ||
-		existing.CoverArt != new.CoverArt
+		existing.CoverArt != new.CoverArt ||
+		existing.Genre != new.Genre ||
+		existing.Year != new.Year ||
+		existing.MusicFolderID != new.MusicFolderID ||
+		existing.AlbumID != new.AlbumID ||
+		existing.ArtistID != new.ArtistID ||
+		existing.Track != new.Track ||
+		existing.Suffix != new.Suffix ||
+		existing.ContentType != new.ContentType ||
+		existing.BitRate != new.BitRate ||
+		existing.Size != new.Size ||
+		existing.Path != new.Path ||
+		existing.Parent != new.Parent
 }
 
-
-// ProcessScrobble processes a scrobble event and handles pending songs
+// ProcessScrobble processes a scrobble event from one of the user's devices and handles its pending song
 // Returns true if a play event should be recorded, false if it's a duplicate submission
-func (ps *ProxyServer) ProcessScrobble(userID, songID string, isSubmission bool) bool {
-	recordSkipFunc := func(userID string, song *models.Song) {
-		err := ps.db.RecordPlayEvent(userID, song.ID, "skip", nil)
-		if err != nil {
-			ps.logger.WithError(err).WithFields(logrus.Fields{
-				"user_id": userID,
-				"song_id": song.ID,
-			}).Error("Failed to record skip event from pending song processing")
+func (ps *ProxyServer) ProcessScrobble(userID, deviceID, songID string, isSubmission bool) bool {
+	return ps.shuffle.ProcessScrobble(userID, deviceID, songID, isSubmission, ps.skipRecorder(userID))
+}
+
+// skipRecorder returns the function recording skips detected by the shuffle service. It
+// captures the transition source beforehand, since the skipped song was presented after the
+// user's last played song. The shuffle service calls it without its lock held, so recording
+// may write to the database and use the service.
+func (ps *ProxyServer) skipRecorder(userID string) func(string, *models.Song) {
+	previousSong := ps.shuffle.GetLastPlayedSongID(userID)
+
+	return func(userID string, song *models.Song) {
+		skipPreviousSong := previousSong
+		if skipPreviousSong != nil && *skipPreviousSong == song.ID {
+			skipPreviousSong = nil
 		}
+		ps.RecordPlayEvent(userID, song.ID, "skip", skipPreviousSong)
 	}
-	return ps.shuffle.ProcessScrobble(userID, songID, isSubmission, recordSkipFunc)
 }
 
-
 func (ps *ProxyServer) GetHandlers() *handlers.Handler {
 	return ps.handlers
 }
@@ -8,10 +8,12 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/credentials"
 	"github.com/syeo66/subsoxy/models"
 )
 
@@ -385,6 +387,61 @@ func TestRecordPlayEvent(t *testing.T) {
 	})
 }
 
+func TestProcessScrobbleRecordsTransitions(t *testing.T) {
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       "http://localhost:4533",
+		LogLevel:          "warn",
+		DatabasePath:      "test.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+	}
+	defer os.Remove("test.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	testSongs := []models.Song{
+		{ID: "1", Title: "Song 1", Artist: "Artist", Album: "Album", Duration: 300},
+		{ID: "2", Title: "Song 2", Artist: "Artist", Album: "Album", Duration: 250},
+		{ID: "3", Title: "Song 3", Artist: "Artist", Album: "Album", Duration: 200},
+	}
+	if err := server.db.StoreSongs("testuser", testSongs); err != nil {
+		t.Fatalf("Failed to store test songs: %v", err)
+	}
+
+	handler := server.GetHandlers()
+	scrobble := func(songID, submission string) {
+		req := httptest.NewRequest("GET", "/rest/scrobble?u=testuser&id="+songID+"&submission="+submission, nil)
+		req = req.WithContext(credentials.WithUser(req.Context(), "testuser"))
+		handler.HandleScrobble(httptest.NewRecorder(), req, "/rest/scrobble", server.RecordPlayEvent, server.SetLastPlayed, server.ProcessScrobble)
+	}
+
+	// Song 1 played, song 2 started and abandoned for song 3, song 3 played
+	scrobble("1", "true")
+	scrobble("2", "false")
+	scrobble("3", "false")
+	scrobble("3", "true")
+
+	probability, err := server.db.GetTransitionProbability("testuser", "1", "3")
+	if err != nil {
+		t.Fatalf("Failed to get transition probability: %v", err)
+	}
+	if probability != 1.0 {
+		t.Errorf("Expected play transition 1->3 with probability 1.0, got %f", probability)
+	}
+
+	probability, err = server.db.GetTransitionProbability("testuser", "1", "2")
+	if err != nil {
+		t.Fatalf("Failed to get transition probability: %v", err)
+	}
+	if probability != 0.0 {
+		t.Errorf("Expected skip transition 1->2 with probability 0.0, got %f", probability)
+	}
+}
+
 func TestSetLastPlayed(t *testing.T) {
 	cfg := &config.Config{
 		ProxyPort:    "8080",
@@ -472,6 +529,10 @@ func TestFetchAndStoreSongs(t *testing.T) {
 						Directory struct {
 							Child []models.Song `json:"child"`
 						} `json:"directory,omitempty"`
+						Error struct {
+							Code    int    `json:"code"`
+							Message string `json:"message"`
+						} `json:"error,omitempty"`
 					}{
 						Status:  "ok",
 						Version: "1.15.0",
@@ -1565,3 +1626,190 @@ func TestSetCORSHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestPersistentCredentialStore(t *testing.T) {
+	rejectCredentials := false
+	var mu sync.Mutex
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reject := rejectCredentials
+		mu.Unlock()
+
+		response := map[string]interface{}{"status": "ok", "version": "1.15.0"}
+		if reject && !strings.Contains(r.URL.Path, "/rest/ping") {
+			response["status"] = "failed"
+			response["error"] = map[string]interface{}{"code": 40, "message": "Wrong username or password"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": response})
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       mockServer.URL,
+		LogLevel:          "warn",
+		DatabasePath:      "test_credential_store.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		CredentialKey:     "a-sufficiently-long-master-key",
+	}
+	defer os.Remove("test_credential_store.db")
+
+	first, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if _, err := first.credentials.ValidateAndStore("testuser", "testpass"); err != nil {
+		t.Fatalf("Failed to store credentials: %v", err)
+	}
+	first.Shutdown(context.Background())
+
+	// Restarted server restores the credentials from the database
+	second, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer second.Shutdown(context.Background())
+
+	if pass := second.credentials.GetAllValid()["testuser"]; pass != "testpass" {
+		t.Fatalf("Expected restored credentials, got %q", pass)
+	}
+
+	// Upstream rejecting the credentials purges them from memory and the store
+	mu.Lock()
+	rejectCredentials = true
+	mu.Unlock()
+	second.fetchAndStoreSongs()
+
+	if len(second.credentials.GetAllValid()) != 0 {
+		t.Error("Rejected credentials should be removed from memory")
+	}
+	stored, err := second.db.LoadCredentials()
+	if err != nil {
+		t.Fatalf("Failed to load stored credentials: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("Rejected credentials should be removed from the store, %d left", len(stored))
+	}
+}
+
+func TestAuthenticatedHooks(t *testing.T) {
+	var pingCount int
+	var mu sync.Mutex
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{"status": "ok", "version": "1.15.0"}
+		if strings.Contains(r.URL.Path, "/rest/ping") {
+			mu.Lock()
+			pingCount++
+			mu.Unlock()
+			if r.URL.Query().Get("u") != "alice" || r.URL.Query().Get("p") != "secret" {
+				response["status"] = "failed"
+				response["error"] = map[string]interface{}{"code": 40, "message": "Wrong username or password"}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": response})
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       mockServer.URL,
+		LogLevel:          "warn",
+		DatabasePath:      "test_auth_hooks.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+	}
+	defer os.Remove("test_auth_hooks.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	var servedUser string
+	server.AddAuthenticatedHook("/rest/getRandomSongs", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+		servedUser = credentials.UserFromContext(r.Context())
+		w.Write([]byte("served"))
+		return true
+	})
+
+	t.Run("Valid credentials reach the hook with identity", func(t *testing.T) {
+		servedUser = ""
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=alice&p=secret", nil)
+		w := httptest.NewRecorder()
+
+		server.proxyHandler(w, req)
+
+		if servedUser != "alice" {
+			t.Errorf("Expected hook to run as alice, got %q", servedUser)
+		}
+	})
+
+	t.Run("Username without credentials is rejected", func(t *testing.T) {
+		servedUser = ""
+		req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=alice&f=json", nil)
+		w := httptest.NewRecorder()
+
+		server.proxyHandler(w, req)
+
+		if servedUser != "" {
+			t.Error("Hook should not run for unauthenticated requests")
+		}
+		if !strings.Contains(w.Body.String(), `"code":40`) {
+			t.Errorf("Expected Subsonic error code 40, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("Wrong password is rejected and cached", func(t *testing.T) {
+		mu.Lock()
+		pingCount = 0
+		mu.Unlock()
+
+		for i := 0; i < 3; i++ {
+			servedUser = ""
+			req := httptest.NewRequest("GET", "/rest/getRandomSongs?u=alice&p=wrong", nil)
+			w := httptest.NewRecorder()
+
+			server.proxyHandler(w, req)
+
+			if servedUser != "" {
+				t.Error("Hook should not run for rejected credentials")
+			}
+			if !strings.Contains(w.Body.String(), `code="40"`) {
+				t.Errorf("Expected Subsonic error code 40, got %s", w.Body.String())
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if pingCount != 1 {
+			t.Errorf("Expected rejected credentials to be validated upstream once, got %d", pingCount)
+		}
+	})
+
+	t.Run("Debug pages challenge browsers for Basic Auth", func(t *testing.T) {
+		server.AddAuthenticatedHook("/debug", func(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+			w.Write([]byte(credentials.UserFromContext(r.Context())))
+			return true
+		})
+
+		req := httptest.NewRequest("GET", "/debug?page=2", nil)
+		w := httptest.NewRecorder()
+		server.proxyHandler(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", w.Code)
+		}
+		if !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+			t.Errorf("Expected Basic Auth challenge, got %q", w.Header().Get("WWW-Authenticate"))
+		}
+
+		req = httptest.NewRequest("GET", "/debug?page=2", nil)
+		req.SetBasicAuth("alice", "secret")
+		w = httptest.NewRecorder()
+		server.proxyHandler(w, req)
+
+		if w.Body.String() != "alice" {
+			t.Errorf("Expected Basic Auth credentials to reach the debug page, got %q", w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/handlers"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// StreamEndpoint is the endpoint whose responses partial-play skip detection observes
+const StreamEndpoint = "/rest/stream"
+
+// tracksStream reports whether a request is a stream to observe for partial-play skip detection
+func (ps *ProxyServer) tracksStream(endpoint string, r *http.Request) bool {
+	if !ps.config.StreamTracking || endpoint != StreamEndpoint || r.Method != http.MethodGet {
+		return false
+	}
+	if credentials.UserFromContext(r.Context()) == "" {
+		return false
+	}
+	return handlers.ValidateSongID(r.URL.Query().Get("id")) == nil
+}
+
+// proxyTrackedStream proxies a stream while observing its response, then lets the shuffle
+// service classify it as play or skip and records the play
+func (ps *ProxyServer) proxyTrackedStream(w http.ResponseWriter, r *http.Request) {
+	userID := credentials.UserFromContext(r.Context())
+	songID := r.URL.Query().Get("id")
+
+	session := ps.shuffle.StartStream(userID, ps.scrobbleDeviceID(r), songID, ps.skipRecorder(userID))
+	recorder := &streamRecorder{ResponseWriter: w}
+	start := time.Now()
+
+	// Deferred, as the reverse proxy aborts the handler with a panic when the client goes away
+	defer func() {
+		playback := recorder.playback(r, time.Since(start))
+		if !ps.shuffle.FinishStream(session, playback, ps.skipRecorder(userID)) {
+			return
+		}
+		previousSong := ps.shuffle.GetLastPlayedSongID(userID)
+		if previousSong != nil && *previousSong == songID {
+			previousSong = nil
+		}
+		ps.RecordPlayEvent(userID, songID, "play", previousSong)
+		ps.SetLastPlayed(userID, songID)
+	}()
+
+	ps.proxy.ServeHTTP(recorder, r)
+}
+
+// streamRecorder observes a stream response: its status, size and range, and how many bytes
+// reached the client
+type streamRecorder struct {
+	http.ResponseWriter
+	status        int
+	contentLength int64 // -1 if unknown
+	contentRange  string
+	written       int64
+	writeErr      error
+}
+
+func (sr *streamRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+		sr.contentLength = -1
+		if length, err := strconv.ParseInt(sr.Header().Get("Content-Length"), 10, 64); err == nil {
+			sr.contentLength = length
+		}
+		sr.contentRange = sr.Header().Get("Content-Range")
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *streamRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.WriteHeader(http.StatusOK)
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.written += int64(n)
+	if err != nil && sr.writeErr == nil {
+		sr.writeErr = err
+	}
+	return n, err
+}
+
+// Flush supports streamed responses through the reverse proxy
+func (sr *streamRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (sr *streamRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// playback summarizes the observed response of a stream request that took elapsed. The
+// response is complete if all of it reached the client before the client went away.
+func (sr *streamRecorder) playback(r *http.Request, elapsed time.Duration) models.StreamPlayback {
+	playback := models.StreamPlayback{
+		Status:         sr.status,
+		BytesDelivered: sr.written,
+		Elapsed:        elapsed,
+		Completed: sr.status != 0 && sr.writeErr == nil && r.Context().Err() == nil &&
+			(sr.contentLength < 0 || sr.written >= sr.contentLength),
+	}
+
+	switch sr.status {
+	case http.StatusPartialContent:
+		if start, total, ok := parseContentRange(sr.contentRange); ok {
+			playback.RangeStart, playback.TotalBytes = start, total
+		}
+	case http.StatusOK:
+		playback.TotalBytes = max(sr.contentLength, 0)
+	}
+
+	// Transcoded streams are smaller than the original, unless the client asked for the original
+	query := r.URL.Query()
+	if query.Get("format") != "raw" {
+		if maxBitRate, err := strconv.Atoi(query.Get("maxBitRate")); err == nil && maxBitRate > 0 {
+			playback.MaxBitRate = maxBitRate
+		}
+	}
+	return playback
+}
+
+// parseContentRange parses the first byte and complete length of a "bytes first-last/length"
+// Content-Range header. The length is 0 if it's unknown ("*").
+func parseContentRange(header string) (int64, int64, bool) {
+	var first, last int64
+	var length string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &first, &last, &length); err != nil || first < 0 || last < first {
+		return 0, 0, false
+	}
+	if length == "*" {
+		return first, 0, true
+	}
+	total, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || total <= last {
+		return 0, 0, false
+	}
+	return first, total, true
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/credentials"
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		expectedStart int64
+		expectedTotal int64
+		expectedOK    bool
+	}{
+		{"bytes 0-999/5000", 0, 5000, true},
+		{"bytes 2500-4999/5000", 2500, 5000, true},
+		{"bytes 100-199/*", 100, 0, true},
+		{"bytes */5000", 0, 0, false},
+		{"bytes 200-100/5000", 0, 0, false},
+		{"bytes 0-5000/5000", 0, 0, false},
+		{"items 0-10/20", 0, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.header)
+		if start != tt.expectedStart || total != tt.expectedTotal || ok != tt.expectedOK {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.header, start, total, ok, tt.expectedStart, tt.expectedTotal, tt.expectedOK)
+		}
+	}
+}
+
+// failingWriter fails writes after limit bytes, like a client that went away
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if len(b) > fw.limit {
+		n, _ := fw.ResponseRecorder.Write(b[:fw.limit])
+		fw.limit = 0
+		return n, errors.New("connection reset by peer")
+	}
+	fw.limit -= len(b)
+	return fw.ResponseRecorder.Write(b)
+}
+
+func TestStreamRecorderPlayback(t *testing.T) {
+	t.Run("complete response", func(t *testing.T) {
+		recorder := &streamRecorder{ResponseWriter: httptest.NewRecorder()}
+		recorder.Header().Set("Content-Length", "10")
+		recorder.WriteHeader(http.StatusOK)
+		recorder.Write([]byte("0123456789"))
+
+		req := httptest.NewRequest("GET", "/rest/stream?id=1&maxBitRate=128", nil)
+		playback := recorder.playback(req, time.Minute)
+		expected := models.StreamPlayback{Status: http.StatusOK, BytesDelivered: 10, TotalBytes: 10, MaxBitRate: 128, Elapsed: time.Minute, Completed: true}
+		if playback != expected {
+			t.Errorf("Expected %+v, got %+v", expected, playback)
+		}
+	})
+
+	t.Run("aborted range response", func(t *testing.T) {
+		recorder := &streamRecorder{ResponseWriter: &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 4}}
+		recorder.Header().Set("Content-Length", "10")
+		recorder.Header().Set("Content-Range", "bytes 90-99/100")
+		recorder.WriteHeader(http.StatusPartialContent)
+		recorder.Write([]byte("0123456789"))
+
+		req := httptest.NewRequest("GET", "/rest/stream?id=1&maxBitRate=128&format=raw", nil)
+		playback := recorder.playback(req, time.Minute)
+		expected := models.StreamPlayback{Status: http.StatusPartialContent, BytesDelivered: 4, RangeStart: 90, TotalBytes: 100, Elapsed: time.Minute}
+		if playback != expected {
+			t.Errorf("Expected %+v, got %+v", expected, playback)
+		}
+	})
+
+	t.Run("chunked response", func(t *testing.T) {
+		recorder := &streamRecorder{ResponseWriter: httptest.NewRecorder()}
+		recorder.Write([]byte("0123456789"))
+
+		playback := recorder.playback(httptest.NewRequest("GET", "/rest/stream?id=1", nil), time.Second)
+		if playback.Status != http.StatusOK || playback.TotalBytes != 0 || !playback.Completed {
+			t.Errorf("Expected complete response of unknown size, got %+v", playback)
+		}
+	})
+}
+
+func TestTrackedStreamRecordsPlay(t *testing.T) {
+	// The upstream delivers the stream at listening speed: half of it, then the rest after 600ms
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("01234"))
+		w.(http.Flusher).Flush()
+		time.Sleep(600 * time.Millisecond)
+		w.Write([]byte("56789"))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       upstream.URL,
+		LogLevel:          "warn",
+		DatabasePath:      "test_stream.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		StreamTracking:    true,
+	}
+	defer os.Remove("test_stream.db")
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	songs := []models.Song{
+		{ID: "1", Title: "Song 1", Artist: "Artist", Album: "Album", Duration: 1},
+		{ID: "2", Title: "Song 2", Artist: "Artist", Album: "Album", Duration: 300},
+	}
+	if err := server.db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store test songs: %v", err)
+	}
+
+	stream := func(songID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/rest/stream?u=testuser&c=phone&id="+songID, nil)
+		req = req.WithContext(credentials.WithUser(req.Context(), "testuser"))
+		if !server.tracksStream(StreamEndpoint, req) {
+			t.Fatal("Expected the stream to be tracked")
+		}
+		w := httptest.NewRecorder()
+		server.proxyTrackedStream(w, req)
+		return w
+	}
+
+	// Song 2 downloads far faster than its 5 minutes: the client is buffering
+	if w := stream("2"); w.Body.String() != "0123456789" {
+		t.Errorf("Expected the stream to be proxied unchanged, got %q", w.Body.String())
+	}
+	if lastPlayed := server.shuffle.GetLastPlayedSongID("testuser"); lastPlayed != nil {
+		t.Errorf("Expected a buffered stream not to be a play, got last played %s", *lastPlayed)
+	}
+
+	// Song 1 lasts one second, so its stream took longer than half of it
+	stream("1")
+	lastPlayed := server.shuffle.GetLastPlayedSongID("testuser")
+	if lastPlayed == nil || *lastPlayed != "1" {
+		t.Errorf("Expected song 1 to be played, got %v", lastPlayed)
+	}
+	stored, err := server.db.GetAllSongs("testuser")
+	if err != nil {
+		t.Fatalf("Failed to get songs: %v", err)
+	}
+	for _, song := range stored {
+		expected := 0
+		if song.ID == "1" {
+			expected = 1
+		}
+		if song.PlayCount != expected || song.SkipCount != 0 {
+			t.Errorf("Expected song %s with %d plays and no skips, got %d plays and %d skips", song.ID, expected, song.PlayCount, song.SkipCount)
+		}
+	}
+}
+
+func TestTracksStream(t *testing.T) {
+	server := &ProxyServer{config: &config.Config{StreamTracking: true}}
+	authenticated := func(req *http.Request) *http.Request {
+		return req.WithContext(credentials.WithUser(req.Context(), "testuser"))
+	}
+
+	tests := []struct {
+		name     string
+		server   *ProxyServer
+		endpoint string
+		req      *http.Request
+		expected bool
+	}{
+		{"stream", server, StreamEndpoint, authenticated(httptest.NewRequest("GET", "/rest/stream?id=1", nil)), true},
+		{"disabled", &ProxyServer{config: &config.Config{}}, StreamEndpoint, authenticated(httptest.NewRequest("GET", "/rest/stream?id=1", nil)), false},
+		{"other endpoint", server, "/rest/download", authenticated(httptest.NewRequest("GET", "/rest/download?id=1", nil)), false},
+		{"head request", server, StreamEndpoint, authenticated(httptest.NewRequest("HEAD", "/rest/stream?id=1", nil)), false},
+		{"unauthenticated", server, StreamEndpoint, httptest.NewRequest("GET", "/rest/stream?id=1", nil), false},
+		{"missing song ID", server, StreamEndpoint, authenticated(httptest.NewRequest("GET", "/rest/stream", nil)), false},
+		{"invalid song ID", server, StreamEndpoint, authenticated(httptest.NewRequest("GET", "/rest/stream?id="+strings.Repeat("a", 300), nil)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tracked := tt.server.tracksStream(tt.endpoint, tt.req); tracked != tt.expected {
+				t.Errorf("Expected tracked %v, got %v", tt.expected, tracked)
+			}
+		})
+	}
+}
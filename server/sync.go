@@ -0,0 +1,525 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Library sync constants
+const (
+	Search3PageSize = 500 // Songs requested per search3 page
+)
+
+// SyncResult is the outcome of a sync strategy run
+type SyncResult struct {
+	Songs []models.Song
+	// Complete is true when Songs is the whole library, so songs missing from it can be deleted.
+	// Incremental runs only return songs from changed subtrees.
+	Complete bool
+	// Cursor holds the change markers to use for the next incremental run
+	Cursor models.SyncCursor
+}
+
+// SyncStrategy fetches the song library of a user from the upstream server. With a nil
+// cursor it fetches everything; otherwise it may skip subtrees unchanged since the cursor.
+type SyncStrategy interface {
+	Name() string
+	FetchSongs(username, password string, since *models.SyncCursor) (*SyncResult, error)
+}
+
+// newSyncStrategy returns the sync strategy with the given name, defaulting to ID3
+func newSyncStrategy(name string, ps *ProxyServer) SyncStrategy {
+	switch name {
+	case config.SyncStrategyFolder:
+		return &folderSyncStrategy{ps: ps}
+	case config.SyncStrategySearch3:
+		return &search3SyncStrategy{ps: ps}
+	default:
+		return &id3SyncStrategy{ps: ps}
+	}
+}
+
+// needsFullSync reports whether the next sync for a user must be a full reconciliation
+func (ps *ProxyServer) needsFullSync(cursor *models.SyncCursor) bool {
+	if cursor == nil || cursor.Strategy != ps.syncStrategy.Name() {
+		return true
+	}
+	if ps.config.FullSyncInterval <= 0 {
+		return true
+	}
+	return time.Since(cursor.LastFullSync) >= ps.config.FullSyncInterval
+}
+
+// fetchLibrary fetches songs for a user with the configured strategy, falling back to a
+// full folder walk if the strategy fails or finds nothing (e.g. no ID3 support upstream)
+func (ps *ProxyServer) fetchLibrary(username, password string, since *models.SyncCursor) (*SyncResult, error) {
+	result, err := ps.syncStrategy.FetchSongs(username, password, since)
+
+	// Requests aborted by shutdown may have been skipped as failures; never treat that as a result
+	if ps.syncCtx.Err() != nil {
+		return nil, errors.ErrSyncCanceled
+	}
+
+	failed := err != nil || (result.Complete && len(result.Songs) == 0)
+	if failed && ps.syncStrategy.Name() != config.SyncStrategyFolder &&
+		!errors.Is(err, errors.ErrUpstreamAuth) && !errors.Is(err, errors.ErrSyncCanceled) {
+		logEntry := ps.logger.WithFields(logrus.Fields{
+			"user":     sanitizeUsername(username),
+			"strategy": ps.syncStrategy.Name(),
+		})
+		if err != nil {
+			logEntry = logEntry.WithError(err)
+		}
+		logEntry.Warn("Sync strategy failed or returned no songs, falling back to folder walk")
+
+		result, err = (&folderSyncStrategy{ps: ps}).FetchSongs(username, password, nil)
+	}
+
+	if ps.syncCtx.Err() != nil {
+		return nil, errors.ErrSyncCanceled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Songs = dedupeSongs(result.Songs)
+	return result, nil
+}
+
+// dedupeSongs removes songs reported more than once (e.g. through several folders)
+func dedupeSongs(songs []models.Song) []models.Song {
+	seen := make(map[string]bool, len(songs))
+	unique := songs[:0]
+	for _, song := range songs {
+		if seen[song.ID] {
+			continue
+		}
+		seen[song.ID] = true
+		unique = append(unique, song)
+	}
+	return unique
+}
+
+// newSyncResult starts a result with an empty cursor; strategies fill in the change markers
+// of each music folder they walk
+func newSyncResult(strategy string, since *models.SyncCursor) *SyncResult {
+	result := &SyncResult{Complete: since == nil}
+	result.Cursor.Strategy = strategy
+	result.Cursor.Folders = make(map[string]models.FolderSyncCursor)
+	return result
+}
+
+// previousFolderCursor returns the change markers of a music folder from the previous run,
+// or zero markers (everything changed) on a full run or for a new folder
+func previousFolderCursor(since *models.SyncCursor, folderID string) models.FolderSyncCursor {
+	if since == nil {
+		return models.FolderSyncCursor{}
+	}
+	return since.Folders[folderID]
+}
+
+// collectionUnchanged reports whether an artist collection timestamp shows no change since the folder cursor
+func collectionUnchanged(previous models.FolderSyncCursor, lastModified int64) bool {
+	return lastModified > 0 && previous.LastModified > 0 && lastModified <= previous.LastModified
+}
+
+// albumUnchanged reports whether an album was neither created nor changed after the folder cursor.
+// Albums without usable timestamps are always treated as changed.
+func albumUnchanged(previous models.FolderSyncCursor, timestamp time.Time) bool {
+	return !timestamp.IsZero() && !previous.AlbumCursor.IsZero() && !timestamp.After(previous.AlbumCursor)
+}
+
+// advanceAlbumCursor moves a folder's album cursor to an album timestamp if it's newer
+func advanceAlbumCursor(cursor *models.FolderSyncCursor, albumTimestamp time.Time) {
+	if albumTimestamp.After(cursor.AlbumCursor) {
+		cursor.AlbumCursor = albumTimestamp
+	}
+}
+
+// finishFolder records the change markers of a walked music folder. If any fetch in the folder
+// failed the previous markers are kept, so the next run fetches the skipped albums again, and
+// the result is no longer complete, so the songs of the skipped albums aren't deleted.
+func (ps *ProxyServer) finishFolder(result *SyncResult, username, folderID string, next models.FolderSyncCursor, failed bool) {
+	if failed {
+		result.Complete = false
+		ps.logger.WithFields(logrus.Fields{
+			"user":      sanitizeUsername(username),
+			"folder_id": folderID,
+		}).Warn("Some fetches failed in music folder, keeping its previous change markers")
+		return
+	}
+	result.Cursor.Folders[folderID] = next
+}
+
+// parseUpstreamTime parses the ISO 8601 timestamps used by Subsonic servers
+func parseUpstreamTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// latestTime returns the later of the parsed timestamps
+func latestTime(values ...string) time.Time {
+	var latest time.Time
+	for _, value := range values {
+		if t := parseUpstreamTime(value); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// folderSyncStrategy walks getMusicFolders -> getIndexes -> getMusicDirectory. Incremental
+// runs skip folders whose index lastModified did not move and albums not created since the cursor.
+type folderSyncStrategy struct {
+	ps *ProxyServer
+}
+
+func (s *folderSyncStrategy) Name() string {
+	return config.SyncStrategyFolder
+}
+
+func (s *folderSyncStrategy) FetchSongs(username, password string, since *models.SyncCursor) (*SyncResult, error) {
+	ps := s.ps
+	result := newSyncResult(s.Name(), since)
+
+	// First, get all music folders
+	musicFolders, err := ps.getMusicFolders(username, password)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryNetwork, "MUSIC_FOLDERS_FAILED", "failed to get music folders").
+			WithContext("username", username)
+	}
+
+	// Traverse each music folder
+	for _, folder := range musicFolders {
+		// Convert folder ID to string
+		folderID := fmt.Sprintf("%v", folder.ID)
+
+		ps.logger.WithFields(logrus.Fields{
+			"user":        sanitizeUsername(username),
+			"folder_id":   folderID,
+			"folder_name": folder.Name,
+		}).Debug("Processing music folder")
+
+		// Until the folder is walked without failures, the next run starts from the previous markers
+		previous := previousFolderCursor(since, folderID)
+		result.Cursor.Folders[folderID] = previous
+
+		// Get indexes for this folder to get artists
+		params := url.Values{}
+		params.Add("musicFolderId", folderID)
+		if previous.LastModified > 0 {
+			params.Add("ifModifiedSince", strconv.FormatInt(previous.LastModified, 10))
+		}
+		indexesResponse, err := ps.fetchUpstream(username, password, "getIndexes", params)
+		if err != nil {
+			ps.logger.WithError(err).WithFields(logrus.Fields{
+				"user":      sanitizeUsername(username),
+				"folder_id": folderID,
+			}).Warn("Failed to get indexes for folder, skipping")
+			result.Complete = false // The folder's songs are missing, not removed
+			continue
+		}
+
+		indexes := indexesResponse.SubsonicResponse.Indexes
+		if collectionUnchanged(previous, indexes.LastModified) {
+			ps.logger.WithFields(logrus.Fields{
+				"user":      sanitizeUsername(username),
+				"folder_id": folderID,
+			}).Debug("Music folder unchanged since last sync, skipping")
+			continue
+		}
+
+		var artistIDs []string
+		for _, index := range indexes.Index {
+			for _, artist := range index.Artists {
+				artistIDs = append(artistIDs, artist.ID)
+			}
+		}
+
+		next := models.FolderSyncCursor{LastModified: indexes.LastModified, AlbumCursor: previous.AlbumCursor}
+		var failed atomic.Bool
+
+		// Get albums for each artist
+		artistAlbums := make([][]models.Song, len(artistIDs))
+		err = ps.crawl(len(artistIDs), func(i int) {
+			albums, err := ps.getMusicDirectory(username, password, artistIDs[i])
+			if err != nil {
+				failed.Store(true)
+				ps.logger.WithError(err).WithFields(logrus.Fields{
+					"user":      sanitizeUsername(username),
+					"artist_id": artistIDs[i],
+				}).Warn("Failed to get albums for artist, skipping")
+				return
+			}
+			artistAlbums[i] = albums
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var albumIDs []string
+		for _, albums := range artistAlbums {
+			for _, album := range albums {
+				if !album.IsDir {
+					continue
+				}
+
+				created := parseUpstreamTime(album.Created)
+				advanceAlbumCursor(&next, created)
+				if albumUnchanged(previous, created) {
+					continue
+				}
+				albumIDs = append(albumIDs, album.ID)
+			}
+		}
+
+		// Get songs for each changed album
+		albumSongs := make([][]models.Song, len(albumIDs))
+		err = ps.crawl(len(albumIDs), func(i int) {
+			songs, err := ps.getMusicDirectory(username, password, albumIDs[i])
+			if err != nil {
+				failed.Store(true)
+				ps.logger.WithError(err).WithFields(logrus.Fields{
+					"user":     sanitizeUsername(username),
+					"album_id": albumIDs[i],
+				}).Warn("Failed to get songs for album, skipping")
+				return
+			}
+			albumSongs[i] = songs
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Add songs (filter out directories)
+		for _, songs := range albumSongs {
+			for _, song := range songs {
+				if !song.IsDir {
+					song.MusicFolderID = folderID
+					result.Songs = append(result.Songs, song)
+				}
+			}
+		}
+		ps.finishFolder(result, username, folderID, next, failed.Load())
+	}
+
+	return result, nil
+}
+
+// id3SyncStrategy walks getArtists -> getArtist -> getAlbum, which works independently
+// of the folder layout and keeps the tags reported by upstream. Incremental runs skip
+// folders whose artist lastModified did not move and albums not created/changed since the cursor.
+type id3SyncStrategy struct {
+	ps *ProxyServer
+}
+
+func (s *id3SyncStrategy) Name() string {
+	return config.SyncStrategyID3
+}
+
+func (s *id3SyncStrategy) FetchSongs(username, password string, since *models.SyncCursor) (*SyncResult, error) {
+	ps := s.ps
+	result := newSyncResult(s.Name(), since)
+
+	musicFolders, err := ps.getMusicFolders(username, password)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryNetwork, "MUSIC_FOLDERS_FAILED", "failed to get music folders").
+			WithContext("username", username)
+	}
+
+	for _, folder := range musicFolders {
+		folderID := fmt.Sprintf("%v", folder.ID)
+		previous := previousFolderCursor(since, folderID)
+		result.Cursor.Folders[folderID] = previous
+
+		params := url.Values{}
+		params.Add("musicFolderId", folderID)
+		artistsResponse, err := ps.fetchUpstream(username, password, "getArtists", params)
+		if err != nil {
+			// A failing getArtists usually means the server has no ID3 support
+			return nil, errors.Wrap(err, errors.CategoryNetwork, "ARTISTS_FAILED", "failed to get ID3 artists").
+				WithContext("username", username).
+				WithContext("folder_id", folderID)
+		}
+
+		artists := artistsResponse.SubsonicResponse.Artists
+		if collectionUnchanged(previous, artists.LastModified) {
+			ps.logger.WithFields(logrus.Fields{
+				"user":      sanitizeUsername(username),
+				"folder_id": folderID,
+			}).Debug("Music folder unchanged since last sync, skipping")
+			continue
+		}
+
+		var artistIDs []string
+		for _, index := range artists.Index {
+			for _, artist := range index.Artists {
+				artistIDs = append(artistIDs, artist.ID)
+			}
+		}
+
+		next := models.FolderSyncCursor{LastModified: artists.LastModified, AlbumCursor: previous.AlbumCursor}
+		var failed atomic.Bool
+
+		artistAlbums := make([][]models.Album, len(artistIDs))
+		err = ps.crawl(len(artistIDs), func(i int) {
+			params := url.Values{}
+			params.Add("id", artistIDs[i])
+			artistResponse, err := ps.fetchUpstream(username, password, "getArtist", params)
+			if err != nil {
+				failed.Store(true)
+				ps.logger.WithError(err).WithFields(logrus.Fields{
+					"user":      sanitizeUsername(username),
+					"artist_id": artistIDs[i],
+				}).Warn("Failed to get ID3 artist, skipping")
+				return
+			}
+			artistAlbums[i] = artistResponse.SubsonicResponse.Artist.Album
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var albumIDs []string
+		for _, albums := range artistAlbums {
+			for _, album := range albums {
+				albumTimestamp := latestTime(album.Created, album.Changed)
+				advanceAlbumCursor(&next, albumTimestamp)
+				if albumUnchanged(previous, albumTimestamp) {
+					continue
+				}
+				albumIDs = append(albumIDs, album.ID)
+			}
+		}
+
+		albumSongs := make([][]models.Song, len(albumIDs))
+		err = ps.crawl(len(albumIDs), func(i int) {
+			params := url.Values{}
+			params.Add("id", albumIDs[i])
+			albumResponse, err := ps.fetchUpstream(username, password, "getAlbum", params)
+			if err != nil {
+				failed.Store(true)
+				ps.logger.WithError(err).WithFields(logrus.Fields{
+					"user":     sanitizeUsername(username),
+					"album_id": albumIDs[i],
+				}).Warn("Failed to get ID3 album, skipping")
+				return
+			}
+			albumSongs[i] = albumResponse.SubsonicResponse.Album.Song
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, songs := range albumSongs {
+			for _, song := range songs {
+				if !song.IsDir {
+					song.MusicFolderID = folderID
+					result.Songs = append(result.Songs, song)
+				}
+			}
+		}
+		ps.finishFolder(result, username, folderID, next, failed.Load())
+	}
+
+	return result, nil
+}
+
+// search3SyncStrategy pages through search3 with an empty query, which returns the
+// whole library in a few large requests on servers that support it. search3 offers no
+// change markers, so every run is a complete listing.
+type search3SyncStrategy struct {
+	ps *ProxyServer
+}
+
+func (s *search3SyncStrategy) Name() string {
+	return config.SyncStrategySearch3
+}
+
+func (s *search3SyncStrategy) FetchSongs(username, password string, since *models.SyncCursor) (*SyncResult, error) {
+	ps := s.ps
+	result := newSyncResult(s.Name(), nil)
+
+	musicFolders, err := ps.getMusicFolders(username, password)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.CategoryNetwork, "MUSIC_FOLDERS_FAILED", "failed to get music folders").
+			WithContext("username", username)
+	}
+
+	for _, folder := range musicFolders {
+		folderID := fmt.Sprintf("%v", folder.ID)
+		seen := make(map[string]bool)
+
+		for offset := 0; ; offset += Search3PageSize {
+			params := url.Values{}
+			params.Add("query", "")
+			params.Add("artistCount", "0")
+			params.Add("albumCount", "0")
+			params.Add("songCount", strconv.Itoa(Search3PageSize))
+			params.Add("songOffset", strconv.Itoa(offset))
+			params.Add("musicFolderId", folderID)
+
+			response, err := ps.fetchUpstream(username, password, "search3", params)
+			if err != nil {
+				return nil, errors.Wrap(err, errors.CategoryNetwork, "SEARCH3_FAILED", "failed to page through search3").
+					WithContext("username", username).
+					WithContext("folder_id", folderID).
+					WithContext("offset", offset)
+			}
+
+			songs := response.SubsonicResponse.SearchResult3.Song
+			newInPage := 0
+			for _, song := range songs {
+				if song.IsDir || seen[song.ID] {
+					continue
+				}
+				seen[song.ID] = true
+				song.MusicFolderID = folderID
+				result.Songs = append(result.Songs, song)
+				newInPage++
+			}
+
+			// Stop on the last page, or if the server ignores songOffset and repeats itself
+			if len(songs) < Search3PageSize || newInPage == 0 {
+				break
+			}
+		}
+
+		ps.logger.WithFields(logrus.Fields{
+			"user":      sanitizeUsername(username),
+			"folder_id": folderID,
+			"songs":     len(seen),
+		}).Debug("Paged through search3 for music folder")
+	}
+
+	return result, nil
+}
+
+// getMusicDirectory fetches directory contents (albums or songs)
+func (ps *ProxyServer) getMusicDirectory(username, password, id string) ([]models.Song, error) {
+	params := url.Values{}
+	params.Add("id", id)
+
+	response, err := ps.fetchUpstream(username, password, "getMusicDirectory", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.SubsonicResponse.Directory.Child, nil
+}
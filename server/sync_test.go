@@ -0,0 +1,536 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newMockLibraryServer serves a small library through the folder, ID3 and search3 endpoints.
+// With id3Supported=false the ID3 and search3 endpoints answer with a Subsonic error.
+func newMockLibraryServer(t *testing.T, id3Supported bool, searchSongs int) *httptest.Server {
+	t.Helper()
+
+	write := func(w http.ResponseWriter, body map[string]interface{}) {
+		body["status"] = "ok"
+		body["version"] = "1.15.0"
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": body})
+	}
+	unsupported := func(w http.ResponseWriter) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": map[string]interface{}{
+			"status": "failed",
+			"error":  map[string]interface{}{"code": 0, "message": "not supported"},
+		}})
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		endpoint := strings.TrimPrefix(r.URL.Path, "/rest/")
+
+		switch endpoint {
+		case "getMusicFolders":
+			write(w, map[string]interface{}{"musicFolders": map[string]interface{}{
+				"musicFolder": []map[string]interface{}{{"id": 1, "name": "Music"}},
+			}})
+		case "getIndexes":
+			write(w, map[string]interface{}{"indexes": map[string]interface{}{
+				"index": []map[string]interface{}{{"name": "A", "artist": []map[string]interface{}{{"id": "dir-artist", "name": "Artist"}}}},
+			}})
+		case "getMusicDirectory":
+			if query.Get("id") == "dir-artist" {
+				write(w, map[string]interface{}{"directory": map[string]interface{}{
+					"child": []map[string]interface{}{{"id": "dir-album", "title": "Album", "isDir": true}},
+				}})
+				return
+			}
+			write(w, map[string]interface{}{"directory": map[string]interface{}{
+				"child": []map[string]interface{}{{"id": "folder-song", "title": "Folder Song", "artist": "Artist", "album": "Album", "duration": 100}},
+			}})
+		case "getArtists", "getArtist", "getAlbum", "search3":
+			if !id3Supported {
+				unsupported(w)
+				return
+			}
+			switch endpoint {
+			case "getArtists":
+				write(w, map[string]interface{}{"artists": map[string]interface{}{
+					"index": []map[string]interface{}{{"name": "A", "artist": []map[string]interface{}{{"id": "ar-1", "name": "Artist"}}}},
+				}})
+			case "getArtist":
+				write(w, map[string]interface{}{"artist": map[string]interface{}{
+					"id": "ar-1", "name": "Artist",
+					"album": []map[string]interface{}{{"id": "al-1", "name": "Album"}, {"id": "al-2", "name": "Other"}},
+				}})
+			case "getAlbum":
+				id := query.Get("id")
+				write(w, map[string]interface{}{"album": map[string]interface{}{
+					"id": id, "name": "Album",
+					"song": []map[string]interface{}{
+						{"id": id + "-s1", "title": "Song 1", "artist": "Artist", "album": "Album", "duration": 200, "genre": "Rock", "year": 1994,
+							"albumId": id, "artistId": "ar-1", "track": 1, "suffix": "flac", "contentType": "audio/flac", "bitRate": 1024,
+							"size": 25600000, "path": "Artist/Album/01 - Song 1.flac", "playCount": 12},
+						{"id": id + "-s2", "title": "Song 2", "artist": "Artist", "album": "Album", "duration": 210, "genre": "Rock", "year": 1994},
+					},
+				}})
+			case "search3":
+				offset, _ := strconv.Atoi(query.Get("songOffset"))
+				count, _ := strconv.Atoi(query.Get("songCount"))
+				var songs []map[string]interface{}
+				for i := offset; i < offset+count && i < searchSongs; i++ {
+					songs = append(songs, map[string]interface{}{"id": fmt.Sprintf("search-%d", i), "title": "Song", "artist": "Artist", "album": "Album", "duration": 180})
+				}
+				write(w, map[string]interface{}{"searchResult3": map[string]interface{}{"song": songs}})
+			}
+		default:
+			write(w, map[string]interface{}{})
+		}
+	}))
+}
+
+func newSyncTestServer(t *testing.T, upstreamURL, strategy string) *ProxyServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		ProxyPort:         "8080",
+		UpstreamURL:       upstreamURL,
+		LogLevel:          "error",
+		DatabasePath:      "test_sync_strategy.db",
+		CredentialWorkers: config.DefaultCredentialWorkers,
+		SyncStrategy:      strategy,
+	}
+	t.Cleanup(func() { os.Remove("test_sync_strategy.db") })
+
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return server
+}
+
+func TestSyncStrategySelection(t *testing.T) {
+	tests := []struct {
+		configured string
+		expected   string
+	}{
+		{"", config.SyncStrategyID3},
+		{config.SyncStrategyID3, config.SyncStrategyID3},
+		{config.SyncStrategySearch3, config.SyncStrategySearch3},
+		{config.SyncStrategyFolder, config.SyncStrategyFolder},
+	}
+
+	for _, tt := range tests {
+		if name := newSyncStrategy(tt.configured, nil).Name(); name != tt.expected {
+			t.Errorf("newSyncStrategy(%q) = %s, want %s", tt.configured, name, tt.expected)
+		}
+	}
+}
+
+func TestID3SyncStrategy(t *testing.T) {
+	mockServer := newMockLibraryServer(t, true, 0)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("syncSongsForUser failed: %v", err)
+	}
+
+	songs, err := server.db.GetAllSongs("testuser")
+	if err != nil {
+		t.Fatalf("Failed to get songs: %v", err)
+	}
+	if len(songs) != 4 {
+		t.Fatalf("Expected 4 songs from ID3 sync, got %d", len(songs))
+	}
+	for _, song := range songs {
+		if !strings.HasPrefix(song.ID, "al-") {
+			t.Errorf("Unexpected song %s, folder walk should not have been used", song.ID)
+		}
+		if song.Genre != "Rock" || song.Year != 1994 || song.MusicFolderID != "1" {
+			t.Errorf("Expected ID3 tags and folder to be kept, got genre=%q year=%d folder=%q", song.Genre, song.Year, song.MusicFolderID)
+		}
+		if strings.HasSuffix(song.ID, "-s1") {
+			if song.AlbumID != strings.TrimSuffix(song.ID, "-s1") || song.ArtistID != "ar-1" || song.Track != 1 ||
+				song.Suffix != "flac" || song.ContentType != "audio/flac" || song.BitRate != 1024 ||
+				song.Size != 25600000 || song.Path != "Artist/Album/01 - Song 1.flac" {
+				t.Errorf("Expected full child attributes to be stored, got %+v", song)
+			}
+			if song.PlayCount != 0 {
+				t.Errorf("Expected upstream play count to be ignored, got %d", song.PlayCount)
+			}
+		}
+	}
+}
+
+func TestSearch3SyncStrategyPaging(t *testing.T) {
+	total := Search3PageSize*2 + 17
+	mockServer := newMockLibraryServer(t, true, total)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategySearch3)
+
+	result, err := server.fetchLibrary("testuser", "testpass", nil)
+	if err != nil {
+		t.Fatalf("fetchLibrary failed: %v", err)
+	}
+	if len(result.Songs) != total {
+		t.Errorf("Expected %d songs across all search3 pages, got %d", total, len(result.Songs))
+	}
+}
+
+func TestSyncStrategyFallsBackToFolderWalk(t *testing.T) {
+	mockServer := newMockLibraryServer(t, false, 0)
+	defer mockServer.Close()
+
+	for _, strategy := range []string{config.SyncStrategyID3, config.SyncStrategySearch3} {
+		t.Run(strategy, func(t *testing.T) {
+			server := newSyncTestServer(t, mockServer.URL, strategy)
+
+			result, err := server.fetchLibrary("testuser", "testpass", nil)
+			if err != nil {
+				t.Fatalf("fetchLibrary failed: %v", err)
+			}
+			songs := result.Songs
+			if len(songs) != 1 || songs[0].ID != "folder-song" {
+				t.Errorf("Expected fallback to folder walk, got %+v", songs)
+			}
+			if songs[0].MusicFolderID != "1" {
+				t.Errorf("Expected music folder 1, got %q", songs[0].MusicFolderID)
+			}
+		})
+	}
+}
+
+// mockChangingLibrary is an ID3 library whose music folders and albums can be replaced
+// between syncs. Each folder has a single artist, "ar-<folder ID>".
+type mockChangingLibrary struct {
+	mu           sync.Mutex
+	folders      map[string]*mockChangingFolder
+	failing      map[string]bool // Albums whose getAlbum fails
+	albumFetches map[string]int
+}
+
+type mockChangingFolder struct {
+	lastModified int64
+	albums       map[string]string // album ID -> created timestamp
+}
+
+func newMockChangingLibrary() *mockChangingLibrary {
+	return &mockChangingLibrary{
+		folders:      make(map[string]*mockChangingFolder),
+		failing:      make(map[string]bool),
+		albumFetches: make(map[string]int),
+	}
+}
+
+// setAlbums replaces the albums of music folder 1
+func (l *mockChangingLibrary) setAlbums(lastModified int64, albums map[string]string) {
+	l.setFolder("1", lastModified, albums)
+}
+
+func (l *mockChangingLibrary) setFolder(folderID string, lastModified int64, albums map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.folders[folderID] = &mockChangingFolder{lastModified: lastModified, albums: albums}
+}
+
+func (l *mockChangingLibrary) setFailing(albumID string, failing bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failing[albumID] = failing
+}
+
+func (l *mockChangingLibrary) fetches(albumID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.albumFetches[albumID]
+}
+
+func newMockChangingLibraryServer(t *testing.T, library *mockChangingLibrary) *httptest.Server {
+	t.Helper()
+
+	write := func(w http.ResponseWriter, body map[string]interface{}) {
+		body["status"] = "ok"
+		body["version"] = "1.15.0"
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": body})
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		library.mu.Lock()
+		defer library.mu.Unlock()
+
+		id := r.URL.Query().Get("id")
+		switch strings.TrimPrefix(r.URL.Path, "/rest/") {
+		case "getMusicFolders":
+			var folders []map[string]interface{}
+			for folderID := range library.folders {
+				folders = append(folders, map[string]interface{}{"id": folderID, "name": "Music " + folderID})
+			}
+			write(w, map[string]interface{}{"musicFolders": map[string]interface{}{"musicFolder": folders}})
+		case "getArtists":
+			folderID := r.URL.Query().Get("musicFolderId")
+			write(w, map[string]interface{}{"artists": map[string]interface{}{
+				"lastModified": library.folders[folderID].lastModified,
+				"index":        []map[string]interface{}{{"name": "A", "artist": []map[string]interface{}{{"id": "ar-" + folderID, "name": "Artist"}}}},
+			}})
+		case "getArtist":
+			var albums []map[string]interface{}
+			for albumID, created := range library.folders[strings.TrimPrefix(id, "ar-")].albums {
+				albums = append(albums, map[string]interface{}{"id": albumID, "name": albumID, "created": created})
+			}
+			write(w, map[string]interface{}{"artist": map[string]interface{}{"id": id, "name": "Artist", "album": albums}})
+		case "getAlbum":
+			library.albumFetches[id]++
+			if library.failing[id] {
+				json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": map[string]interface{}{
+					"status": "failed",
+					"error":  map[string]interface{}{"code": 70, "message": "album unavailable"},
+				}})
+				return
+			}
+			write(w, map[string]interface{}{"album": map[string]interface{}{
+				"id": id, "name": id,
+				"song": []map[string]interface{}{{"id": id + "-s1", "title": "Song", "artist": "Artist", "album": id, "duration": 200}},
+			}})
+		default:
+			write(w, map[string]interface{}{})
+		}
+	}))
+}
+
+// storedSongIDs returns the IDs of the songs stored for the test user
+func storedSongIDs(t *testing.T, server *ProxyServer) map[string]bool {
+	t.Helper()
+
+	songs, err := server.db.GetAllSongs("testuser")
+	if err != nil {
+		t.Fatalf("Failed to get songs: %v", err)
+	}
+	ids := make(map[string]bool)
+	for _, song := range songs {
+		ids[song.ID] = true
+	}
+	return ids
+}
+
+func TestIncrementalSync(t *testing.T) {
+	library := newMockChangingLibrary()
+	library.setAlbums(1000, map[string]string{
+		"al-1": "2024-01-01T10:00:00Z",
+		"al-2": "2024-01-02T10:00:00Z",
+	})
+	mockServer := newMockChangingLibraryServer(t, library)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+	server.config.FullSyncInterval = time.Hour
+
+	// First run has no cursor and is a full sync
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	if ids := storedSongIDs(t, server); len(ids) != 2 {
+		t.Fatalf("Expected 2 songs after initial sync, got %v", ids)
+	}
+	cursor, err := server.db.GetSyncCursor("testuser")
+	if err != nil || cursor == nil {
+		t.Fatalf("Expected sync cursor to be saved, got %v (err %v)", cursor, err)
+	}
+	if cursor.Folders["1"].LastModified != 1000 || cursor.LastFullSync.IsZero() {
+		t.Errorf("Unexpected cursor after full sync: %+v", cursor)
+	}
+
+	// al-2 disappears and al-3 is added upstream
+	library.setAlbums(2000, map[string]string{
+		"al-1": "2024-01-01T10:00:00Z",
+		"al-3": "2024-02-01T10:00:00Z",
+	})
+
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Incremental sync failed: %v", err)
+	}
+	ids := storedSongIDs(t, server)
+	if !ids["al-3-s1"] {
+		t.Error("Expected incremental sync to add songs of the new album")
+	}
+	if !ids["al-2-s1"] {
+		t.Error("Incremental sync must not delete songs")
+	}
+	if library.fetches("al-1") != 1 {
+		t.Errorf("Expected unchanged album to be skipped, fetched %d times", library.fetches("al-1"))
+	}
+
+	// Nothing changed upstream: the whole folder is skipped
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Unchanged sync failed: %v", err)
+	}
+	if library.fetches("al-3") != 1 {
+		t.Errorf("Expected unchanged library to be skipped, al-3 fetched %d times", library.fetches("al-3"))
+	}
+
+	// Once the full sync interval has passed, removed songs are reconciled
+	cursor, _ = server.db.GetSyncCursor("testuser")
+	cursor.LastFullSync = time.Now().Add(-2 * time.Hour)
+	if err := server.db.SaveSyncCursor(*cursor); err != nil {
+		t.Fatalf("Failed to save cursor: %v", err)
+	}
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Full sync failed: %v", err)
+	}
+	ids = storedSongIDs(t, server)
+	if ids["al-2-s1"] {
+		t.Error("Expected full sync to delete songs of the removed album")
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected 2 songs after full sync, got %v", ids)
+	}
+}
+
+func TestIncrementalSyncTracksFoldersSeparately(t *testing.T) {
+	library := newMockChangingLibrary()
+	library.setFolder("1", 100, map[string]string{"al-1a": "2024-01-01T10:00:00Z"})
+	library.setFolder("2", 200, map[string]string{"al-2a": "2024-03-01T10:00:00Z"})
+	mockServer := newMockChangingLibraryServer(t, library)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+	server.config.FullSyncInterval = time.Hour
+
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+
+	// Folder 1 changes with markers below those of folder 2
+	library.setFolder("1", 150, map[string]string{
+		"al-1a": "2024-01-01T10:00:00Z",
+		"al-1b": "2024-02-01T10:00:00Z",
+	})
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Incremental sync failed: %v", err)
+	}
+
+	if ids := storedSongIDs(t, server); !ids["al-1b-s1"] {
+		t.Errorf("Expected the change in folder 1 to be synced, got %v", ids)
+	}
+	if library.fetches("al-2a") != 1 {
+		t.Errorf("Expected unchanged folder 2 to be skipped, al-2a fetched %d times", library.fetches("al-2a"))
+	}
+	cursor, err := server.db.GetSyncCursor("testuser")
+	if err != nil || cursor == nil {
+		t.Fatalf("Expected sync cursor, got %v (err %v)", cursor, err)
+	}
+	if cursor.Folders["1"].LastModified != 150 || cursor.Folders["2"].LastModified != 200 {
+		t.Errorf("Expected change markers per folder, got %+v", cursor.Folders)
+	}
+}
+
+func TestIncrementalSyncRetriesFailedFetches(t *testing.T) {
+	library := newMockChangingLibrary()
+	library.setAlbums(1000, map[string]string{"al-1": "2024-01-01T10:00:00Z"})
+	mockServer := newMockChangingLibraryServer(t, library)
+	defer mockServer.Close()
+
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+	server.config.FullSyncInterval = time.Hour
+
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+
+	// Two albums are added, and fetching the older one fails
+	library.setAlbums(2000, map[string]string{
+		"al-1": "2024-01-01T10:00:00Z",
+		"al-2": "2024-02-01T10:00:00Z",
+		"al-3": "2024-03-01T10:00:00Z",
+	})
+	library.setFailing("al-2", true)
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Incremental sync failed: %v", err)
+	}
+	if ids := storedSongIDs(t, server); !ids["al-3-s1"] || ids["al-2-s1"] {
+		t.Fatalf("Expected only the album fetched successfully to be synced, got %v", ids)
+	}
+	cursor, _ := server.db.GetSyncCursor("testuser")
+	if cursor.Folders["1"].LastModified != 1000 {
+		t.Errorf("Expected the folder to keep its previous markers after a failed fetch, got %+v", cursor.Folders["1"])
+	}
+
+	// The next run fetches the album again
+	library.setFailing("al-2", false)
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Incremental sync failed: %v", err)
+	}
+	if ids := storedSongIDs(t, server); !ids["al-2-s1"] {
+		t.Errorf("Expected the failed album to be synced by the next run, got %v", ids)
+	}
+	cursor, _ = server.db.GetSyncCursor("testuser")
+	if cursor.Folders["1"].LastModified != 2000 {
+		t.Errorf("Expected the folder markers to advance once all fetches succeed, got %+v", cursor.Folders["1"])
+	}
+}
+
+func TestFullSyncKeepsSongsOfFailedFetches(t *testing.T) {
+	library := newMockChangingLibrary()
+	library.setAlbums(1000, map[string]string{
+		"al-1": "2024-01-01T10:00:00Z",
+		"al-2": "2024-02-01T10:00:00Z",
+	})
+	mockServer := newMockChangingLibraryServer(t, library)
+	defer mockServer.Close()
+
+	// Without a full sync interval every run is a full reconciliation
+	server := newSyncTestServer(t, mockServer.URL, config.SyncStrategyID3)
+
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+
+	library.setFailing("al-2", true)
+	if err := server.syncSongsForUser("testuser", "testpass"); err != nil {
+		t.Fatalf("Full sync failed: %v", err)
+	}
+	if ids := storedSongIDs(t, server); !ids["al-1-s1"] || !ids["al-2-s1"] {
+		t.Errorf("Expected the songs of the album that failed to be kept, got %v", ids)
+	}
+}
+
+func TestNeedsFullSync(t *testing.T) {
+	server := newSyncTestServer(t, "http://localhost:4533", config.SyncStrategyID3)
+	server.config.FullSyncInterval = time.Hour
+
+	recent := &models.SyncCursor{Strategy: config.SyncStrategyID3, LastFullSync: time.Now()}
+	tests := []struct {
+		name     string
+		cursor   *models.SyncCursor
+		interval time.Duration
+		expected bool
+	}{
+		{"no cursor", nil, time.Hour, true},
+		{"recent full sync", recent, time.Hour, false},
+		{"interval elapsed", &models.SyncCursor{Strategy: config.SyncStrategyID3, LastFullSync: time.Now().Add(-2 * time.Hour)}, time.Hour, true},
+		{"strategy changed", &models.SyncCursor{Strategy: config.SyncStrategyFolder, LastFullSync: time.Now()}, time.Hour, true},
+		{"incremental disabled", recent, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.config.FullSyncInterval = tt.interval
+			if got := server.needsFullSync(tt.cursor); got != tt.expected {
+				t.Errorf("needsFullSync() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
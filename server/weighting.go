@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/config"
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+	"github.com/syeo66/subsoxy/shuffle"
+)
+
+// weightingResponse is returned by the /admin/api/weighting endpoints
+type weightingResponse struct {
+	Default models.WeightingProfile            `json:"default"`
+	Presets map[string]models.WeightingProfile `json:"presets"`
+}
+
+// defaultWeightingProfile returns the weighting profile for users without their own: the
+// profile stored through the admin API, or the configured preset
+func defaultWeightingProfile(db *database.DB, preset string, logger *logrus.Logger) (models.WeightingProfile, error) {
+	stored, err := db.GetDefaultWeightingProfile()
+	if err != nil {
+		logger.WithError(err).Warn("Failed to load the stored default weighting profile, using the configured preset")
+	} else if stored != nil {
+		if err := shuffle.ValidateWeightingProfile(*stored); err == nil {
+			return *stored, nil
+		}
+		logger.WithError(err).Warn("Ignoring invalid stored default weighting profile, using the configured preset")
+	}
+
+	return presetWeightingProfile(preset)
+}
+
+// presetWeightingProfile returns the profile of the configured preset, where empty means the default preset
+func presetWeightingProfile(preset string) (models.WeightingProfile, error) {
+	if preset == "" {
+		preset = config.DefaultWeightingPreset
+	}
+	profile, ok := shuffle.WeightingPreset(preset)
+	if !ok {
+		return models.WeightingProfile{}, errors.New(errors.CategoryConfig, "INVALID_WEIGHTING_PRESET", "invalid weighting preset").
+			WithContext("preset", preset).
+			WithContext("valid_presets", shuffle.WeightingPresetNames())
+	}
+	return profile, nil
+}
+
+// parseWeightingProfile builds a weighting profile from a JSON object. Parameters missing from
+// the object are taken from the preset named in it or, without a preset, from the current default.
+func (ps *ProxyServer) parseWeightingProfile(raw json.RawMessage) (models.WeightingProfile, error) {
+	var selection struct {
+		Preset string `json:"preset"`
+	}
+	if err := json.Unmarshal(raw, &selection); err != nil {
+		return models.WeightingProfile{}, errors.New(errors.CategoryValidation, "INVALID_WEIGHTING_PROFILE", "weighting must be a JSON object")
+	}
+
+	profile := ps.shuffle.DefaultWeightingProfile()
+	if selection.Preset != "" {
+		preset, ok := shuffle.WeightingPreset(selection.Preset)
+		if !ok {
+			return models.WeightingProfile{}, errors.New(errors.CategoryValidation, "INVALID_WEIGHTING_PROFILE", "unknown weighting preset").
+				WithContext("preset", selection.Preset)
+		}
+		profile = preset
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		return models.WeightingProfile{}, errors.New(errors.CategoryValidation, "INVALID_WEIGHTING_PROFILE", "invalid weighting parameters")
+	}
+
+	return profile, shuffle.ValidateWeightingProfile(profile)
+}
+
+// writeWeightingError responds with the reason a weighting profile was rejected
+func writeWeightingError(w http.ResponseWriter, err error) {
+	message := "invalid weighting profile"
+	var validationErr *errors.SubsoxyError
+	if errors.As(err, &validationErr) {
+		message += ": " + validationErr.Message
+	}
+	writeAdminError(w, http.StatusBadRequest, message)
+}
+
+func (ps *ProxyServer) handleGetWeighting(w http.ResponseWriter, r *http.Request) {
+	ps.writeWeighting(w)
+}
+
+// handleSaveWeighting replaces the default weighting profile and stores it across restarts
+func (ps *ProxyServer) handleSaveWeighting(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxUserSettingsBodySize)).Decode(&raw); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid weighting body")
+		return
+	}
+	profile, err := ps.parseWeightingProfile(raw)
+	if err != nil {
+		writeWeightingError(w, err)
+		return
+	}
+
+	if err := ps.db.SaveDefaultWeightingProfile(profile); err != nil {
+		ps.logger.WithError(err).Error("Failed to save default weighting profile")
+		writeAdminError(w, http.StatusInternalServerError, "failed to save default weighting profile")
+		return
+	}
+	ps.shuffle.SetDefaultWeightingProfile(profile)
+
+	ps.logger.WithFields(logrus.Fields{
+		"preset":      profile.Preset,
+		"remote_addr": sanitizeRemoteAddr(r.RemoteAddr),
+	}).Info("Default weighting profile updated via admin API")
+	ps.writeWeighting(w)
+}
+
+// handleDeleteWeighting removes the stored default weighting profile, restoring the configured preset
+func (ps *ProxyServer) handleDeleteWeighting(w http.ResponseWriter, r *http.Request) {
+	profile, err := presetWeightingProfile(ps.config.WeightingPreset)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "invalid configured weighting preset")
+		return
+	}
+
+	if err := ps.db.DeleteDefaultWeightingProfile(); err != nil {
+		ps.logger.WithError(err).Error("Failed to delete default weighting profile")
+		writeAdminError(w, http.StatusInternalServerError, "failed to delete default weighting profile")
+		return
+	}
+	ps.shuffle.SetDefaultWeightingProfile(profile)
+
+	ps.logger.WithField("remote_addr", sanitizeRemoteAddr(r.RemoteAddr)).Info("Default weighting profile reset via admin API")
+	ps.writeWeighting(w)
+}
+
+func (ps *ProxyServer) writeWeighting(w http.ResponseWriter) {
+	response := weightingResponse{
+		Default: ps.shuffle.DefaultWeightingProfile(),
+		Presets: make(map[string]models.WeightingProfile),
+	}
+	for _, name := range shuffle.WeightingPresetNames() {
+		response.Presets[name], _ = shuffle.WeightingPreset(name)
+	}
+
+	writeAdminJSON(w, http.StatusOK, response)
+}
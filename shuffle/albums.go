@@ -0,0 +1,103 @@
+package shuffle
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// GetAlbums returns the user's albums in the given order, built from the user's own play
+// events rather than the upstream server's global statistics
+func (s *Service) GetAlbums(userID string, query database.AlbumQuery) ([]models.Album, error) {
+	return s.db.GetAlbums(userID, query)
+}
+
+// GetWeightedRandomAlbums returns up to count random albums, each drawn with a probability
+// proportional to the average shuffle weight of its songs (time decay, Bayesian play/skip
+// and artist weights). Unlike shuffled songs, recently played albums are not excluded; their
+// songs' time decay makes them less likely instead.
+func (s *Service) GetWeightedRandomAlbums(userID string, grouping database.AlbumGrouping, filter models.SongFilter, count int) ([]models.Album, error) {
+	albums, err := s.db.GetAlbums(userID, database.AlbumQuery{Grouping: grouping, Order: database.AlbumOrderName, Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	if len(albums) == 0 {
+		return nil, nil
+	}
+
+	songs, err := s.db.GetAllSongs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Artist weights are shared by many songs, so calculate each only once
+	profile := s.WeightingProfile(userID)
+	artistWeights := make(map[string]float64)
+	totals := make(map[string]float64, len(albums))
+	counts := make(map[string]int, len(albums))
+	for _, song := range songs {
+		if !filter.Matches(song) {
+			continue
+		}
+		key := song.AlbumID
+		if grouping == database.AlbumGroupingDirectory {
+			key = song.Parent
+		}
+		if key == "" {
+			continue
+		}
+
+		artistWeight, ok := artistWeights[song.Artist]
+		if !ok {
+			artistWeight = s.calculateArtistWeight(userID, profile, song.Artist)
+			artistWeights[song.Artist] = artistWeight
+		}
+		totals[key] += s.calculateTimeDecayWeight(profile, song.LastPlayed, song.LastSkipped) *
+			s.calculatePlaySkipWeight(userID, profile, song.AdjustedPlays, song.AdjustedSkips) * artistWeight
+		counts[key]++
+	}
+
+	weights := make([]float64, len(albums))
+	totalWeight := 0.0
+	for i, album := range albums {
+		if counts[album.ID] > 0 {
+			weights[i] = totals[album.ID] / float64(counts[album.ID])
+		}
+		totalWeight += weights[i]
+	}
+
+	rng := s.newRand(nil)
+	result := make([]models.Album, 0, count)
+	used := make([]bool, len(albums))
+	for len(result) < count && len(result) < len(albums) && totalWeight > 0 {
+		target := rng.Float64() * totalWeight
+		current := 0.0
+		picked := false
+		for i, weight := range weights {
+			if used[i] || weight == 0 {
+				continue
+			}
+			current += weight
+			if current >= target {
+				result = append(result, albums[i])
+				used[i] = true
+				totalWeight -= weight
+				picked = true
+				break
+			}
+		}
+		if !picked {
+			break // Only rounding errors are left in totalWeight
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":    userID,
+		"albums":    len(albums),
+		"returned":  len(result),
+		"requested": count,
+	}).Debug("Selected weighted random albums")
+
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package shuffle
+
+import (
+	"testing"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestGetWeightedRandomAlbums(t *testing.T) {
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Parent: "dir-a", MusicFolderID: "1"},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Parent: "dir-a", MusicFolderID: "1"},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", Parent: "dir-b", MusicFolderID: "1"},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", AlbumID: "al-c", Parent: "dir-c", MusicFolderID: "2"},
+		{ID: "x1", Title: "X1", Artist: "Artist X", Album: "Loose"},
+	}
+	service, _ := newTestService(t, songs)
+
+	albums, err := service.GetWeightedRandomAlbums("testuser", database.AlbumGroupingID3, models.SongFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetWeightedRandomAlbums failed: %v", err)
+	}
+	if len(albums) != 3 {
+		t.Fatalf("Expected all 3 albums with an album ID, got %d", len(albums))
+	}
+	seen := make(map[string]bool)
+	for _, album := range albums {
+		if seen[album.ID] {
+			t.Errorf("Album %s returned twice", album.ID)
+		}
+		seen[album.ID] = true
+	}
+
+	albums, err = service.GetWeightedRandomAlbums("testuser", database.AlbumGroupingDirectory, models.SongFilter{MusicFolderID: "2"}, 10)
+	if err != nil {
+		t.Fatalf("GetWeightedRandomAlbums failed: %v", err)
+	}
+	if len(albums) != 1 || albums[0].ID != "dir-c" {
+		t.Errorf("Expected only dir-c in music folder 2, got %+v", albums)
+	}
+
+	albums, err = service.GetWeightedRandomAlbums("testuser", database.AlbumGroupingID3, models.SongFilter{}, 2)
+	if err != nil {
+		t.Fatalf("GetWeightedRandomAlbums failed: %v", err)
+	}
+	if len(albums) != 2 {
+		t.Errorf("Expected 2 albums, got %d", len(albums))
+	}
+}
+
+func TestGetWeightedRandomAlbumsPrefersPlayedAlbums(t *testing.T) {
+	songs := []models.Song{
+		{ID: "liked", Title: "Liked", Artist: "Artist A", Album: "Liked", AlbumID: "al-liked"},
+		{ID: "skipped", Title: "Skipped", Artist: "Artist B", Album: "Skipped", AlbumID: "al-skipped"},
+	}
+	service, db := newTestService(t, songs)
+	for i := 0; i < 5; i++ {
+		if err := db.RecordPlayEvent("testuser", "liked", "play", nil); err != nil {
+			t.Fatalf("Failed to record play: %v", err)
+		}
+		if err := db.RecordPlayEvent("testuser", "skipped", "skip", nil); err != nil {
+			t.Fatalf("Failed to record skip: %v", err)
+		}
+	}
+
+	liked := 0
+	const draws = 200
+	for i := 0; i < draws; i++ {
+		albums, err := service.GetWeightedRandomAlbums("testuser", database.AlbumGroupingID3, models.SongFilter{}, 1)
+		if err != nil {
+			t.Fatalf("GetWeightedRandomAlbums failed: %v", err)
+		}
+		if len(albums) == 1 && albums[0].ID == "al-liked" {
+			liked++
+		}
+	}
+
+	if liked < draws/2 {
+		t.Errorf("Expected the played album to be drawn more often than the skipped one, got %d of %d", liked, draws)
+	}
+}
@@ -0,0 +1,94 @@
+package shuffle
+
+import (
+	"strings"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// DiversityRules limit how often an artist or album appears in a shuffled batch.
+// Zero values disable the corresponding rule. Batches are shorter than requested if
+// the eligible songs can't satisfy the rules.
+type DiversityRules struct {
+	MaxPerArtist  int // Maximum songs by the same artist per batch
+	MaxPerAlbum   int // Maximum songs from the same album per batch
+	ArtistSpacing int // Minimum number of other songs between two songs by the same artist
+}
+
+// SetDiversityRules sets the diversity rules applied to weighted shuffles
+func (s *Service) SetDiversityRules(rules DiversityRules) {
+	s.diversity = rules
+}
+
+// diversityTracker tracks the artists and albums of a batch being drawn, to check
+// candidates against the diversity rules
+type diversityTracker struct {
+	rules      DiversityRules
+	artists    map[string]int // Songs per artist
+	albums     map[string]int // Songs per album
+	lastArtist map[string]int // Batch position of the latest song per artist
+	position   int
+}
+
+func newDiversityTracker(rules DiversityRules) *diversityTracker {
+	return &diversityTracker{
+		rules:      rules,
+		artists:    make(map[string]int),
+		albums:     make(map[string]int),
+		lastArtist: make(map[string]int),
+	}
+}
+
+// active reports whether any rule is enabled. Without rules every song is allowed, so
+// callers can skip the tracker altogether.
+func (t *diversityTracker) active() bool {
+	return t.rules != DiversityRules{}
+}
+
+// allows reports whether a song with the given keys can be added to the batch without breaking a rule
+func (t *diversityTracker) allows(keys diversityKeys) bool {
+	if t.rules.MaxPerArtist > 0 && t.artists[keys.artist] >= t.rules.MaxPerArtist {
+		return false
+	}
+	if t.rules.MaxPerAlbum > 0 && t.albums[keys.album] >= t.rules.MaxPerAlbum {
+		return false
+	}
+	if t.rules.ArtistSpacing > 0 {
+		if last, ok := t.lastArtist[keys.artist]; ok && t.position-last <= t.rules.ArtistSpacing {
+			return false
+		}
+	}
+	return true
+}
+
+// add records a song with the given keys as the next one of the batch
+func (t *diversityTracker) add(keys diversityKeys) {
+	t.artists[keys.artist]++
+	t.albums[keys.album]++
+	t.lastArtist[keys.artist] = t.position
+	t.position++
+}
+
+// diversityKeys identify the artist and album of a song for the diversity rules. They're
+// computed once per song, as candidates are checked against the rules on every draw.
+type diversityKeys struct {
+	artist string
+	album  string
+}
+
+func songDiversityKeys(song models.Song) diversityKeys {
+	return diversityKeys{artist: artistKey(song), album: albumKey(song)}
+}
+
+func artistKey(song models.Song) string {
+	return strings.ToLower(song.Artist)
+}
+
+// albumKey identifies an album by its ID, or by its name and artist for songs synced
+// without album IDs
+func albumKey(song models.Song) string {
+	if song.AlbumID != "" {
+		return song.AlbumID
+	}
+	return strings.ToLower(song.Album) + "\x00" + artistKey(song)
+}
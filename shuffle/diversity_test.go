@@ -0,0 +1,152 @@
+package shuffle
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// checkDiversity fails the test if the batch breaks any of the rules
+func checkDiversity(t *testing.T, songs []models.Song, rules DiversityRules) {
+	t.Helper()
+
+	artists := make(map[string]int)
+	albums := make(map[string]int)
+	lastArtist := make(map[string]int)
+	for i, song := range songs {
+		artist := strings.ToLower(song.Artist)
+		artists[artist]++
+		albums[albumKey(song)]++
+
+		if rules.MaxPerArtist > 0 && artists[artist] > rules.MaxPerArtist {
+			t.Errorf("Artist %q appears %d times, max %d", song.Artist, artists[artist], rules.MaxPerArtist)
+		}
+		if rules.MaxPerAlbum > 0 && albums[albumKey(song)] > rules.MaxPerAlbum {
+			t.Errorf("Album %q appears %d times, max %d", song.Album, albums[albumKey(song)], rules.MaxPerAlbum)
+		}
+		if last, ok := lastArtist[artist]; ok && rules.ArtistSpacing > 0 && i-last-1 < rules.ArtistSpacing {
+			t.Errorf("Artist %q at positions %d and %d, expected at least %d songs between", song.Artist, last, i, rules.ArtistSpacing)
+		}
+		lastArtist[artist] = i
+	}
+}
+
+// skewedSongs returns 20 songs by one artist on two albums weighted 1000x higher than
+// 40 songs by distinct artists
+func skewedSongs() []models.WeightedSong {
+	var songs []models.WeightedSong
+	for i := 0; i < 20; i++ {
+		songs = append(songs, models.WeightedSong{
+			Song:   models.Song{ID: fmt.Sprintf("hot%d", i), Artist: "Hot Artist", Album: fmt.Sprintf("Hot Album %d", i%2)},
+			Weight: 1000,
+		})
+	}
+	for i := 0; i < 40; i++ {
+		songs = append(songs, models.WeightedSong{
+			Song:   models.Song{ID: fmt.Sprintf("other%d", i), Artist: fmt.Sprintf("Artist %d", i), Album: fmt.Sprintf("Album %d", i)},
+			Weight: 1,
+		})
+	}
+	return songs
+}
+
+func TestWeightedSampleDiversity(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules DiversityRules
+	}{
+		{"max per artist", DiversityRules{MaxPerArtist: 3}},
+		{"max per album", DiversityRules{MaxPerAlbum: 2}},
+		{"artist spacing", DiversityRules{ArtistSpacing: 4}},
+		{"all rules", DiversityRules{MaxPerArtist: 5, MaxPerAlbum: 2, ArtistSpacing: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				songs := weightedSample(skewedSongs(), 30, tt.rules, rand.New(rand.NewSource(1)))
+				if len(songs) != 30 {
+					t.Fatalf("Expected 30 songs, got %d", len(songs))
+				}
+				checkDiversity(t, songs, tt.rules)
+			}
+		})
+	}
+}
+
+func TestWeightedSampleWithoutDiversityRules(t *testing.T) {
+	// Without rules the skewed weights put almost only the hot artist first
+	songs := weightedSample(skewedSongs(), 20, DiversityRules{}, rand.New(rand.NewSource(1)))
+	hot := 0
+	for _, song := range songs {
+		if song.Artist == "Hot Artist" {
+			hot++
+		}
+	}
+	if hot < 15 {
+		t.Errorf("Expected the high-weight artist to dominate without rules, got %d of 20", hot)
+	}
+}
+
+func TestWeightedSampleDiversityUnsatisfiable(t *testing.T) {
+	songs := []models.WeightedSong{
+		{Song: models.Song{ID: "1", Artist: "Only", Album: "A"}, Weight: 1},
+		{Song: models.Song{ID: "2", Artist: "Only", Album: "A"}, Weight: 1},
+		{Song: models.Song{ID: "3", Artist: "Only", Album: "B"}, Weight: 1},
+	}
+
+	if got := weightedSample(songs, 3, DiversityRules{MaxPerArtist: 2}, rand.New(rand.NewSource(1))); len(got) != 2 {
+		t.Errorf("Expected the batch to stop at the artist limit, got %d songs", len(got))
+	}
+	if got := weightedSample(songs, 3, DiversityRules{ArtistSpacing: 1}, rand.New(rand.NewSource(1))); len(got) != 1 {
+		t.Errorf("Expected the batch to stop when spacing can't be kept, got %d songs", len(got))
+	}
+}
+
+func TestGetWeightedShuffledSongsDiversity(t *testing.T) {
+	rules := DiversityRules{MaxPerArtist: 3, MaxPerAlbum: 2, ArtistSpacing: 2}
+
+	for _, tt := range []struct {
+		name      string
+		songCount int
+	}{
+		{"small library", 500},
+		{"large library", LargeLibraryThreshold + 500},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			service, db := newTestService(t, nil)
+			service.SetDiversityRules(rules)
+
+			// Most of the library is by one artist on one album
+			songs := make([]models.Song, tt.songCount)
+			for i := range songs {
+				songs[i] = models.Song{ID: fmt.Sprintf("song_%d", i), Title: "Song", Artist: "Prolific", Album: "Box Set", Duration: 200}
+				if i%4 == 0 {
+					songs[i].Artist = fmt.Sprintf("Artist %d", i)
+					songs[i].Album = fmt.Sprintf("Album %d", i)
+				}
+			}
+			for start := 0; start < len(songs); start += BatchSize {
+				end := start + BatchSize
+				if end > len(songs) {
+					end = len(songs)
+				}
+				if err := db.StoreSongs("testuser", songs[start:end]); err != nil {
+					t.Fatalf("Failed to store songs: %v", err)
+				}
+			}
+
+			shuffled, err := service.GetWeightedShuffledSongs("testuser", 30, models.SongFilter{}, nil)
+			if err != nil {
+				t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+			}
+			if len(shuffled) != 30 {
+				t.Errorf("Expected 30 songs, got %d", len(shuffled))
+			}
+			checkDiversity(t, shuffled, rules)
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package shuffle
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// MaxExplainSongs is the maximum number of songs explained per request
+const MaxExplainSongs = 100
+
+// ExplainSongs explains the weights of songs in the user's next shuffle: each weight factor
+// as the shuffle path for the user's library size calculates it, the empirical Bayes priors,
+// whether the replay windows exclude the song and its probability share in the eligible pool,
+// which is the chance of being the first pick. Transition weights are calculated from
+// fromSongID, or from the user's last played song if fromSongID is empty. Unknown song IDs
+// return ErrSongNotFound.
+func (s *Service) ExplainSongs(userID string, songIDs []string, fromSongID string, filter models.SongFilter) (*models.ShuffleExplanation, error) {
+	if len(songIDs) == 0 || len(songIDs) > MaxExplainSongs {
+		return nil, errors.ErrValidationFailed.WithContext("field", "id").
+			WithContext("count", len(songIDs)).
+			WithContext("max_allowed", MaxExplainSongs)
+	}
+
+	songs, err := s.db.GetAllSongs(userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+	requested := make([]models.Song, 0, len(songIDs))
+	for _, songID := range songIDs {
+		song, ok := byID[songID]
+		if !ok {
+			return nil, errors.ErrSongNotFound.WithContext("songID", songID)
+		}
+		requested = append(requested, song)
+	}
+
+	if fromSongID == "" {
+		if lastPlayed := s.GetLastPlayedSongID(userID); lastPlayed != nil {
+			fromSongID = *lastPlayed
+		}
+	}
+
+	// Weigh the eligible pool with batched transition probabilities
+	windows := s.ReplayWindows(userID)
+	cutoffs := windows.cutoffs(s.now())
+	var eligible []models.Song
+	for _, song := range songs {
+		if filter.Matches(song) && !cutoffs.excludes(song) {
+			eligible = append(eligible, song)
+		}
+	}
+	weigher, err := s.newSongWeigher(userID, fromSongID, len(songs), append(requested, eligible...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The explained songs are weighed the same way, so the shares of all eligible songs add up to 1
+	profile := weigher.profile
+	eligibleWeight := 0.0
+	for _, song := range eligible {
+		eligibleWeight += weigher.factors(song).Final
+	}
+
+	songAlpha, songBeta := s.getEmpiricalPriors(userID, profile)
+	artistAlpha, artistBeta := s.getEmpiricalArtistPriors(userID, profile)
+
+	explanation := &models.ShuffleExplanation{
+		UserID:                  userID,
+		ReferenceSongID:         fromSongID,
+		WeightingPreset:         profile.Preset,
+		ReplayWindowPlayedDays:  windows.PlayedDays,
+		ReplayWindowSkippedDays: windows.SkippedDays,
+		EligibleSongs:           len(eligible),
+		EligibleWeight:          eligibleWeight,
+		Songs:                   make([]models.SongExplanation, 0, len(songIDs)),
+	}
+	for _, song := range requested {
+		weights := weigher.factors(song)
+
+		songExplanation := models.SongExplanation{
+			Song:           song,
+			PlayCount:      song.PlayCount,
+			SkipCount:      song.SkipCount,
+			AdjustedPlays:  song.AdjustedPlays,
+			AdjustedSkips:  song.AdjustedSkips,
+			LastPlayed:     timeOrNil(song.LastPlayed),
+			LastSkipped:    timeOrNil(song.LastSkipped),
+			Weights:        weights,
+			SongPriors:     models.BayesPriors{Alpha: songAlpha, Beta: songBeta},
+			ArtistPriors:   models.BayesPriors{Alpha: artistAlpha, Beta: artistBeta},
+			MatchesFilter:  filter.Matches(song),
+			ReplayExcluded: cutoffs.excludes(song),
+		}
+		if songExplanation.ReplayExcluded {
+			songExplanation.ExcludedUntil = cutoffs.excludedUntil(song)
+		} else if songExplanation.MatchesFilter && eligibleWeight > 0 {
+			songExplanation.Probability = weights.Final / eligibleWeight
+		}
+		explanation.Songs = append(explanation.Songs, songExplanation)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":        userID,
+		"songs":         len(songIDs),
+		"eligibleSongs": len(eligible),
+		"fromSongID":    fromSongID,
+	}).Debug("Explained shuffle weights")
+
+	return explanation, nil
+}
+
+// GetAllSongWeightFactors returns the weight factors of all of the user's songs as the shuffle
+// path for the library size calculates them, with transition weights calculated from fromSongID,
+// or from the user's last played song if fromSongID is empty. Unlike
+// GetWeightComponentsWithTransition it loads the transition probabilities in batches, so it
+// stays fast for large libraries.
+func (s *Service) GetAllSongWeightFactors(userID, fromSongID string) ([]models.SongWeightFactors, error) {
+	songs, err := s.db.GetAllSongs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromSongID == "" {
+		if lastPlayed := s.GetLastPlayedSongID(userID); lastPlayed != nil {
+			fromSongID = *lastPlayed
+		}
+	}
+	weigher, err := s.newSongWeigher(userID, fromSongID, len(songs), songs)
+	if err != nil {
+		return nil, err
+	}
+
+	factors := make([]models.SongWeightFactors, len(songs))
+	for i, song := range songs {
+		factors[i] = models.SongWeightFactors{Song: song, Weights: weigher.factors(song)}
+	}
+
+	return factors, nil
+}
+
+// songWeigher calculates the weight factors of a user's songs the way the user's shuffle does
+type songWeigher struct {
+	s             *Service
+	userID        string
+	profile       models.WeightingProfile
+	fromSongID    string
+	optimized     bool               // Whether shuffles take the optimized path for large libraries
+	probabilities map[string]float64 // Transition probabilities from fromSongID
+}
+
+// newSongWeigher prepares the weighing of songs of a library of librarySize songs, with
+// transition probabilities from fromSongID loaded for the given songs
+func (s *Service) newSongWeigher(userID, fromSongID string, librarySize int, songs []models.Song) (*songWeigher, error) {
+	probabilities, err := s.transitionProbabilities(userID, fromSongID, songs)
+	if err != nil {
+		return nil, err
+	}
+	return &songWeigher{
+		s:             s,
+		userID:        userID,
+		profile:       s.WeightingProfile(userID),
+		fromSongID:    fromSongID,
+		optimized:     librarySize > LargeLibraryThreshold,
+		probabilities: probabilities,
+	}, nil
+}
+
+// factors returns the weight factors of a song and their product
+func (w *songWeigher) factors(song models.Song) models.WeightFactors {
+	weights := models.WeightFactors{
+		Time:       w.s.calculateTimeDecayWeight(w.profile, song.LastPlayed, song.LastSkipped),
+		PlaySkip:   w.s.calculatePlaySkipWeight(w.userID, w.profile, song.AdjustedPlays, song.AdjustedSkips),
+		Transition: 1.0,
+		Artist:     w.s.calculateArtistWeight(w.userID, w.profile, song.Artist),
+	}
+	if w.fromSongID != "" {
+		weights.Transition = transitionWeightFor(w.probabilities[song.ID], w.optimized)
+	}
+	weights.Final = weights.Time * weights.PlaySkip * weights.Transition * weights.Artist
+	return weights
+}
+
+// transitionProbabilities loads the transition probabilities from fromSongID to songs in
+// batches of BatchSize. It returns an empty map without a song to transition from.
+func (s *Service) transitionProbabilities(userID, fromSongID string, songs []models.Song) (map[string]float64, error) {
+	probabilities := make(map[string]float64)
+	if fromSongID == "" {
+		return probabilities, nil
+	}
+
+	for start := 0; start < len(songs); start += BatchSize {
+		end := min(start+BatchSize, len(songs))
+		songIDs := make([]string, 0, end-start)
+		for _, song := range songs[start:end] {
+			songIDs = append(songIDs, song.ID)
+		}
+		batch, err := s.db.GetTransitionProbabilities(userID, fromSongID, songIDs)
+		if err != nil {
+			return nil, err
+		}
+		for songID, probability := range batch {
+			probabilities[songID] = probability
+		}
+	}
+	return probabilities, nil
+}
+
+// excludedUntil returns when an excluded song leaves the replay windows
+func (c replayCutoffs) excludedUntil(song models.Song) *time.Time {
+	var until time.Time
+	if c.excludesPlay(song) {
+		until = song.LastPlayed.AddDate(0, 0, c.windows.PlayedDays)
+	}
+	if c.excludesSkip(song) {
+		if skipUntil := song.LastSkipped.AddDate(0, 0, c.windows.SkippedDays); skipUntil.After(until) {
+			until = skipUntil
+		}
+	}
+	return timeOrNil(until)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,154 @@
+package shuffle
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestExplainSongs(t *testing.T) {
+	service := newRadioTestService(t)
+
+	songIDs := []string{"a1", "a2", "a3", "b1", "b2"}
+	for i := 0; i < 10; i++ {
+		songIDs = append(songIDs, fmt.Sprintf("z%d", i))
+	}
+
+	explanation, err := service.ExplainSongs("testuser", songIDs, "a1", models.SongFilter{})
+	if err != nil {
+		t.Fatalf("ExplainSongs failed: %v", err)
+	}
+	if explanation.EligibleSongs != 14 {
+		t.Errorf("Expected 14 eligible songs, got %d", explanation.EligibleSongs)
+	}
+	if explanation.ReferenceSongID != "a1" {
+		t.Errorf("Expected reference song a1, got %s", explanation.ReferenceSongID)
+	}
+
+	total := 0.0
+	for _, song := range explanation.Songs {
+		total += song.Probability
+
+		weights := song.Weights
+		if product := weights.Time * weights.PlaySkip * weights.Transition * weights.Artist; math.Abs(product-weights.Final) > 1e-9 {
+			t.Errorf("Expected final weight %f to be the product of the factors %f", weights.Final, product)
+		}
+		if song.SongPriors.Alpha <= 0 || song.ArtistPriors.Beta <= 0 {
+			t.Errorf("Expected positive priors for %s, got %+v and %+v", song.Song.ID, song.SongPriors, song.ArtistPriors)
+		}
+
+		switch song.Song.ID {
+		case "a3":
+			if !song.ReplayExcluded || song.ExcludedUntil == nil || song.Probability != 0 {
+				t.Errorf("Expected recently played a3 to be excluded, got %+v", song)
+			}
+			if song.LastPlayed == nil || song.PlayCount != 1 {
+				t.Errorf("Expected play of a3 to be reported, got %+v", song)
+			}
+		case "b1":
+			if song.Weights.Transition <= BaseTransitionWeight+0.5 {
+				t.Errorf("Expected recorded transition to raise b1's transition weight, got %f", song.Weights.Transition)
+			}
+		}
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("Expected probabilities of the eligible pool to add up to 1, got %f", total)
+	}
+}
+
+func TestExplainSongsLargeLibrary(t *testing.T) {
+	service := newRadioTestService(t)
+
+	songs := make([]models.Song, 0, LargeLibraryThreshold)
+	for i := 0; i < LargeLibraryThreshold; i++ {
+		songs = append(songs, models.Song{ID: fmt.Sprintf("l%d", i), Title: "L", Artist: "Large", Album: "Large"})
+	}
+	if err := service.db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+	// A transition that was only ever skipped has probability 0
+	if err := service.db.RecordTransition("testuser", "a1", "a2", "skip"); err != nil {
+		t.Fatalf("Failed to record transition: %v", err)
+	}
+
+	explanation, err := service.ExplainSongs("testuser", []string{"a2", "b1"}, "a1", models.SongFilter{})
+	if err != nil {
+		t.Fatalf("ExplainSongs failed: %v", err)
+	}
+
+	// The optimized shuffle path of large libraries weighs the songs
+	for _, song := range explanation.Songs {
+		probability, err := service.db.GetTransitionProbability("testuser", "a1", song.Song.ID)
+		if err != nil {
+			t.Fatalf("Failed to get transition probability: %v", err)
+		}
+		if expected := service.calculateSongWeightWithTransition("testuser", song.Song, probability); math.Abs(song.Weights.Final-expected) > 1e-9 {
+			t.Errorf("Expected weight %f of %s to match the optimized shuffle, got %f", expected, song.Song.ID, song.Weights.Final)
+		}
+	}
+	if transition := explanation.Songs[0].Weights.Transition; transition != 1.0 {
+		t.Errorf("Expected the optimized path's neutral transition weight for probability 0, got %f", transition)
+	}
+}
+
+func TestExplainSongsFilter(t *testing.T) {
+	service := newRadioTestService(t)
+
+	explanation, err := service.ExplainSongs("testuser", []string{"a1", "b2"}, "", models.SongFilter{Genre: "Jazz"})
+	if err != nil {
+		t.Fatalf("ExplainSongs failed: %v", err)
+	}
+	if explanation.EligibleSongs != 1 {
+		t.Errorf("Expected only b2 to be eligible, got %d songs", explanation.EligibleSongs)
+	}
+	if song := explanation.Songs[0]; song.MatchesFilter || song.Probability != 0 {
+		t.Errorf("Expected a1 not to match the filter, got %+v", song)
+	}
+	if song := explanation.Songs[1]; !song.MatchesFilter || math.Abs(song.Probability-1) > 1e-9 {
+		t.Errorf("Expected b2 to be the only pick, got %+v", song)
+	}
+}
+
+func TestExplainSongsErrors(t *testing.T) {
+	service := newRadioTestService(t)
+
+	if _, err := service.ExplainSongs("testuser", []string{"a1", "unknown"}, "", models.SongFilter{}); !errors.Is(err, errors.ErrSongNotFound) {
+		t.Errorf("Expected song not found error, got %v", err)
+	}
+	if _, err := service.ExplainSongs("testuser", nil, "", models.SongFilter{}); !errors.Is(err, errors.ErrValidationFailed) {
+		t.Errorf("Expected validation error without songs, got %v", err)
+	}
+}
+
+func TestGetAllSongWeightFactors(t *testing.T) {
+	service := newRadioTestService(t)
+	now := time.Now()
+	service.SetClock(func() time.Time { return now })
+
+	factors, err := service.GetAllSongWeightFactors("testuser", "a1")
+	if err != nil {
+		t.Fatalf("GetAllSongWeightFactors failed: %v", err)
+	}
+	if len(factors) != 15 {
+		t.Fatalf("Expected 15 songs, got %d", len(factors))
+	}
+
+	// Batched transition probabilities match the per-song calculation
+	for _, f := range factors {
+		timeWeight, playSkipWeight, transitionWeight, artistWeight := service.GetWeightComponentsWithTransition("testuser", f.Song, "a1")
+		expected := models.WeightFactors{
+			Time:       timeWeight,
+			PlaySkip:   playSkipWeight,
+			Transition: transitionWeight,
+			Artist:     artistWeight,
+			Final:      timeWeight * playSkipWeight * transitionWeight * artistWeight,
+		}
+		if f.Weights != expected {
+			t.Errorf("Expected weights %+v for %s, got %+v", expected, f.Song.ID, f.Weights)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package shuffle
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// RadioSeedArtistAffinity is the affinity of songs by the seed's artists in seeded shuffles,
+// where the seed's artist should dominate unlike in similar songs
+const RadioSeedArtistAffinity = 1.0
+
+// GetSeededShuffledSongs returns a shuffle biased toward a seed, which may be a song, album or
+// artist ID, like an artist radio. Candidates are the songs by the seed's artists, the songs
+// played right before or after the seed songs and the songs by co-played artists (see
+// findRelatedSongs), each weighted by its affinity times its regular shuffle weight. The
+// user's replay windows and the filter apply as in GetWeightedShuffledSongs, and the seed
+// song itself is never returned. If there are too few related songs, the rest of the shuffle
+// is filled from the whole library. randomSeed reproduces a shuffle as in GetWeightedShuffledSongs.
+func (s *Service) GetSeededShuffledSongs(userID, seedID string, count int, filter models.SongFilter, randomSeed *int64) ([]models.Song, error) {
+	start := time.Now()
+	defer s.observeDuration(start, ShufflePathSeeded)
+	rng := s.newRand(randomSeed)
+
+	seedSongs, err := s.db.GetSeedSongs(userID, seedID)
+	if err != nil {
+		return nil, err
+	}
+	if len(seedSongs) == 0 {
+		return nil, errors.ErrSongNotFound.WithContext("seed", seedID)
+	}
+
+	related, err := s.findRelatedSongs(userID, seedSongs, RadioSeedArtistAffinity)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoffs := s.ReplayWindows(userID).cutoffs(s.now())
+	weightedSongs := make([]models.WeightedSong, 0, len(related.candidates))
+	for _, song := range related.candidates {
+		if song.ID == seedID || related.affinity[song.ID] <= 0 || !filter.Matches(song) || cutoffs.excludes(song) {
+			continue
+		}
+		weight := related.affinity[song.ID] * s.calculateSongWeightWithTransition(userID, song, 0)
+		weightedSongs = append(weightedSongs, models.WeightedSong{Song: song, Weight: weight})
+	}
+
+	// The seed's artist is meant to dominate, so the diversity rules only apply to the fill
+	result := weightedSample(weightedSongs, count, DiversityRules{}, rng)
+	relatedCount := len(result)
+
+	if len(result) < count {
+		used := make(map[string]bool, len(result)+1)
+		used[seedID] = true
+		for _, song := range result {
+			used[song.ID] = true
+		}
+
+		fill, _, err := s.weightedShuffle(userID, count-len(result)+len(used), filter, rng)
+		if err != nil {
+			return nil, err
+		}
+		for _, song := range fill {
+			if len(result) >= count {
+				break
+			}
+			if !used[song.ID] {
+				result = append(result, song)
+				used[song.ID] = true
+			}
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":     userID,
+		"seedSongs":  len(seedSongs),
+		"history":    related.history,
+		"candidates": len(weightedSongs),
+		"related":    relatedCount,
+		"returned":   len(result),
+		"requested":  count,
+	}).Debug("Calculated seeded shuffle")
+
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package shuffle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newRadioTestService creates a service with three songs by Artist A (a3 played recently),
+// two by Artist B played after a1, and ten unrelated songs
+func newRadioTestService(t *testing.T) *Service {
+	t.Helper()
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", ArtistID: "ar-a", Genre: "Rock"},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", ArtistID: "ar-a", Genre: "Rock"},
+		{ID: "a3", Title: "A3", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", ArtistID: "ar-a", Genre: "Rock"},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", ArtistID: "ar-b", Genre: "Rock"},
+		{ID: "b2", Title: "B2", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", ArtistID: "ar-b", Genre: "Jazz"},
+	}
+	for i := 0; i < 10; i++ {
+		songs = append(songs, models.Song{ID: fmt.Sprintf("z%d", i), Title: "Z", Artist: fmt.Sprintf("Other %d", i), Album: "Other", Genre: "Rock"})
+	}
+	service, db := newTestService(t, songs)
+
+	if err := db.RecordTransition("testuser", "a1", "b1", "play"); err != nil {
+		t.Fatalf("Failed to record transition: %v", err)
+	}
+	if err := db.RecordPlayEvent("testuser", "a3", "play", nil); err != nil {
+		t.Fatalf("Failed to record play: %v", err)
+	}
+
+	return service
+}
+
+func TestGetSeededShuffledSongs(t *testing.T) {
+	service := newRadioTestService(t)
+
+	// Only a2, b1 and b2 are related to a1 and not played recently
+	for i := 0; i < 10; i++ {
+		songs, err := service.GetSeededShuffledSongs("testuser", "a1", 3, models.SongFilter{}, nil)
+		if err != nil {
+			t.Fatalf("GetSeededShuffledSongs failed: %v", err)
+		}
+		if len(songs) != 3 {
+			t.Fatalf("Expected 3 songs, got %d", len(songs))
+		}
+		for _, song := range songs {
+			if song.ID != "a2" && song.ID != "b1" && song.ID != "b2" {
+				t.Errorf("Expected only related songs, got %s", song.ID)
+			}
+		}
+	}
+
+	// The filter and the replay prevention also apply to related songs
+	songs, err := service.GetSeededShuffledSongs("testuser", "ar-a", 2, models.SongFilter{Genre: "Jazz"}, nil)
+	if err != nil {
+		t.Fatalf("GetSeededShuffledSongs failed: %v", err)
+	}
+	if len(songs) != 1 || songs[0].ID != "b2" {
+		t.Errorf("Expected only the jazz song b2, got %+v", songs)
+	}
+}
+
+func TestGetSeededShuffledSongsFillsFromLibrary(t *testing.T) {
+	service := newRadioTestService(t)
+
+	songs, err := service.GetSeededShuffledSongs("testuser", "a1", 8, models.SongFilter{}, nil)
+	if err != nil {
+		t.Fatalf("GetSeededShuffledSongs failed: %v", err)
+	}
+	if len(songs) != 8 {
+		t.Fatalf("Expected 8 songs, got %d", len(songs))
+	}
+
+	seen := make(map[string]bool)
+	for i, song := range songs {
+		if seen[song.ID] {
+			t.Errorf("Song %s returned twice", song.ID)
+		}
+		seen[song.ID] = true
+		if song.ID == "a1" || song.ID == "a3" {
+			t.Errorf("Did not expect the seed or a recently played song, got %s", song.ID)
+		}
+		// Related songs come first, the library-wide fill after them
+		if i < 3 && song.ID[0] == 'z' {
+			t.Errorf("Expected related songs before unrelated ones, got %s at %d", song.ID, i)
+		}
+	}
+}
+
+func TestGetSeededShuffledSongsUnknownSeed(t *testing.T) {
+	service := newRadioTestService(t)
+
+	_, err := service.GetSeededShuffledSongs("testuser", "unknown", 5, models.SongFilter{}, nil)
+	if !errors.Is(err, errors.ErrSongNotFound) {
+		t.Errorf("Expected song not found error, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package shuffle
+
+import (
+	"math/rand"
+	"time"
+)
+
+// SetClock replaces the clock used for replay windows, time decay and skip detection, so
+// tests can run against a fixed point in time
+func (s *Service) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// SetRandSource replaces the random source of shuffles without a random seed. A source with
+// a fixed seed makes a sequence of shuffles reproducible.
+func (s *Service) SetRandSource(source rand.Source) {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	s.randSource = source
+}
+
+// newRand returns the random generator of a single shuffle: seeded with randomSeed if given,
+// otherwise drawn from the service's random source. Every shuffle gets its own generator, as
+// rand.Rand is not safe for concurrent use.
+func (s *Service) newRand(randomSeed *int64) *rand.Rand {
+	if randomSeed != nil {
+		return rand.New(rand.NewSource(*randomSeed))
+	}
+
+	s.randMu.Lock()
+	seed := s.randSource.Int63()
+	s.randMu.Unlock()
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,121 @@
+package shuffle
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func songIDList(songs []models.Song) []string {
+	ids := make([]string, len(songs))
+	for i, song := range songs {
+		ids[i] = song.ID
+	}
+	return ids
+}
+
+func TestRandomSeedReproducesShuffle(t *testing.T) {
+	service := newRadioTestService(t)
+
+	seed := int64(42)
+	first, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, &seed)
+	if err != nil {
+		t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+	}
+	second, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, &seed)
+	if err != nil {
+		t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+	}
+	if !reflect.DeepEqual(songIDList(first), songIDList(second)) {
+		t.Errorf("Expected identical shuffles for the same seed, got %v and %v", songIDList(first), songIDList(second))
+	}
+
+	// Different seeds should give different orders for at least one of a few seeds
+	differs := false
+	for other := int64(1); other <= 5 && !differs; other++ {
+		songs, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, &other)
+		if err != nil {
+			t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+		}
+		differs = !reflect.DeepEqual(songIDList(first), songIDList(songs))
+	}
+	if !differs {
+		t.Error("Expected other seeds to give different shuffles")
+	}
+
+	radioFirst, err := service.GetSeededShuffledSongs("testuser", "a1", 8, models.SongFilter{}, &seed)
+	if err != nil {
+		t.Fatalf("GetSeededShuffledSongs failed: %v", err)
+	}
+	radioSecond, err := service.GetSeededShuffledSongs("testuser", "a1", 8, models.SongFilter{}, &seed)
+	if err != nil {
+		t.Fatalf("GetSeededShuffledSongs failed: %v", err)
+	}
+	if !reflect.DeepEqual(songIDList(radioFirst), songIDList(radioSecond)) {
+		t.Errorf("Expected identical seeded shuffles for the same random seed, got %v and %v", songIDList(radioFirst), songIDList(radioSecond))
+	}
+}
+
+func TestSetRandSourceReproducesShuffleSequence(t *testing.T) {
+	service := newRadioTestService(t)
+
+	shuffles := func() [][]string {
+		service.SetRandSource(rand.NewSource(7))
+		var result [][]string
+		for i := 0; i < 3; i++ {
+			songs, err := service.GetWeightedShuffledSongs("testuser", 5, models.SongFilter{}, nil)
+			if err != nil {
+				t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+			}
+			result = append(result, songIDList(songs))
+		}
+		return result
+	}
+
+	if first, second := shuffles(), shuffles(); !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same sequence of shuffles from the same source, got %v and %v", first, second)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	// Songs 1 to 3 were just played, so only song 4 is eligible until the replay window has passed
+	for _, songID := range []string{"1", "2", "3"} {
+		if err := db.RecordPlayEvent("testuser", songID, "play", nil); err != nil {
+			t.Fatalf("Failed to record play event: %v", err)
+		}
+	}
+	firstSongs := func() map[string]bool {
+		first := make(map[string]bool)
+		for seed := int64(1); seed <= 20; seed++ {
+			songs, err := service.GetWeightedShuffledSongs("testuser", 4, models.SongFilter{}, &seed)
+			if err != nil {
+				t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+			}
+			first[songs[0].ID] = true
+		}
+		return first
+	}
+
+	now := time.Now()
+	service.SetClock(func() time.Time { return now })
+	if first := firstSongs(); len(first) != 1 || !first["4"] {
+		t.Errorf("Expected the only eligible song 4 first, got %v", first)
+	}
+
+	service.SetClock(func() time.Time { return now.AddDate(0, 0, TwoWeekReplayThreshold+1) })
+	if first := firstSongs(); len(first) < 2 {
+		t.Errorf("Expected all songs to be eligible once the clock passed the replay window, got %v first", first)
+	}
+
+	lastPlayed := now.AddDate(0, 0, -10)
+	profile := service.DefaultWeightingProfile()
+	service.SetClock(func() time.Time { return lastPlayed })
+	if weight := service.calculateTimeDecayWeight(profile, lastPlayed, time.Time{}); weight != profile.TimeDecayMinWeight {
+		t.Errorf("Expected minimum time decay weight at the time of the play, got %f", weight)
+	}
+}
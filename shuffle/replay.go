@@ -0,0 +1,138 @@
+package shuffle
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// MinReplayFallbackWeight is the lowest weight multiplier of a replay-excluded song filling
+// a short shuffle, reached by songs presented just now
+const MinReplayFallbackWeight = 0.05
+
+// ReplayWindows are the number of days during which played and skipped songs are excluded
+// from shuffles. Zero disables the corresponding exclusion.
+type ReplayWindows struct {
+	PlayedDays  int
+	SkippedDays int
+}
+
+// SetReplayWindows sets the server-wide replay windows, which users may override in their settings
+func (s *Service) SetReplayWindows(windows ReplayWindows) {
+	s.replayWindows = windows
+}
+
+// ReplayWindows returns the user's effective replay windows: the user's own settings where
+// present, the server-wide windows otherwise
+func (s *Service) ReplayWindows(userID string) ReplayWindows {
+	windows := s.replayWindows
+
+	settings, err := s.db.GetUserSettings(userID)
+	if err != nil {
+		s.logger.WithError(err).WithField("userID", userID).Warn("Failed to load user settings, using default replay windows")
+		return windows
+	}
+	if settings == nil {
+		return windows
+	}
+	if settings.ReplayWindowPlayedDays != nil {
+		windows.PlayedDays = *settings.ReplayWindowPlayedDays
+	}
+	if settings.ReplayWindowSkippedDays != nil {
+		windows.SkippedDays = *settings.ReplayWindowSkippedDays
+	}
+	return windows
+}
+
+// replayCutoffs are the replay windows resolved against a single point in time, so all
+// components of a shuffle agree on which songs are excluded
+type replayCutoffs struct {
+	windows ReplayWindows
+	now     time.Time
+	played  time.Time // Songs played at or after this time are excluded
+	skipped time.Time // Songs skipped at or after this time are excluded
+}
+
+func (w ReplayWindows) cutoffs(now time.Time) replayCutoffs {
+	return replayCutoffs{
+		windows: w,
+		now:     now,
+		played:  now.AddDate(0, 0, -w.PlayedDays),
+		skipped: now.AddDate(0, 0, -w.SkippedDays),
+	}
+}
+
+// excludes reports whether the song was played or skipped within the replay windows
+func (c replayCutoffs) excludes(song models.Song) bool {
+	return c.excludesPlay(song) || c.excludesSkip(song)
+}
+
+func (c replayCutoffs) excludesPlay(song models.Song) bool {
+	return !song.LastPlayed.IsZero() && !song.LastPlayed.Before(c.played)
+}
+
+func (c replayCutoffs) excludesSkip(song models.Song) bool {
+	return !song.LastSkipped.IsZero() && !song.LastSkipped.Before(c.skipped)
+}
+
+// penalty returns the weight multiplier of an excluded song filling a short shuffle: the
+// fraction of its replay window that has passed, so the songs presented longest ago are
+// the most likely picks
+func (c replayCutoffs) penalty(song models.Song) float64 {
+	penalty := 1.0
+	if c.excludesPlay(song) {
+		penalty = math.Min(penalty, elapsedFraction(song.LastPlayed, c.now, c.windows.PlayedDays))
+	}
+	if c.excludesSkip(song) {
+		penalty = math.Min(penalty, elapsedFraction(song.LastSkipped, c.now, c.windows.SkippedDays))
+	}
+	return math.Max(penalty, MinReplayFallbackWeight)
+}
+
+// elapsedFraction returns the fraction of a window of days that has passed between presented and now
+func elapsedFraction(presented, now time.Time, days int) float64 {
+	if days <= 0 {
+		return 1.0
+	}
+	elapsed := now.Sub(presented).Hours() / (float64(days) * HoursPerDay)
+	return math.Min(math.Max(elapsed, 0), 1.0)
+}
+
+// fillFromReplayExcluded completes a shuffle that came up short of count with songs excluded
+// by the replay windows. Only the least recently presented excluded songs are candidates, each
+// weighted by its regular weight times its recency penalty. The diversity rules keep applying
+// to the whole batch.
+func (s *Service) fillFromReplayExcluded(userID string, result []models.Song, count int, cutoffs replayCutoffs, filter models.SongFilter, rng *rand.Rand) ([]models.Song, error) {
+	shortfall := count - len(result)
+	excluded, err := s.db.GetReplayExcludedSongs(userID, cutoffs.played, cutoffs.skipped, filter, shortfall*OversampleFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	weightedSongs := make([]models.WeightedSong, 0, len(excluded))
+	for _, song := range excluded {
+		weight := s.calculateSongWeightWithTransition(userID, song, 0) * cutoffs.penalty(song)
+		weightedSongs = append(weightedSongs, models.WeightedSong{Song: song, Weight: weight})
+	}
+
+	tracker := newDiversityTracker(s.diversity)
+	for _, song := range result {
+		tracker.add(songDiversityKeys(song))
+	}
+	filled := sampleInto(result, weightedSongs, count, tracker, rng)
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":      userID,
+		"eligible":    len(result),
+		"candidates":  len(excluded),
+		"filled":      len(filled) - len(result),
+		"playedDays":  cutoffs.windows.PlayedDays,
+		"skippedDays": cutoffs.windows.SkippedDays,
+	}).Debug("Filled short shuffle from replay-excluded songs")
+
+	return filled, nil
+}
@@ -0,0 +1,131 @@
+package shuffle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/models"
+)
+
+func newReplayTestService(t *testing.T) (*Service, *database.DB) {
+	t.Helper()
+
+	songs := []models.Song{
+		{ID: "1", Title: "Song 1", Artist: "Artist A", Album: "Album", Duration: 200},
+		{ID: "2", Title: "Song 2", Artist: "Artist A", Album: "Album", Duration: 200},
+		{ID: "3", Title: "Song 3", Artist: "Artist B", Album: "Album", Duration: 200},
+		{ID: "4", Title: "Song 4", Artist: "Artist B", Album: "Album", Duration: 200},
+	}
+	return newTestService(t, songs)
+}
+
+func TestReplayWindowsUserOverrides(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	defaults := ReplayWindows{PlayedDays: TwoWeekReplayThreshold, SkippedDays: TwoWeekReplayThreshold}
+	if windows := service.ReplayWindows("testuser"); windows != defaults {
+		t.Errorf("Expected default windows %+v, got %+v", defaults, windows)
+	}
+
+	service.SetReplayWindows(ReplayWindows{PlayedDays: 7, SkippedDays: 30})
+	playedDays := 0
+	if err := db.SaveUserSettings(models.UserSettings{UserID: "testuser", ReplayWindowPlayedDays: &playedDays}); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+
+	expected := ReplayWindows{PlayedDays: 0, SkippedDays: 30}
+	if windows := service.ReplayWindows("testuser"); windows != expected {
+		t.Errorf("Expected windows %+v, got %+v", expected, windows)
+	}
+	if windows := service.ReplayWindows("otheruser"); windows != (ReplayWindows{PlayedDays: 7, SkippedDays: 30}) {
+		t.Errorf("Expected server-wide windows for other users, got %+v", windows)
+	}
+}
+
+func TestReplayCutoffsSeparateWindows(t *testing.T) {
+	now := time.Now()
+	cutoffs := ReplayWindows{PlayedDays: 0, SkippedDays: 14}.cutoffs(now)
+
+	played := models.Song{ID: "played", LastPlayed: now.Add(-time.Hour)}
+	skipped := models.Song{ID: "skipped", LastSkipped: now.AddDate(0, 0, -7)}
+	oldSkip := models.Song{ID: "old", LastSkipped: now.AddDate(0, 0, -15)}
+
+	if cutoffs.excludes(played) {
+		t.Error("Played songs must not be excluded with a played window of 0 days")
+	}
+	if !cutoffs.excludes(skipped) {
+		t.Error("Songs skipped 7 days ago must be excluded with a skipped window of 14 days")
+	}
+	if cutoffs.excludes(oldSkip) {
+		t.Error("Songs skipped 15 days ago must not be excluded with a skipped window of 14 days")
+	}
+}
+
+func TestReplayCutoffsPenalty(t *testing.T) {
+	now := time.Now()
+	cutoffs := ReplayWindows{PlayedDays: 10, SkippedDays: 10}.cutoffs(now)
+
+	tests := []struct {
+		name     string
+		song     models.Song
+		expected float64
+	}{
+		{"just played", models.Song{LastPlayed: now}, MinReplayFallbackWeight},
+		{"half the window", models.Song{LastPlayed: now.AddDate(0, 0, -5)}, 0.5},
+		{"most recent event counts", models.Song{LastPlayed: now.AddDate(0, 0, -8), LastSkipped: now.AddDate(0, 0, -2)}, 0.2},
+		{"not excluded", models.Song{LastPlayed: now.AddDate(0, 0, -20)}, 1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if penalty := cutoffs.penalty(tt.song); penalty < tt.expected-0.001 || penalty > tt.expected+0.001 {
+				t.Errorf("Expected penalty %.3f, got %.3f", tt.expected, penalty)
+			}
+		})
+	}
+}
+
+func TestWeightedShuffleFillsFromReplayExcluded(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	// Songs 1 to 3 were presented within the replay windows, song 1 least recently
+	for _, event := range []struct{ songID, eventType string }{
+		{"1", "play"},
+		{"2", "skip"},
+		{"3", "play"},
+	} {
+		if err := db.RecordPlayEvent("testuser", event.songID, event.eventType, nil); err != nil {
+			t.Fatalf("Failed to record play event: %v", err)
+		}
+	}
+
+	songs, err := service.GetWeightedShuffledSongs("testuser", 4, models.SongFilter{}, nil)
+	if err != nil {
+		t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+	}
+	if len(songs) != 4 {
+		t.Fatalf("Expected 4 songs including replay-excluded ones, got %d", len(songs))
+	}
+	if songs[0].ID != "4" {
+		t.Errorf("Expected the only eligible song 4 first, got %s", songs[0].ID)
+	}
+
+	seen := make(map[string]bool)
+	for _, song := range songs {
+		if seen[song.ID] {
+			t.Errorf("Song %s returned twice", song.ID)
+		}
+		seen[song.ID] = true
+	}
+
+	// Diversity rules still apply to the fill
+	service.SetDiversityRules(DiversityRules{MaxPerArtist: 1})
+	songs, err = service.GetWeightedShuffledSongs("testuser", 4, models.SongFilter{}, nil)
+	if err != nil {
+		t.Fatalf("GetWeightedShuffledSongs failed: %v", err)
+	}
+	if len(songs) != 2 || songs[0].ID != "4" || songs[1].Artist != "Artist A" {
+		t.Errorf("Expected song 4 and one song by Artist A, got %+v", songs)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/metrics"
 	"github.com/syeo66/subsoxy/models"
 )
 
@@ -20,6 +21,13 @@ const (
 	OversampleFactor      = 3
 )
 
+// Algorithm path labels for shuffle latency metrics
+const (
+	ShufflePathSmall     = "small"
+	ShufflePathOptimized = "optimized"
+	ShufflePathSeeded    = "seeded"
+)
+
 // Weight calculation constants
 const (
 	NeverPlayedWeight      = 4.0
@@ -32,7 +40,7 @@ const (
 	PlayRatioMinWeight     = 0.2
 	PlayRatioMaxWeight     = 1.8
 	BaseTransitionWeight   = 0.5
-	TwoWeekReplayThreshold = 14  // Minimum days before a song can be replayed (unless no alternatives)
+	TwoWeekReplayThreshold = 14  // Default days before a played or skipped song can be replayed (unless no alternatives)
 	MaxSkipTimeoutHours    = 1.0 // Maximum hours to wait before marking as skipped when song duration is unavailable
 	ArtistRatioMinWeight   = 0.5 // Minimum weight multiplier for artists with poor play/skip ratio
 	ArtistRatioMaxWeight   = 1.5 // Maximum weight multiplier for artists with good play/skip ratio
@@ -49,6 +57,12 @@ type ScrobbleInfo struct {
 	Timestamp    time.Time
 }
 
+// scrobbleKey identifies the device of a user that scrobbles are compared within
+type scrobbleKey struct {
+	userID   string
+	deviceID string
+}
+
 // EmpiricalPriors holds the calculated Bayesian priors for a user
 // These are derived from the user's overall listening patterns
 type EmpiricalPriors struct {
@@ -59,28 +73,83 @@ type EmpiricalPriors struct {
 type Service struct {
 	db                    *database.DB
 	logger                *logrus.Logger
-	lastPlayed            map[string]*models.Song     // Map userID to last played song
-	lastScrobble          map[string]*ScrobbleInfo    // Map userID to last scrobble info
-	empiricalPriors       map[string]*EmpiricalPriors // Map userID to calculated priors (song-level)
-	empiricalArtistPriors map[string]*EmpiricalPriors // Map userID to calculated priors (artist-level)
-	mu                    sync.RWMutex                // Protects all maps
+	lastPlayed            map[string]*models.Song             // Map userID to last played song
+	lastScrobble          map[scrobbleKey]*ScrobbleInfo       // Map user and device to last scrobble info
+	streams               map[scrobbleKey]*deviceStreams      // Map user and device to stream tracking state
+	streamPlayThreshold   float64                             // Listened fraction at which a stream counts as a play
+	lastExpiry            time.Time                           // Last time expireDeviceState looked for idle devices
+	empiricalPriors       map[string]*EmpiricalPriors         // Map userID to calculated priors (song-level)
+	empiricalArtistPriors map[string]*EmpiricalPriors         // Map userID to calculated priors (artist-level)
+	mu                    sync.RWMutex                        // Protects all maps
+	durations             *metrics.HistogramVec               // Shuffle latency by algorithm path, nil when not collected
+	diversity             DiversityRules                      // Artist and album limits for weighted shuffles
+	replayWindows         ReplayWindows                       // Server-wide replay exclusion windows
+	defaultWeighting      models.WeightingProfile             // Weighting profile of users without their own
+	userWeighting         map[string]*models.WeightingProfile // Map userID to cached weighting profile (nil = default)
+	weightingMu           sync.RWMutex                        // Protects the weighting profiles, separate from mu as they are read while mu is held
+	now                   func() time.Time                    // Clock for replay windows, time decay and skip detection
+	randSource            rand.Source                         // Seeds the random generator of shuffles without a random seed
+	randMu                sync.Mutex                          // Protects randSource
 }
 
+// New creates the shuffle service and restores the last played songs and last scrobbles
+// persisted by an earlier run, so skip detection and transition weights survive restarts
 func New(db *database.DB, logger *logrus.Logger) *Service {
-	return &Service{
+	s := &Service{
 		db:                    db,
 		logger:                logger,
 		lastPlayed:            make(map[string]*models.Song),
-		lastScrobble:          make(map[string]*ScrobbleInfo),
+		lastScrobble:          make(map[scrobbleKey]*ScrobbleInfo),
+		streams:               make(map[scrobbleKey]*deviceStreams),
+		streamPlayThreshold:   DefaultStreamPlayThreshold,
 		empiricalPriors:       make(map[string]*EmpiricalPriors),
 		empiricalArtistPriors: make(map[string]*EmpiricalPriors),
-	}
+		replayWindows:         ReplayWindows{PlayedDays: TwoWeekReplayThreshold, SkippedDays: TwoWeekReplayThreshold},
+		defaultWeighting:      weightingPresets[WeightingPresetBalanced],
+		userWeighting:         make(map[string]*models.WeightingProfile),
+		now:                   time.Now,
+		randSource:            rand.NewSource(time.Now().UnixNano()),
+	}
+	s.restoreScrobbleState()
+	return s
+}
+
+// SetDurationMetric sets the histogram that records shuffle latency, labeled by
+// algorithm path (ShufflePathSmall, ShufflePathOptimized or ShufflePathSeeded)
+func (s *Service) SetDurationMetric(durations *metrics.HistogramVec) {
+	s.durations = durations
 }
 
+// SetLastPlayed sets the last song played by a user and writes it through to the database
 func (s *Service) SetLastPlayed(userID string, song *models.Song) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	s.lastPlayed[userID] = song
+	s.mu.Unlock()
+
+	// Written after releasing mu, so shuffles don't wait for the database
+	songID := ""
+	if song != nil {
+		songID = song.ID
+	}
+	if err := s.db.SaveLastPlayed(userID, songID, s.now()); err != nil {
+		s.logger.WithError(err).WithField("userID", userID).Warn("Failed to persist last played song")
+	}
+}
+
+// GetLastPlayedSongID returns the ID of the last song played by a user, or nil if none is known.
+// It is used as the "from" side when recording song-to-song transitions, matching the reference
+// song that calculateTransitionWeight looks up.
+func (s *Service) GetLastPlayedSongID(userID string) *string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lastPlayed, exists := s.lastPlayed[userID]
+	if !exists || lastPlayed == nil || lastPlayed.ID == "" {
+		return nil
+	}
+
+	songID := lastPlayed.ID
+	return &songID
 }
 
 // InvalidateEmpiricalPriors clears the cached empirical priors for a user
@@ -95,7 +164,7 @@ func (s *Service) InvalidateEmpiricalPriors(userID string) {
 // getEmpiricalPriors calculates and caches the empirical Bayesian priors for a user
 // based on their overall listening patterns. This implements the empirical Bayes approach
 // where priors are derived from the data itself rather than being fixed constants.
-func (s *Service) getEmpiricalPriors(userID string) (alpha, beta float64) {
+func (s *Service) getEmpiricalPriors(userID string, profile models.WeightingProfile) (alpha, beta float64) {
 	// Check cache first (with read lock)
 	s.mu.RLock()
 	if priors, exists := s.empiricalPriors[userID]; exists {
@@ -108,20 +177,20 @@ func (s *Service) getEmpiricalPriors(userID string) (alpha, beta float64) {
 	totalAdjustedPlays, totalAdjustedSkips, err := s.db.GetUserTotalAdjustedPlaySkips(userID)
 	if err != nil {
 		s.logger.WithError(err).WithField("userID", userID).Debug("Failed to get user total adjusted play/skip counts, using default priors")
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	// Get song count to calculate averages
 	songCount, err := s.db.GetSongCount(userID)
 	if err != nil || songCount == 0 {
 		s.logger.WithError(err).WithField("userID", userID).Debug("Failed to get song count, using default priors")
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	// Calculate average adjusted plays and skips per song as priors
 	// If user has no play/skip history yet, fall back to default priors
 	if totalAdjustedPlays == 0.0 && totalAdjustedSkips == 0.0 {
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	alpha = totalAdjustedPlays / float64(songCount)
@@ -160,7 +229,7 @@ func (s *Service) getEmpiricalPriors(userID string) (alpha, beta float64) {
 // getEmpiricalArtistPriors calculates and caches the empirical Bayesian priors for artist weights
 // based on the user's overall artist-level listening patterns. Similar to getEmpiricalPriors but
 // operates at the artist level rather than song level.
-func (s *Service) getEmpiricalArtistPriors(userID string) (alpha, beta float64) {
+func (s *Service) getEmpiricalArtistPriors(userID string, profile models.WeightingProfile) (alpha, beta float64) {
 	// Check cache first (with read lock)
 	s.mu.RLock()
 	if priors, exists := s.empiricalArtistPriors[userID]; exists {
@@ -174,20 +243,20 @@ func (s *Service) getEmpiricalArtistPriors(userID string) (alpha, beta float64)
 	totalAdjustedPlays, totalAdjustedSkips, err := s.db.GetUserTotalAdjustedPlaySkips(userID)
 	if err != nil {
 		s.logger.WithError(err).WithField("userID", userID).Debug("Failed to get user total adjusted play/skip counts, using default priors")
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	// Get artist count to calculate averages
 	artistCount, err := s.db.GetArtistCount(userID)
 	if err != nil || artistCount == 0 {
 		s.logger.WithError(err).WithField("userID", userID).Debug("Failed to get artist count, using default priors")
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	// Calculate average adjusted plays and skips per artist as priors
 	// If user has no play/skip history yet, fall back to default priors
 	if totalAdjustedPlays == 0.0 && totalAdjustedSkips == 0.0 {
-		return BayesianPriorAlpha, BayesianPriorBeta
+		return profile.BayesianPriorAlpha, profile.BayesianPriorBeta
 	}
 
 	alpha = totalAdjustedPlays / float64(artistCount)
@@ -223,28 +292,48 @@ func (s *Service) getEmpiricalArtistPriors(userID string) (alpha, beta float64)
 	return alpha, beta
 }
 
-// ProcessScrobble processes a scrobble event with simplified skip detection
+// ProcessScrobble processes a scrobble event with simplified skip detection. Scrobbles are only
+// compared with earlier scrobbles of the same device, so a user listening on several devices at
+// once doesn't skip the songs of one device with the scrobbles of another.
 // Returns true if a play event should be recorded, false if it's a duplicate submission
-func (s *Service) ProcessScrobble(userID, songID string, isSubmission bool, recordSkipFunc func(string, *models.Song)) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Service) ProcessScrobble(userID, deviceID, songID string, isSubmission bool, recordSkipFunc func(string, *models.Song)) bool {
+	s.expireDeviceState()
+
+	// Fetch current song details to get duration, before locking so shuffles don't wait for the database
+	currentSong := s.songDetails(userID, songID)
 
-	// Fetch current song details to get duration
-	currentSongs, err := s.db.GetSongsByIDs(userID, []string{songID})
-	var currentSong *models.Song
-	if err == nil && len(currentSongs) > 0 {
-		if song, exists := currentSongs[songID]; exists {
-			currentSong = &song
+	update := s.applyScrobble(userID, deviceID, currentSong, isSubmission)
+
+	// The database writes happen after releasing mu as well
+	if update.skipped != nil {
+		recordSkipFunc(userID, update.skipped)
+	}
+	if update.scrobble != nil {
+		if err := s.db.SaveLastScrobble(userID, *update.scrobble); err != nil {
+			s.logger.WithError(err).WithField("user_id", userID).Warn("Failed to persist last scrobble")
 		}
 	}
+	return update.record
+}
 
-	// If we can't get the song details, create a minimal song with just the ID
-	if currentSong == nil {
-		currentSong = &models.Song{ID: songID}
-	}
+// scrobbleUpdate is what's left to do for a scrobble once mu is released
+type scrobbleUpdate struct {
+	record   bool                   // Whether a play event should be recorded
+	skipped  *models.Song           // Previous song of the device to record as skipped
+	scrobble *models.DeviceScrobble // Last scrobble of the device to persist
+}
+
+// applyScrobble runs skip detection for a scrobble and makes it the last scrobble of the device
+func (s *Service) applyScrobble(userID, deviceID string, currentSong *models.Song, isSubmission bool) scrobbleUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Check if there was a previous scrobble
-	lastScrobble, hadPreviousScrobble := s.lastScrobble[userID]
+	songID := currentSong.ID
+	var update scrobbleUpdate
+
+	// Check if there was a previous scrobble from this device
+	key := scrobbleKey{userID: userID, deviceID: deviceID}
+	lastScrobble, hadPreviousScrobble := s.lastScrobble[key]
 
 	// Check if this is a duplicate submission for the same song
 	// This prevents double-counting when clients retry or send multiple submission=true requests
@@ -254,14 +343,15 @@ func (s *Service) ProcessScrobble(userID, songID string, isSubmission bool, reco
 			"song_id": songID,
 			"reason":  "duplicate_submission",
 		}).Debug("Ignoring duplicate submission for same song")
-		return false // Don't record another play event
+		return update // Don't record another play event
 	}
 
 	// If there was a previous scrobble that wasn't a definitive play, mark it as skipped
 	// BUT only if it's a different song (same song being scrobbled again should just update status)
 	// AND only if the time between scrobbles is less than 2x the song duration (when duration is available)
-	if hadPreviousScrobble && !lastScrobble.IsSubmission && lastScrobble.Song.ID != songID {
-		timeSinceLastScrobble := time.Since(lastScrobble.Timestamp)
+	// AND only if stream tracking hasn't already recorded its play or skip
+	if hadPreviousScrobble && !lastScrobble.IsSubmission && lastScrobble.Song.ID != songID && !s.streamRecorded(key, lastScrobble.Song.ID) {
+		timeSinceLastScrobble := s.now().Sub(lastScrobble.Timestamp)
 		songDuration := time.Duration(lastScrobble.Song.Duration) * time.Second
 		maxSkipTime := songDuration * 2
 
@@ -282,7 +372,8 @@ func (s *Service) ProcessScrobble(userID, songID string, isSubmission bool, reco
 		}
 
 		if shouldMarkAsSkipped {
-			recordSkipFunc(userID, lastScrobble.Song)
+			update.skipped = lastScrobble.Song
+			s.settleStream(key, lastScrobble.Song.ID)
 			s.logger.WithFields(logrus.Fields{
 				"user_id":                userID,
 				"song_id":                lastScrobble.Song.ID,
@@ -307,53 +398,113 @@ func (s *Service) ProcessScrobble(userID, songID string, isSubmission bool, reco
 	}
 
 	// Update the last scrobble info
-	s.lastScrobble[userID] = &ScrobbleInfo{
+	scrobbledAt := s.now()
+	s.lastScrobble[key] = &ScrobbleInfo{
 		Song:         currentSong,
 		IsSubmission: isSubmission,
-		Timestamp:    time.Now(),
+		Timestamp:    scrobbledAt,
+	}
+	update.scrobble = &models.DeviceScrobble{
+		DeviceID:     deviceID,
+		SongID:       songID,
+		Duration:     currentSong.Duration,
+		IsSubmission: isSubmission,
+		ScrobbledAt:  scrobbledAt,
 	}
 
 	s.logger.WithFields(logrus.Fields{
 		"user_id":    userID,
+		"device_id":  deviceID,
 		"song_id":    songID,
 		"submission": isSubmission,
 	}).Debug("Processed scrobble")
 
-	return true // OK to record play event
+	// Stream tracking may have recorded the play (or skip) already
+	if isSubmission && s.streamRecorded(key, songID) {
+		s.logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"song_id": songID,
+			"reason":  "recorded_by_stream",
+		}).Debug("Not recording play already recorded by stream tracking")
+		return update
+	}
+
+	update.record = true // OK to record play event
+	return update
 }
 
 // GetWeightedShuffledSongs returns a shuffled list of songs based on user listening history
-// with strict 2-week replay prevention. Songs played OR skipped within the last 14 days are
-// strictly excluded from the results.
+// with replay prevention. Songs played or skipped within the user's replay windows (see
+// ReplayWindows) are only used to fill the shuffle if too few other songs are eligible, least
+// recently presented first. Songs not matching the given filter (genre, year range, music
+// folder from the getRandomSongs parameters) are always excluded.
 // Uses consistent cutoff time calculation and improved database filtering for reliability.
-func (s *Service) GetWeightedShuffledSongs(userID string, count int) ([]models.Song, error) {
+// A non-nil randomSeed reproduces the exact shuffle for as long as the library and listening
+// history don't change; without one, the service's random source is used (see SetRandSource).
+func (s *Service) GetWeightedShuffledSongs(userID string, count int, filter models.SongFilter, randomSeed *int64) ([]models.Song, error) {
+	start := time.Now()
+	songs, path, err := s.weightedShuffle(userID, count, filter, s.newRand(randomSeed))
+	s.observeDuration(start, path)
+	return songs, err
+}
+
+// observeDuration records the latency of a shuffle that started at start
+func (s *Service) observeDuration(start time.Time, path string) {
+	if s.durations != nil {
+		s.durations.Observe(time.Since(start).Seconds(), path)
+	}
+}
+
+// weightedShuffle implements GetWeightedShuffledSongs and reports the algorithm path it used
+func (s *Service) weightedShuffle(userID string, count int, filter models.SongFilter, rng *rand.Rand) ([]models.Song, string, error) {
 	// For small libraries, use the original algorithm
 	totalSongs, err := s.db.GetSongCount(userID)
 	if err != nil {
-		return nil, err
+		return nil, ShufflePathSmall, err
 	}
 
+	// Calculate cutoff times once for consistency to prevent edge cases
+	// from multiple clock readings across components
+	cutoffs := s.ReplayWindows(userID).cutoffs(s.now())
+
 	// Switch to memory-efficient algorithm for large libraries
+	path := ShufflePathSmall
+	var songs []models.Song
 	if totalSongs > LargeLibraryThreshold {
-		return s.getWeightedShuffledSongsOptimized(userID, count, totalSongs)
+		path = ShufflePathOptimized
+		songs, err = s.getWeightedShuffledSongsOptimized(userID, count, totalSongs, cutoffs, filter, rng)
+	} else {
+		songs, err = s.getWeightedShuffledSongsSmall(userID, count, cutoffs, filter, rng)
+	}
+	if err != nil {
+		return nil, path, err
 	}
 
-	// Original algorithm for small libraries
+	// Rather than returning fewer songs, fall back to the songs excluded from replay
+	if len(songs) < count {
+		songs, err = s.fillFromReplayExcluded(userID, songs, count, cutoffs, filter, rng)
+	}
+	return songs, path, err
+}
+
+// getWeightedShuffledSongsSmall is the original shuffle algorithm for small libraries, which
+// weights all eligible songs in memory
+func (s *Service) getWeightedShuffledSongsSmall(userID string, count int, cutoffs replayCutoffs, filter models.SongFilter, rng *rand.Rand) ([]models.Song, error) {
 	songs, err := s.db.GetAllSongs(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate cutoff time once for consistency to prevent edge cases
-	// from multiple time.Now() calls across components
-	now := time.Now()
-	twoWeeksAgo := now.AddDate(0, 0, -TwoWeekReplayThreshold)
-
 	var eligibleSongs []models.Song
 	var recentSongs []models.Song
+	filteredOut := 0
 
 	for _, song := range songs {
-		if (song.LastPlayed.IsZero() || song.LastPlayed.Before(twoWeeksAgo)) && (song.LastSkipped.IsZero() || song.LastSkipped.Before(twoWeeksAgo)) {
+		if !filter.Matches(song) {
+			filteredOut++
+			continue
+		}
+		if !cutoffs.excludes(song) {
 			eligibleSongs = append(eligibleSongs, song)
 		} else {
 			recentSongs = append(recentSongs, song)
@@ -365,10 +516,13 @@ func (s *Service) GetWeightedShuffledSongs(userID string, count int) ([]models.S
 		"totalSongs":     len(songs),
 		"eligibleSongs":  len(eligibleSongs),
 		"recentSongs":    len(recentSongs),
+		"filteredOut":    filteredOut,
 		"requestedCount": count,
-	}).Debug("Filtered songs by 2-week replay threshold")
+		"playedDays":     cutoffs.windows.PlayedDays,
+		"skippedDays":    cutoffs.windows.SkippedDays,
+	}).Debug("Filtered songs by replay windows")
 
-	// Only use eligible songs - no fallback to recent songs
+	// Only use eligible songs here, recent songs are the fallback of weightedShuffle
 	weightedSongs := make([]models.WeightedSong, 0, len(eligibleSongs))
 	for _, song := range eligibleSongs {
 		weight := s.calculateSongWeight(userID, song)
@@ -378,59 +532,90 @@ func (s *Service) GetWeightedShuffledSongs(userID string, count int) ([]models.S
 		})
 	}
 
+	return weightedSample(weightedSongs, count, s.diversity, rng), nil
+}
+
+// weightedSample draws up to count distinct songs, each with a probability proportional
+// to its weight among the songs not drawn yet that satisfy the diversity rules. Stops early
+// if no remaining song satisfies the rules.
+func weightedSample(weightedSongs []models.WeightedSong, count int, rules DiversityRules, rng *rand.Rand) []models.Song {
+	return sampleInto(make([]models.Song, 0, count), weightedSongs, count, newDiversityTracker(rules), rng)
+}
+
+// sampleInto draws songs like weightedSample and appends them to result until it holds count
+// songs. The tracker must already contain the songs of result.
+func sampleInto(result []models.Song, weightedSongs []models.WeightedSong, count int, tracker *diversityTracker, rng *rand.Rand) []models.Song {
+	// Ties are broken by ID so the draws only depend on rng, not on the order of the songs
 	sort.Slice(weightedSongs, func(i, j int) bool {
-		return weightedSongs[i].Weight > weightedSongs[j].Weight
+		if weightedSongs[i].Weight != weightedSongs[j].Weight {
+			return weightedSongs[i].Weight > weightedSongs[j].Weight
+		}
+		return weightedSongs[i].Song.ID < weightedSongs[j].Song.ID
 	})
 
-	totalWeight := 0.0
-	for _, ws := range weightedSongs {
-		totalWeight += ws.Weight
+	used := make([]bool, len(weightedSongs))
+
+	// Without diversity rules every song is allowed, so the keys aren't needed
+	var keys []diversityKeys
+	if tracker.active() {
+		keys = make([]diversityKeys, len(weightedSongs))
+		for i, ws := range weightedSongs {
+			keys[i] = songDiversityKeys(ws.Song)
+		}
+	}
+	allowed := func(i int) bool {
+		return keys == nil || tracker.allows(keys[i])
 	}
 
-	result := make([]models.Song, 0, count)
-	used := make(map[string]bool)
+	for len(result) < count {
+		totalWeight := 0.0
+		eligible := -1
+		for i, ws := range weightedSongs {
+			if !used[i] && allowed(i) {
+				totalWeight += ws.Weight
+				eligible = i
+			}
+		}
+		if eligible < 0 {
+			break
+		}
 
-	for len(result) < count && len(result) < len(weightedSongs) {
-		target := rand.Float64() * totalWeight
+		// Falls back to the last eligible song if rounding keeps current below target
+		target := rng.Float64() * totalWeight
 		current := 0.0
-
-		for _, ws := range weightedSongs {
-			if used[ws.Song.ID] {
+		for i, ws := range weightedSongs {
+			if used[i] || !allowed(i) {
 				continue
 			}
 			current += ws.Weight
 			if current >= target {
-				result = append(result, ws.Song)
-				used[ws.Song.ID] = true
-				totalWeight -= ws.Weight
+				eligible = i
 				break
 			}
 		}
+
+		result = append(result, weightedSongs[eligible].Song)
+		used[eligible] = true
+		if keys != nil {
+			tracker.add(keys[eligible])
+		}
 	}
 
-	return result, nil
+	return result
 }
 
 // getWeightedShuffledSongsOptimized implements a memory-efficient shuffle algorithm
-// for large song libraries using reservoir sampling and batch processing with strict 2-week
-// replay prevention. Filters at the database level for optimal memory usage.
-// Uses consistent cutoff time passed to database methods for timing consistency.
-func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, totalSongs int) ([]models.Song, error) {
-	const batchSize = BatchSize
-	result := make([]models.Song, 0, count)
-
-	// Calculate cutoff time once for consistency to prevent edge cases
-	// from multiple time.Now() calls across database methods
-	now := time.Now()
-	cutoffTime := now.AddDate(0, 0, -TwoWeekReplayThreshold)
-
-	// First try to get songs that haven't been played within 2 weeks
-	eligibleSongs, err := s.db.GetSongCountFiltered(userID, cutoffTime)
+// for large song libraries using reservoir sampling and batch processing with replay
+// prevention. Filters at the database level (including the song filter) for optimal memory usage.
+// Uses consistent cutoff times passed to database methods for timing consistency.
+func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, totalSongs int, cutoffs replayCutoffs, filter models.SongFilter, rng *rand.Rand) ([]models.Song, error) {
+	// First try to get songs that haven't been played or skipped within the replay windows
+	eligibleSongs, err := s.db.GetSongCountFiltered(userID, cutoffs.played, cutoffs.skipped, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	// Always use filtered songs - no fallback to recent songs
+	// Only sample filtered songs here, recent songs are the fallback of weightedShuffle
 	songsToSampleFrom := eligibleSongs
 	useFiltered := true
 
@@ -439,7 +624,7 @@ func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, to
 		"eligibleSongs":  eligibleSongs,
 		"totalSongs":     totalSongs,
 		"requestedCount": count,
-	}).Debug("Using filtered songs (not played within 2 weeks)")
+	}).Debug("Using filtered songs (not presented within the replay windows)")
 
 	// Use reservoir sampling approach to avoid loading all songs
 	// We'll sample more songs than needed to account for weight distribution
@@ -449,6 +634,48 @@ func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, to
 		sampleSize = songsToSampleFrom
 	}
 
+	// Diversity rules may reject most of a skewed reservoir, so grow it until the batch
+	// is complete or every eligible song was considered
+	var result []models.Song
+	for {
+		weightedSongs, err := s.sampleWeightedReservoir(userID, sampleSize, songsToSampleFrom, cutoffs, filter, useFiltered, rng)
+		if err != nil {
+			return nil, err
+		}
+
+		result = weightedSample(weightedSongs, count, s.diversity, rng)
+		if len(result) >= count || sampleSize >= songsToSampleFrom {
+			break
+		}
+		sampleSize *= oversampleFactor
+		if sampleSize > songsToSampleFrom {
+			sampleSize = songsToSampleFrom
+		}
+	}
+
+	algorithmType := "optimized"
+	if useFiltered {
+		algorithmType = "optimized-filtered"
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":        userID,
+		"totalSongs":    totalSongs,
+		"eligibleSongs": eligibleSongs,
+		"sampleSize":    sampleSize,
+		"resultCount":   len(result),
+		"algorithm":     algorithmType,
+		"useFiltered":   useFiltered,
+	}).Debug("Completed optimized weighted shuffle with replay prevention")
+
+	return result, nil
+}
+
+// sampleWeightedReservoir draws a uniform sample of sampleSize songs out of the
+// songsToSampleFrom eligible songs, read in batches, and weights the sampled songs
+func (s *Service) sampleWeightedReservoir(userID string, sampleSize, songsToSampleFrom int, cutoffs replayCutoffs, filter models.SongFilter, useFiltered bool, rng *rand.Rand) ([]models.WeightedSong, error) {
+	const batchSize = BatchSize
+
 	// Create reservoir for sampling
 	reservoir := make([]models.Song, 0, sampleSize)
 
@@ -461,7 +688,7 @@ func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, to
 		var err error
 
 		if useFiltered {
-			batch, err = s.db.GetSongsBatchFiltered(userID, batchSize, offset, cutoffTime)
+			batch, err = s.db.GetSongsBatchFiltered(userID, batchSize, offset, cutoffs.played, cutoffs.skipped, filter)
 		} else {
 			batch, err = s.db.GetSongsBatch(userID, batchSize, offset)
 		}
@@ -477,7 +704,7 @@ func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, to
 				reservoir = append(reservoir, song)
 			} else {
 				// Replace with probability sampleSize/totalProcessed
-				randomIndex := rand.Intn(totalProcessed)
+				randomIndex := rng.Intn(totalProcessed)
 				if randomIndex < sampleSize {
 					reservoir[randomIndex] = song
 				}
@@ -518,62 +745,17 @@ func (s *Service) getWeightedShuffledSongsOptimized(userID string, count int, to
 		})
 	}
 
-	// Sort by weight for biased selection
-	sort.Slice(weightedSongs, func(i, j int) bool {
-		return weightedSongs[i].Weight > weightedSongs[j].Weight
-	})
-
-	// Select final songs using weighted distribution
-	totalWeight := 0.0
-	for _, ws := range weightedSongs {
-		totalWeight += ws.Weight
-	}
-
-	used := make(map[string]bool)
-
-	for len(result) < count && len(result) < len(weightedSongs) {
-		target := rand.Float64() * totalWeight
-		current := 0.0
-
-		for _, ws := range weightedSongs {
-			if used[ws.Song.ID] {
-				continue
-			}
-			current += ws.Weight
-			if current >= target {
-				result = append(result, ws.Song)
-				used[ws.Song.ID] = true
-				totalWeight -= ws.Weight
-				break
-			}
-		}
-	}
-
-	algorithmType := "optimized"
-	if useFiltered {
-		algorithmType = "optimized-filtered"
-	}
-
-	s.logger.WithFields(logrus.Fields{
-		"userID":        userID,
-		"totalSongs":    totalSongs,
-		"eligibleSongs": eligibleSongs,
-		"sampleSize":    sampleSize,
-		"resultCount":   len(result),
-		"algorithm":     algorithmType,
-		"useFiltered":   useFiltered,
-	}).Debug("Completed optimized weighted shuffle with 2-week replay prevention")
-
-	return result, nil
+	return weightedSongs, nil
 }
 
 func (s *Service) calculateSongWeight(userID string, song models.Song) float64 {
 	baseWeight := 1.0
+	profile := s.WeightingProfile(userID)
 
-	timeWeight := s.calculateTimeDecayWeight(song.LastPlayed, song.LastSkipped)
-	playSkipWeight := s.calculatePlaySkipWeight(userID, song.AdjustedPlays, song.AdjustedSkips)
+	timeWeight := s.calculateTimeDecayWeight(profile, song.LastPlayed, song.LastSkipped)
+	playSkipWeight := s.calculatePlaySkipWeight(userID, profile, song.AdjustedPlays, song.AdjustedSkips)
 	transitionWeight := s.calculateTransitionWeight(userID, song.ID)
-	artistWeight := s.calculateArtistWeight(userID, song.Artist)
+	artistWeight := s.calculateArtistWeight(userID, profile, song.Artist)
 
 	finalWeight := baseWeight * timeWeight * playSkipWeight * transitionWeight * artistWeight
 
@@ -594,16 +776,14 @@ func (s *Service) calculateSongWeight(userID string, song models.Song) float64 {
 // to avoid N+1 database queries when processing batches
 func (s *Service) calculateSongWeightWithTransition(userID string, song models.Song, transitionProbability float64) float64 {
 	baseWeight := 1.0
+	profile := s.WeightingProfile(userID)
 
-	timeWeight := s.calculateTimeDecayWeight(song.LastPlayed, song.LastSkipped)
-	playSkipWeight := s.calculatePlaySkipWeight(userID, song.AdjustedPlays, song.AdjustedSkips)
-	artistWeight := s.calculateArtistWeight(userID, song.Artist)
+	timeWeight := s.calculateTimeDecayWeight(profile, song.LastPlayed, song.LastSkipped)
+	playSkipWeight := s.calculatePlaySkipWeight(userID, profile, song.AdjustedPlays, song.AdjustedSkips)
+	artistWeight := s.calculateArtistWeight(userID, profile, song.Artist)
 
 	// Use provided transition probability or default to 1.0 if not available
-	transitionWeight := 1.0
-	if transitionProbability > 0 {
-		transitionWeight = BaseTransitionWeight + transitionProbability
-	}
+	transitionWeight := transitionWeightFor(transitionProbability, true)
 
 	finalWeight := baseWeight * timeWeight * playSkipWeight * transitionWeight * artistWeight
 
@@ -620,7 +800,7 @@ func (s *Service) calculateSongWeightWithTransition(userID string, song models.S
 	return finalWeight
 }
 
-func (s *Service) calculateTimeDecayWeight(lastPlayed, lastSkipped time.Time) float64 {
+func (s *Service) calculateTimeDecayWeight(profile models.WeightingProfile, lastPlayed, lastSkipped time.Time) float64 {
 	// Use the most recent timestamp between lastPlayed and lastSkipped
 	// since both represent when the song was presented to the listener
 	lastPresented := lastPlayed
@@ -629,13 +809,14 @@ func (s *Service) calculateTimeDecayWeight(lastPlayed, lastSkipped time.Time) fl
 	}
 
 	if lastPresented.IsZero() {
-		return NeverPlayedWeight
+		return profile.NeverPlayedWeight
 	}
 
-	daysSinceLastPresented := time.Since(lastPresented).Hours() / HoursPerDay
+	daysSinceLastPresented := s.now().Sub(lastPresented).Hours() / HoursPerDay
 
-	if daysSinceLastPresented < TimeDecayDaysThreshold {
-		return TimeDecayMinWeight + (daysSinceLastPresented/TimeDecayDaysThreshold)*TimeDecayMaxWeight
+	decayDays := float64(profile.TimeDecayDays)
+	if daysSinceLastPresented < decayDays {
+		return profile.TimeDecayMinWeight + (daysSinceLastPresented/decayDays)*profile.TimeDecayMaxWeight
 	}
 
 	return 1.0 + math.Min(daysSinceLastPresented/DaysPerYear, 1.0)
@@ -654,14 +835,14 @@ func (s *Service) calculateTimeDecayWeight(lastPlayed, lastSkipped time.Time) fl
 // - Songs with few observations get conservative estimates based on user tendencies
 // - Songs with many observations converge to their true play ratio
 // - Prevents extreme weights from small sample sizes (e.g., 1 play, 0 skips)
-// - Recent plays/skips have more influence due to exponential decay (0.95 factor per event)
-func (s *Service) calculatePlaySkipWeight(userID string, adjustedPlays, adjustedSkips float64) float64 {
+// - Recent plays/skips have more influence due to exponential decay (0.95 factor per event by default)
+func (s *Service) calculatePlaySkipWeight(userID string, profile models.WeightingProfile, adjustedPlays, adjustedSkips float64) float64 {
 	if adjustedPlays == 0.0 && adjustedSkips == 0.0 {
-		return UnplayedSongWeight
+		return profile.UnplayedSongWeight
 	}
 
 	// Get empirical priors based on user's overall listening patterns (using adjusted totals)
-	alpha, beta := s.getEmpiricalPriors(userID)
+	alpha, beta := s.getEmpiricalPriors(userID, profile)
 
 	// Calculate Bayesian posterior mean using Beta-Binomial model with adjusted values
 	// This gives us a regularized estimate of the play ratio weighted toward recent behavior
@@ -669,9 +850,9 @@ func (s *Service) calculatePlaySkipWeight(userID string, adjustedPlays, adjusted
 	posteriorTotal := adjustedPlays + adjustedSkips + alpha + beta
 	bayesianPlayRatio := posteriorPlays / posteriorTotal
 
-	// Map the Bayesian ratio to the weight range [PlayRatioMinWeight, PlayRatioMaxWeight]
+	// Map the Bayesian ratio to the weight range [PlayRatioMinWeight, PlayRatioMaxWeight] of the profile
 	// Using proper linear interpolation: min + (ratio * (max - min))
-	return PlayRatioMinWeight + (bayesianPlayRatio * (PlayRatioMaxWeight - PlayRatioMinWeight))
+	return profile.PlayRatioMinWeight + (bayesianPlayRatio * (profile.PlayRatioMaxWeight - profile.PlayRatioMinWeight))
 }
 
 func (s *Service) calculateTransitionWeight(userID, songID string) float64 {
@@ -688,6 +869,15 @@ func (s *Service) calculateTransitionWeight(userID, songID string) float64 {
 		return 1.0
 	}
 
+	return transitionWeightFor(probability, false)
+}
+
+// transitionWeightFor maps a transition probability to the transition weight of a shuffle path.
+// The optimized path for large libraries treats a probability of 0 as unavailable (weight 1.0).
+func transitionWeightFor(probability float64, optimized bool) float64 {
+	if optimized && probability <= 0 {
+		return 1.0
+	}
 	return BaseTransitionWeight + probability
 }
 
@@ -704,8 +894,8 @@ func (s *Service) calculateTransitionWeight(userID, songID string) float64 {
 // - Artists with few observations get conservative estimates based on user tendencies
 // - Artists with many observations converge to their true play ratio
 // - Prevents extreme weights from small sample sizes (e.g., 1 play, 0 skips for a new artist)
-// - Recent plays/skips have more influence due to exponential decay (0.95 factor per event)
-func (s *Service) calculateArtistWeight(userID, artist string) float64 {
+// - Recent plays/skips have more influence due to exponential decay (0.95 factor per event by default)
+func (s *Service) calculateArtistWeight(userID string, profile models.WeightingProfile, artist string) float64 {
 	// Get adjusted stats by summing from songs table (song-level decay applied)
 	adjustedPlays, adjustedSkips, err := s.db.GetArtistAdjustedStats(userID, artist)
 	if err != nil {
@@ -722,7 +912,7 @@ func (s *Service) calculateArtistWeight(userID, artist string) float64 {
 	}
 
 	// Get empirical priors based on user's overall artist listening patterns (using adjusted totals)
-	alpha, beta := s.getEmpiricalArtistPriors(userID)
+	alpha, beta := s.getEmpiricalArtistPriors(userID, profile)
 
 	// Calculate Bayesian posterior mean using Beta-Binomial model with adjusted values
 	// This gives us a regularized estimate of the artist play ratio weighted toward recent behavior
@@ -730,12 +920,12 @@ func (s *Service) calculateArtistWeight(userID, artist string) float64 {
 	posteriorTotal := adjustedPlays + adjustedSkips + alpha + beta
 	bayesianArtistRatio := posteriorPlays / posteriorTotal
 
-	// Map the Bayesian ratio to the weight range [ArtistRatioMinWeight, ArtistRatioMaxWeight]
+	// Map the Bayesian ratio to the weight range [ArtistRatioMinWeight, ArtistRatioMaxWeight] of the profile
 	// Using proper linear interpolation: min + (ratio * (max - min))
 	// ratio=0 (all skips) -> 0.5x weight
 	// ratio=0.5 (equal) -> 1.0x weight
 	// ratio=1.0 (all plays) -> 1.5x weight
-	artistWeight := ArtistRatioMinWeight + (bayesianArtistRatio * (ArtistRatioMaxWeight - ArtistRatioMinWeight))
+	artistWeight := profile.ArtistRatioMinWeight + (bayesianArtistRatio * (profile.ArtistRatioMaxWeight - profile.ArtistRatioMinWeight))
 
 	s.logger.WithFields(logrus.Fields{
 		"user_id":             userID,
@@ -778,26 +968,28 @@ func (s *Service) GetAllSongsWithWeights(userID string) ([]models.WeightedSong,
 
 // GetWeightComponents returns individual weight components for debugging
 func (s *Service) GetWeightComponents(userID string, song models.Song) (timeWeight, playSkipWeight, transitionWeight, artistWeight float64) {
-	timeWeight = s.calculateTimeDecayWeight(song.LastPlayed, song.LastSkipped)
-	playSkipWeight = s.calculatePlaySkipWeight(userID, song.AdjustedPlays, song.AdjustedSkips)
+	profile := s.WeightingProfile(userID)
+	timeWeight = s.calculateTimeDecayWeight(profile, song.LastPlayed, song.LastSkipped)
+	playSkipWeight = s.calculatePlaySkipWeight(userID, profile, song.AdjustedPlays, song.AdjustedSkips)
 	transitionWeight = s.calculateTransitionWeight(userID, song.ID)
-	artistWeight = s.calculateArtistWeight(userID, song.Artist)
+	artistWeight = s.calculateArtistWeight(userID, profile, song.Artist)
 	return
 }
 
 // GetWeightComponentsWithTransition returns individual weight components with transition calculated from a specific song
 func (s *Service) GetWeightComponentsWithTransition(userID string, song models.Song, fromSongID string) (timeWeight, playSkipWeight, transitionWeight, artistWeight float64) {
-	timeWeight = s.calculateTimeDecayWeight(song.LastPlayed, song.LastSkipped)
-	playSkipWeight = s.calculatePlaySkipWeight(userID, song.AdjustedPlays, song.AdjustedSkips)
+	profile := s.WeightingProfile(userID)
+	timeWeight = s.calculateTimeDecayWeight(profile, song.LastPlayed, song.LastSkipped)
+	playSkipWeight = s.calculatePlaySkipWeight(userID, profile, song.AdjustedPlays, song.AdjustedSkips)
 
 	// Calculate transition probability from the specified song
 	probability, err := s.db.GetTransitionProbability(userID, fromSongID, song.ID)
 	if err != nil {
 		transitionWeight = 1.0
 	} else {
-		transitionWeight = BaseTransitionWeight + probability
+		transitionWeight = transitionWeightFor(probability, false)
 	}
 
-	artistWeight = s.calculateArtistWeight(userID, song.Artist)
+	artistWeight = s.calculateArtistWeight(userID, profile, song.Artist)
 	return
 }
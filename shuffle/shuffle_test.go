@@ -8,6 +8,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/metrics"
 	"github.com/syeo66/subsoxy/models"
 )
 
@@ -74,6 +75,40 @@ func TestSetLastPlayed(t *testing.T) {
 	}
 }
 
+func TestGetLastPlayedSongID(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	service := New(db, logger)
+
+	if previous := service.GetLastPlayedSongID("testuser"); previous != nil {
+		t.Errorf("Expected nil for user without history, got '%s'", *previous)
+	}
+
+	service.SetLastPlayed("testuser", &models.Song{ID: "123"})
+
+	previous := service.GetLastPlayedSongID("testuser")
+	if previous == nil {
+		t.Fatal("Expected last played song ID after setting")
+	}
+	if *previous != "123" {
+		t.Errorf("Expected last played ID '123', got '%s'", *previous)
+	}
+
+	if other := service.GetLastPlayedSongID("otheruser"); other != nil {
+		t.Errorf("Expected nil for other user, got '%s'", *other)
+	}
+}
+
 func TestCalculateTimeDecayWeight(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -173,7 +208,7 @@ func TestCalculateTimeDecayWeight(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			weight := service.calculateTimeDecayWeight(tt.lastPlayed, tt.lastSkipped)
+			weight := service.calculateTimeDecayWeight(service.DefaultWeightingProfile(), tt.lastPlayed, tt.lastSkipped)
 			if weight < tt.expectedMin || weight > tt.expectedMax {
 				t.Errorf("%s: expected weight between %.2f and %.2f, got %.2f",
 					tt.description, tt.expectedMin, tt.expectedMax, weight)
@@ -275,7 +310,7 @@ func TestCalculatePlaySkipWeight(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			weight := service.calculatePlaySkipWeight(testUserID, float64(tt.playCount), float64(tt.skipCount))
+			weight := service.calculatePlaySkipWeight(testUserID, service.DefaultWeightingProfile(), float64(tt.playCount), float64(tt.skipCount))
 			// Use approximate comparison for floating point values
 			if weight < tt.expected-0.001 || weight > tt.expected+0.001 {
 				t.Errorf("%s: expected weight %.3f, got %.3f",
@@ -694,7 +729,7 @@ func TestGetWeightedShuffledSongs(t *testing.T) {
 	service := New(db, logger)
 
 	// Test with empty database
-	songs, err := service.GetWeightedShuffledSongs("testuser", 10)
+	songs, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs: %v", err)
 	}
@@ -717,7 +752,7 @@ func TestGetWeightedShuffledSongs(t *testing.T) {
 	}
 
 	// Test requesting more songs than available
-	songs, err = service.GetWeightedShuffledSongs("testuser", 10)
+	songs, err = service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs: %v", err)
 	}
@@ -726,7 +761,7 @@ func TestGetWeightedShuffledSongs(t *testing.T) {
 	}
 
 	// Test requesting fewer songs than available
-	songs, err = service.GetWeightedShuffledSongs("testuser", 3)
+	songs, err = service.GetWeightedShuffledSongs("testuser", 3, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs: %v", err)
 	}
@@ -744,6 +779,89 @@ func TestGetWeightedShuffledSongs(t *testing.T) {
 	}
 }
 
+func TestGetWeightedShuffledSongsDurationMetric(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test_shuffle_metrics.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	service := New(db, logger)
+
+	// Shuffling without a metric configured must not fail
+	if _, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, nil); err != nil {
+		t.Fatalf("Failed to get shuffled songs: %v", err)
+	}
+
+	durations := metrics.NewRegistry().NewHistogramVec("test_shuffle_duration_seconds", "Test.", metrics.DefaultBuckets, "path")
+	service.SetDurationMetric(durations)
+
+	if _, err := service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{}, nil); err != nil {
+		t.Fatalf("Failed to get shuffled songs: %v", err)
+	}
+	if got := durations.Count(ShufflePathSmall); got != 1 {
+		t.Errorf("Expected 1 small path observation, got %d", got)
+	}
+	if got := durations.Count(ShufflePathOptimized); got != 0 {
+		t.Errorf("Expected no optimized path observations, got %d", got)
+	}
+}
+
+func TestGetWeightedShuffledSongsWithFilter(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	dbPath := "test.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath, logger)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	service := New(db, logger)
+
+	songs := []models.Song{
+		{ID: "1", Title: "Song 1", Artist: "Artist 1", Album: "Album 1", Duration: 180, Genre: "Rock", Year: 1991, MusicFolderID: "1"},
+		{ID: "2", Title: "Song 2", Artist: "Artist 2", Album: "Album 2", Duration: 200, Genre: "Rock", Year: 2005, MusicFolderID: "1"},
+		{ID: "3", Title: "Song 3", Artist: "Artist 3", Album: "Album 3", Duration: 220, Genre: "Pop", Year: 1995, MusicFolderID: "2"},
+		{ID: "4", Title: "Song 4", Artist: "Artist 4", Album: "Album 4", Duration: 240, Genre: "Rock", Year: 1999, MusicFolderID: "2"},
+	}
+	if err := db.StoreSongs("testuser", songs); err != nil {
+		t.Fatalf("Failed to store songs: %v", err)
+	}
+
+	filter := models.SongFilter{Genre: "Rock", FromYear: 1990, ToYear: 1999}
+	result, err := service.GetWeightedShuffledSongs("testuser", 10, filter, nil)
+	if err != nil {
+		t.Fatalf("Failed to get filtered shuffled songs: %v", err)
+	}
+
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 songs matching filter, got %d", len(result))
+	}
+	for _, song := range result {
+		if !filter.Matches(song) {
+			t.Errorf("Song %s does not match filter (genre=%s, year=%d)", song.ID, song.Genre, song.Year)
+		}
+	}
+
+	result, err = service.GetWeightedShuffledSongs("testuser", 10, models.SongFilter{MusicFolderID: "2"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to get folder-filtered shuffled songs: %v", err)
+	}
+	if len(result) != 2 {
+		t.Errorf("Expected 2 songs in music folder 2, got %d", len(result))
+	}
+}
+
 func TestGetWeightedShuffledSongsWithHistory(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.WarnLevel)
@@ -783,19 +901,21 @@ func TestGetWeightedShuffledSongsWithHistory(t *testing.T) {
 	}
 
 	// Test that songs are returned (we can't easily test randomness, but we can verify functionality)
-	// Note: Only 1 song should be eligible because the other 2 have recent play/skip events
-	// within the 2-week replay prevention window
-	songs, err := service.GetWeightedShuffledSongs("testuser", 2)
+	// Note: Only 1 song is eligible because the other 2 have recent play/skip events
+	// within the replay windows, so the second song is filled from those
+	songs, err := service.GetWeightedShuffledSongs("testuser", 2, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs: %v", err)
 	}
-	// Should return only 1 song (song "3") because songs "1" and "2" were recently played/skipped
-	if len(songs) != 1 {
-		t.Errorf("Expected 1 song (due to 2-week replay prevention), got %d", len(songs))
+	if len(songs) != 2 {
+		t.Fatalf("Expected 2 songs (1 eligible, 1 filled from replay-excluded songs), got %d", len(songs))
+	}
+	// Eligible songs come before the fill
+	if songs[0].ID != "3" {
+		t.Errorf("Expected song '3' first, got '%s'", songs[0].ID)
 	}
-	// Verify it's song "3" (the only eligible one)
-	if len(songs) == 1 && songs[0].ID != "3" {
-		t.Errorf("Expected song '3', got '%s'", songs[0].ID)
+	if songs[1].ID == "3" {
+		t.Errorf("Expected a recently played or skipped song as fill, got '%s' twice", songs[1].ID)
 	}
 }
 
@@ -841,7 +961,7 @@ func TestGetWeightedShuffledSongsWithTransitions(t *testing.T) {
 	}
 
 	// Test that songs are returned with transition weighting
-	songs, err := service.GetWeightedShuffledSongs("testuser", 2)
+	songs, err := service.GetWeightedShuffledSongs("testuser", 2, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs: %v", err)
 	}
@@ -879,7 +999,7 @@ func TestGetWeightedShuffledSongsConsistency(t *testing.T) {
 
 	// Test multiple calls return valid results
 	for i := 0; i < 10; i++ {
-		songs, err := service.GetWeightedShuffledSongs("testuser", 2)
+		songs, err := service.GetWeightedShuffledSongs("testuser", 2, models.SongFilter{}, nil)
 		if err != nil {
 			t.Errorf("Failed to get shuffled songs on iteration %d: %v", i, err)
 		}
@@ -914,7 +1034,7 @@ func TestEdgeCases(t *testing.T) {
 	service := New(db, logger)
 
 	// Test requesting 0 songs
-	songs, err := service.GetWeightedShuffledSongs("testuser", 0)
+	songs, err := service.GetWeightedShuffledSongs("testuser", 0, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get 0 shuffled songs: %v", err)
 	}
@@ -923,7 +1043,7 @@ func TestEdgeCases(t *testing.T) {
 	}
 
 	// Test requesting large number of songs (should not panic)
-	songs, err = service.GetWeightedShuffledSongs("testuser", 1000000)
+	songs, err = service.GetWeightedShuffledSongs("testuser", 1000000, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get large number of shuffled songs: %v", err)
 	}
@@ -993,7 +1113,7 @@ func TestConcurrentAccess(t *testing.T) {
 	}
 
 	// Verify that the service is still functional after concurrent access
-	shuffledSongs, err := service.GetWeightedShuffledSongs("testuser", 3)
+	shuffledSongs, err := service.GetWeightedShuffledSongs("testuser", 3, models.SongFilter{}, nil)
 	if err != nil {
 		t.Errorf("Failed to get shuffled songs after concurrent access: %v", err)
 	}
@@ -1100,7 +1220,7 @@ func TestCalculateArtistWeight(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			weight := service.calculateArtistWeight(userID, tt.artist)
+			weight := service.calculateArtistWeight(userID, service.DefaultWeightingProfile(), tt.artist)
 			// Use approximate comparison for floating point values
 			if math.Abs(weight-tt.expected) > 0.001 {
 				t.Errorf("%s: expected weight %.3f, got %.3f",
@@ -1149,7 +1269,7 @@ func TestCalculateArtistWeightBoundaryConditions(t *testing.T) {
 	// alpha = 20.0/1 = 20.0, beta = max(0/1, 1.0) = 1.0 (minimum prior strength)
 	// Bayesian ratio: (20+20)/(20+0+20+1) = 40/41 ≈ 0.976
 	// Weight: 0.5 + 0.976*1.0 ≈ 1.476
-	weight := service.calculateArtistWeight(userID, "Popular Artist")
+	weight := service.calculateArtistWeight(userID, service.DefaultWeightingProfile(), "Popular Artist")
 	expectedWeight := 1.476
 	if math.Abs(weight-expectedWeight) > 0.01 {
 		t.Errorf("Expected weight close to %.3f for artist with 100 plays (with decay, converges to ~1.476), got %.3f", expectedWeight, weight)
@@ -1188,28 +1308,28 @@ func TestProcessScrobbleDuplicateDetection(t *testing.T) {
 	}
 
 	t.Run("First submission should allow recording", func(t *testing.T) {
-		shouldRecord := service.ProcessScrobble(userID, song1, true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", song1, true, recordSkipFunc)
 		if !shouldRecord {
 			t.Error("First submission should allow recording")
 		}
 	})
 
 	t.Run("Duplicate submission for same song should NOT allow recording", func(t *testing.T) {
-		shouldRecord := service.ProcessScrobble(userID, song1, true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", song1, true, recordSkipFunc)
 		if shouldRecord {
 			t.Error("Duplicate submission for same song should NOT allow recording")
 		}
 	})
 
 	t.Run("Third duplicate submission should still NOT allow recording", func(t *testing.T) {
-		shouldRecord := service.ProcessScrobble(userID, song1, true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", song1, true, recordSkipFunc)
 		if shouldRecord {
 			t.Error("Third duplicate submission for same song should NOT allow recording")
 		}
 	})
 
 	t.Run("Different song submission should allow recording", func(t *testing.T) {
-		shouldRecord := service.ProcessScrobble(userID, song2, true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", song2, true, recordSkipFunc)
 		if !shouldRecord {
 			t.Error("Submission for different song should allow recording")
 		}
@@ -1219,13 +1339,13 @@ func TestProcessScrobbleDuplicateDetection(t *testing.T) {
 		song3 := "song3"
 
 		// First scrobble without submission (now playing)
-		shouldRecord := service.ProcessScrobble(userID, song3, false, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", song3, false, recordSkipFunc)
 		if !shouldRecord {
 			t.Error("Non-submission scrobble should allow recording (though handler won't record it)")
 		}
 
 		// Then scrobble with submission (actual play)
-		shouldRecord = service.ProcessScrobble(userID, song3, true, recordSkipFunc)
+		shouldRecord = service.ProcessScrobble(userID, "", song3, true, recordSkipFunc)
 		if !shouldRecord {
 			t.Error("Submission after non-submission for same song should allow recording")
 		}
@@ -1239,10 +1359,10 @@ func TestProcessScrobbleDuplicateDetection(t *testing.T) {
 		skipRecords = make(map[string]int)
 
 		// Scrobble song4 without submission
-		service.ProcessScrobble(userID, song4, false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", song4, false, recordSkipFunc)
 
 		// Scrobble song5 without submission - should mark song4 as skipped
-		service.ProcessScrobble(userID, song5, false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", song5, false, recordSkipFunc)
 
 		if skipRecords[song4] != 1 {
 			t.Errorf("Expected song4 to be marked as skipped once, got %d", skipRecords[song4])
@@ -1257,10 +1377,10 @@ func TestProcessScrobbleDuplicateDetection(t *testing.T) {
 		skipRecords = make(map[string]int)
 
 		// Scrobble song6 WITH submission
-		service.ProcessScrobble(userID, song6, true, recordSkipFunc)
+		service.ProcessScrobble(userID, "", song6, true, recordSkipFunc)
 
 		// Scrobble song7 - song6 should NOT be marked as skipped (it was a definitive play)
-		service.ProcessScrobble(userID, song7, true, recordSkipFunc)
+		service.ProcessScrobble(userID, "", song7, true, recordSkipFunc)
 
 		if skipRecords[song6] != 0 {
 			t.Errorf("Expected song6 NOT to be marked as skipped, got %d", skipRecords[song6])
@@ -1305,7 +1425,7 @@ func TestProcessScrobbleIntegrationWithDatabase(t *testing.T) {
 
 	t.Run("Single play should result in play_count=1", func(t *testing.T) {
 		// Process scrobble with submission=true
-		shouldRecord := service.ProcessScrobble(userID, "song1", true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", "song1", true, recordSkipFunc)
 		if !shouldRecord {
 			t.Fatal("First submission should allow recording")
 		}
@@ -1334,7 +1454,7 @@ func TestProcessScrobbleIntegrationWithDatabase(t *testing.T) {
 
 	t.Run("Duplicate submission should NOT increment play_count again", func(t *testing.T) {
 		// Try to submit the same song again (duplicate/retry)
-		shouldRecord := service.ProcessScrobble(userID, "song1", true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", "song1", true, recordSkipFunc)
 		if shouldRecord {
 			t.Fatal("Duplicate submission should NOT allow recording")
 		}
@@ -1361,7 +1481,7 @@ func TestProcessScrobbleIntegrationWithDatabase(t *testing.T) {
 
 	t.Run("New song play should work correctly after duplicate", func(t *testing.T) {
 		// Process scrobble for song2 with submission=true
-		shouldRecord := service.ProcessScrobble(userID, "song2", true, recordSkipFunc)
+		shouldRecord := service.ProcessScrobble(userID, "", "song2", true, recordSkipFunc)
 		if !shouldRecord {
 			t.Fatal("Submission for different song should allow recording")
 		}
@@ -1428,13 +1548,13 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song1 (60s duration) without submission
-		service.ProcessScrobble(userID, "song1", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song1", false, recordSkipFunc)
 
 		// Wait 100ms (much less than 2x60s = 120s)
 		time.Sleep(100 * time.Millisecond)
 
 		// Scrobble song2 - song1 should be marked as skipped
-		service.ProcessScrobble(userID, "song2", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song2", false, recordSkipFunc)
 
 		if skipRecords["song1"] != 1 {
 			t.Errorf("Expected song1 to be marked as skipped once, got %d", skipRecords["song1"])
@@ -1451,17 +1571,17 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song1 (60s duration) without submission
-		service.ProcessScrobble(userID, "song1", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song1", false, recordSkipFunc)
 
 		// Manually set the timestamp to simulate time passing (more than 2x60s = 120s)
 		service.mu.Lock()
-		if lastScrobble, exists := service.lastScrobble[userID]; exists {
+		if lastScrobble, exists := service.lastScrobble[scrobbleKey{userID: userID}]; exists {
 			lastScrobble.Timestamp = time.Now().Add(-130 * time.Second) // 130 seconds ago (> 2x60s)
 		}
 		service.mu.Unlock()
 
 		// Scrobble song2 - song1 should NOT be marked as skipped (too much time passed)
-		service.ProcessScrobble(userID, "song2", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song2", false, recordSkipFunc)
 
 		if skipRecords["song1"] != 0 {
 			t.Errorf("Expected song1 NOT to be marked as skipped (too much time), got %d", skipRecords["song1"])
@@ -1478,13 +1598,13 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song4 (0s duration) without submission
-		service.ProcessScrobble(userID, "song4", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song4", false, recordSkipFunc)
 
 		// Wait 100ms
 		time.Sleep(100 * time.Millisecond)
 
 		// Scrobble song3 - song4 should be marked as skipped (fallback behavior when duration is 0)
-		service.ProcessScrobble(userID, "song3", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song3", false, recordSkipFunc)
 
 		if skipRecords["song4"] != 1 {
 			t.Errorf("Expected song4 to be marked as skipped (fallback), got %d", skipRecords["song4"])
@@ -1501,17 +1621,17 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song4 (0s duration) without submission
-		service.ProcessScrobble(userID, "song4", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song4", false, recordSkipFunc)
 
 		// Manually set the timestamp to simulate time exceeding the max timeout (1 hour + 1 minute)
 		service.mu.Lock()
-		if lastScrobble, exists := service.lastScrobble[userID]; exists {
+		if lastScrobble, exists := service.lastScrobble[scrobbleKey{userID: userID}]; exists {
 			lastScrobble.Timestamp = time.Now().Add(-61 * time.Minute) // 61 minutes ago (> 1 hour)
 		}
 		service.mu.Unlock()
 
 		// Scrobble song3 - song4 should NOT be marked as skipped (exceeded max timeout)
-		service.ProcessScrobble(userID, "song3", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song3", false, recordSkipFunc)
 
 		if skipRecords["song4"] != 0 {
 			t.Errorf("Expected song4 NOT to be marked as skipped (exceeded max timeout), got %d", skipRecords["song4"])
@@ -1528,17 +1648,17 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song4 (0s duration) without submission
-		service.ProcessScrobble(userID, "song4", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song4", false, recordSkipFunc)
 
 		// Manually set the timestamp to simulate time within the max timeout (30 minutes)
 		service.mu.Lock()
-		if lastScrobble, exists := service.lastScrobble[userID]; exists {
+		if lastScrobble, exists := service.lastScrobble[scrobbleKey{userID: userID}]; exists {
 			lastScrobble.Timestamp = time.Now().Add(-30 * time.Minute) // 30 minutes ago (< 1 hour)
 		}
 		service.mu.Unlock()
 
 		// Scrobble song3 - song4 should be marked as skipped (within max timeout)
-		service.ProcessScrobble(userID, "song3", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song3", false, recordSkipFunc)
 
 		if skipRecords["song4"] != 1 {
 			t.Errorf("Expected song4 to be marked as skipped (within max timeout), got %d", skipRecords["song4"])
@@ -1555,17 +1675,17 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song2 (300s = 5 min duration) without submission
-		service.ProcessScrobble(userID, "song2", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song2", false, recordSkipFunc)
 
 		// Manually set the timestamp to simulate 550 seconds passing (less than 2x300s = 600s)
 		service.mu.Lock()
-		if lastScrobble, exists := service.lastScrobble[userID]; exists {
+		if lastScrobble, exists := service.lastScrobble[scrobbleKey{userID: userID}]; exists {
 			lastScrobble.Timestamp = time.Now().Add(-550 * time.Second)
 		}
 		service.mu.Unlock()
 
 		// Scrobble song3 - song2 should be marked as skipped (still within threshold)
-		service.ProcessScrobble(userID, "song3", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song3", false, recordSkipFunc)
 
 		if skipRecords["song2"] != 1 {
 			t.Errorf("Expected song2 to be marked as skipped (within 2x300s threshold), got %d", skipRecords["song2"])
@@ -1582,17 +1702,17 @@ func TestProcessScrobbleTimeBasedSkipDetection(t *testing.T) {
 		}
 
 		// Scrobble song2 (300s = 5 min duration) without submission
-		service.ProcessScrobble(userID, "song2", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song2", false, recordSkipFunc)
 
 		// Manually set the timestamp to simulate 650 seconds passing (more than 2x300s = 600s)
 		service.mu.Lock()
-		if lastScrobble, exists := service.lastScrobble[userID]; exists {
+		if lastScrobble, exists := service.lastScrobble[scrobbleKey{userID: userID}]; exists {
 			lastScrobble.Timestamp = time.Now().Add(-650 * time.Second)
 		}
 		service.mu.Unlock()
 
 		// Scrobble song3 - song2 should NOT be marked as skipped (exceeded threshold)
-		service.ProcessScrobble(userID, "song3", false, recordSkipFunc)
+		service.ProcessScrobble(userID, "", "song3", false, recordSkipFunc)
 
 		if skipRecords["song2"] != 0 {
 			t.Errorf("Expected song2 NOT to be marked as skipped (exceeded 2x300s threshold), got %d", skipRecords["song2"])
@@ -0,0 +1,176 @@
+package shuffle
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Similarity constants
+const (
+	MaxSimilarArtists       = 20   // Co-played artists considered for similar songs
+	MaxTransitionNeighbours = 200  // Transition neighbours considered for similar songs
+	MinSimilarityHistory    = 3    // Played transitions involving the seed required to answer locally
+	MinTopSongsPlays        = 3    // Plays of an artist required to answer top songs locally
+	NeighbourAffinity       = 2.0  // Affinity of songs played right before or after the seed
+	SeedArtistAffinity      = 0.25 // Affinity of other songs by the seed's artists
+)
+
+// GetSimilarSongs returns up to count songs similar to a seed, which may be a song, album or
+// artist ID. Similarity is derived from the user's listening data: songs played right before
+// or after the seed songs, and songs by artists co-played with the seed's artists, weighted by
+// the user's preference for those artists. Each candidate's affinity is multiplied with its
+// regular shuffle weight (time decay, Bayesian play/skip and artist weights).
+// Returns no songs if the seed is unknown or the user's history is insufficient, in which
+// case the request should be answered by the upstream server.
+func (s *Service) GetSimilarSongs(userID, seedID string, count int) ([]models.Song, error) {
+	seedSongs, err := s.db.GetSeedSongs(userID, seedID)
+	if err != nil {
+		return nil, err
+	}
+	if len(seedSongs) == 0 {
+		return nil, nil
+	}
+
+	related, err := s.findRelatedSongs(userID, seedSongs, SeedArtistAffinity)
+	if err != nil {
+		return nil, err
+	}
+	if related.history < MinSimilarityHistory {
+		s.logger.WithFields(logrus.Fields{
+			"userID":  userID,
+			"history": related.history,
+		}).Debug("Insufficient listening history for similar songs")
+		return nil, nil
+	}
+
+	isSeed := make(map[string]bool, len(seedSongs))
+	for _, song := range seedSongs {
+		isSeed[song.ID] = true
+	}
+
+	weightedSongs := make([]models.WeightedSong, 0, len(related.candidates))
+	for _, song := range related.candidates {
+		if isSeed[song.ID] || related.affinity[song.ID] <= 0 {
+			continue
+		}
+		weight := related.affinity[song.ID] * s.calculateSongWeightWithTransition(userID, song, 0)
+		weightedSongs = append(weightedSongs, models.WeightedSong{Song: song, Weight: weight})
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userID":     userID,
+		"seedSongs":  len(seedSongs),
+		"history":    related.history,
+		"candidates": len(weightedSongs),
+		"requested":  count,
+	}).Debug("Calculated similar songs from listening data")
+
+	return weightedSample(weightedSongs, count, DiversityRules{}, s.newRand(nil)), nil
+}
+
+// relatedSongs holds the songs related to a set of seed songs with their affinity
+type relatedSongs struct {
+	candidates []models.Song
+	affinity   map[string]float64
+	history    int // Transition neighbours plus played transitions to co-played artists
+}
+
+// findRelatedSongs collects the songs related to the seed songs through the user's listening
+// data: songs by the seed's artists get seedArtistAffinity, songs by co-played artists an
+// affinity relative to how often they were co-played, scaled by the user's preference for
+// them, and songs played right before or after the seed songs an additional NeighbourAffinity
+// scaled by the transition's play probability. The seed songs are among the candidates.
+func (s *Service) findRelatedSongs(userID string, seedSongs []models.Song, seedArtistAffinity float64) (*relatedSongs, error) {
+	seedIDs := make([]string, 0, len(seedSongs))
+	seedArtists := make([]string, 0, 1)
+	seenArtist := make(map[string]bool)
+	for _, song := range seedSongs {
+		seedIDs = append(seedIDs, song.ID)
+		if !seenArtist[song.Artist] {
+			seenArtist[song.Artist] = true
+			seedArtists = append(seedArtists, song.Artist)
+		}
+	}
+
+	neighbours, err := s.db.GetTransitionNeighbours(userID, seedIDs, MaxTransitionNeighbours)
+	if err != nil {
+		return nil, err
+	}
+	coPlays, err := s.db.GetCoPlayedArtists(userID, seedArtists, MaxSimilarArtists)
+	if err != nil {
+		return nil, err
+	}
+
+	history := len(neighbours)
+	maxCoPlays := 0
+	for _, plays := range coPlays {
+		history += plays
+		if plays > maxCoPlays {
+			maxCoPlays = plays
+		}
+	}
+
+	artistAffinity := make(map[string]float64, len(coPlays)+len(seedArtists))
+	for _, artist := range seedArtists {
+		artistAffinity[artist] = seedArtistAffinity
+	}
+	artists := append([]string{}, seedArtists...)
+	for artist, plays := range coPlays {
+		preference := 1.0
+		if stats, err := s.db.GetArtistStats(userID, artist); err == nil {
+			preference = 2 * stats.Ratio // Neutral ratio 0.5 keeps the affinity unchanged
+		}
+		artistAffinity[artist] = float64(plays) / float64(maxCoPlays) * preference
+		artists = append(artists, artist)
+	}
+
+	candidates, err := s.db.GetSongsByArtists(userID, artists)
+	if err != nil {
+		return nil, err
+	}
+
+	// Neighbours may be by any artist, so fetch the ones not covered yet
+	affinity := make(map[string]float64, len(candidates))
+	for _, song := range candidates {
+		affinity[song.ID] = artistAffinity[song.Artist]
+	}
+	var missing []string
+	for songID := range neighbours {
+		if _, ok := affinity[songID]; !ok {
+			missing = append(missing, songID)
+		}
+	}
+	if len(missing) > 0 {
+		extra, err := s.db.GetSongsWithHistory(userID, missing)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, extra...)
+	}
+	for songID, probability := range neighbours {
+		affinity[songID] += NeighbourAffinity * probability
+	}
+
+	return &relatedSongs{candidates: candidates, affinity: affinity, history: history}, nil
+}
+
+// GetTopSongs returns the user's most played songs by an artist. Returns no songs if the
+// user played the artist fewer than MinTopSongsPlays times, in which case the request should
+// be answered by the upstream server.
+func (s *Service) GetTopSongs(userID, artist string, count int) ([]models.Song, error) {
+	songs, err := s.db.GetTopSongs(userID, artist, count)
+	if err != nil {
+		return nil, err
+	}
+
+	plays := 0
+	for _, song := range songs {
+		plays += song.PlayCount
+	}
+	if plays < MinTopSongsPlays {
+		return nil, nil
+	}
+
+	return songs, nil
+}
@@ -0,0 +1,113 @@
+package shuffle
+
+import (
+	"testing"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// newSimilarTestService creates a service whose user played Artist B around Artist A three
+// times, and skipped from Artist A to Artist C once
+func newSimilarTestService(t *testing.T) *Service {
+	t.Helper()
+
+	songs := []models.Song{
+		{ID: "a1", Title: "A1", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Duration: 200},
+		{ID: "a2", Title: "A2", Artist: "Artist A", Album: "Album A", AlbumID: "al-a", Duration: 200},
+		{ID: "b1", Title: "B1", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", Duration: 200},
+		{ID: "b2", Title: "B2", Artist: "Artist B", Album: "Album B", AlbumID: "al-b", Duration: 200},
+		{ID: "c1", Title: "C1", Artist: "Artist C", Album: "Album C", AlbumID: "al-c", Duration: 200},
+		{ID: "d1", Title: "D1", Artist: "Artist D", Album: "Album D", AlbumID: "al-d", Duration: 200},
+	}
+	service, db := newTestService(t, songs)
+
+	transitions := []struct{ from, to, event string }{
+		{"a1", "b1", "play"},
+		{"a1", "b1", "play"},
+		{"b2", "a2", "play"},
+		{"a1", "c1", "skip"},
+	}
+	for _, tr := range transitions {
+		if err := db.RecordTransition("testuser", tr.from, tr.to, tr.event); err != nil {
+			t.Fatalf("Failed to record transition: %v", err)
+		}
+	}
+
+	return service
+}
+
+func TestGetSimilarSongs(t *testing.T) {
+	service := newSimilarTestService(t)
+
+	songs, err := service.GetSimilarSongs("testuser", "a1", 10)
+	if err != nil {
+		t.Fatalf("GetSimilarSongs failed: %v", err)
+	}
+
+	returned := make(map[string]bool)
+	for _, song := range songs {
+		returned[song.ID] = true
+	}
+	for _, id := range []string{"a2", "b1", "b2"} {
+		if !returned[id] {
+			t.Errorf("Expected %s among similar songs, got %v", id, returned)
+		}
+	}
+	// The seed, a skipped-to artist and an unrelated artist are not similar
+	for _, id := range []string{"a1", "c1", "d1"} {
+		if returned[id] {
+			t.Errorf("Did not expect %s among similar songs", id)
+		}
+	}
+
+	songs, err = service.GetSimilarSongs("testuser", "al-a", 1)
+	if err != nil {
+		t.Fatalf("GetSimilarSongs failed: %v", err)
+	}
+	if len(songs) != 1 || songs[0].Artist != "Artist B" {
+		t.Errorf("Expected one song by Artist B for the album seed, got %+v", songs)
+	}
+}
+
+func TestGetSimilarSongsInsufficientHistory(t *testing.T) {
+	service := newSimilarTestService(t)
+
+	for _, seed := range []string{"c1", "d1", "unknown"} {
+		songs, err := service.GetSimilarSongs("testuser", seed, 10)
+		if err != nil {
+			t.Fatalf("GetSimilarSongs(%q) failed: %v", seed, err)
+		}
+		if songs != nil {
+			t.Errorf("Expected no similar songs for %q, got %d", seed, len(songs))
+		}
+	}
+}
+
+func TestGetTopSongsMinimumPlays(t *testing.T) {
+	service := newSimilarTestService(t)
+
+	record := func(songID string) {
+		if err := service.db.RecordPlayEvent("testuser", songID, "play", nil); err != nil {
+			t.Fatalf("Failed to record play: %v", err)
+		}
+	}
+
+	record("b1")
+	record("b2")
+	songs, err := service.GetTopSongs("testuser", "Artist B", 10)
+	if err != nil {
+		t.Fatalf("GetTopSongs failed: %v", err)
+	}
+	if songs != nil {
+		t.Errorf("Expected no top songs below %d plays, got %d", MinTopSongsPlays, len(songs))
+	}
+
+	record("b2")
+	songs, err = service.GetTopSongs("testuser", "Artist B", 10)
+	if err != nil {
+		t.Fatalf("GetTopSongs failed: %v", err)
+	}
+	if len(songs) != 2 || songs[0].ID != "b2" {
+		t.Errorf("Expected b2 as the top song, got %+v", songs)
+	}
+}
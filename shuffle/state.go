@@ -0,0 +1,86 @@
+package shuffle
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// DeviceStateExpiryInterval is how often expireDeviceState looks for idle devices
+const DeviceStateExpiryInterval = 10 * time.Minute
+
+// restoreScrobbleState loads the last played songs and last scrobbles persisted by an earlier
+// run. State older than MaxSkipTimeoutHours has expired: a scrobble that old can't be followed
+// by a skip anymore, and is deleted instead of restored.
+func (s *Service) restoreScrobbleState() {
+	cutoff := s.now().Add(-time.Duration(MaxSkipTimeoutHours * float64(time.Hour)))
+	states, err := s.db.GetScrobbleStates(cutoff)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to restore scrobble state")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, state := range states {
+		if state.LastPlayedSongID != "" {
+			s.lastPlayed[state.UserID] = &models.Song{ID: state.LastPlayedSongID}
+		}
+		for _, scrobble := range state.Scrobbles {
+			s.lastScrobble[scrobbleKey{userID: state.UserID, deviceID: scrobble.DeviceID}] = &ScrobbleInfo{
+				Song:         &models.Song{ID: scrobble.SongID, Duration: scrobble.Duration},
+				IsSubmission: scrobble.IsSubmission,
+				Timestamp:    scrobble.ScrobbledAt,
+			}
+		}
+	}
+
+	if len(states) > 0 {
+		s.logger.WithField("users", len(states)).Info("Restored scrobble state")
+	}
+}
+
+// expireDeviceState drops the last scrobble and stream tracking state of devices idle for longer
+// than MaxSkipTimeoutHours, in memory and in the database. Device IDs come from clients, so
+// without expiry a client sending ever new ones would grow the state without bound. Called
+// when scrobbles and streams arrive, it looks for idle devices at most once per
+// DeviceStateExpiryInterval. Must be called without mu held.
+func (s *Service) expireDeviceState() {
+	now := s.now()
+	cutoff := now.Add(-time.Duration(MaxSkipTimeoutHours * float64(time.Hour)))
+
+	s.mu.Lock()
+	if now.Sub(s.lastExpiry) < DeviceStateExpiryInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastExpiry = now
+
+	expired := 0
+	for key, last := range s.lastScrobble {
+		if last.Timestamp.Before(cutoff) {
+			delete(s.lastScrobble, key)
+			expired++
+		}
+	}
+	for key, device := range s.streams {
+		if len(device.open) == 0 && device.lastActivity().Before(cutoff) {
+			delete(s.streams, key)
+			expired++
+		}
+	}
+	s.mu.Unlock()
+
+	deleted, err := s.db.DeleteExpiredScrobbles(cutoff)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to delete expired scrobbles")
+	}
+	if expired > 0 || deleted > 0 {
+		s.logger.WithFields(logrus.Fields{
+			"expired": expired,
+			"deleted": deleted,
+		}).Debug("Expired state of idle devices")
+	}
+}
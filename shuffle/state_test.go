@@ -0,0 +1,121 @@
+package shuffle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestScrobbleStateSurvivesRestart(t *testing.T) {
+	service, db := newReplayTestService(t)
+	noSkip := func(userID string, song *models.Song) {
+		t.Errorf("Unexpected skip of %s", song.ID)
+	}
+
+	service.SetLastPlayed("testuser", &models.Song{ID: "3"})
+	service.ProcessScrobble("testuser", "", "1", false, noSkip)
+
+	// A new service on the same database continues where the first one stopped
+	restarted := New(db, service.logger)
+	if lastPlayed := restarted.GetLastPlayedSongID("testuser"); lastPlayed == nil || *lastPlayed != "3" {
+		t.Errorf("Expected last played song 3 after restart, got %v", lastPlayed)
+	}
+
+	var skipped []string
+	restarted.ProcessScrobble("testuser", "", "2", false, func(userID string, song *models.Song) {
+		skipped = append(skipped, song.ID)
+	})
+	if len(skipped) != 1 || skipped[0] != "1" {
+		t.Errorf("Expected song 1 to be skipped after restart, got %v", skipped)
+	}
+}
+
+func TestScrobbleStateExpires(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	expired := time.Now().Add(-time.Duration(MaxSkipTimeoutHours*float64(time.Hour)) - time.Minute)
+	if err := db.SaveLastScrobble("testuser", models.DeviceScrobble{SongID: "1", ScrobbledAt: expired}); err != nil {
+		t.Fatalf("SaveLastScrobble failed: %v", err)
+	}
+	if err := db.SaveLastPlayed("testuser", "3", expired); err != nil {
+		t.Fatalf("SaveLastPlayed failed: %v", err)
+	}
+
+	restarted := New(db, service.logger)
+	if lastPlayed := restarted.GetLastPlayedSongID("testuser"); lastPlayed != nil {
+		t.Errorf("Expected expired last played song to be dropped, got %s", *lastPlayed)
+	}
+	restarted.ProcessScrobble("testuser", "", "2", false, func(userID string, song *models.Song) {
+		t.Errorf("Expected no skip from expired state, got %s", song.ID)
+	})
+}
+
+func TestProcessScrobbleSeparatesDevices(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	var skipped []string
+	recordSkip := func(userID string, song *models.Song) {
+		skipped = append(skipped, song.ID)
+	}
+
+	// Phone and desktop play at the same time, their scrobbles interleave
+	service.ProcessScrobble("testuser", "phone", "1", false, recordSkip)
+	service.ProcessScrobble("testuser", "desktop", "2", false, recordSkip)
+	service.ProcessScrobble("testuser", "phone", "1", true, recordSkip)
+	service.ProcessScrobble("testuser", "desktop", "2", true, recordSkip)
+	if len(skipped) != 0 {
+		t.Errorf("Expected no skips from interleaved devices, got %v", skipped)
+	}
+
+	// The desktop skips its next song; only its own next scrobble resolves it
+	service.ProcessScrobble("testuser", "desktop", "3", false, recordSkip)
+	service.ProcessScrobble("testuser", "phone", "4", false, recordSkip)
+	if len(skipped) != 0 {
+		t.Errorf("Expected the phone not to resolve the desktop's song, got %v", skipped)
+	}
+
+	// Each device's pending song survives a restart
+	restarted := New(db, service.logger)
+	restarted.ProcessScrobble("testuser", "desktop", "1", false, recordSkip)
+	if len(skipped) != 1 || skipped[0] != "3" {
+		t.Errorf("Expected the desktop's song 3 to be skipped, got %v", skipped)
+	}
+}
+
+func TestDeviceStateExpires(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	now := time.Now()
+	service.SetClock(func() time.Time { return now })
+	noSkip := func(userID string, song *models.Song) {}
+
+	// Devices that scrobble or stream once and are never seen again
+	service.ProcessScrobble("testuser", "rotated-1", "1", false, noSkip)
+	service.ProcessScrobble("testuser", "rotated-2", "2", true, noSkip)
+	finished := service.StartStream("testuser", "rotated-3", "3", noSkip)
+	service.FinishStream(finished, abortedAt(time.Minute), noSkip)
+	service.StartStream("testuser", "listening", "4", noSkip)
+
+	now = now.Add(time.Duration(MaxSkipTimeoutHours*float64(time.Hour)) + time.Minute)
+	service.ProcessScrobble("testuser", "phone", "1", false, noSkip)
+
+	service.mu.RLock()
+	scrobbles, streams := len(service.lastScrobble), len(service.streams)
+	_, listening := service.streams[scrobbleKey{userID: "testuser", deviceID: "listening"}]
+	service.mu.RUnlock()
+	if scrobbles != 1 {
+		t.Errorf("Expected only the phone's scrobble to be kept, got %d scrobbles", scrobbles)
+	}
+	if streams != 1 || !listening {
+		t.Errorf("Expected only the device with an open stream to be kept, got %d devices", streams)
+	}
+
+	states, err := db.GetScrobbleStates(time.Time{})
+	if err != nil {
+		t.Fatalf("GetScrobbleStates failed: %v", err)
+	}
+	if len(states) != 1 || len(states[0].Scrobbles) != 1 || states[0].Scrobbles[0].DeviceID != "phone" {
+		t.Errorf("Expected only the phone's scrobble to be stored, got %+v", states)
+	}
+}
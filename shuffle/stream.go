@@ -0,0 +1,309 @@
+package shuffle
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Partial-play skip detection constants
+const (
+	DefaultStreamPlayThreshold = 0.5             // Listened fraction at which a stream counts as a play
+	MinStreamListenTime        = 3 * time.Second // Streams aborted sooner are probes or cancelled prefetches
+)
+
+// Outcomes of a classified stream
+const (
+	StreamOutcomePlay     = "play"
+	StreamOutcomeSkip     = "skip"
+	StreamOutcomeBuffered = "buffered" // Delivered faster than real time, so it doesn't tell how much was heard
+	StreamOutcomePrefetch = "prefetch" // Requested ahead of playback, or aborted right away
+	StreamOutcomeUnknown  = "unknown"  // Failed, or neither the size nor the duration of the song is known
+)
+
+// StreamSession is a stream of a song to one of a user's devices, opened with StartStream
+// and closed with FinishStream
+type StreamSession struct {
+	key       scrobbleKey
+	song      *models.Song
+	prefetch  bool // Opened while the device was streaming another song
+	startedAt time.Time
+}
+
+// deviceStreams tracks the streams of one device of a user
+type deviceStreams struct {
+	open       map[*StreamSession]bool
+	latest     *StreamSession // Most recently opened stream
+	pending    *StreamSession // Skipped stream, recorded once the device streams another song
+	resolved   string         // Song whose play or skip was recorded last, by a stream or a scrobble
+	resolvedAt time.Time
+	byStream   bool // Whether stream tracking recorded the resolved song
+}
+
+// settle marks the play or skip of a song as recorded
+func (d *deviceStreams) settle(songID string, at time.Time, byStream bool) {
+	if d.pending != nil && d.pending.song.ID == songID {
+		d.pending = nil
+	}
+	d.resolved, d.resolvedAt, d.byStream = songID, at, byStream
+}
+
+// lastActivity returns when a stream of the device started or its play or skip was last recorded
+func (d *deviceStreams) lastActivity() time.Time {
+	last := d.resolvedAt
+	if d.latest != nil && d.latest.startedAt.After(last) {
+		last = d.latest.startedAt
+	}
+	return last
+}
+
+// SetStreamPlayThreshold sets the listened fraction at which a stream counts as a play.
+// Zero uses DefaultStreamPlayThreshold.
+func (s *Service) SetStreamPlayThreshold(threshold float64) {
+	if threshold <= 0 {
+		threshold = DefaultStreamPlayThreshold
+	}
+	s.streamPlayThreshold = threshold
+}
+
+// StartStream registers a stream of a song to one of the user's devices. A stream opened while
+// the device is streaming another song is a prefetch. A skip pending from the device's previous
+// stream is recorded with recordSkipFunc if this stream is of another song and starts within the
+// skip timeout; it's dropped if this stream is of the same song, as the client seeked or reconnected.
+func (s *Service) StartStream(userID, deviceID, songID string, recordSkipFunc func(string, *models.Song)) *StreamSession {
+	s.expireDeviceState()
+
+	// Loaded before locking, so shuffles don't wait for the database
+	session := &StreamSession{
+		key:  scrobbleKey{userID: userID, deviceID: deviceID},
+		song: s.songDetails(userID, songID),
+	}
+	if skipped := s.openStream(session); skipped != nil {
+		s.recordStreamSkip(skipped, recordSkipFunc)
+	}
+	return session
+}
+
+// openStream adds a stream to its device and returns the pending stream it resolves as skipped, if any
+func (s *Service) openStream(session *StreamSession) *StreamSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.startedAt = s.now()
+	device := s.deviceStreams(session.key)
+	for open := range device.open {
+		if open.song.ID != session.song.ID {
+			session.prefetch = true
+		}
+	}
+
+	var skipped *StreamSession
+	if pending := device.pending; pending != nil {
+		device.pending = nil
+		if pending.song.ID != session.song.ID && session.startedAt.Sub(pending.startedAt) <= skipTimeout(pending.song) {
+			device.settle(pending.song.ID, session.startedAt, true)
+			skipped = pending
+		}
+	}
+
+	device.open[session] = true
+	device.latest = session
+	return skipped
+}
+
+// FinishStream closes a stream and classifies it from what the proxy observed (see
+// ClassifyStream). Prefetches are ignored. A skip is recorded with recordSkipFunc as soon as
+// the device streams another song, so seeking within a song doesn't count as a skip. Songs
+// whose play or skip was already recorded, by a stream or a submission scrobble, are ignored.
+// Returns true if a play event should be recorded.
+func (s *Service) FinishStream(session *StreamSession, playback models.StreamPlayback, recordSkipFunc func(string, *models.Song)) bool {
+	play, skipped := s.closeStream(session, playback)
+	if skipped != nil {
+		s.recordStreamSkip(skipped, recordSkipFunc)
+	}
+	return play
+}
+
+// closeStream removes a stream from its device and classifies it. It returns whether the stream
+// is a play, and the stream to record as skipped, if any.
+func (s *Service) closeStream(session *StreamSession, playback models.StreamPlayback) (bool, *StreamSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device := s.deviceStreams(session.key)
+	delete(device.open, session)
+
+	outcome, listened := StreamOutcomePrefetch, 0.0
+	if !session.prefetch {
+		outcome, listened = s.ClassifyStream(session.song, playback)
+	}
+	logger := s.logger.WithFields(logrus.Fields{
+		"user_id":   session.key.userID,
+		"device_id": session.key.deviceID,
+		"song_id":   session.song.ID,
+		"outcome":   outcome,
+		"listened":  listened,
+		"bytes":     playback.BytesDelivered,
+		"elapsed":   playback.Elapsed,
+	})
+
+	if outcome != StreamOutcomePlay && outcome != StreamOutcomeSkip {
+		logger.Debug("Stream not classified as play or skip")
+		return false, nil
+	}
+	if s.alreadyRecorded(session.key, session.song.ID) {
+		logger.WithField("reason", "already_recorded").Debug("Ignoring stream of a song already played or skipped")
+		return false, nil
+	}
+
+	if outcome == StreamOutcomePlay {
+		device.settle(session.song.ID, s.now(), true)
+		logger.Debug("Stream classified as play")
+		return true, nil
+	}
+
+	switch {
+	case device.latest == session:
+		device.pending = session
+		logger.Debug("Stream classified as skip, pending until the device streams another song")
+	case device.latest.song.ID != session.song.ID:
+		device.settle(session.song.ID, s.now(), true)
+		return false, session
+	default:
+		logger.WithField("reason", "restreamed").Debug("Ignoring skip of a song streamed again")
+	}
+	return false, nil
+}
+
+// ClassifyStream estimates the listened fraction of a song from a stream response and
+// classifies it as play (at least the play threshold) or skip. The fraction is based on the
+// bytes delivered (after the range start) of the whole stream, whose size is taken from the
+// response or estimated from the song's duration and bitrate, capped by the connection time
+// relative to the song's duration. Completed responses are only a play if the connection lasted
+// long enough, as clients download songs faster than real time to buffer or preload them.
+// Responses aborted within MinStreamListenTime are prefetches.
+func (s *Service) ClassifyStream(song *models.Song, playback models.StreamPlayback) (string, float64) {
+	if playback.Status != http.StatusOK && playback.Status != http.StatusPartialContent {
+		return StreamOutcomeUnknown, 0
+	}
+
+	duration := time.Duration(song.Duration) * time.Second
+	totalBytes := playback.TotalBytes
+	if totalBytes <= 0 {
+		totalBytes = estimatedStreamBytes(song, playback.MaxBitRate)
+	}
+
+	listened, startFraction := -1.0, 0.0
+	if totalBytes > 0 {
+		startFraction = float64(playback.RangeStart) / float64(totalBytes)
+		listened = float64(playback.RangeStart+playback.BytesDelivered) / float64(totalBytes)
+	}
+	timeFraction := -1.0
+	if duration > 0 {
+		timeFraction = startFraction + playback.Elapsed.Seconds()/duration.Seconds()
+	}
+
+	if playback.Completed {
+		if timeFraction >= s.streamPlayThreshold {
+			return StreamOutcomePlay, min(timeFraction, 1)
+		}
+		return StreamOutcomeBuffered, max(timeFraction, 0)
+	}
+	if playback.Elapsed < MinStreamListenTime {
+		return StreamOutcomePrefetch, 0
+	}
+
+	if timeFraction >= 0 && (listened < 0 || timeFraction < listened) {
+		listened = timeFraction
+	}
+	if listened < 0 {
+		return StreamOutcomeUnknown, 0
+	}
+	listened = min(listened, 1)
+	if listened >= s.streamPlayThreshold {
+		return StreamOutcomePlay, listened
+	}
+	return StreamOutcomeSkip, listened
+}
+
+// estimatedStreamBytes estimates the size of a song's stream from its duration and bitrate,
+// or the requested transcoding bitrate if lower. It returns 0 if either is unknown.
+func estimatedStreamBytes(song *models.Song, maxBitRate int) int64 {
+	bitRate := song.BitRate
+	if maxBitRate > 0 && (bitRate == 0 || maxBitRate < bitRate) {
+		bitRate = maxBitRate
+	}
+	return int64(song.Duration) * int64(bitRate) * 1000 / 8
+}
+
+// recordStreamSkip records the skip of a stream's song, settled beforehand with mu held. It's
+// called without mu held, as recordSkipFunc writes to the database.
+func (s *Service) recordStreamSkip(session *StreamSession, recordSkipFunc func(string, *models.Song)) {
+	recordSkipFunc(session.key.userID, session.song)
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   session.key.userID,
+		"device_id": session.key.deviceID,
+		"song_id":   session.song.ID,
+		"reason":    "stream_aborted",
+	}).Debug("Marking streamed song as skipped")
+}
+
+// alreadyRecorded reports whether the play or skip of a song was recorded within the skip
+// timeout, by stream tracking or by a scrobble of the device. Must be called with mu held.
+func (s *Service) alreadyRecorded(key scrobbleKey, songID string) bool {
+	timeout := time.Duration(MaxSkipTimeoutHours * float64(time.Hour))
+	if device, exists := s.streams[key]; exists && device.resolved == songID && s.now().Sub(device.resolvedAt) <= timeout {
+		return true
+	}
+	last := s.lastScrobble[key]
+	return last != nil && last.IsSubmission && last.Song.ID == songID && s.now().Sub(last.Timestamp) <= timeout
+}
+
+// streamRecorded reports whether stream tracking recorded the play or skip of a song of the
+// device within the skip timeout. Must be called with mu held.
+func (s *Service) streamRecorded(key scrobbleKey, songID string) bool {
+	device, exists := s.streams[key]
+	return exists && device.byStream && device.resolved == songID &&
+		s.now().Sub(device.resolvedAt) <= time.Duration(MaxSkipTimeoutHours*float64(time.Hour))
+}
+
+// settleStream marks the skip of a song recorded by a scrobble, so stream tracking doesn't
+// record it again. Must be called with mu held.
+func (s *Service) settleStream(key scrobbleKey, songID string) {
+	if device, exists := s.streams[key]; exists {
+		device.settle(songID, s.now(), false)
+	}
+}
+
+// deviceStreams returns the streams of a device, creating them if needed. Must be called with mu held.
+func (s *Service) deviceStreams(key scrobbleKey) *deviceStreams {
+	device, exists := s.streams[key]
+	if !exists {
+		device = &deviceStreams{open: make(map[*StreamSession]bool)}
+		s.streams[key] = device
+	}
+	return device
+}
+
+// songDetails loads a song of the user, or returns a song with just the ID if it's unknown
+func (s *Service) songDetails(userID, songID string) *models.Song {
+	songs, err := s.db.GetSongsByIDs(userID, []string{songID})
+	if err == nil {
+		if song, exists := songs[songID]; exists {
+			return &song
+		}
+	}
+	return &models.Song{ID: songID}
+}
+
+// skipTimeout is how long after a song started another song may start for the first one to
+// count as skipped: twice its duration, or MaxSkipTimeoutHours if the duration is unknown
+func skipTimeout(song *models.Song) time.Duration {
+	if song.Duration == 0 {
+		return time.Duration(MaxSkipTimeoutHours * float64(time.Hour))
+	}
+	return time.Duration(song.Duration) * time.Second * 2
+}
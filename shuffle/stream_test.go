@@ -0,0 +1,184 @@
+package shuffle
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestClassifyStream(t *testing.T) {
+	service, _ := newReplayTestService(t)
+
+	// 200 seconds at 320 kbps are 8 MB
+	song := &models.Song{ID: "1", Duration: 200, BitRate: 320}
+	const size = 8_000_000
+
+	tests := []struct {
+		name             string
+		song             *models.Song
+		playback         models.StreamPlayback
+		expectedOutcome  string
+		expectedListened float64
+	}{
+		{"failed response", song, models.StreamPlayback{Status: http.StatusNotFound, Elapsed: time.Minute}, StreamOutcomeUnknown, 0},
+		{"complete at listening speed", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size, TotalBytes: size, Elapsed: 190 * time.Second, Completed: true}, StreamOutcomePlay, 0.95},
+		{"complete faster than real time", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size, TotalBytes: size, Elapsed: 5 * time.Second, Completed: true}, StreamOutcomeBuffered, 0.025},
+		{"aborted early", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size / 4, TotalBytes: size, Elapsed: 40 * time.Second}, StreamOutcomeSkip, 0.2},
+		{"aborted late", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size * 3 / 4, TotalBytes: size, Elapsed: 170 * time.Second}, StreamOutcomePlay, 0.75},
+		{"aborted right away", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: 1000, TotalBytes: size, Elapsed: time.Second}, StreamOutcomePrefetch, 0},
+		{"range from the middle", song, models.StreamPlayback{Status: http.StatusPartialContent, RangeStart: size / 2, BytesDelivered: size / 8, TotalBytes: size, Elapsed: 20 * time.Second}, StreamOutcomePlay, 0.6},
+		{"size estimated from bitrate", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size / 10, Elapsed: 100 * time.Second}, StreamOutcomeSkip, 0.1},
+		{"size estimated from transcoding bitrate", song, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size / 10, MaxBitRate: 128, Elapsed: 100 * time.Second}, StreamOutcomeSkip, 0.25},
+		{"duration only", &models.Song{ID: "2", Duration: 200}, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size, Elapsed: 30 * time.Second}, StreamOutcomeSkip, 0.15},
+		{"neither size nor duration", &models.Song{ID: "3"}, models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size, Elapsed: 30 * time.Second}, StreamOutcomeUnknown, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, listened := service.ClassifyStream(tt.song, tt.playback)
+			if outcome != tt.expectedOutcome {
+				t.Errorf("Expected outcome %s, got %s", tt.expectedOutcome, outcome)
+			}
+			if math.Abs(listened-tt.expectedListened) > 1e-9 {
+				t.Errorf("Expected listened fraction %.3f, got %.3f", tt.expectedListened, listened)
+			}
+		})
+	}
+
+	service.SetStreamPlayThreshold(0.9)
+	lateAbort := models.StreamPlayback{Status: http.StatusOK, BytesDelivered: size * 3 / 4, TotalBytes: size, Elapsed: 170 * time.Second}
+	if outcome, _ := service.ClassifyStream(song, lateAbort); outcome != StreamOutcomeSkip {
+		t.Errorf("Expected skip below a higher threshold, got %s", outcome)
+	}
+}
+
+// abortedAt returns the playback of a stream of a 200 second song aborted after elapsed
+func abortedAt(elapsed time.Duration) models.StreamPlayback {
+	return models.StreamPlayback{
+		Status:         http.StatusOK,
+		BytesDelivered: int64(elapsed.Seconds()) * 1000,
+		TotalBytes:     200 * 1000,
+		Elapsed:        elapsed,
+	}
+}
+
+func TestStreamSkipRecordedOnNextSong(t *testing.T) {
+	service, _ := newReplayTestService(t)
+
+	// Skips are recorded without the service lock held, so recording may use the service
+	var skipped []string
+	recordSkip := func(userID string, song *models.Song) {
+		service.GetLastPlayedSongID(userID)
+		skipped = append(skipped, song.ID)
+	}
+
+	session := service.StartStream("testuser", "phone", "1", recordSkip)
+	if service.FinishStream(session, abortedAt(20*time.Second), recordSkip) {
+		t.Error("Expected no play for a stream aborted early")
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("Expected the skip to wait for the next stream, got %v", skipped)
+	}
+
+	// Another device doesn't resolve the phone's skip
+	service.StartStream("testuser", "desktop", "2", recordSkip)
+	if len(skipped) != 0 {
+		t.Fatalf("Expected another device not to resolve the skip, got %v", skipped)
+	}
+
+	service.StartStream("testuser", "phone", "2", recordSkip)
+	if len(skipped) != 1 || skipped[0] != "1" {
+		t.Errorf("Expected song 1 to be skipped, got %v", skipped)
+	}
+}
+
+func TestStreamSeekIsNotSkip(t *testing.T) {
+	service, _ := newReplayTestService(t)
+
+	var skipped []string
+	recordSkip := func(userID string, song *models.Song) {
+		skipped = append(skipped, song.ID)
+	}
+
+	// The client seeks: the stream is aborted and the song requested again from an offset
+	session := service.StartStream("testuser", "phone", "1", recordSkip)
+	service.FinishStream(session, abortedAt(10*time.Second), recordSkip)
+	session = service.StartStream("testuser", "phone", "1", recordSkip)
+	playback := models.StreamPlayback{
+		Status:         http.StatusPartialContent,
+		RangeStart:     150 * 1000,
+		BytesDelivered: 50 * 1000,
+		TotalBytes:     200 * 1000,
+		Elapsed:        45 * time.Second,
+		Completed:      true,
+	}
+	if !service.FinishStream(session, playback, recordSkip) {
+		t.Error("Expected a play for the stream listened to the end")
+	}
+
+	service.StartStream("testuser", "phone", "2", recordSkip)
+	if len(skipped) != 0 {
+		t.Errorf("Expected seeking not to skip, got %v", skipped)
+	}
+}
+
+func TestStreamPrefetchIsIgnored(t *testing.T) {
+	service, _ := newReplayTestService(t)
+
+	var skipped []string
+	recordSkip := func(userID string, song *models.Song) {
+		skipped = append(skipped, song.ID)
+	}
+
+	// The client preloads song 2 while playing song 1, then the user skips song 1
+	current := service.StartStream("testuser", "phone", "1", recordSkip)
+	prefetch := service.StartStream("testuser", "phone", "2", recordSkip)
+	if service.FinishStream(prefetch, abortedAt(30*time.Second), recordSkip) {
+		t.Error("Expected no play for a prefetch")
+	}
+	service.FinishStream(current, abortedAt(40*time.Second), recordSkip)
+
+	if len(skipped) != 1 || skipped[0] != "1" {
+		t.Errorf("Expected only song 1 to be skipped, got %v", skipped)
+	}
+}
+
+func TestStreamAndScrobbleRecordOnce(t *testing.T) {
+	service, _ := newReplayTestService(t)
+
+	var skipped []string
+	recordSkip := func(userID string, song *models.Song) {
+		skipped = append(skipped, song.ID)
+	}
+	played := models.StreamPlayback{Status: http.StatusOK, BytesDelivered: 200 * 1000, TotalBytes: 200 * 1000, Elapsed: 190 * time.Second, Completed: true}
+
+	// A play recorded by the stream isn't recorded again by the submission scrobble
+	service.ProcessScrobble("testuser", "phone", "1", false, recordSkip)
+	session := service.StartStream("testuser", "phone", "1", recordSkip)
+	if !service.FinishStream(session, played, recordSkip) {
+		t.Error("Expected a play for the stream listened to the end")
+	}
+	if service.ProcessScrobble("testuser", "phone", "1", true, recordSkip) {
+		t.Error("Expected the submission of a play recorded by the stream not to be recorded")
+	}
+
+	// A skip recorded by the stream isn't recorded again by the next scrobble
+	service.ProcessScrobble("testuser", "phone", "2", false, recordSkip)
+	session = service.StartStream("testuser", "phone", "2", recordSkip)
+	service.FinishStream(session, abortedAt(20*time.Second), recordSkip)
+	service.StartStream("testuser", "phone", "3", recordSkip)
+	service.ProcessScrobble("testuser", "phone", "3", false, recordSkip)
+	if len(skipped) != 1 || skipped[0] != "2" {
+		t.Errorf("Expected song 2 to be skipped once, got %v", skipped)
+	}
+
+	// A play submitted by the client isn't recorded again by the stream
+	service.ProcessScrobble("testuser", "phone", "3", true, recordSkip)
+	session = service.StartStream("testuser", "phone", "3", recordSkip)
+	if service.FinishStream(session, played, recordSkip) {
+		t.Error("Expected the stream of a submitted play not to be recorded")
+	}
+}
@@ -0,0 +1,178 @@
+package shuffle
+
+import (
+	"sort"
+
+	"github.com/syeo66/subsoxy/database"
+	"github.com/syeo66/subsoxy/errors"
+	"github.com/syeo66/subsoxy/models"
+)
+
+// Weighting presets
+const (
+	WeightingPresetBalanced  = "balanced"  // The default weights
+	WeightingPresetDiscovery = "discovery" // Favors songs never played and spreads plays across the library
+	WeightingPresetComfort   = "comfort"   // Favors songs and artists with a high play ratio
+)
+
+var weightingPresets = map[string]models.WeightingProfile{
+	WeightingPresetBalanced: {
+		Preset:               WeightingPresetBalanced,
+		NeverPlayedWeight:    NeverPlayedWeight,
+		TimeDecayDays:        TimeDecayDaysThreshold,
+		TimeDecayMinWeight:   TimeDecayMinWeight,
+		TimeDecayMaxWeight:   TimeDecayMaxWeight,
+		UnplayedSongWeight:   UnplayedSongWeight,
+		PlayRatioMinWeight:   PlayRatioMinWeight,
+		PlayRatioMaxWeight:   PlayRatioMaxWeight,
+		ArtistRatioMinWeight: ArtistRatioMinWeight,
+		ArtistRatioMaxWeight: ArtistRatioMaxWeight,
+		BayesianPriorAlpha:   BayesianPriorAlpha,
+		BayesianPriorBeta:    BayesianPriorBeta,
+		DecayFactor:          database.DefaultDecayFactor,
+	},
+	WeightingPresetDiscovery: {
+		Preset:               WeightingPresetDiscovery,
+		NeverPlayedWeight:    8.0,
+		TimeDecayDays:        60,
+		TimeDecayMinWeight:   0.05,
+		TimeDecayMaxWeight:   0.95,
+		UnplayedSongWeight:   2.5,
+		PlayRatioMinWeight:   0.6,
+		PlayRatioMaxWeight:   1.4,
+		ArtistRatioMinWeight: 0.8,
+		ArtistRatioMaxWeight: 1.2,
+		BayesianPriorAlpha:   BayesianPriorAlpha,
+		BayesianPriorBeta:    BayesianPriorBeta,
+		DecayFactor:          0.9,
+	},
+	WeightingPresetComfort: {
+		Preset:               WeightingPresetComfort,
+		NeverPlayedWeight:    1.0,
+		TimeDecayDays:        14,
+		TimeDecayMinWeight:   0.2,
+		TimeDecayMaxWeight:   0.8,
+		UnplayedSongWeight:   0.8,
+		PlayRatioMinWeight:   0.1,
+		PlayRatioMaxWeight:   2.5,
+		ArtistRatioMinWeight: 0.3,
+		ArtistRatioMaxWeight: 2.0,
+		BayesianPriorAlpha:   BayesianPriorAlpha,
+		BayesianPriorBeta:    BayesianPriorBeta,
+		DecayFactor:          0.98,
+	},
+}
+
+// WeightingPreset returns the weighting profile of a preset
+func WeightingPreset(name string) (models.WeightingProfile, bool) {
+	profile, ok := weightingPresets[name]
+	return profile, ok
+}
+
+// WeightingPresetNames returns the names of all weighting presets in alphabetical order
+func WeightingPresetNames() []string {
+	names := make([]string, 0, len(weightingPresets))
+	for name := range weightingPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateWeightingProfile checks that a weighting profile yields positive weights and a
+// decay factor in (0, 1]. The error message names the first invalid parameter.
+func ValidateWeightingProfile(profile models.WeightingProfile) error {
+	invalid := func(field, reason string) *errors.SubsoxyError {
+		return errors.New(errors.CategoryValidation, "INVALID_WEIGHTING_PROFILE", field+" "+reason).
+			WithContext("field", field)
+	}
+
+	if _, ok := weightingPresets[profile.Preset]; !ok {
+		return invalid("preset", "must be one of the weighting presets").WithContext("presets", WeightingPresetNames())
+	}
+	for _, weight := range []struct {
+		field string
+		value float64
+	}{
+		{"neverPlayedWeight", profile.NeverPlayedWeight},
+		{"timeDecayMinWeight", profile.TimeDecayMinWeight},
+		{"unplayedSongWeight", profile.UnplayedSongWeight},
+		{"playRatioMinWeight", profile.PlayRatioMinWeight},
+		{"artistRatioMinWeight", profile.ArtistRatioMinWeight},
+		{"bayesianPriorAlpha", profile.BayesianPriorAlpha},
+		{"bayesianPriorBeta", profile.BayesianPriorBeta},
+	} {
+		if !(weight.value > 0) {
+			return invalid(weight.field, "must be positive")
+		}
+	}
+	if profile.TimeDecayDays < 1 {
+		return invalid("timeDecayDays", "must be at least 1")
+	}
+	if !(profile.TimeDecayMaxWeight >= 0) {
+		return invalid("timeDecayMaxWeight", "cannot be negative")
+	}
+	if !(profile.PlayRatioMaxWeight >= profile.PlayRatioMinWeight) {
+		return invalid("playRatioMaxWeight", "must be at least playRatioMinWeight")
+	}
+	if !(profile.ArtistRatioMaxWeight >= profile.ArtistRatioMinWeight) {
+		return invalid("artistRatioMaxWeight", "must be at least artistRatioMinWeight")
+	}
+	if !(profile.DecayFactor > 0 && profile.DecayFactor <= 1) {
+		return invalid("decayFactor", "must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+// SetDefaultWeightingProfile sets the weighting profile of users without their own. It takes
+// effect for the next weight calculation.
+func (s *Service) SetDefaultWeightingProfile(profile models.WeightingProfile) {
+	s.weightingMu.Lock()
+	defer s.weightingMu.Unlock()
+	s.defaultWeighting = profile
+}
+
+// DefaultWeightingProfile returns the weighting profile of users without their own
+func (s *Service) DefaultWeightingProfile() models.WeightingProfile {
+	s.weightingMu.RLock()
+	defer s.weightingMu.RUnlock()
+	return s.defaultWeighting
+}
+
+// WeightingProfile returns the user's weighting profile: the profile in the user's settings,
+// or the default profile. User profiles are cached until InvalidateUserSettings is called.
+func (s *Service) WeightingProfile(userID string) models.WeightingProfile {
+	s.weightingMu.RLock()
+	profile, cached := s.userWeighting[userID]
+	defaultProfile := s.defaultWeighting
+	s.weightingMu.RUnlock()
+
+	if !cached {
+		settings, err := s.db.GetUserSettings(userID)
+		if err != nil {
+			// Don't cache, the next calculation retries
+			s.logger.WithError(err).WithField("userID", userID).Warn("Failed to load user settings, using default weighting profile")
+			return defaultProfile
+		}
+		if settings != nil {
+			profile = settings.Weighting
+		}
+
+		s.weightingMu.Lock()
+		s.userWeighting[userID] = profile
+		s.weightingMu.Unlock()
+	}
+
+	if profile == nil {
+		return defaultProfile
+	}
+	return *profile
+}
+
+// InvalidateUserSettings clears the cached settings of a user
+// This must be called whenever the user's settings are changed
+func (s *Service) InvalidateUserSettings(userID string) {
+	s.weightingMu.Lock()
+	defer s.weightingMu.Unlock()
+	delete(s.userWeighting, userID)
+}
@@ -0,0 +1,108 @@
+package shuffle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syeo66/subsoxy/models"
+)
+
+func TestWeightingPresetsAreValid(t *testing.T) {
+	for _, name := range WeightingPresetNames() {
+		profile, ok := WeightingPreset(name)
+		if !ok {
+			t.Fatalf("Preset %s not found", name)
+		}
+		if err := ValidateWeightingProfile(profile); err != nil {
+			t.Errorf("Preset %s is invalid: %v", name, err)
+		}
+	}
+
+	if _, ok := WeightingPreset("unknown"); ok {
+		t.Error("Expected unknown preset not to be found")
+	}
+}
+
+func TestValidateWeightingProfile(t *testing.T) {
+	balanced, _ := WeightingPreset(WeightingPresetBalanced)
+
+	tests := []struct {
+		name   string
+		modify func(*models.WeightingProfile)
+	}{
+		{"unknown preset", func(p *models.WeightingProfile) { p.Preset = "unknown" }},
+		{"zero never played weight", func(p *models.WeightingProfile) { p.NeverPlayedWeight = 0 }},
+		{"zero decay days", func(p *models.WeightingProfile) { p.TimeDecayDays = 0 }},
+		{"inverted play ratio range", func(p *models.WeightingProfile) { p.PlayRatioMaxWeight = p.PlayRatioMinWeight - 0.1 }},
+		{"inverted artist ratio range", func(p *models.WeightingProfile) { p.ArtistRatioMaxWeight = p.ArtistRatioMinWeight - 0.1 }},
+		{"zero prior", func(p *models.WeightingProfile) { p.BayesianPriorBeta = 0 }},
+		{"decay factor above 1", func(p *models.WeightingProfile) { p.DecayFactor = 1.1 }},
+		{"zero decay factor", func(p *models.WeightingProfile) { p.DecayFactor = 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := balanced
+			tt.modify(&profile)
+			if err := ValidateWeightingProfile(profile); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestWeightingProfileUserOverride(t *testing.T) {
+	service, db := newReplayTestService(t)
+
+	balanced, _ := WeightingPreset(WeightingPresetBalanced)
+	if profile := service.WeightingProfile("testuser"); profile != balanced {
+		t.Errorf("Expected balanced default, got %+v", profile)
+	}
+
+	comfort, _ := WeightingPreset(WeightingPresetComfort)
+	service.SetDefaultWeightingProfile(comfort)
+	if profile := service.WeightingProfile("testuser"); profile != comfort {
+		t.Errorf("Expected new default to apply immediately, got %+v", profile)
+	}
+
+	discovery, _ := WeightingPreset(WeightingPresetDiscovery)
+	if err := db.SaveUserSettings(models.UserSettings{UserID: "testuser", Weighting: &discovery}); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+	if profile := service.WeightingProfile("testuser"); profile != comfort {
+		t.Errorf("Expected cached profile until invalidated, got %+v", profile)
+	}
+
+	service.InvalidateUserSettings("testuser")
+	if profile := service.WeightingProfile("testuser"); profile != discovery {
+		t.Errorf("Expected user profile after invalidation, got %+v", profile)
+	}
+	if profile := service.WeightingProfile("otheruser"); profile != comfort {
+		t.Errorf("Expected default for other users, got %+v", profile)
+	}
+}
+
+func TestWeightingPresetsFavorTheirSongs(t *testing.T) {
+	service, _ := newReplayTestService(t)
+	discovery, _ := WeightingPreset(WeightingPresetDiscovery)
+	comfort, _ := WeightingPreset(WeightingPresetComfort)
+
+	// Discovery favors never played songs over songs played a month ago more than comfort does
+	monthAgo := time.Now().AddDate(0, 0, -30)
+	ratio := func(profile models.WeightingProfile) float64 {
+		return service.calculateTimeDecayWeight(profile, time.Time{}, time.Time{}) /
+			service.calculateTimeDecayWeight(profile, monthAgo, time.Time{})
+	}
+	if ratio(discovery) <= ratio(comfort) {
+		t.Errorf("Expected discovery to favor never played songs more than comfort (%.2f <= %.2f)", ratio(discovery), ratio(comfort))
+	}
+
+	// Comfort separates often played from often skipped songs more than discovery does
+	spread := func(profile models.WeightingProfile) float64 {
+		return service.calculatePlaySkipWeight("testuser", profile, 10, 0) /
+			service.calculatePlaySkipWeight("testuser", profile, 0, 10)
+	}
+	if spread(comfort) <= spread(discovery) {
+		t.Errorf("Expected comfort to favor a high play ratio more than discovery (%.2f <= %.2f)", spread(comfort), spread(discovery))
+	}
+}